@@ -4,10 +4,13 @@ import "github.com/zeromicro/go-zero/rest"
 
 type Config struct {
 	rest.RestConf
-	OpenAI OpenAIConfig
-	Manim  ManimConfig
-	Redis  RedisConfig
-	MySQL  MySQLConfig
+	OpenAI     OpenAIConfig
+	Manim      ManimConfig
+	Storage    StorageConfig
+	Download   DownloadConfig
+	CodeSafety CodeSafetyConfig
+	Redis      RedisConfig `json:",optional"`
+	MySQL      MySQLConfig
 }
 
 type OpenAIConfig struct {
@@ -18,14 +21,116 @@ type OpenAIConfig struct {
 type ManimConfig struct {
 	PythonPath    string
 	MaxConcurrent int
-	Timeout       int
+	Timeout       int    `json:",default=300"` // 单个任务渲染和拼接的最长时间（秒），超时终止整个进程树，0表示不限制
+	Renderer      string `json:",default=local,options=local|docker|sandbox|fake"`
+	DockerPath    string `json:",default=docker"`
+	DockerImage   string `json:",default=manimcommunity/manim:stable"`
+	FFmpegPath    string `json:",default=ffmpeg"`  // 拼接多个场景视频时使用
+	FFprobePath   string `json:",default=ffprobe"` // 校验渲染产物时使用
+	Sandbox       SandboxConfig
+	Workspace     WorkspaceConfig
+	Queue         QueueConfig
+	Retry         RetryConfig
+	Cache         CacheConfig
+	Tiers         []RenderTierConfig `json:",optional"` // 各用户等级的渲染参数上限，为空时使用内置默认值
 }
 
+// RenderTierConfig 用户等级对应的渲染参数上限
+type RenderTierConfig struct {
+	Tier         string
+	MaxQuality   string `json:",options=l|m|h|p|k"`
+	MaxWidth     int
+	MaxHeight    int
+	MaxFrameRate int
+	Timeout      int `json:",optional"` // 该等级的渲染超时（秒），为0时使用ManimConfig.Timeout
+}
+
+// SandboxConfig 沙箱和Docker渲染器的资源限制，为0表示不限制
+type SandboxConfig struct {
+	BwrapPath    string   `json:",default=bwrap"`
+	PrlimitPath  string   `json:",default=prlimit"`
+	CgroupRoot   string   `json:",default=/sys/fs/cgroup/manim-render"` // cgroup v2目录，每次渲染在其下创建子组限制进程数，需要对服务用户委派写权限
+	CPUSeconds   int      `json:",default=600"`
+	CPUs         float64  `json:",default=2"` // Docker容器可用的CPU核数
+	MemoryMB     int      `json:",default=2048"`
+	FileSizeMB   int      `json:",default=512"`
+	MaxProcesses int      `json:",default=64"` // 渲染进程树的进程数上限（cgroup pids.max），不受服务用户的其他进程影响
+	PassEnv      []string `json:",optional"`   // 需要透传给渲染进程的环境变量名
+}
+
+// CacheConfig 渲染缓存配置，相同代码和渲染参数的视频直接引用已有的产物
+type CacheConfig struct {
+	Enabled      bool   `json:",default=true"`
+	ManimVersion string `json:",optional"` // 参与缓存键计算的Manim版本，为空时local和sandbox渲染器通过PythonPath读取，docker渲染器使用镜像名
+}
+
+// RetryConfig 临时失败的自动重试配置，第n次重试前等待 BaseDelaySeconds*2^(n-1) 秒，不超过MaxDelaySeconds
+type RetryConfig struct {
+	MaxAttempts      int `json:",default=3"`   // 最多渲染次数（含首次），用尽后任务移入死信队列，1表示不重试
+	BaseDelaySeconds int `json:",default=10"`  // 首次重试前的等待时间
+	MaxDelaySeconds  int `json:",default=300"` // 重试等待时间上限
+}
+
+// QueueConfig 渲染队列配置
+type QueueConfig struct {
+	Backend          string `json:",default=stream,options=stream|zset|memory|sql"` // stream（Redis Streams）| zset（Redis有序集合）| memory（进程内）| sql（数据库表）
+	PollSeconds      int    `json:",default=1"`                                     // sql队列为空时的轮询间隔
+	LeaseSeconds     int    `json:",default=60"`                                    // 任务租约时长，工作者崩溃后超过该时长任务重新入队
+	BlockSeconds     int    `json:",default=5"`                                     // 工作者阻塞等待新任务的最长时间
+	MaxPerUser       int    `json:",default=2"`                                     // 每个用户同时处理的任务数上限，0表示不限制
+	GraceSeconds     int    `json:",default=60"`                                    // 停止或排空时等待进行中的渲染完成的时间，超时的任务重新入队
+	ReconcileSeconds int    `json:",default=300"`                                   // 对账间隔，启动时先对账一次，之后定期处理数据库与队列不一致的孤儿任务，0表示只在启动时对账
+}
+
+// WorkspaceConfig 渲染临时工作目录的管理配置
+type WorkspaceConfig struct {
+	Root            string `json:",default=temp"`
+	KeepFailedHours int    `json:",default=0"`    // 失败任务的工作目录保留时长（小时），用于调试，0表示立即删除
+	MaxTotalMB      int    `json:",default=2048"` // 工作目录总大小上限，超出时从最早保留的失败目录开始删除，0表示不限制
+	JanitorMinutes  int    `json:",default=10"`   // 清理间隔（分钟）
+}
+
+// StorageConfig 渲染产物的存储配置，多实例部署时需使用s3，各实例共享同一个存储桶
+type StorageConfig struct {
+	Backend        string `json:",default=local,options=local|s3"` // local（本地目录）| s3（S3兼容的对象存储，如MinIO）
+	PresignSeconds int    `json:",default=900"`                    // 预签名下载地址的有效期（秒）
+	Local          LocalStorageConfig
+	S3             S3StorageConfig
+}
+
+// LocalStorageConfig 本地存储配置，产物保存在Root/videos下
+type LocalStorageConfig struct {
+	Root string `json:",default=."`
+}
+
+// S3StorageConfig S3兼容对象存储的连接配置，Backend为s3时Endpoint和Bucket必填
+type S3StorageConfig struct {
+	Endpoint  string `json:",optional"` // 如 http://127.0.0.1:9000，不带路径
+	Region    string `json:",default=us-east-1"`
+	Bucket    string `json:",optional"`
+	AccessKey string `json:",optional"`
+	SecretKey string `json:",optional"`
+	PathStyle bool   `json:",default=true"` // 使用 Endpoint/Bucket/Key 形式的地址，MinIO需要开启；关闭时使用 Bucket.Endpoint/Key
+}
+
+// DownloadConfig 视频下载地址的签名配置
+type DownloadConfig struct {
+	SigningKey    string `json:",optional"`     // HMAC签名密钥，多实例部署时各实例需相同；为空时启动时随机生成，重启后已签发的地址失效
+	ExpireSeconds int    `json:",default=3600"` // 签名下载地址的有效期（秒）
+}
+
+// CodeSafetyConfig 用户代码静态安全检查配置，列表为空时使用内置默认值
+type CodeSafetyConfig struct {
+	AllowedImports []string `json:",optional"` // 允许导入的顶层模块
+	BlockedCalls   []string `json:",optional"` // 禁止使用的内置函数
+}
+
+// RedisConfig 不配置Host时不连接Redis，事件、进度和渲染锁只在进程内生效，队列需使用memory或sql实现，否则启动失败
 type RedisConfig struct {
-	Host     string
-	Port     int
-	Password string
-	DB       int
+	Host     string `json:",optional"`
+	Port     int    `json:",default=6379"`
+	Password string `json:",optional"`
+	DB       int    `json:",optional"`
 }
 
 type MySQLConfig struct {
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"manim-backend/internal/model"
+	"manim-backend/internal/service"
+	"manim-backend/internal/svc"
+	"manim-backend/internal/types"
+)
+
+const (
+	// maxDeadLetterBatch 单次批量操作的任务数上限
+	maxDeadLetterBatch = 500
+	// maxReconcileAudits 单次查询的对账记录数上限
+	maxReconcileAudits = 1000
+)
+
+// AdminHandler 管理员接口，路由使用AuthMiddleware.HandleAdmin保护
+type AdminHandler struct {
+	ctx *svc.ServiceContext
+}
+
+func NewAdminHandler(ctx *svc.ServiceContext) *AdminHandler {
+	return &AdminHandler{ctx: ctx}
+}
+
+// ListDeadLetters 获取死信队列中的任务，包含渲染记录、最后一段日志和代码哈希
+func (h *AdminHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	details, err := h.ctx.VideoService.ListDeadLetterDetails(r.Context())
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "获取死信任务失败: " + err.Error()})
+		return
+	}
+
+	resp := types.DeadLetterListResponse{Jobs: make([]types.DeadLetterJob, 0, len(details)), Total: len(details)}
+	for _, detail := range details {
+		job := types.DeadLetterJob{
+			VideoID:        detail.Job.VideoID,
+			UserID:         detail.Job.UserID,
+			Attempts:       detail.Job.Attempts,
+			FailureReason:  detail.Job.FailureReason,
+			ErrorMsg:       detail.Job.ErrorMsg,
+			CodeHash:       detail.Job.CodeHash,
+			LastLogExcerpt: detail.LastLogExcerpt(),
+			History:        make([]types.RenderAttempt, 0, len(detail.Attempts)),
+			CreatedAt:      detail.Job.CreatedAt.Format("2006-01-02 15:04:05"),
+			UpdatedAt:      detail.Job.UpdatedAt.Format("2006-01-02 15:04:05"),
+		}
+		for _, attempt := range detail.Attempts {
+			job.History = append(job.History, types.RenderAttempt{
+				Attempt:       attempt.Attempt,
+				FailureClass:  attempt.FailureClass,
+				FailureReason: attempt.FailureReason,
+				ErrorMsg:      attempt.ErrorMsg,
+				LogExcerpt:    attempt.LogExcerpt,
+				CodeHash:      attempt.CodeHash,
+				CreatedAt:     attempt.CreatedAt.Format("2006-01-02 15:04:05"),
+			})
+		}
+		resp.Jobs = append(resp.Jobs, job)
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
+
+// ReplayDeadLetter 将单个死信任务重新加入渲染队列
+func (h *AdminHandler) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	videoIDUint, err := strconv.ParseUint(PathParam(r, "id"), 10, 32)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "无效的视频ID"})
+		return
+	}
+
+	if err := h.ctx.VideoService.ReplayDeadLetter(r.Context(), uint(videoIDUint)); err != nil {
+		if errors.Is(err, service.ErrDeadLetterNotFound) {
+			WriteJSON(w, http.StatusNotFound, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "重新入队失败: " + err.Error()})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, types.SuccessResponse{Message: "已重新入队"})
+}
+
+// ReplayDeadLetters 批量将死信任务重新加入渲染队列
+func (h *AdminHandler) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	videoIDs, ok := parseDeadLetterBatch(w, r)
+	if !ok {
+		return
+	}
+	WriteJSON(w, http.StatusOK, deadLetterBatchResponse(h.ctx.VideoService.ReplayDeadLetters(r.Context(), videoIDs)))
+}
+
+// DiscardDeadLetters 批量从死信队列中移除任务，视频保持失败状态
+func (h *AdminHandler) DiscardDeadLetters(w http.ResponseWriter, r *http.Request) {
+	videoIDs, ok := parseDeadLetterBatch(w, r)
+	if !ok {
+		return
+	}
+	result, err := h.ctx.VideoService.DiscardDeadLetters(r.Context(), videoIDs)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "丢弃死信任务失败: " + err.Error()})
+		return
+	}
+	WriteJSON(w, http.StatusOK, deadLetterBatchResponse(result))
+}
+
+// parseDeadLetterBatch 解析批量操作的视频ID列表，出错时已写入响应
+func parseDeadLetterBatch(w http.ResponseWriter, r *http.Request) ([]uint, bool) {
+	var req types.DeadLetterBatchRequest
+	if err := ParseJSON(r, &req); err != nil {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "请求参数错误"})
+		return nil, false
+	}
+	if len(req.VideoIDs) == 0 {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "视频ID列表不能为空"})
+		return nil, false
+	}
+	if len(req.VideoIDs) > maxDeadLetterBatch {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "单次最多处理" + strconv.Itoa(maxDeadLetterBatch) + "个任务"})
+		return nil, false
+	}
+	return req.VideoIDs, true
+}
+
+func deadLetterBatchResponse(result service.DeadLetterBatchResult) types.DeadLetterBatchResponse {
+	resp := types.DeadLetterBatchResponse{Succeeded: result.Succeeded}
+	if resp.Succeeded == nil {
+		resp.Succeeded = []uint{}
+	}
+	for videoID, err := range result.Failed {
+		resp.Failed = append(resp.Failed, types.DeadLetterBatchFailure{VideoID: videoID, Error: err.Error()})
+	}
+	sort.Slice(resp.Failed, func(i, j int) bool { return resp.Failed[i].VideoID < resp.Failed[j].VideoID })
+	return resp
+}
+
+// DrainWorkers 停止处理该请求的实例领取新任务，进行中的渲染在宽限期内完成，超时的任务重新入队。
+// 用于滚动发布，请求立即返回，通过GetWorkerStatus查看进行中的任务数
+func (h *AdminHandler) DrainWorkers(w http.ResponseWriter, r *http.Request) {
+	var req types.DrainWorkersRequest
+	if r.ContentLength != 0 {
+		if err := ParseJSON(r, &req); err != nil {
+			WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "请求参数错误"})
+			return
+		}
+	}
+	if req.GraceSeconds < 0 {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "宽限期不能为负数"})
+		return
+	}
+
+	h.ctx.VideoService.DrainQueueWorkers(time.Duration(req.GraceSeconds) * time.Second)
+	WriteJSON(w, http.StatusAccepted, workerStatusResponse(h.ctx.VideoService.QueueWorkerStatus()))
+}
+
+// GetWorkerStatus 获取处理该请求的实例上渲染工作者的状态
+func (h *AdminHandler) GetWorkerStatus(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, workerStatusResponse(h.ctx.VideoService.QueueWorkerStatus()))
+}
+
+func workerStatusResponse(status service.WorkerStatus) types.WorkerStatusResponse {
+	return types.WorkerStatusResponse{Running: status.Running, Draining: status.Draining, InFlight: status.InFlight}
+}
+
+// ReconcileQueue 立即对账一次，返回本次处理的孤儿任务
+func (h *AdminHandler) ReconcileQueue(w http.ResponseWriter, r *http.Request) {
+	result, err := h.ctx.VideoService.ReconcileQueue(r.Context())
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "对账失败: " + err.Error()})
+		return
+	}
+	WriteJSON(w, http.StatusOK, types.ReconcileResponse{Checked: result.Checked, Audits: reconcileAudits(result.Audits)})
+}
+
+// ListReconcileAudits 获取最近的对账记录
+func (h *AdminHandler) ListReconcileAudits(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxReconcileAudits {
+			WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "limit须为1到" + strconv.Itoa(maxReconcileAudits) + "之间的整数"})
+			return
+		}
+		limit = n
+	}
+
+	audits, err := h.ctx.VideoService.ListReconcileAudits(r.Context(), limit)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "获取对账记录失败: " + err.Error()})
+		return
+	}
+	WriteJSON(w, http.StatusOK, types.ReconcileAuditListResponse{Audits: reconcileAudits(audits)})
+}
+
+func reconcileAudits(audits []model.ReconcileAudit) []types.ReconcileAudit {
+	resp := make([]types.ReconcileAudit, 0, len(audits))
+	for _, audit := range audits {
+		resp = append(resp, types.ReconcileAudit{
+			VideoID:    audit.VideoID,
+			PrevStatus: audit.PrevStatus,
+			Action:     audit.Action,
+			Reason:     audit.Reason,
+			CreatedAt:  audit.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return resp
+}
+
+// GetRenderCacheStats 获取渲染缓存的条目数和命中统计
+func (h *AdminHandler) GetRenderCacheStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.ctx.VideoService.RenderCacheStats(r.Context())
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "获取渲染缓存统计失败: " + err.Error()})
+		return
+	}
+
+	resp := types.RenderCacheStatsResponse{
+		Enabled:    stats.Enabled,
+		Entries:    stats.Entries,
+		References: stats.References,
+		TotalHits:  stats.TotalHits,
+		Hits:       stats.Hits,
+		Misses:     stats.Misses,
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		resp.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"manim-backend/internal/model"
+	"manim-backend/internal/service"
+	"manim-backend/internal/svc"
+)
+
+// DownloadHandler 提供渲染产物的下载。下载地址为 /downloadvideo/{视频ID}/{文件名}，
+// 文件名只用于在视频的产物记录中查找存储路径，不拼接到文件路径中
+type DownloadHandler struct {
+	ctx *svc.ServiceContext
+}
+
+func NewDownloadHandler(ctx *svc.ServiceContext) *DownloadHandler {
+	return &DownloadHandler{ctx: ctx}
+}
+
+// downloadPath 视频产物的下载路径，签名覆盖该路径
+func downloadPath(videoID uint, name string) string {
+	return "/downloadvideo/" + strconv.FormatUint(uint64(videoID), 10) + "/" + name
+}
+
+// artifactDownloadPath 产物存储路径对应的下载路径，如视频1的产物 videos/3/1/a.mp4 对应 /downloadvideo/1/a.mp4。
+// 命中渲染缓存的视频引用其他视频的文件，下载路径仍使用自己的视频ID
+func artifactDownloadPath(videoID uint, storedPath string) string {
+	return downloadPath(videoID, path.Base(filepath.ToSlash(storedPath)))
+}
+
+// shareURL 产物的公开分享地址，撤销分享前一直有效
+func shareURL(videoID uint, storedPath, token string) string {
+	return (&url.URL{Path: artifactDownloadPath(videoID, storedPath), RawQuery: url.Values{"share": {token}}.Encode()}).String()
+}
+
+// ServeVideoFile 提供视频文件。请求须带有GetVideo签发的未过期签名或视频的分享令牌，
+// 文件须是该视频的产物；存储支持预签名地址时重定向到存储，否则由服务读取文件返回
+func (h *DownloadHandler) ServeVideoFile(w http.ResponseWriter, r *http.Request) {
+	// 允许的来源由CORS中间件设置，签名地址本身即为凭证，不需要携带Cookie
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range")
+
+	// 处理预检请求
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	videoID, err := strconv.ParseUint(PathParam(r, "id"), 10, 32)
+	if err != nil || videoID == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	name := PathParam(r, "file")
+
+	if err := h.authorize(r, uint(videoID), name); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	video, err := h.ctx.VideoService.GetVideoByID(r.Context(), uint(videoID))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 通过产物记录得到存储路径，文件不属于该视频时返回404
+	filePath, err := h.ctx.VideoService.ResolveArtifact(r.Context(), video, name)
+	if err != nil {
+		writeArtifactError(w, r, err)
+		return
+	}
+
+	// 对象存储直接重定向到预签名地址，由存储提供文件
+	presigned, err := h.ctx.VideoService.PresignArtifact(r.Context(), filePath)
+	if err == nil {
+		http.Redirect(w, r, presigned, http.StatusFound)
+		return
+	}
+	if !errors.Is(err, service.ErrPresignNotSupported) {
+		writeArtifactError(w, r, err)
+		return
+	}
+
+	body, info, err := h.ctx.VideoService.OpenArtifact(r.Context(), filePath)
+	if err != nil {
+		writeArtifactError(w, r, err)
+		return
+	}
+	defer body.Close()
+
+	// 根据文件扩展名设置正确的Content-Type头部
+	w.Header().Set("Content-Type", model.MIMETypeForFormat(model.FormatFromPath(filePath)))
+
+	// 设置缓存控制头部，避免浏览器缓存问题
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+
+	// 设置额外的安全头部，避免ORB错误
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", "inline")
+
+	// 提供文件，本地文件支持Range请求
+	if content, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, info.ModTime, content)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	io.Copy(w, body)
+}
+
+// authorize 校验下载请求：带share参数时要求令牌是该视频的分享令牌，
+// 否则要求下载路径的签名有效且未过期，签名覆盖视频ID和文件名
+func (h *DownloadHandler) authorize(r *http.Request, videoID uint, name string) error {
+	query := r.URL.Query()
+	if token := query.Get("share"); token != "" {
+		video, err := h.ctx.VideoService.GetVideoByShareToken(r.Context(), token)
+		if err != nil || video.ID != videoID {
+			return service.ErrShareNotFound
+		}
+		return nil
+	}
+	return h.ctx.DownloadSigner.Verify(downloadPath(videoID, name), query.Get("expires"), query.Get("signature"))
+}
+
+// writeArtifactError 读取产物失败时的响应，路径无效和文件不存在返回404
+func writeArtifactError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, service.ErrBlobNotFound) || errors.Is(err, service.ErrInvalidBlobKey) {
+		http.NotFound(w, r)
+		return
+	}
+	http.Error(w, "读取视频文件失败", http.StatusInternalServerError)
+}
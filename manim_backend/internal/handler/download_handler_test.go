@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+	"manim-backend/internal/service"
+	"manim-backend/internal/svc"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const testShareToken = "share-token-of-video-2"
+
+// downloadFixture 两个用户各一个已完成的视频，存储根目录下另有不属于任何视频的文件，
+// 视频1还有一条指向根目录外文件的符号链接产物
+type downloadFixture struct {
+	handler *DownloadHandler
+	signer  *service.DownloadSigner
+	// contents 允许下载的文件内容，键为 视频ID/文件名
+	contents map[string]string
+}
+
+func setupDownloadHandler(t testing.TB) *downloadFixture {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.User{}, &model.Video{}, &model.VideoArtifact{}, &model.RenderCacheEntry{}))
+
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeFile := func(name, content string) {
+		target := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(target), 0755))
+		require.NoError(t, os.WriteFile(target, []byte(content), 0644))
+	}
+	writeFile("videos/1/1/Circle.mp4", "circle video")
+	writeFile("videos/1/1/Circle.png", "circle frame")
+	writeFile("videos/2/2/Square.mp4", "square video")
+	writeFile("etc/config.yaml", "private config")
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.mp4"), []byte("outside secret"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.mp4"), filepath.Join(root, "videos", "1", "1", "Link.mp4")))
+
+	token := testShareToken
+	videos := []model.Video{
+		{UserID: 1, Prompt: "画一个圆", Status: model.VideoStatusCompleted, VideoPath: "videos/1/1/Circle.mp4"},
+		{UserID: 2, Prompt: "画一个正方形", Status: model.VideoStatusCompleted, VideoPath: "videos/2/2/Square.mp4", ShareToken: &token},
+	}
+	require.NoError(t, db.Create(&videos).Error)
+	artifacts := []model.VideoArtifact{
+		{VideoID: videos[0].ID, Kind: model.ArtifactKindVideo, Format: model.OutputFormatMP4, MIMEType: "video/mp4", Path: "videos/1/1/Circle.mp4"},
+		{VideoID: videos[0].ID, Kind: model.ArtifactKindImage, Format: model.OutputFormatPNG, MIMEType: "image/png", Path: "videos/1/1/Circle.png"},
+		{VideoID: videos[0].ID, Kind: model.ArtifactKindVideo, Format: model.OutputFormatMP4, MIMEType: "video/mp4", Path: "videos/1/1/Link.mp4"},
+		{VideoID: videos[1].ID, Kind: model.ArtifactKindVideo, Format: model.OutputFormatMP4, MIMEType: "video/mp4", Path: "videos/2/2/Square.mp4"},
+	}
+	require.NoError(t, db.Create(&artifacts).Error)
+
+	manimCfg := config.ManimConfig{Renderer: service.RendererFake}
+	videoSvc := service.NewVideoService(db, service.NewMemoryJobQueue(time.Minute, 0), service.NewVideoEventService(nil), service.NewRenderProgressStore(nil), service.NewRenderCanceller(nil), manimCfg, nil)
+	videoSvc.SetBlobStore(service.NewLocalBlobStore(root), 0)
+	signer := service.NewDownloadSigner(config.DownloadConfig{SigningKey: "test-signing-key", ExpireSeconds: 3600})
+
+	return &downloadFixture{
+		handler: NewDownloadHandler(&svc.ServiceContext{VideoService: videoSvc, DownloadSigner: signer}),
+		signer:  signer,
+		contents: map[string]string{
+			"1/Circle.mp4": "circle video",
+			"1/Circle.png": "circle frame",
+			"2/Square.mp4": "square video",
+		},
+	}
+}
+
+// signedQuery 为路径参数对应的下载路径签名，返回查询字符串
+func (f *downloadFixture) signedQuery(id, file string) string {
+	_, query, _ := strings.Cut(f.signer.Sign("/downloadvideo/"+id+"/"+file), "?")
+	return query
+}
+
+// serve 以路由解析出的路径参数请求下载
+func (f *downloadFixture) serve(id, file, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.URL.Path = "/downloadvideo/" + id + "/" + file
+	r.URL.RawQuery = query
+	r = pathvar.WithVars(r, map[string]string{"id": id, "file": file})
+	w := httptest.NewRecorder()
+	f.handler.ServeVideoFile(w, r)
+	return w
+}
+
+func TestDownloadHandler_ServeVideoFile(t *testing.T) {
+	f := setupDownloadHandler(t)
+
+	tests := []struct {
+		name     string
+		id       string
+		file     string
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "签名下载", id: "1", file: "Circle.mp4", query: f.signedQuery("1", "Circle.mp4"), wantCode: http.StatusOK, wantBody: "circle video"},
+		{name: "签名下载图片", id: "1", file: "Circle.png", query: f.signedQuery("1", "Circle.png"), wantCode: http.StatusOK, wantBody: "circle frame"},
+		{name: "分享令牌", id: "2", file: "Square.mp4", query: "share=" + testShareToken, wantCode: http.StatusOK, wantBody: "square video"},
+		{name: "没有签名", id: "1", file: "Circle.mp4", wantCode: http.StatusForbidden},
+		{name: "签名用于其他文件", id: "1", file: "Circle.png", query: f.signedQuery("1", "Circle.mp4"), wantCode: http.StatusForbidden},
+		{name: "签名用于其他视频", id: "2", file: "Square.mp4", query: f.signedQuery("1", "Square.mp4"), wantCode: http.StatusForbidden},
+		{name: "分享令牌用于其他视频", id: "1", file: "Circle.mp4", query: "share=" + testShareToken, wantCode: http.StatusForbidden},
+		{name: "文件属于其他视频", id: "1", file: "Square.mp4", query: f.signedQuery("1", "Square.mp4"), wantCode: http.StatusNotFound},
+		{name: "上级目录", id: "1", file: "../2/Square.mp4", query: f.signedQuery("1", "../2/Square.mp4"), wantCode: http.StatusNotFound},
+		{name: "根目录下的其他文件", id: "1", file: "../../../etc/config.yaml", query: f.signedQuery("1", "../../../etc/config.yaml"), wantCode: http.StatusNotFound},
+		{name: "绝对路径", id: "1", file: "/etc/passwd", query: f.signedQuery("1", "/etc/passwd"), wantCode: http.StatusNotFound},
+		{name: "符号链接", id: "1", file: "Link.mp4", query: f.signedQuery("1", "Link.mp4"), wantCode: http.StatusNotFound},
+		{name: "视频不存在", id: "99", file: "Circle.mp4", query: f.signedQuery("99", "Circle.mp4"), wantCode: http.StatusNotFound},
+		{name: "视频ID无效", id: "abc", file: "Circle.mp4", query: f.signedQuery("abc", "Circle.mp4"), wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := f.serve(tt.id, tt.file, tt.query)
+			assert.Equal(t, tt.wantCode, w.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+// FuzzServeVideoFile 任意路径参数都只能下载请求的视频自己的产物，
+// 根目录下的其他文件和符号链接指向的文件不会被返回
+func FuzzServeVideoFile(f *testing.F) {
+	fixture := setupDownloadHandler(f)
+
+	for _, seed := range []struct {
+		id, file string
+		mode     uint8
+	}{
+		{"1", "Circle.mp4", 1},
+		{"2", "Square.mp4", 2},
+		{"1", "Square.mp4", 1},
+		{"1", "../2/Square.mp4", 1},
+		{"1", "..", 1},
+		{"1", ".", 1},
+		{"1", "../../../etc/config.yaml", 1},
+		{"1", "..\\..\\..\\etc\\config.yaml", 1},
+		{"1", "/etc/passwd", 1},
+		{"1", "Link.mp4", 1},
+		{"1", "Circle.mp4\x00.png", 1},
+		{"01", "Circle.mp4", 1},
+		{"-1", "Circle.mp4", 1},
+		{"1", "Circle.mp4", 0},
+		{"1", "Circle.mp4", 2},
+	} {
+		f.Add(seed.id, seed.file, seed.mode)
+	}
+
+	// mode 0不带凭证，1带路径参数的签名，2带视频2的分享令牌
+	f.Fuzz(func(t *testing.T, id, file string, mode uint8) {
+		var query string
+		switch mode % 3 {
+		case 1:
+			query = fixture.signedQuery(id, file)
+		case 2:
+			query = "share=" + testShareToken
+		}
+
+		w := fixture.serve(id, file, query)
+		body := w.Body.String()
+		for _, leaked := range []string{"outside secret", "private config"} {
+			if strings.Contains(body, leaked) {
+				t.Fatalf("id=%q file=%q 返回了不可下载的文件: %q", id, file, body)
+			}
+		}
+
+		switch w.Code {
+		case http.StatusOK:
+			videoID, err := strconv.ParseUint(id, 10, 32)
+			if err != nil {
+				t.Fatalf("id=%q file=%q 视频ID无效却返回200", id, file)
+			}
+			if mode%3 == 0 || (mode%3 == 2 && videoID != 2) {
+				t.Fatalf("id=%q file=%q mode=%d 凭证无效却返回200", id, file, mode)
+			}
+			want, ok := fixture.contents[strconv.FormatUint(videoID, 10)+"/"+file]
+			if !ok || body != want {
+				t.Fatalf("id=%q file=%q 返回了其他文件: %q", id, file, body)
+			}
+		case http.StatusForbidden, http.StatusNotFound:
+		default:
+			t.Fatalf("id=%q file=%q 返回了意外的状态码 %d", id, file, w.Code)
+		}
+	})
+}
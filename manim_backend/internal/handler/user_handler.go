@@ -7,6 +7,8 @@ import (
 	"manim-backend/internal/middleware"
 	"manim-backend/internal/svc"
 	"manim-backend/internal/types"
+
+	"github.com/zeromicro/go-zero/rest/pathvar"
 )
 
 type UserHandler struct {
@@ -29,6 +31,11 @@ func ParseJSON(r *http.Request, data interface{}) error {
 	return json.NewDecoder(r.Body).Decode(data)
 }
 
+// PathParam 辅助函数用于获取路由中的路径参数
+func PathParam(r *http.Request, name string) string {
+	return pathvar.Vars(r)[name]
+}
+
 // Register 用户注册
 func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req types.RegisterRequest
@@ -60,6 +67,7 @@ func (h *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 			ID:       user.ID,
 			Username: user.Username,
 			Email:    user.Email,
+			Tier:     user.Tier,
 		},
 	})
 }
@@ -95,6 +103,7 @@ func (h *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 			ID:       user.ID,
 			Username: user.Username,
 			Email:    user.Email,
+			Tier:     user.Tier,
 		},
 	})
 }
@@ -111,5 +120,6 @@ func (h *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 		ID:       user.ID,
 		Username: user.Username,
 		Email:    user.Email,
+		Tier:     user.Tier,
 	})
 }
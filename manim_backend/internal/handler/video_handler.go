@@ -1,8 +1,16 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+
+	"manim-backend/internal/model"
+	"manim-backend/internal/service"
 
 	"manim-backend/internal/middleware"
 	"manim-backend/internal/svc"
@@ -64,8 +72,40 @@ func (h *VideoHandler) CreateVideo(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// 检测代码中的场景类并校验选择的场景
+	scenes, err := service.ResolveScenes(manimCode, req.Scenes)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "场景选择无效: " + err.Error()})
+		return
+	}
+
+	// 按用户等级校验渲染参数
+	renderOptions, err := h.ctx.VideoService.ResolveRenderOptions(r.Context(), userID, model.RenderOptions{
+		Quality:         req.Quality,
+		Width:           req.Width,
+		Height:          req.Height,
+		FrameRate:       req.FrameRate,
+		BackgroundColor: req.BackgroundColor,
+		Format:          req.Format,
+		Transparent:     req.Transparent,
+		SaveLastFrame:   req.SaveLastFrame,
+		Scenes:          strings.Join(scenes, ","),
+		Concat:          req.Concat,
+	})
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "渲染参数无效: " + err.Error()})
+		return
+	}
+
+	// 校验队列优先级
+	priority, err := h.ctx.VideoService.ResolvePriority(r.Context(), userID, req.Priority)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "优先级无效: " + err.Error()})
+		return
+	}
+
 	// 创建视频记录
-	video, err := h.ctx.VideoService.CreateVideo(r.Context(), userID, req.Prompt)
+	video, err := h.ctx.VideoService.CreateVideoWithPriority(r.Context(), userID, req.Prompt, renderOptions, priority)
 	if err != nil {
 		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "创建视频失败: " + err.Error()})
 		return
@@ -78,25 +118,31 @@ func (h *VideoHandler) CreateVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 将视频添加到渲染队列
+	// 将视频添加到渲染队列，由队列工作者统一渲染
 	err = h.ctx.VideoService.AddToQueue(r.Context(), video.ID)
 	if err != nil {
 		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "添加到队列失败: " + err.Error()})
 		return
 	}
 
-	// 异步处理视频生成
-	go func() {
-		h.ctx.ManimService.GenerateVideo(r.Context(), video.ID, manimCode)
-	}()
-
 	WriteJSON(w, http.StatusOK, types.VideoResponse{
-		ID:        video.ID,
-		Prompt:    video.Prompt,
-		ManimCode: manimCode,
-		Status:    video.Status.String(),
-		CreatedAt: video.CreatedAt.Format("2006-01-02 15:04:05"),
-		UpdatedAt: video.UpdatedAt.Format("2006-01-02 15:04:05"),
+		ID:              video.ID,
+		Prompt:          video.Prompt,
+		ManimCode:       manimCode,
+		Status:          video.Status.String(),
+		Priority:        video.Priority,
+		CreatedAt:       video.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt:       video.UpdatedAt.Format("2006-01-02 15:04:05"),
+		Quality:         video.Quality,
+		Width:           video.Width,
+		Height:          video.Height,
+		FrameRate:       video.FrameRate,
+		BackgroundColor: video.BackgroundColor,
+		Format:          video.Format,
+		Transparent:     video.Transparent,
+		SaveLastFrame:   video.SaveLastFrame,
+		Scenes:          video.SceneList(),
+		Concat:          video.Concat,
 	})
 }
 
@@ -105,7 +151,7 @@ func (h *VideoHandler) GetVideo(w http.ResponseWriter, r *http.Request) {
 	// 优先从查询参数获取ID，如果没有则从路径参数获取
 	videoID := r.URL.Query().Get("id")
 	if videoID == "" {
-		videoID = r.PathValue("id")
+		videoID = PathParam(r, "id")
 	}
 
 	if videoID == "" {
@@ -145,15 +191,234 @@ func (h *VideoHandler) GetVideo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	WriteJSON(w, http.StatusOK, types.VideoResponse{
-		ID:        video.ID,
-		Prompt:    video.Prompt,
-		ManimCode: video.ManimCode,
-		VideoPath: videoURL,
-		Status:    video.Status.String(),
-		ErrorMsg:  video.ErrorMsg,
-		CreatedAt: video.CreatedAt.Format("2006-01-02 15:04:05"),
-		UpdatedAt: video.UpdatedAt.Format("2006-01-02 15:04:05"),
+		ID:            video.ID,
+		Prompt:        video.Prompt,
+		ManimCode:     video.ManimCode,
+		VideoPath:     videoURL,
+		DownloadURL:   h.downloadURL(video.ID, video.VideoPath),
+		ShareToken:    shareToken(video),
+		Status:        video.Status.String(),
+		ErrorMsg:      video.ErrorMsg,
+		FailureReason: video.FailureReason,
+		Priority:      video.Priority,
+		Attempts:      video.Attempts,
+		NextAttemptAt: formatOptionalTime(video.NextAttemptAt),
+		Progress:      h.renderProgress(r, video),
+		CreatedAt:     video.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt:     video.UpdatedAt.Format("2006-01-02 15:04:05"),
+
+		Quality:         video.Quality,
+		Width:           video.Width,
+		Height:          video.Height,
+		FrameRate:       video.FrameRate,
+		BackgroundColor: video.BackgroundColor,
+		Format:          video.Format,
+		Transparent:     video.Transparent,
+		SaveLastFrame:   video.SaveLastFrame,
+		Scenes:          video.SceneList(),
+		Concat:          video.Concat,
+
+		Media:     mediaInfoResponse(video.Media),
+		Artifacts: h.videoArtifacts(r, video),
+	})
+}
+
+// videoArtifacts 获取已完成视频的全部渲染产物
+func (h *VideoHandler) videoArtifacts(r *http.Request, video *model.Video) []types.VideoArtifact {
+	if video.Status != model.VideoStatusCompleted {
+		return nil
+	}
+
+	artifacts, err := h.ctx.VideoService.GetVideoArtifacts(r.Context(), video.ID)
+	if err != nil {
+		return nil
+	}
+
+	var result []types.VideoArtifact
+	for _, artifact := range artifacts {
+		result = append(result, types.VideoArtifact{
+			Kind:     artifact.Kind,
+			Scene:    artifact.Scene,
+			Format:   artifact.Format,
+			MIMEType: artifact.MIMEType,
+			URL:      h.downloadURL(video.ID, artifact.Path),
+			Size:     artifact.Size,
+			Media:    mediaInfoResponse(artifact.Media),
+		})
+	}
+	return result
+}
+
+// downloadURL 产物的签名下载地址，在Download.ExpireSeconds内有效
+func (h *VideoHandler) downloadURL(videoID uint, path string) string {
+	if path == "" {
+		return ""
+	}
+	return h.ctx.DownloadSigner.Sign(artifactDownloadPath(videoID, path))
+}
+
+func shareToken(video *model.Video) string {
+	if video.ShareToken == nil {
+		return ""
+	}
+	return *video.ShareToken
+}
+
+// mediaInfoResponse 转换媒体信息，未校验过的旧视频返回nil
+func mediaInfoResponse(media model.MediaInfo) *types.MediaInfo {
+	if media.Codec == "" {
+		return nil
+	}
+	return &types.MediaInfo{
+		Duration: media.Duration,
+		Codec:    media.Codec,
+		Width:    media.Width,
+		Height:   media.Height,
+	}
+}
+
+// renderProgress 获取渲染中视频的进度，其他状态不返回进度
+func (h *VideoHandler) renderProgress(r *http.Request, video *model.Video) *types.RenderProgress {
+	if video.Status != model.VideoStatusProcessing {
+		return nil
+	}
+
+	progress, err := h.ctx.VideoService.GetRenderProgress(r.Context(), video.ID)
+	if err != nil || progress == nil {
+		return nil
+	}
+
+	return &types.RenderProgress{
+		Phase:            progress.Phase,
+		CurrentAnimation: progress.CurrentAnimation,
+		TotalAnimations:  progress.TotalAnimations,
+		Percent:          progress.Percent,
+		OverallPercent:   progress.OverallPercent,
+		FramesRendered:   progress.FramesRendered,
+		TotalFrames:      progress.TotalFrames,
+		ETASeconds:       progress.ETASeconds,
+		UpdatedAt:        progress.UpdatedAt,
+	}
+}
+
+// StreamVideoEvents 通过SSE推送视频状态和渲染进度
+func (h *VideoHandler) StreamVideoEvents(w http.ResponseWriter, r *http.Request) {
+	videoID := PathParam(r, "id")
+	if videoID == "" {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "视频ID不能为空"})
+		return
+	}
+
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		WriteJSON(w, http.StatusUnauthorized, types.ErrorResponse{Error: "用户未认证"})
+		return
+	}
+
+	// 将字符串ID转换为uint
+	videoIDUint, err := strconv.ParseUint(videoID, 10, 32)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "无效的视频ID"})
+		return
+	}
+
+	video, err := h.ctx.VideoService.GetVideoByID(r.Context(), uint(videoIDUint))
+	if err != nil {
+		WriteJSON(w, http.StatusNotFound, types.ErrorResponse{Error: "视频不存在"})
+		return
+	}
+
+	if video.UserID != userID {
+		WriteJSON(w, http.StatusForbidden, types.ErrorResponse{Error: "无权访问此视频"})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "当前连接不支持事件流"})
+		return
+	}
+
+	// 先订阅再读取当前状态，避免遗漏两者之间发生的状态变更
+	events, unsubscribe, err := h.ctx.VideoService.Events().Subscribe(r.Context(), video.ID)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "订阅视频事件失败: " + err.Error()})
+		return
+	}
+	defer unsubscribe()
+
+	if latest, err := h.ctx.VideoService.GetVideoByID(r.Context(), video.ID); err == nil {
+		video = latest
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// 推送当前状态快照
+	writeSSE(w, service.VideoEvent{
+		Type:    service.VideoEventStatus,
+		VideoID: video.ID,
+		Status:  video.Status.String(),
+		Message: video.ErrorMsg,
+		Time:    video.UpdatedAt.Unix(),
 	})
+	flusher.Flush()
+
+	if isFinalVideoStatus(video.Status.String()) {
+		return
+	}
+
+	// 定期发送心跳，防止代理断开空闲连接
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			writeSSE(w, event)
+			flusher.Flush()
+
+			// 渲染结束后关闭事件流
+			if event.Type == service.VideoEventStatus && isFinalVideoStatus(event.Status) {
+				return
+			}
+		}
+	}
+}
+
+// writeSSE 写入一条SSE事件
+func writeSSE(w http.ResponseWriter, event service.VideoEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
+
+// formatOptionalTime 格式化可能为空的时间，为空时返回空字符串
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// isFinalVideoStatus 判断视频是否已进入最终状态
+func isFinalVideoStatus(status string) bool {
+	return status == model.VideoStatusCompleted.String() ||
+		status == model.VideoStatusFailed.String() ||
+		status == model.VideoStatusCancelled.String()
 }
 
 // ListVideos 获取用户视频列表
@@ -197,13 +462,31 @@ func (h *VideoHandler) ListVideos(w http.ResponseWriter, r *http.Request) {
 		}
 
 		videoResponses = append(videoResponses, types.VideoResponse{
-			ID:        video.ID,
-			Prompt:    video.Prompt,
-			VideoPath: videoURL,
-			Status:    video.Status.String(),
-			ErrorMsg:  video.ErrorMsg,
-			CreatedAt: video.CreatedAt.Format("2006-01-02 15:04:05"),
-			UpdatedAt: video.UpdatedAt.Format("2006-01-02 15:04:05"),
+			ID:            video.ID,
+			Prompt:        video.Prompt,
+			VideoPath:     videoURL,
+			DownloadURL:   h.downloadURL(video.ID, video.VideoPath),
+			ShareToken:    shareToken(&video),
+			Status:        video.Status.String(),
+			ErrorMsg:      video.ErrorMsg,
+			FailureReason: video.FailureReason,
+			Priority:      video.Priority,
+			Attempts:      video.Attempts,
+			NextAttemptAt: formatOptionalTime(video.NextAttemptAt),
+			Progress:      h.renderProgress(r, &video),
+			CreatedAt:     video.CreatedAt.Format("2006-01-02 15:04:05"),
+			UpdatedAt:     video.UpdatedAt.Format("2006-01-02 15:04:05"),
+
+			Quality:         video.Quality,
+			Width:           video.Width,
+			Height:          video.Height,
+			FrameRate:       video.FrameRate,
+			BackgroundColor: video.BackgroundColor,
+			Format:          video.Format,
+			Transparent:     video.Transparent,
+			SaveLastFrame:   video.SaveLastFrame,
+			Scenes:          video.SceneList(),
+			Concat:          video.Concat,
 		})
 	}
 
@@ -215,9 +498,30 @@ func (h *VideoHandler) ListVideos(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetQueuePositions 获取渲染队列中等待的任务总数和当前用户的任务位置
+func (h *VideoHandler) GetQueuePositions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		WriteJSON(w, http.StatusUnauthorized, types.ErrorResponse{Error: "用户未认证"})
+		return
+	}
+
+	total, positions, err := h.ctx.VideoService.GetUserQueuePositions(r.Context(), userID)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "获取队列位置失败: " + err.Error()})
+		return
+	}
+
+	resp := types.UserQueueResponse{Total: total, Positions: make([]types.QueuePosition, 0, len(positions))}
+	for _, position := range positions {
+		resp.Positions = append(resp.Positions, types.QueuePosition{VideoID: position.VideoID, Position: position.Position})
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
+
 // DeleteVideo 删除视频
 func (h *VideoHandler) DeleteVideo(w http.ResponseWriter, r *http.Request) {
-	videoID := r.PathValue("id")
+	videoID := PathParam(r, "id")
 	if videoID == "" {
 		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "视频ID不能为空"})
 		return
@@ -257,6 +561,111 @@ func (h *VideoHandler) DeleteVideo(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// CancelVideo 取消等待中或正在渲染的视频
+func (h *VideoHandler) CancelVideo(w http.ResponseWriter, r *http.Request) {
+	videoID := PathParam(r, "id")
+	if videoID == "" {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "视频ID不能为空"})
+		return
+	}
+
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		WriteJSON(w, http.StatusUnauthorized, types.ErrorResponse{Error: "用户未认证"})
+		return
+	}
+
+	// 将字符串ID转换为uint
+	videoIDUint, err := strconv.ParseUint(videoID, 10, 32)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "无效的视频ID"})
+		return
+	}
+
+	video, err := h.ctx.VideoService.GetVideoByID(r.Context(), uint(videoIDUint))
+	if err != nil {
+		WriteJSON(w, http.StatusNotFound, types.ErrorResponse{Error: "视频不存在"})
+		return
+	}
+
+	if video.UserID != userID {
+		WriteJSON(w, http.StatusForbidden, types.ErrorResponse{Error: "无权取消此视频"})
+		return
+	}
+
+	if err := h.ctx.VideoService.CancelVideo(r.Context(), video.ID); err != nil {
+		if errors.Is(err, service.ErrVideoNotCancellable) {
+			WriteJSON(w, http.StatusConflict, types.ErrorResponse{Error: err.Error()})
+			return
+		}
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "取消视频失败: " + err.Error()})
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, types.SuccessResponse{
+		Message: "已取消",
+	})
+}
+
+// ShareVideo 为已完成的视频生成公开分享链接，已分享时返回现有链接
+func (h *VideoHandler) ShareVideo(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.ownedVideo(w, r, "无权分享此视频")
+	if !ok {
+		return
+	}
+	if video.Status != model.VideoStatusCompleted {
+		WriteJSON(w, http.StatusConflict, types.ErrorResponse{Error: "视频尚未完成，无法分享"})
+		return
+	}
+
+	token, err := h.ctx.VideoService.ShareVideo(r.Context(), video.ID)
+	if err != nil {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "分享视频失败: " + err.Error()})
+		return
+	}
+	WriteJSON(w, http.StatusOK, types.ShareVideoResponse{ShareToken: token, DownloadURL: shareURL(video.ID, video.VideoPath, token)})
+}
+
+// UnshareVideo 撤销视频的公开分享链接
+func (h *VideoHandler) UnshareVideo(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.ownedVideo(w, r, "无权撤销此视频的分享")
+	if !ok {
+		return
+	}
+
+	if err := h.ctx.VideoService.RevokeVideoShare(r.Context(), video.ID); err != nil {
+		WriteJSON(w, http.StatusInternalServerError, types.ErrorResponse{Error: "撤销分享失败: " + err.Error()})
+		return
+	}
+	WriteJSON(w, http.StatusOK, types.SuccessResponse{Message: "已撤销分享"})
+}
+
+// ownedVideo 获取路径参数id对应的当前用户的视频，出错时已写入响应
+func (h *VideoHandler) ownedVideo(w http.ResponseWriter, r *http.Request, forbidden string) (*model.Video, bool) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		WriteJSON(w, http.StatusUnauthorized, types.ErrorResponse{Error: "用户未认证"})
+		return nil, false
+	}
+
+	videoIDUint, err := strconv.ParseUint(PathParam(r, "id"), 10, 32)
+	if err != nil {
+		WriteJSON(w, http.StatusBadRequest, types.ErrorResponse{Error: "无效的视频ID"})
+		return nil, false
+	}
+
+	video, err := h.ctx.VideoService.GetVideoByID(r.Context(), uint(videoIDUint))
+	if err != nil {
+		WriteJSON(w, http.StatusNotFound, types.ErrorResponse{Error: "视频不存在"})
+		return nil, false
+	}
+	if video.UserID != userID {
+		WriteJSON(w, http.StatusForbidden, types.ErrorResponse{Error: forbidden})
+		return nil, false
+	}
+	return video, true
+}
+
 // GenerateCode 生成Manim代码
 func (h *VideoHandler) GenerateCode(w http.ResponseWriter, r *http.Request) {
 	var req types.GenerateCodeRequest
@@ -300,12 +709,23 @@ func (h *VideoHandler) ValidateCode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 验证代码
-	isValid, msg := h.ctx.AIService.ValidateManimCode(r.Context(), req.Code)
+	isValid, msg, diagnostics := h.ctx.AIService.ValidateManimCodeWithDiagnostics(r.Context(), req.Code)
+
+	var diagnosticResponses []types.CodeDiagnostic
+	for _, d := range diagnostics {
+		diagnosticResponses = append(diagnosticResponses, types.CodeDiagnostic{
+			Line:    d.Line,
+			Column:  d.Column,
+			Rule:    d.Rule,
+			Message: d.Message,
+		})
+	}
 
 	WriteJSON(w, http.StatusOK, types.GenerateCodeResponse{
-		Code:    req.Code,
-		IsValid: isValid,
-		Message: msg,
+		Code:        req.Code,
+		IsValid:     isValid,
+		Message:     msg,
+		Diagnostics: diagnosticResponses,
 	})
 }
 
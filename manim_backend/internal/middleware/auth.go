@@ -51,6 +51,18 @@ func (m *AuthMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// HandleAdmin 在认证的基础上要求当前用户为管理员
+func (m *AuthMiddleware) HandleAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return m.Handle(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := GetUserFromContext(r.Context())
+		if !ok || user.Role != model.UserRoleAdmin {
+			http.Error(w, "需要管理员权限", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
 func extractToken(r *http.Request) string {
 	// 从Authorization头中提取token
 	authHeader := r.Header.Get("Authorization")
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 渲染产物类型
+const (
+	ArtifactKindVideo = "video"
+	ArtifactKindImage = "image"
+)
+
+// 输出格式，对应Manim的 --format 参数，png为保存的最后一帧
+const (
+	OutputFormatMP4  = "mp4"
+	OutputFormatGIF  = "gif"
+	OutputFormatWebM = "webm"
+	OutputFormatMOV  = "mov"
+	OutputFormatPNG  = "png"
+)
+
+var outputMIMETypes = map[string]string{
+	OutputFormatMP4:  "video/mp4",
+	OutputFormatGIF:  "image/gif",
+	OutputFormatWebM: "video/webm",
+	OutputFormatMOV:  "video/quicktime",
+	OutputFormatPNG:  "image/png",
+}
+
+// VideoArtifact 视频的一个渲染产物，一次渲染可以为每个场景产生视频和最后一帧图片等多个产物
+type VideoArtifact struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	VideoID   uint      `gorm:"index;not null" json:"video_id"`
+	Kind      string    `gorm:"size:20;not null" json:"kind"`
+	Scene     string    `gorm:"size:100" json:"scene"` // 产物对应的场景类名，拼接后的完整视频为空
+	Format    string    `gorm:"size:10;not null" json:"format"`
+	MIMEType  string    `gorm:"size:50;not null" json:"mime_type"`
+	Path      string    `gorm:"size:500;not null" json:"path"`
+	Size      int64     `json:"size"`
+	Media     MediaInfo `gorm:"embedded;embeddedPrefix:media_" json:"media"`
+	CacheKey  string    `gorm:"size:64;index" json:"cache_key"` // 产物来自渲染缓存时为缓存键，文件与其他视频共享
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MediaInfo 媒体文件的时长、编码和实际分辨率，图片的时长为0
+type MediaInfo struct {
+	Duration float64 `json:"duration"` // 秒
+	Codec    string  `gorm:"size:20" json:"codec"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+}
+
+func (VideoArtifact) TableName() string {
+	return "video_artifacts"
+}
+
+// FormatFromPath 根据文件扩展名获取输出格式
+func FormatFromPath(path string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
+
+// MIMETypeForFormat 获取输出格式对应的MIME类型，未知格式返回application/octet-stream
+func MIMETypeForFormat(format string) string {
+	if mimeType, ok := outputMIMETypes[format]; ok {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
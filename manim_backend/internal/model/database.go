@@ -37,7 +37,7 @@ func InitDB(cfg config.MySQLConfig) *gorm.DB {
 	sqlDB.SetConnMaxLifetime(5 * time.Minute)
 
 	// 自动迁移表结构
-	err = db.AutoMigrate(&User{}, &Video{})
+	err = db.AutoMigrate(&User{}, &Video{}, &VideoArtifact{}, &RenderQueueJob{}, &RenderAttempt{}, &DeadLetterJob{}, &ReconcileAudit{}, &RenderCacheEntry{})
 	if err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
@@ -45,7 +45,13 @@ func InitDB(cfg config.MySQLConfig) *gorm.DB {
 	return db
 }
 
+// InitRedis 连接Redis，未配置Host时返回nil
 func InitRedis(cfg config.RedisConfig) *redis.Client {
+	if cfg.Host == "" {
+		log.Println("未配置Redis，事件和渲染锁只在进程内生效")
+		return nil
+	}
+
 	rdb = redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
 		Password: cfg.Password,
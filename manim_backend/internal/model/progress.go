@@ -0,0 +1,23 @@
+package model
+
+// 渲染阶段
+const (
+	RenderPhaseStarting  = "starting"
+	RenderPhaseCompiling = "compiling"
+	RenderPhaseRendering = "rendering"
+	RenderPhaseMuxing    = "muxing"
+	RenderPhaseDone      = "done"
+)
+
+// RenderProgress 渲染进度，由Manim的进度条输出解析而来并保存在Redis中
+type RenderProgress struct {
+	Phase            string  `json:"phase"`
+	CurrentAnimation int     `json:"current_animation"` // 当前动画序号，从1开始
+	TotalAnimations  int     `json:"total_animations"`  // 动画总数，渲染结束前为估算值
+	Percent          float64 `json:"percent"`           // 当前动画的进度百分比
+	OverallPercent   float64 `json:"overall_percent"`   // 整体进度百分比
+	FramesRendered   int     `json:"frames_rendered"`   // 当前动画已渲染帧数
+	TotalFrames      int     `json:"total_frames"`      // 当前动画总帧数
+	ETASeconds       int     `json:"eta_seconds"`       // 当前动画预计剩余秒数，未知时为-1
+	UpdatedAt        int64   `json:"updated_at"`
+}
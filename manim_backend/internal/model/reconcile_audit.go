@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// 对账时孤儿任务的处理方式
+const (
+	ReconcileActionRequeued = "requeued"        // 重新加入渲染队列
+	ReconcileActionRetry    = "retry_scheduled" // 按一次临时失败记录，由重试策略安排重试
+	ReconcileActionFailed   = "failed"          // 无法继续渲染，标记失败
+)
+
+// ReconcileAudit 对账处理的孤儿任务：数据库中视频处于等待、排队或处理中，
+// 但队列里既没有等待中的任务也没有工作者的租约，通常是进程崩溃或Redis数据丢失后遗留的
+type ReconcileAudit struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	VideoID    uint      `gorm:"index;not null" json:"video_id"`
+	PrevStatus string    `gorm:"size:20" json:"prev_status"` // 对账前的视频状态
+	Action     string    `gorm:"size:20" json:"action"`      // 见ReconcileAction*
+	Reason     string    `gorm:"size:200" json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (ReconcileAudit) TableName() string {
+	return "reconcile_audits"
+}
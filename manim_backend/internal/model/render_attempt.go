@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// RenderAttempt 一次失败的渲染尝试，用于排查重试和死信任务
+type RenderAttempt struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	VideoID       uint      `gorm:"index;not null" json:"video_id"`
+	Attempt       int       `json:"attempt"` // 第几次渲染，从1开始
+	FailureClass  string    `gorm:"size:20" json:"failure_class"`
+	FailureReason string    `gorm:"size:50" json:"failure_reason"`
+	ErrorMsg      string    `gorm:"type:text" json:"error_msg"`
+	LogExcerpt    string    `gorm:"type:text" json:"log_excerpt"` // 渲染输出的最后一段
+	CodeHash      string    `gorm:"size:64" json:"code_hash"`     // Manim代码的SHA-256
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// DeadLetterJob 临时失败且重试次数用尽的渲染任务，管理员检查后可以重新入队。
+// 每个视频最多一条记录，重新入队时删除
+type DeadLetterJob struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	VideoID       uint      `gorm:"uniqueIndex;not null" json:"video_id"`
+	UserID        uint      `gorm:"index;not null" json:"user_id"`
+	Attempts      int       `json:"attempts"`
+	FailureReason string    `gorm:"size:50" json:"failure_reason"`
+	ErrorMsg      string    `gorm:"type:text" json:"error_msg"`
+	CodeHash      string    `gorm:"size:64" json:"code_hash"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (DeadLetterJob) TableName() string {
+	return "dead_letter_jobs"
+}
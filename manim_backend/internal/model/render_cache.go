@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// RenderCacheEntry 渲染缓存条目，键为规范化代码、渲染参数和Manim版本的SHA-256。
+// 产物文件由引用该条目的所有视频共享，RefCount为引用的视频数，降为0时删除条目和文件
+type RenderCacheEntry struct {
+	ID            uint      `gorm:"primarykey" json:"id"`
+	CacheKey      string    `gorm:"size:64;uniqueIndex;not null" json:"cache_key"`
+	SourceVideoID uint      `gorm:"index" json:"source_video_id"` // 首次渲染产生该产物的视频
+	RefCount      int       `gorm:"not null;default:0" json:"ref_count"`
+	HitCount      int64     `gorm:"not null;default:0" json:"hit_count"` // 累计命中次数
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (RenderCacheEntry) TableName() string {
+	return "render_cache_entries"
+}
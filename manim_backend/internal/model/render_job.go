@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// 渲染任务在数据库队列中的状态
+const (
+	RenderJobWaiting = "waiting" // 等待工作者领取
+	RenderJobClaimed = "claimed" // 已被工作者领取，租约到期前需要续约
+)
+
+// RenderQueueJob 数据库队列中的渲染任务，不依赖Redis的部署使用。
+// 每个视频最多一条记录，任务确认完成后删除
+type RenderQueueJob struct {
+	ID             uint       `gorm:"primarykey" json:"id"`
+	VideoID        uint       `gorm:"uniqueIndex;not null" json:"video_id"`
+	UserID         uint       `gorm:"index;not null" json:"user_id"`
+	Status         string     `gorm:"size:20;index:idx_render_jobs_order,priority:1;not null" json:"status"`
+	Band           int        `gorm:"index:idx_render_jobs_order,priority:2" json:"band"`  // 优先级档位，见JobPriorityBand
+	Round          int64      `gorm:"index:idx_render_jobs_order,priority:3" json:"round"` // 公平调度轮次，同一档位内轮次小的先调度
+	Consumer       string     `gorm:"size:100" json:"consumer"`
+	LeaseExpiresAt *time.Time `gorm:"index" json:"lease_expires_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+func (RenderQueueJob) TableName() string {
+	return "render_jobs"
+}
@@ -6,11 +6,25 @@ import (
 	"gorm.io/gorm"
 )
 
+// 用户角色，管理员可以提交最高优先级的渲染任务
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+)
+
+// 用户等级，决定可用的渲染参数上限
+const (
+	UserTierFree = "free"
+	UserTierPro  = "pro"
+)
+
 type User struct {
 	ID        uint           `gorm:"primarykey" json:"id"`
 	Username  string         `gorm:"uniqueIndex;size:50;not null" json:"username"`
 	Email     string         `gorm:"uniqueIndex;size:100;not null" json:"email"`
 	Password  string         `gorm:"size:255;not null" json:"-"`
+	Tier      string         `gorm:"size:20;default:free" json:"tier"`
+	Role      string         `gorm:"size:20;default:user" json:"role"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
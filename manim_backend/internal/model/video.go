@@ -1,6 +1,8 @@
 package model
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -14,21 +16,143 @@ const (
 	VideoStatusProcessing
 	VideoStatusCompleted
 	VideoStatusFailed
+	VideoStatusCancelled // 用户取消，不再渲染
+)
+
+// 渲染失败原因
+const (
+	FailureReasonSandboxCPU        = "sandbox_cpu_limit"
+	FailureReasonSandboxMemory     = "sandbox_memory_limit"
+	FailureReasonSandboxFileSize   = "sandbox_file_size_limit"
+	FailureReasonSandboxProcesses  = "sandbox_process_limit"
+	FailureReasonSandboxNetwork    = "sandbox_network_denied"
+	FailureReasonSandboxFilesystem = "sandbox_filesystem_denied"
+	FailureReasonInvalidOutput     = "invalid_output" // 输出文件缺失或未通过媒体校验
+	FailureReasonTimeout           = "timeout"
+	FailureReasonOOMKilled         = "oom_killed"
+	FailureReasonDiskFull          = "disk_full"
+	FailureReasonLockContention    = "lock_contention"
+	FailureReasonStorage           = "storage_error" // 工作目录、最终目录或数据库写入失败
+	FailureReasonWorkerLost        = "worker_lost"   // 处理中的任务没有租约，渲染工作者在完成前退出
+	FailureReasonPythonSyntax      = "python_syntax_error"
+	FailureReasonPythonName        = "python_name_error"
+	FailureReasonPythonError       = "python_error" // 其他未捕获的Python异常
+	FailureReasonLatex             = "latex_error"
+	FailureReasonInvalidScene      = "invalid_scene" // 选择的场景不存在于代码中
+	FailureReasonConcat            = "concat_error"  // ffmpeg拼接场景视频失败，输出中没有可识别的原因
+)
+
+// 失败类别：临时失败（超时、内存不足被杀、磁盘已满、锁冲突等）按退避时间自动重试，
+// 永久失败（代码错误、LaTeX错误等）重试也不会成功，直接标记失败
+const (
+	FailureClassTransient = "transient"
+	FailureClassPermanent = "permanent"
 )
 
 type Video struct {
-	ID          uint           `gorm:"primarykey" json:"id"`
-	UserID      uint           `gorm:"index;not null" json:"user_id"`
-	Title       string         `gorm:"size:200;not null" json:"title"`
-	Description string         `gorm:"type:text" json:"description"`
-	Prompt      string         `gorm:"type:text;not null" json:"prompt"`
-	ManimCode   string         `gorm:"type:longtext" json:"manim_code"`
-	VideoPath   string         `gorm:"size:500" json:"video_path"`
-	Status      VideoStatus    `gorm:"default:0" json:"status"`
-	ErrorMsg    string         `gorm:"type:text" json:"error_msg"`
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	ID          uint        `gorm:"primarykey" json:"id"`
+	UserID      uint        `gorm:"index;not null" json:"user_id"`
+	Title       string      `gorm:"size:200;not null" json:"title"`
+	Description string      `gorm:"type:text" json:"description"`
+	Prompt      string      `gorm:"type:text;not null" json:"prompt"`
+	ManimCode   string      `gorm:"type:longtext" json:"manim_code"`
+	VideoPath   string      `gorm:"size:500" json:"video_path"`
+	Status      VideoStatus `gorm:"default:0" json:"status"`
+	ErrorMsg    string      `gorm:"type:text" json:"error_msg"`
+	// FailureReason 机器可读的失败原因，见FailureReason*常量
+	FailureReason string `gorm:"size:50" json:"failure_reason"`
+	// Priority 渲染任务优先级，见JobPriority*常量
+	Priority string `gorm:"size:20;default:interactive" json:"priority"`
+	// Attempts 已开始的渲染次数，NextAttemptAt 临时失败后下次重试的时间
+	Attempts      int            `gorm:"default:0" json:"attempts"`
+	NextAttemptAt *time.Time     `gorm:"index" json:"next_attempt_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// 渲染参数
+	RenderOptions `gorm:"embedded"`
+	// 主视频的媒体信息，渲染完成后由ffprobe校验得到
+	Media MediaInfo `gorm:"embedded;embeddedPrefix:media_" json:"media"`
+
+	// ShareToken 公开分享的令牌，持有令牌的人不登录也能下载该视频，为空表示未分享
+	ShareToken *string `gorm:"size:64;uniqueIndex" json:"-"`
+}
+
+// 渲染任务优先级：管理员任务最先调度，其次是交互式预览，批量任务最后
+const (
+	JobPriorityAdmin       = "admin"
+	JobPriorityInteractive = "interactive"
+	JobPriorityBatch       = "batch"
+)
+
+var jobPriorityBands = map[string]int{
+	JobPriorityAdmin:       0,
+	JobPriorityInteractive: 1,
+	JobPriorityBatch:       2,
+}
+
+// JobPriorityBand 优先级对应的调度档位，数值越小越先调度，未知优先级按交互式处理
+func JobPriorityBand(priority string) int {
+	if band, ok := jobPriorityBands[priority]; ok {
+		return band
+	}
+	return jobPriorityBands[JobPriorityInteractive]
+}
+
+// ValidJobPriority 判断是否为支持的优先级
+func ValidJobPriority(priority string) bool {
+	_, ok := jobPriorityBands[priority]
+	return ok
+}
+
+// 渲染质量预设，对应Manim的 -ql/-qm/-qh/-qp/-qk
+const (
+	RenderQualityLow    = "l"
+	RenderQualityMedium = "m"
+	RenderQualityHigh   = "h"
+	RenderQualityPro    = "p"
+	RenderQualityFourK  = "k"
+)
+
+// RenderOptions 视频渲染参数，保存的是校验后的最终值
+type RenderOptions struct {
+	Quality         string `gorm:"size:1" json:"quality"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	FrameRate       int    `json:"frame_rate"`
+	BackgroundColor string `gorm:"size:20" json:"background_color"`
+	Format          string `gorm:"size:10" json:"format"`
+	Transparent     bool   `json:"transparent"`
+	SaveLastFrame   bool   `json:"save_last_frame"`
+	Scenes          string `gorm:"size:500" json:"scenes"` // 逗号分隔的场景类名，按渲染和拼接顺序排列
+	Concat          bool   `json:"concat"`                 // 多个场景时拼接为一个完整视频
+}
+
+// SceneList 要渲染的场景类名列表，为空表示未指定
+func (o RenderOptions) SceneList() []string {
+	if o.Scenes == "" {
+		return nil
+	}
+	return strings.Split(o.Scenes, ",")
+}
+
+// OutputFolder Manim按 {高度}p{帧率} 命名的输出目录，例如 720p30
+func (o RenderOptions) OutputFolder() string {
+	return fmt.Sprintf("%dp%d", o.Height, o.FrameRate)
+}
+
+// MovieFormat Manim实际输出的视频格式，透明背景的mp4会输出为mov
+func (o RenderOptions) MovieFormat() string {
+	switch {
+	case o.Format == "" || o.Format == OutputFormatMP4:
+		if o.Transparent {
+			return OutputFormatMOV
+		}
+		return OutputFormatMP4
+	default:
+		return o.Format
+	}
 }
 
 func (Video) TableName() string {
@@ -47,6 +171,8 @@ func (s VideoStatus) String() string {
 		return "completed"
 	case VideoStatusFailed:
 		return "failed"
+	case VideoStatusCancelled:
+		return "cancelled"
 	default:
 		return "unknown"
 	}
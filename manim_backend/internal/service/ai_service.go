@@ -12,7 +12,8 @@ import (
 )
 
 type AIService struct {
-	client *openai.Client
+	client   *openai.Client
+	analyzer *CodeAnalyzer
 }
 
 func NewAIService(cfg config.OpenAIConfig) *AIService {
@@ -404,29 +405,94 @@ func (s *AIService) generateImprovementSuggestions(code string) string {
 	return ""
 }
 
+// SetCodeAnalyzer 设置代码安全检查器
+func (s *AIService) SetCodeAnalyzer(analyzer *CodeAnalyzer) {
+	s.analyzer = analyzer
+}
+
 // ValidateManimCode 验证Manim代码的语法和布局质量
 func (s *AIService) ValidateManimCode(ctx context.Context, code string) (bool, string) {
-	// 基本验证：检查是否包含必要的Manim元素
-	if !strings.Contains(code, "class") || !strings.Contains(code, "Scene") {
-		return false, "代码必须包含Scene类定义"
+	isValid, msg, _ := s.ValidateManimCodeWithDiagnostics(ctx, code)
+	return isValid, msg
+}
+
+// ValidateManimCodeWithDiagnostics 验证Manim代码，同时返回带行列号的诊断信息
+func (s *AIService) ValidateManimCodeWithDiagnostics(ctx context.Context, code string) (bool, string, []CodeDiagnostic) {
+	analyzer := s.analyzer
+	if analyzer == nil {
+		analyzer = NewCodeAnalyzer(config.CodeSafetyConfig{})
+	}
+
+	// 安全检查：语法错误、危险导入和危险调用
+	analysis := analyzer.Analyze(code)
+	if len(analysis.Diagnostics) > 0 {
+		msg := "代码安全检查未通过: " + analysis.Diagnostics[0].String()
+		if len(analysis.Diagnostics) > 1 {
+			msg += fmt.Sprintf(" 等%d个问题", len(analysis.Diagnostics))
+		}
+		return false, msg, analysis.Diagnostics
+	}
+
+	// 基本验证：检查是否包含继承自Scene的类及其construct方法
+	var scene *pyClass
+	for i, class := range analysis.Module.Classes {
+		if isSceneClass(class) {
+			scene = &analysis.Module.Classes[i]
+			break
+		}
+	}
+	if scene == nil {
+		return false, "代码必须包含Scene类定义", nil
 	}
 
-	if !strings.Contains(code, "def construct") {
-		return false, "代码必须包含construct方法"
+	hasConstruct := false
+	for _, method := range scene.Methods {
+		if method == "construct" {
+			hasConstruct = true
+		}
+	}
+	if !hasConstruct {
+		return false, "代码必须包含construct方法", nil
 	}
 
 	// 检查是否有动画操作
-	if !strings.Contains(code, "self.play") && !strings.Contains(code, "self.wait") {
-		return false, "代码应该包含动画操作（play或wait）"
+	if !hasSelfCall(analysis.Module.Tokens, "play", "wait") {
+		return false, "代码应该包含动画操作（play或wait）", nil
 	}
 
 	// 布局质量检查
 	layoutIssues := s.checkLayoutQuality(code)
 	if layoutIssues != "" {
-		return true, fmt.Sprintf("代码验证通过，但有以下布局建议：%s", layoutIssues)
+		return true, fmt.Sprintf("代码验证通过，但有以下布局建议：%s", layoutIssues), nil
+	}
+
+	return true, "代码验证通过，布局质量良好", nil
+}
+
+// isSceneClass 判断类是否直接继承自Manim的场景类（Scene、ThreeDScene等）
+func isSceneClass(class pyClass) bool {
+	for _, base := range class.Bases {
+		parts := strings.Split(base, ".")
+		if strings.HasSuffix(parts[len(parts)-1], "Scene") {
+			return true
+		}
 	}
+	return false
+}
 
-	return true, "代码验证通过，布局质量良好"
+// hasSelfCall 判断代码中是否调用了self上的指定方法
+func hasSelfCall(tokens []pyToken, methods ...string) bool {
+	for i := 0; i+3 < len(tokens); i++ {
+		if tokens[i].Value != "self" || tokens[i+1].Value != "." || tokens[i+3].Value != "(" {
+			continue
+		}
+		for _, method := range methods {
+			if tokens[i+2].Value == method {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // checkLayoutQuality 检查代码的布局质量
@@ -72,6 +72,18 @@ class MyAnimation(Scene):
 			isValid: false,
 			message: "代码应该包含动画操作（play或wait）",
 		},
+		{
+			name: "导入危险模块",
+			code: `from manim import *
+import os
+
+class MyAnimation(Scene):
+    def construct(self):
+        os.system("rm -rf /")
+        self.play(Create(Circle()))`,
+			isValid: false,
+			message: "代码安全检查未通过: 第2行第8列: 禁止导入模块 os",
+		},
 		{
 			name: "有布局建议的代码",
 			code: `from manim import *
@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+)
+
+// 渲染产物存储实现
+const (
+	StorageBackendLocal = "local" // 本地目录，只适合单实例部署
+	StorageBackendS3    = "s3"    // S3兼容的对象存储，多个实例共享
+)
+
+var (
+	// ErrBlobNotFound 文件不存在
+	ErrBlobNotFound = errors.New("文件不存在")
+	// ErrInvalidBlobKey 文件键为空、是绝对路径或包含..等不规范的路径
+	ErrInvalidBlobKey = errors.New("无效的文件路径")
+	// ErrPresignNotSupported 存储不支持预签名地址，需要由服务直接提供文件
+	ErrPresignNotSupported = errors.New("存储不支持预签名地址")
+)
+
+// BlobInfo 存储中文件的元信息
+type BlobInfo struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// BlobStore 渲染产物的存储。文件键为以/分隔的相对路径，如 videos/{用户ID}/{视频ID}/{文件名}，
+// 同时保存在产物记录和视频的video_path中
+type BlobStore interface {
+	// Put 写入文件，已存在时覆盖，size为内容长度
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Get 读取文件，调用方负责关闭，文件不存在时返回ErrBlobNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error)
+	// Stat 获取文件的元信息，文件不存在时返回ErrBlobNotFound
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	// Delete 删除文件，文件不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// PresignGet 生成有效期为expires的下载地址，不支持时返回ErrPresignNotSupported
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// NewBlobStore 根据配置创建产物存储
+func NewBlobStore(cfg config.StorageConfig) (BlobStore, error) {
+	switch cfg.Backend {
+	case "", StorageBackendLocal:
+		return NewLocalBlobStore(cfg.Local.Root), nil
+	case StorageBackendS3:
+		return NewS3BlobStore(cfg.S3)
+	default:
+		return nil, fmt.Errorf("未知的存储实现: %s", cfg.Backend)
+	}
+}
+
+// validateBlobKey 检查文件键是规范的相对路径，不能逃出存储根目录
+func validateBlobKey(key string) error {
+	if key == "" || strings.ContainsAny(key, "\\\x00") || path.IsAbs(key) || filepath.IsAbs(key) || path.Clean(key) != key {
+		return ErrInvalidBlobKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || part == "." {
+			return ErrInvalidBlobKey
+		}
+	}
+	return nil
+}
+
+// LocalBlobStore 将文件保存在本地目录中，不支持预签名地址。读取时解析符号链接，
+// 不提供经由链接指向其他位置的文件
+type LocalBlobStore struct {
+	root string
+}
+
+func NewLocalBlobStore(root string) *LocalBlobStore {
+	if root == "" {
+		root = "."
+	}
+	return &LocalBlobStore{root: root}
+}
+
+// Root 存储的根目录
+func (s *LocalBlobStore) Root() string {
+	return s.root
+}
+
+// path 文件键对应的本地路径
+func (s *LocalBlobStore) path(key string) (string, error) {
+	if err := validateBlobKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// resolve 已存在的文件键对应的本地路径，要求路径经符号链接解析后不变，
+// 拒绝链接到根目录外或根目录内其他位置的文件
+func (s *LocalBlobStore) resolve(key string) (string, error) {
+	target, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := s.contain(target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// contain 检查本地路径位于根目录下且路径中没有符号链接，路径不存在时返回ErrBlobNotFound
+func (s *LocalBlobStore) contain(target string) error {
+	root, err := filepath.Abs(s.root)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ErrInvalidBlobKey
+	}
+
+	// 根目录本身可以是符号链接，根目录以下的部分解析后须与原路径一致
+	realRoot, err := filepath.EvalSymlinks(root)
+	if os.IsNotExist(err) {
+		return ErrBlobNotFound
+	}
+	if err != nil {
+		return err
+	}
+	realTarget, err := filepath.EvalSymlinks(abs)
+	if os.IsNotExist(err) {
+		return ErrBlobNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if realTarget != filepath.Join(realRoot, rel) {
+		return ErrInvalidBlobKey
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := s.contain(filepath.Dir(target)); err != nil {
+		return err
+	}
+
+	// 先写入同目录的临时文件再重命名，读取方不会看到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("写入 %d 字节，预期 %d 字节", written, size)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (s *LocalBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error) {
+	target, err := s.resolve(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	file, err := os.Open(target)
+	if os.IsNotExist(err) {
+		return nil, BlobInfo{}, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, BlobInfo{}, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, BlobInfo{}, ErrBlobNotFound
+	}
+	return file, localBlobInfo(key, info), nil
+}
+
+func (s *LocalBlobStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	target, err := s.resolve(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info, err := os.Stat(target)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return localBlobInfo(key, info), nil
+}
+
+// Delete 删除文件，之后移除变空的视频目录
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	// 不经由链接到其他位置的目录删除文件
+	if err := s.contain(filepath.Dir(target)); err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// 目录不为空时删除失败，忽略
+	os.Remove(filepath.Dir(target))
+	return nil
+}
+
+func (s *LocalBlobStore) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return "", ErrPresignNotSupported
+}
+
+func localBlobInfo(key string, info os.FileInfo) BlobInfo {
+	return BlobInfo{
+		Size:        info.Size(),
+		ContentType: model.MIMETypeForFormat(model.FormatFromPath(key)),
+		ModTime:     info.ModTime(),
+	}
+}
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"manim-backend/internal/config"
+)
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3DateFormat      = "20060102T150405Z"
+	// s3MaxPresignExpires SigV4预签名地址的最长有效期
+	s3MaxPresignExpires = 7 * 24 * time.Hour
+)
+
+// S3BlobStore S3兼容的对象存储（AWS S3、MinIO等）。请求使用SigV4签名，上传不计算内容哈希（UNSIGNED-PAYLOAD），
+// 文件流式上传，不需要读入内存
+type S3BlobStore struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+	now       func() time.Time
+}
+
+func NewS3BlobStore(cfg config.S3StorageConfig) (*S3BlobStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3存储需要配置Endpoint和Bucket")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("无效的S3地址: %s", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3BlobStore{
+		endpoint:  &url.URL{Scheme: endpoint.Scheme, Host: endpoint.Host},
+		region:    region,
+		bucket:    cfg.Bucket,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		pathStyle: cfg.PathStyle,
+		client:    &http.Client{},
+		now:       time.Now,
+	}, nil
+}
+
+// objectURL 对象的地址，路径按SigV4规则编码
+func (s *S3BlobStore) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+		u.RawPath = "/" + s3Escape(s.bucket, true) + "/" + s3Escape(key, false)
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+		u.RawPath = "/" + s3Escape(key, false)
+	}
+	return &u
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	if size < 0 {
+		return errors.New("上传到S3需要指定文件大小")
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, body, size, contentType)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, "")
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	return resp.Body, s3BlobInfo(resp), nil
+}
+
+func (s *S3BlobStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0, "")
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp.Body.Close()
+	return s3BlobInfo(resp), nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if errors.Is(err, ErrBlobNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PresignGet 生成查询参数签名的下载地址，客户端不需要凭证即可在有效期内下载
+func (s *S3BlobStore) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if err := validateBlobKey(key); err != nil {
+		return "", err
+	}
+	if expires < time.Second || expires > s3MaxPresignExpires {
+		return "", fmt.Errorf("预签名有效期须在1秒到%v之间", s3MaxPresignExpires)
+	}
+
+	now := s.now().UTC()
+	u := s.objectURL(key)
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format(s3DateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	stringToSign := s3StringToSign(http.MethodGet, u.EscapedPath(), query, "host:"+u.Host+"\n", "host", s3UnsignedPayload, now, s.scope(now))
+	query.Set("X-Amz-Signature", s3Signature(s.secretKey, now, s.region, stringToSign))
+	u.RawQuery = s3CanonicalQuery(query)
+	return u.String(), nil
+}
+
+// do 发送签名请求，对象不存在时返回ErrBlobNotFound，其他非2xx响应返回包含响应内容的错误
+func (s *S3BlobStore) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	if err := validateBlobKey(key); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, s.now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("S3请求 %s %s 失败: %s %s", method, key, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return resp, nil
+}
+
+// sign 为请求添加SigV4签名头
+func (s *S3BlobStore) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format(s3DateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + s3UnsignedPayload + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	scope := s.scope(now)
+	stringToSign := s3StringToSign(req.Method, req.URL.EscapedPath(), req.URL.Query(), canonicalHeaders, signedHeaders, s3UnsignedPayload, now, scope)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, scope, signedHeaders, s3Signature(s.secretKey, now, s.region, stringToSign)))
+}
+
+// scope 签名的凭证范围：日期/区域/s3/aws4_request
+func (s *S3BlobStore) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+func s3BlobInfo(resp *http.Response) BlobInfo {
+	info := BlobInfo{Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info
+}
+
+// s3StringToSign 按SigV4规则由规范请求计算待签名字符串，canonicalHeaders以换行结尾
+func s3StringToSign(method, escapedPath string, query url.Values, canonicalHeaders, signedHeaders, payloadHash string, now time.Time, scope string) string {
+	canonicalRequest := strings.Join([]string{
+		method,
+		escapedPath,
+		s3CanonicalQuery(query),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{s3Algorithm, now.Format(s3DateFormat), scope, hex.EncodeToString(hash[:])}, "\n")
+}
+
+// s3Signature 使用由密钥、日期和区域派生的签名密钥计算签名
+func s3Signature(secretKey string, now time.Time, region, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalQuery 按参数名和值排序并编码的查询字符串
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape 按SigV4规则编码，只保留字母、数字和-_.~，encodeSlash为false时保留路径中的/
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,457 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testS3Bucket    = "manim-videos"
+	testS3AccessKey = "minioadmin"
+	testS3SecretKey = "minioadmin-secret"
+)
+
+// fakeS3Object 模拟存储中的对象
+type fakeS3Object struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// fakeS3Server 模拟MinIO的对象存储接口，校验SigV4签名和预签名地址的有效期
+type fakeS3Server struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+	now     time.Time
+}
+
+func newFakeS3Server(t *testing.T) *fakeS3Server {
+	fake := &fakeS3Server{objects: map[string]fakeS3Object{}, now: time.Now().UTC()}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.handle))
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+// newTestS3BlobStore 创建连接fake的S3存储，签名时间使用fake的时钟
+func newTestS3BlobStore(t *testing.T, fake *fakeS3Server) *S3BlobStore {
+	store, err := NewS3BlobStore(config.S3StorageConfig{
+		Endpoint:  fake.URL,
+		Region:    "us-east-1",
+		Bucket:    testS3Bucket,
+		AccessKey: testS3AccessKey,
+		SecretKey: testS3SecretKey,
+		PathStyle: true,
+	})
+	require.NoError(t, err)
+	store.now = fake.clock
+	return store
+}
+
+func (f *fakeS3Server) clock() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeS3Server) advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func (f *fakeS3Server) object(key string) (fakeS3Object, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[key]
+	return object, ok
+}
+
+func (f *fakeS3Server) handle(w http.ResponseWriter, r *http.Request) {
+	prefix := "/" + testS3Bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if code := f.verify(r); code != "" {
+		f.fail(w, http.StatusForbidden, code)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			f.fail(w, http.StatusLengthRequired, "MissingContentLength")
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = fakeS3Object{data: data, contentType: r.Header.Get("Content-Type"), modTime: f.now}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet, http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+		w.Header().Set("Last-Modified", object.modTime.Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(object.data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// verify 按服务端收到的请求重新计算签名，返回S3错误码，签名有效时返回空字符串
+func (f *fakeS3Server) verify(r *http.Request) string {
+	now := f.clock()
+	query := r.URL.Query()
+
+	// 预签名地址
+	if signature := query.Get("X-Amz-Signature"); signature != "" {
+		signedAt, err := time.Parse(s3DateFormat, query.Get("X-Amz-Date"))
+		if err != nil {
+			return "AuthorizationQueryParametersError"
+		}
+		expires, _ := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if now.After(signedAt.Add(time.Duration(expires) * time.Second)) {
+			return "AccessDenied"
+		}
+		query.Del("X-Amz-Signature")
+		scope := strings.TrimPrefix(query.Get("X-Amz-Credential"), testS3AccessKey+"/")
+		stringToSign := s3StringToSign(r.Method, r.URL.EscapedPath(), query, "host:"+r.Host+"\n", "host", s3UnsignedPayload, signedAt, scope)
+		if signature != s3Signature(testS3SecretKey, signedAt, "us-east-1", stringToSign) {
+			return "SignatureDoesNotMatch"
+		}
+		return ""
+	}
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, s3Algorithm+" ") {
+		return "AccessDenied"
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(auth, s3Algorithm+" "), ", ") {
+		if name, value, ok := strings.Cut(field, "="); ok {
+			fields[name] = value
+		}
+	}
+	if !strings.HasPrefix(fields["Credential"], testS3AccessKey+"/") {
+		return "InvalidAccessKeyId"
+	}
+	signedAt, err := time.Parse(s3DateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "AccessDenied"
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	scope := strings.TrimPrefix(fields["Credential"], testS3AccessKey+"/")
+	stringToSign := s3StringToSign(r.Method, r.URL.EscapedPath(), query, canonicalHeaders.String(), fields["SignedHeaders"], r.Header.Get("X-Amz-Content-Sha256"), signedAt, scope)
+	if fields["Signature"] != s3Signature(testS3SecretKey, signedAt, "us-east-1", stringToSign) {
+		return "SignatureDoesNotMatch"
+	}
+	return ""
+}
+
+func (f *fakeS3Server) fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, "<Error><Code>"+code+"</Code></Error>")
+}
+
+func TestValidateBlobKey(t *testing.T) {
+	for _, key := range []string{"videos/1/2/Circle.mp4", "a.gif", "videos/1/2/场景 A.mp4"} {
+		assert.NoError(t, validateBlobKey(key), key)
+	}
+	for _, key := range []string{"", "/etc/passwd", "../secret", "videos/../../x", "videos//x", "videos/./x", "videos/x/", `videos\x`, "videos/\x00"} {
+		assert.ErrorIs(t, validateBlobKey(key), ErrInvalidBlobKey, key)
+	}
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewLocalBlobStore(t.TempDir())
+	key := "videos/1/2/Circle.mp4"
+
+	_, err := store.Stat(ctx, key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+
+	data := []byte("fake mp4")
+	assert.NoError(t, store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "video/mp4"))
+	info, err := store.Stat(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.Equal(t, "video/mp4", info.ContentType)
+
+	body, _, err := store.Get(ctx, key)
+	if assert.NoError(t, err) {
+		got, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, data, got)
+	}
+
+	// 长度不符时不留下文件
+	assert.Error(t, store.Put(ctx, "videos/1/3/Square.mp4", bytes.NewReader(data), 100, "video/mp4"))
+	_, err = store.Stat(ctx, "videos/1/3/Square.mp4")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+
+	// 目录不是文件
+	_, err = store.Stat(ctx, "videos/1/2")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	_, _, err = store.Get(ctx, "../outside.mp4")
+	assert.ErrorIs(t, err, ErrInvalidBlobKey)
+
+	_, err = store.PresignGet(ctx, key, time.Minute)
+	assert.ErrorIs(t, err, ErrPresignNotSupported)
+
+	// 删除后移除空的视频目录，重复删除不报错
+	assert.NoError(t, store.Delete(ctx, key))
+	assert.NoDirExists(t, filepath.Join(store.Root(), "videos", "1", "2"))
+	assert.NoError(t, store.Delete(ctx, key))
+}
+
+func TestLocalBlobStore_Symlinks(t *testing.T) {
+	ctx := context.Background()
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "secret.mp4")
+	require.NoError(t, os.WriteFile(secret, []byte("secret"), 0644))
+
+	// 根目录本身是符号链接时正常使用
+	root := filepath.Join(t.TempDir(), "root")
+	require.NoError(t, os.Symlink(t.TempDir(), root))
+	store := NewLocalBlobStore(root)
+	data := []byte("fake mp4")
+	require.NoError(t, store.Put(ctx, "videos/1/2/Circle.mp4", bytes.NewReader(data), int64(len(data)), "video/mp4"))
+	_, err := store.Stat(ctx, "videos/1/2/Circle.mp4")
+	assert.NoError(t, err)
+
+	// 链接到根目录外的文件和目录
+	require.NoError(t, os.Symlink(secret, filepath.Join(root, "videos", "1", "2", "link.mp4")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "videos", "1", "3")))
+	// 链接到根目录内的其他文件
+	require.NoError(t, os.Symlink(filepath.Join(root, "videos", "1", "2", "Circle.mp4"), filepath.Join(root, "videos", "1", "2", "alias.mp4")))
+
+	for _, key := range []string{"videos/1/2/link.mp4", "videos/1/3/secret.mp4", "videos/1/2/alias.mp4"} {
+		_, _, err := store.Get(ctx, key)
+		assert.ErrorIs(t, err, ErrInvalidBlobKey, key)
+		_, err = store.Stat(ctx, key)
+		assert.ErrorIs(t, err, ErrInvalidBlobKey, key)
+	}
+
+	// 不经由链接的目录写入或删除根目录外的文件
+	assert.ErrorIs(t, store.Put(ctx, "videos/1/3/new.mp4", bytes.NewReader(data), int64(len(data)), "video/mp4"), ErrInvalidBlobKey)
+	assert.NoFileExists(t, filepath.Join(outside, "new.mp4"))
+	assert.ErrorIs(t, store.Delete(ctx, "videos/1/3/secret.mp4"), ErrInvalidBlobKey)
+	assert.FileExists(t, secret)
+}
+
+func TestNewBlobStore(t *testing.T) {
+	store, err := NewBlobStore(config.StorageConfig{})
+	assert.NoError(t, err)
+	assert.IsType(t, &LocalBlobStore{}, store)
+
+	store, err = NewBlobStore(config.StorageConfig{Backend: StorageBackendS3, S3: config.S3StorageConfig{Endpoint: "http://127.0.0.1:9000", Bucket: "videos"}})
+	assert.NoError(t, err)
+	assert.IsType(t, &S3BlobStore{}, store)
+
+	_, err = NewBlobStore(config.StorageConfig{Backend: StorageBackendS3})
+	assert.Error(t, err)
+	_, err = NewBlobStore(config.StorageConfig{Backend: StorageBackendS3, S3: config.S3StorageConfig{Endpoint: "127.0.0.1:9000", Bucket: "videos"}})
+	assert.Error(t, err)
+	_, err = NewBlobStore(config.StorageConfig{Backend: "gcs"})
+	assert.Error(t, err)
+}
+
+func TestS3BlobStore(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3Server(t)
+	store := newTestS3BlobStore(t, fake)
+
+	// 文件名包含空格和中文时路径编码与签名一致
+	key := "videos/1/2/场景 A+B.mp4"
+	data := []byte("fake mp4 content")
+	assert.NoError(t, store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "video/mp4"))
+	object, ok := fake.object(key)
+	if assert.True(t, ok) {
+		assert.Equal(t, data, object.data)
+		assert.Equal(t, "video/mp4", object.contentType)
+	}
+
+	info, err := store.Stat(ctx, key)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+	assert.Equal(t, "video/mp4", info.ContentType)
+	assert.False(t, info.ModTime.IsZero())
+
+	body, info, err := store.Get(ctx, key)
+	if assert.NoError(t, err) {
+		got, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, data, got)
+		assert.Equal(t, int64(len(data)), info.Size)
+	}
+
+	// 空文件
+	assert.NoError(t, store.Put(ctx, "videos/1/2/empty.png", bytes.NewReader(nil), 0, "image/png"))
+	info, err = store.Stat(ctx, "videos/1/2/empty.png")
+	assert.NoError(t, err)
+	assert.Zero(t, info.Size)
+
+	assert.NoError(t, store.Delete(ctx, key))
+	_, err = store.Stat(ctx, key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	_, _, err = store.Get(ctx, key)
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	assert.NoError(t, store.Delete(ctx, key))
+
+	assert.ErrorIs(t, store.Put(ctx, "../x.mp4", bytes.NewReader(data), int64(len(data)), "video/mp4"), ErrInvalidBlobKey)
+	assert.Error(t, store.Put(ctx, key, bytes.NewReader(data), -1, "video/mp4"))
+}
+
+func TestS3BlobStore_WrongCredentials(t *testing.T) {
+	fake := newFakeS3Server(t)
+	store := newTestS3BlobStore(t, fake)
+	store.secretKey = "wrong"
+
+	err := store.Put(context.Background(), "videos/1/2/a.mp4", strings.NewReader("x"), 1, "video/mp4")
+	assert.ErrorContains(t, err, "SignatureDoesNotMatch")
+	assert.NotErrorIs(t, err, ErrBlobNotFound)
+}
+
+func TestS3BlobStore_PresignGet(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeS3Server(t)
+	store := newTestS3BlobStore(t, fake)
+
+	key := "videos/1/2/Circle Scene.mp4"
+	data := []byte("presigned content")
+	require.NoError(t, store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "video/mp4"))
+
+	presigned, err := store.PresignGet(ctx, key, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(presigned, fake.URL+"/"+testS3Bucket+"/videos/1/2/Circle%20Scene.mp4?"))
+
+	// 不带凭证即可下载
+	resp, err := http.Get(presigned)
+	require.NoError(t, err)
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, data, got)
+
+	// 篡改路径后签名无效
+	resp, err = http.Get(strings.Replace(presigned, "Circle%20Scene", "Other", 1))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// 过期后拒绝
+	fake.advance(2 * time.Minute)
+	resp, err = http.Get(presigned)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, err = store.PresignGet(ctx, key, 8*24*time.Hour)
+	assert.Error(t, err)
+	_, err = store.PresignGet(ctx, "/abs.mp4", time.Minute)
+	assert.ErrorIs(t, err, ErrInvalidBlobKey)
+}
+
+func TestS3BlobStore_VirtualHostedURL(t *testing.T) {
+	store, err := NewS3BlobStore(config.S3StorageConfig{Endpoint: "https://s3.example.com", Bucket: "videos", AccessKey: "ak", SecretKey: "sk"})
+	require.NoError(t, err)
+
+	u := store.objectURL("videos/1/2/a b.mp4")
+	assert.Equal(t, "videos.s3.example.com", u.Host)
+	assert.Equal(t, "/videos/1/2/a%20b.mp4", u.EscapedPath())
+}
+
+func TestManimService_GenerateVideo_S3Storage(t *testing.T) {
+	manimSvc, videoSvc, _ := setupTestManimService(t)
+	ctx := context.Background()
+	fake := newFakeS3Server(t)
+	videoSvc.SetBlobStore(newTestS3BlobStore(t, fake), time.Minute)
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	require.NoError(t, err)
+	require.NoError(t, manimSvc.GenerateVideo(ctx, video.ID, testManimCode))
+
+	// 产物上传到对象存储，本地不保留文件
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.VideoStatusCompleted, updated.Status)
+	assert.Equal(t, "videos/1/"+strconv.Itoa(int(video.ID))+"/PythagoreanTheorem.mp4", updated.VideoPath)
+	object, ok := fake.object(updated.VideoPath)
+	require.True(t, ok)
+	assert.Equal(t, "video/mp4", object.contentType)
+	assert.NoDirExists(t, "videos")
+
+	artifacts, err := videoSvc.GetVideoArtifacts(ctx, video.ID)
+	require.NoError(t, err)
+	require.Len(t, artifacts, 1)
+	assert.Equal(t, int64(len(object.data)), artifacts[0].Size)
+
+	// 下载地址指向对象存储
+	presigned, err := videoSvc.PresignArtifact(ctx, updated.VideoPath)
+	require.NoError(t, err)
+	resp, err := http.Get(presigned)
+	require.NoError(t, err)
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, object.data, got)
+
+	// 删除视频时删除对象
+	require.NoError(t, videoSvc.DeleteVideo(ctx, video.ID))
+	_, ok = fake.object(updated.VideoPath)
+	assert.False(t, ok)
+}
+
+func TestManimService_GenerateVideo_StorageFailure(t *testing.T) {
+	manimSvc, videoSvc, _ := setupTestManimService(t)
+	ctx := context.Background()
+	fake := newFakeS3Server(t)
+	store := newTestS3BlobStore(t, fake)
+	store.secretKey = "wrong"
+	videoSvc.SetBlobStore(store, time.Minute)
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	require.NoError(t, err)
+	assert.Error(t, manimSvc.GenerateVideo(ctx, video.ID, testManimCode))
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.FailureReasonStorage, updated.FailureReason)
+	_, err = os.Stat("videos")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"manim-backend/internal/config"
+)
+
+// 诊断规则
+const (
+	CodeRuleSyntax           = "syntax"
+	CodeRuleBlockedImport    = "blocked-import"
+	CodeRuleImportNotAllowed = "import-not-allowed"
+	CodeRuleBlockedCall      = "blocked-call"
+	CodeRuleBlockedModule    = "blocked-module-ref"
+	CodeRuleDunderAccess     = "dunder-access"
+)
+
+// 危险模块，即使出现在允许列表中也会被拒绝
+var blockedModules = []string{
+	"os", "subprocess", "socket", "ctypes", "importlib",
+	"sys", "builtins", "shutil", "pickle", "marshal", "multiprocessing", "pty",
+}
+
+// 默认允许导入的模块
+var defaultAllowedImports = []string{
+	"manim", "numpy", "math", "cmath", "random", "itertools", "functools", "operator",
+	"collections", "dataclasses", "typing", "enum", "fractions", "decimal", "statistics",
+	"string", "copy", "re", "colour", "__future__",
+}
+
+// 默认禁止使用的内置函数
+var defaultBlockedCalls = []string{
+	"eval", "exec", "compile", "open", "__import__", "globals", "locals", "vars",
+	"getattr", "setattr", "delattr", "breakpoint", "input", "exit", "quit", "help",
+}
+
+// 允许访问的双下划线属性和名称
+var (
+	allowedDunderAttrs = map[string]bool{"__init__": true}
+	allowedDunderNames = map[string]bool{"__name__": true, "__all__": true}
+)
+
+// CodeDiagnostic 代码检查诊断信息，行号和列号从1开始
+type CodeDiagnostic struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (d CodeDiagnostic) String() string {
+	return fmt.Sprintf("第%d行第%d列: %s", d.Line, d.Column, d.Message)
+}
+
+// CodeAnalysis 代码分析结果，存在语法错误时Module为nil
+type CodeAnalysis struct {
+	Module      *pyModule
+	Diagnostics []CodeDiagnostic
+}
+
+// CodeAnalyzer 对用户提交的Manim代码进行静态安全检查
+type CodeAnalyzer struct {
+	allowedImports map[string]bool
+	blockedImports map[string]bool
+	blockedCalls   map[string]bool
+}
+
+func NewCodeAnalyzer(cfg config.CodeSafetyConfig) *CodeAnalyzer {
+	allowed := cfg.AllowedImports
+	if len(allowed) == 0 {
+		allowed = defaultAllowedImports
+	}
+	blockedCalls := cfg.BlockedCalls
+	if len(blockedCalls) == 0 {
+		blockedCalls = defaultBlockedCalls
+	}
+
+	return &CodeAnalyzer{
+		allowedImports: toSet(allowed),
+		blockedImports: toSet(blockedModules),
+		blockedCalls:   toSet(blockedCalls),
+	}
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
+
+// Analyze 解析代码并检查导入、危险函数和双下划线属性访问
+func (a *CodeAnalyzer) Analyze(code string) *CodeAnalysis {
+	module, err := parsePythonModule(code)
+	if err != nil {
+		diagnostic := CodeDiagnostic{Line: 1, Column: 1, Rule: CodeRuleSyntax, Message: "代码语法错误: " + err.Error()}
+		if syntaxErr, ok := err.(*pySyntaxError); ok {
+			diagnostic.Line, diagnostic.Column = syntaxErr.Line, syntaxErr.Col
+			diagnostic.Message = "代码语法错误: " + syntaxErr.Msg
+		}
+		return &CodeAnalysis{Diagnostics: []CodeDiagnostic{diagnostic}}
+	}
+
+	analysis := &CodeAnalysis{Module: module}
+	for _, imp := range module.Imports {
+		if diagnostic, ok := a.checkImport(imp); !ok {
+			analysis.Diagnostics = append(analysis.Diagnostics, diagnostic)
+		}
+	}
+	analysis.Diagnostics = append(analysis.Diagnostics, a.checkTokens(module.Tokens)...)
+
+	return analysis
+}
+
+// checkImport 检查导入的模块是否允许
+func (a *CodeAnalyzer) checkImport(imp pyImport) (CodeDiagnostic, bool) {
+	diagnostic := CodeDiagnostic{Line: imp.Line, Column: imp.Col}
+
+	if strings.HasPrefix(imp.Module, ".") {
+		diagnostic.Rule = CodeRuleImportNotAllowed
+		diagnostic.Message = "不支持相对导入"
+		return diagnostic, false
+	}
+
+	root := strings.SplitN(imp.Module, ".", 2)[0]
+	switch {
+	case a.blockedImports[root]:
+		diagnostic.Rule = CodeRuleBlockedImport
+		diagnostic.Message = fmt.Sprintf("禁止导入模块 %s", imp.Module)
+		return diagnostic, false
+	case !a.allowedImports[root]:
+		diagnostic.Rule = CodeRuleImportNotAllowed
+		diagnostic.Message = fmt.Sprintf("模块 %s 不在允许导入的列表中", imp.Module)
+		return diagnostic, false
+	}
+
+	return diagnostic, true
+}
+
+// checkTokens 逐个检查标识符，f-string中的表达式同样检查
+func (a *CodeAnalyzer) checkTokens(tokens []pyToken) []CodeDiagnostic {
+	var diagnostics []CodeDiagnostic
+	depth := 0
+
+	for i, token := range tokens {
+		if len(token.Embedded) > 0 {
+			diagnostics = append(diagnostics, a.checkTokens(token.Embedded)...)
+		}
+
+		switch token.Value {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		}
+		if token.Kind != pyName {
+			continue
+		}
+
+		var prev, next string
+		if i > 0 {
+			prev = tokens[i-1].Value
+		}
+		if i+1 < len(tokens) {
+			next = tokens[i+1].Value
+		}
+
+		// 导入语句由checkImport检查；def/class定义的名称和关键字参数名不是引用
+		if prev == "import" || prev == "from" || prev == "def" || prev == "class" || prev == "as" {
+			continue
+		}
+		if depth > 0 && next == "=" && prev != "." {
+			continue
+		}
+
+		diagnostic := CodeDiagnostic{Line: token.Line, Column: token.Col}
+		name := token.Value
+		isDunder := len(name) > 4 && strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__")
+
+		switch {
+		case prev == ".":
+			if isDunder && !allowedDunderAttrs[name] {
+				diagnostic.Rule = CodeRuleDunderAccess
+				diagnostic.Message = fmt.Sprintf("禁止访问双下划线属性 %s", name)
+			} else if a.blockedImports[name] {
+				diagnostic.Rule = CodeRuleBlockedModule
+				diagnostic.Message = fmt.Sprintf("禁止引用模块 %s", name)
+			}
+		case a.blockedCalls[name]:
+			diagnostic.Rule = CodeRuleBlockedCall
+			diagnostic.Message = fmt.Sprintf("禁止使用 %s", name)
+		case isDunder && !allowedDunderNames[name]:
+			diagnostic.Rule = CodeRuleDunderAccess
+			diagnostic.Message = fmt.Sprintf("禁止使用双下划线名称 %s", name)
+		case a.blockedImports[name] && !isImportStatement(tokens, i):
+			diagnostic.Rule = CodeRuleBlockedModule
+			diagnostic.Message = fmt.Sprintf("禁止引用模块 %s", name)
+		}
+
+		if diagnostic.Rule != "" {
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+
+	return diagnostics
+}
+
+// isImportStatement 判断标识符是否位于import语句中（例如 import a, os），这类问题由checkImport报告
+func isImportStatement(tokens []pyToken, index int) bool {
+	for i := index - 1; i >= 0; i-- {
+		switch tokens[i].Kind {
+		case pyNewline, pyIndent, pyDedent:
+			return false
+		}
+		switch tokens[i].Value {
+		case ";", ":":
+			return false
+		case "import", "from":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"testing"
+
+	"manim-backend/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePythonModule(t *testing.T) {
+	code := `from manim import *
+import numpy as np, math
+
+class Base(MovingCameraScene):
+    pass
+
+@decorator
+class Demo(manim.Scene, metaclass=Meta):
+    """多行
+    文档字符串"""
+    def setup(self):
+        label = f"{np.pi:.2f} {self.x!r}"
+
+    def construct(self):
+        items = [
+            Circle(),
+            Square(),
+        ]
+        self.play(*[Create(i) for i in items])
+`
+	module, err := parsePythonModule(code)
+	assert.NoError(t, err)
+
+	var imports []string
+	for _, imp := range module.Imports {
+		imports = append(imports, imp.Module)
+	}
+	assert.Equal(t, []string{"manim", "numpy", "math"}, imports)
+
+	assert.Len(t, module.Classes, 2)
+	assert.Equal(t, "Base", module.Classes[0].Name)
+	assert.Equal(t, []string{"MovingCameraScene"}, module.Classes[0].Bases)
+	assert.Equal(t, "Demo", module.Classes[1].Name)
+	assert.Equal(t, []string{"manim.Scene"}, module.Classes[1].Bases)
+	assert.Equal(t, []string{"setup", "construct"}, module.Classes[1].Methods)
+	assert.Equal(t, 8, module.Classes[1].Line)
+}
+
+func TestParsePythonModule_SyntaxError(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		line int
+	}{
+		{name: "字符串未闭合", code: "x = 1\ny = 'abc\n", line: 2},
+		{name: "括号未闭合", code: "x = (1,\n2\n", line: 1},
+		{name: "括号不匹配", code: "x = [1, 2)\n", line: 1},
+		{name: "缩进不一致", code: "if x:\n    a = 1\n  b = 2\n", line: 3},
+		{name: "无法识别的字符", code: "x = 1 ？ 2\n", line: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePythonModule(tt.code)
+			syntaxErr, ok := err.(*pySyntaxError)
+			assert.True(t, ok)
+			if ok {
+				assert.Equal(t, tt.line, syntaxErr.Line)
+			}
+		})
+	}
+}
+
+func TestCodeAnalyzer_Analyze(t *testing.T) {
+	analyzer := NewCodeAnalyzer(config.CodeSafetyConfig{})
+
+	tests := []struct {
+		name   string
+		code   string
+		rule   string
+		line   int
+		column int
+	}{
+		{name: "安全代码", code: "from manim import *\nimport numpy as np\n\nclass A(Scene):\n    def construct(self):\n        self.play(Create(Circle(radius=np.pi)))\n"},
+		{name: "导入os", code: "import os\n", rule: CodeRuleBlockedImport, line: 1, column: 8},
+		{name: "导入os子模块", code: "import os.path\n", rule: CodeRuleBlockedImport, line: 1, column: 8},
+		{name: "从subprocess导入", code: "from subprocess import run\n", rule: CodeRuleBlockedImport, line: 1, column: 1},
+		{name: "多个导入中的socket", code: "import math, socket\n", rule: CodeRuleBlockedImport, line: 1, column: 14},
+		{name: "函数内导入ctypes", code: "def f():\n    import ctypes\n", rule: CodeRuleBlockedImport, line: 2, column: 12},
+		{name: "单行复合语句导入importlib", code: "if True: import importlib\n", rule: CodeRuleBlockedImport, line: 1, column: 17},
+		{name: "不在白名单的模块", code: "import requests\n", rule: CodeRuleImportNotAllowed, line: 1, column: 8},
+		{name: "相对导入", code: "from . import x\n", rule: CodeRuleImportNotAllowed, line: 1, column: 1},
+		{name: "调用eval", code: "x = eval('1+1')\n", rule: CodeRuleBlockedCall, line: 1, column: 5},
+		{name: "引用exec", code: "f = exec\n", rule: CodeRuleBlockedCall, line: 1, column: 5},
+		{name: "调用open", code: "with open('/etc/passwd') as f:\n    pass\n", rule: CodeRuleBlockedCall, line: 1, column: 6},
+		{name: "调用__import__", code: "m = __import__('os')\n", rule: CodeRuleBlockedCall, line: 1, column: 5},
+		{name: "双下划线属性", code: "x = ().__class__.__bases__\n", rule: CodeRuleDunderAccess, line: 1, column: 8},
+		{name: "双下划线名称", code: "b = __builtins__\n", rule: CodeRuleDunderAccess, line: 1, column: 5},
+		{name: "f-string中调用eval", code: "s = f\"{eval('1')}\"\n", rule: CodeRuleBlockedCall, line: 1, column: 8},
+		{name: "通过属性引用os", code: "np.lib.os.system('ls')\n", rule: CodeRuleBlockedModule, line: 1, column: 8},
+		{name: "语法错误", code: "x = (\n", rule: CodeRuleSyntax, line: 1, column: 5},
+		{name: "允许super().__init__", code: "class A(VGroup):\n    def __init__(self, **kwargs):\n        super().__init__(**kwargs)\n"},
+		{name: "允许__name__", code: "if __name__ == '__main__':\n    pass\n"},
+		{name: "关键字参数与禁止函数同名", code: "x = Foo(input=1, open=True)\n"},
+		{name: "字符串中的内容不检查", code: "t = Text('import os; eval(x)')\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := analyzer.Analyze(tt.code)
+			if tt.rule == "" {
+				assert.Empty(t, analysis.Diagnostics)
+				return
+			}
+
+			assert.NotEmpty(t, analysis.Diagnostics)
+			if len(analysis.Diagnostics) > 0 {
+				d := analysis.Diagnostics[0]
+				assert.Equal(t, tt.rule, d.Rule)
+				assert.Equal(t, tt.line, d.Line)
+				assert.Equal(t, tt.column, d.Column)
+			}
+		})
+	}
+}
+
+func TestCodeAnalyzer_AllowList(t *testing.T) {
+	analyzer := NewCodeAnalyzer(config.CodeSafetyConfig{
+		AllowedImports: []string{"manim", "scipy", "os"},
+	})
+
+	// 白名单中的模块允许导入
+	assert.Empty(t, analyzer.Analyze("import scipy.integrate\n").Diagnostics)
+	// 不在白名单中的默认模块被拒绝
+	assert.Equal(t, CodeRuleImportNotAllowed, analyzer.Analyze("import numpy\n").Diagnostics[0].Rule)
+	// 危险模块即使配置在白名单中也被拒绝
+	assert.Equal(t, CodeRuleBlockedImport, analyzer.Analyze("import os\n").Diagnostics[0].Rule)
+}
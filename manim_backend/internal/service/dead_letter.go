@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"manim-backend/internal/model"
+)
+
+// ErrDeadLetterNotFound 视频不在死信队列中
+var ErrDeadLetterNotFound = errors.New("死信任务不存在")
+
+// ListDeadLetters 获取死信队列中的任务，最近移入的在前
+func (s *VideoQueueService) ListDeadLetters(ctx context.Context) ([]model.DeadLetterJob, error) {
+	var jobs []model.DeadLetterJob
+	if err := s.db.WithContext(ctx).Order("updated_at DESC").Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("获取死信任务失败: %v", err)
+	}
+	return jobs, nil
+}
+
+// DeadLetterDetail 死信任务及其全部渲染记录，渲染记录按次数升序
+type DeadLetterDetail struct {
+	Job      model.DeadLetterJob
+	Attempts []model.RenderAttempt
+}
+
+// LastLogExcerpt 最后一次渲染输出的最后一段
+func (d DeadLetterDetail) LastLogExcerpt() string {
+	if len(d.Attempts) == 0 {
+		return ""
+	}
+	return d.Attempts[len(d.Attempts)-1].LogExcerpt
+}
+
+// DeadLetterBatchResult 批量操作的结果，Failed记录每个失败的视频ID及原因
+type DeadLetterBatchResult struct {
+	Succeeded []uint
+	Failed    map[uint]error
+}
+
+// ListDeadLetterDetails 获取死信队列中的任务及其渲染记录，最近移入的在前
+func (s *VideoQueueService) ListDeadLetterDetails(ctx context.Context) ([]DeadLetterDetail, error) {
+	jobs, err := s.ListDeadLetters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+
+	videoIDs := make([]uint, len(jobs))
+	for i, job := range jobs {
+		videoIDs[i] = job.VideoID
+	}
+	var attempts []model.RenderAttempt
+	err = s.db.WithContext(ctx).Where("video_id IN ?", videoIDs).Order("video_id, attempt, id").Find(&attempts).Error
+	if err != nil {
+		return nil, fmt.Errorf("获取渲染记录失败: %v", err)
+	}
+	byVideo := make(map[uint][]model.RenderAttempt, len(jobs))
+	for _, attempt := range attempts {
+		byVideo[attempt.VideoID] = append(byVideo[attempt.VideoID], attempt)
+	}
+
+	details := make([]DeadLetterDetail, len(jobs))
+	for i, job := range jobs {
+		details[i] = DeadLetterDetail{Job: job, Attempts: byVideo[job.VideoID]}
+	}
+	return details, nil
+}
+
+// ReplayDeadLetter 将死信任务重新加入渲染队列，渲染次数重新计算
+func (s *VideoQueueService) ReplayDeadLetter(ctx context.Context, videoID uint) error {
+	result := s.db.WithContext(ctx).Where("video_id = ?", videoID).Delete(&model.DeadLetterJob{})
+	if result.Error != nil {
+		return fmt.Errorf("删除死信任务失败: %v", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	err := s.db.WithContext(ctx).Model(&model.Video{}).Where("id = ?", videoID).Updates(map[string]interface{}{
+		"status":          model.VideoStatusPending,
+		"attempts":        0,
+		"next_attempt_at": nil,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("重置视频状态失败: %v", err)
+	}
+
+	if err := s.AddToQueue(ctx, videoID); err != nil {
+		return err
+	}
+	log.Printf("死信任务 %d 已重新入队", videoID)
+	return nil
+}
+
+// ReplayDeadLetters 将多个死信任务重新加入渲染队列，单个任务失败不影响其他任务
+func (s *VideoQueueService) ReplayDeadLetters(ctx context.Context, videoIDs []uint) DeadLetterBatchResult {
+	result := DeadLetterBatchResult{Failed: make(map[uint]error)}
+	for _, videoID := range videoIDs {
+		if err := s.ReplayDeadLetter(ctx, videoID); err != nil {
+			result.Failed[videoID] = err
+			continue
+		}
+		result.Succeeded = append(result.Succeeded, videoID)
+	}
+	return result
+}
+
+// DiscardDeadLetters 从死信队列中移除任务，视频保持失败状态，渲染记录保留
+func (s *VideoQueueService) DiscardDeadLetters(ctx context.Context, videoIDs []uint) (DeadLetterBatchResult, error) {
+	result := DeadLetterBatchResult{Failed: make(map[uint]error)}
+	if len(videoIDs) == 0 {
+		return result, nil
+	}
+
+	var existing []uint
+	if err := s.db.WithContext(ctx).Model(&model.DeadLetterJob{}).Where("video_id IN ?", videoIDs).Pluck("video_id", &existing).Error; err != nil {
+		return result, fmt.Errorf("获取死信任务失败: %v", err)
+	}
+	if len(existing) > 0 {
+		if err := s.db.WithContext(ctx).Where("video_id IN ?", existing).Delete(&model.DeadLetterJob{}).Error; err != nil {
+			return result, fmt.Errorf("删除死信任务失败: %v", err)
+		}
+	}
+
+	found := make(map[uint]bool, len(existing))
+	for _, videoID := range existing {
+		found[videoID] = true
+	}
+	for _, videoID := range videoIDs {
+		if found[videoID] {
+			result.Succeeded = append(result.Succeeded, videoID)
+		} else {
+			result.Failed[videoID] = ErrDeadLetterNotFound
+		}
+	}
+	log.Printf("已丢弃 %d 个死信任务", len(result.Succeeded))
+	return result, nil
+}
+
+// ListDeadLetters 获取死信队列中的任务
+func (s *VideoService) ListDeadLetters(ctx context.Context) ([]model.DeadLetterJob, error) {
+	return s.queueSvc.ListDeadLetters(ctx)
+}
+
+// ReplayDeadLetter 将死信任务重新加入渲染队列
+func (s *VideoService) ReplayDeadLetter(ctx context.Context, videoID uint) error {
+	return s.queueSvc.ReplayDeadLetter(ctx, videoID)
+}
+
+// ListDeadLetterDetails 获取死信队列中的任务及其渲染记录
+func (s *VideoService) ListDeadLetterDetails(ctx context.Context) ([]DeadLetterDetail, error) {
+	return s.queueSvc.ListDeadLetterDetails(ctx)
+}
+
+// ReplayDeadLetters 将多个死信任务重新加入渲染队列
+func (s *VideoService) ReplayDeadLetters(ctx context.Context, videoIDs []uint) DeadLetterBatchResult {
+	return s.queueSvc.ReplayDeadLetters(ctx, videoIDs)
+}
+
+// DiscardDeadLetters 从死信队列中移除任务
+func (s *VideoService) DiscardDeadLetters(ctx context.Context, videoIDs []uint) (DeadLetterBatchResult, error) {
+	return s.queueSvc.DiscardDeadLetters(ctx, videoIDs)
+}
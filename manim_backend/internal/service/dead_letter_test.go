@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createDeadLetter 创建一个渲染两次都临时失败、已移入死信队列的视频
+func createDeadLetter(t *testing.T, videoSvc *VideoService, logs string) *model.Video {
+	ctx := context.Background()
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, videoSvc.BeginRenderAttempt(ctx, video.ID, testManimCode))
+		assert.NoError(t, videoSvc.RecordRenderFailureWithLogs(ctx, video.ID, "", testManimCode, "Manim执行失败: signal: killed", logs))
+	}
+	return video
+}
+
+func TestVideoService_ListDeadLetterDetails(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx := context.Background()
+
+	video := createDeadLetter(t, videoSvc, "Animation 1: FadeIn(Square)")
+
+	details, err := videoSvc.ListDeadLetterDetails(ctx)
+	assert.NoError(t, err)
+	if !assert.Len(t, details, 1) {
+		return
+	}
+	assert.Equal(t, video.ID, details[0].Job.VideoID)
+	assert.Equal(t, manimCodeHash(testManimCode), details[0].Job.CodeHash)
+	if assert.Len(t, details[0].Attempts, 2) {
+		assert.Equal(t, 1, details[0].Attempts[0].Attempt)
+		assert.Equal(t, 2, details[0].Attempts[1].Attempt)
+	}
+	assert.Equal(t, "Animation 1: FadeIn(Square)", details[0].LastLogExcerpt())
+}
+
+func TestVideoService_ReplayDeadLetters(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx := context.Background()
+
+	first := createDeadLetter(t, videoSvc, "")
+	second := createDeadLetter(t, videoSvc, "")
+
+	result := videoSvc.ReplayDeadLetters(ctx, []uint{first.ID, second.ID, 999})
+	assert.ElementsMatch(t, []uint{first.ID, second.ID}, result.Succeeded)
+	if assert.Len(t, result.Failed, 1) {
+		assert.ErrorIs(t, result.Failed[999], ErrDeadLetterNotFound)
+	}
+
+	waiting, err := videoSvc.queueSvc.queue.Waiting(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []uint{first.ID, second.ID}, waiting)
+}
+
+func TestVideoService_DiscardDeadLetters(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx := context.Background()
+
+	video := createDeadLetter(t, videoSvc, "")
+
+	result, err := videoSvc.DiscardDeadLetters(ctx, []uint{video.ID, 999})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{video.ID}, result.Succeeded)
+	assert.ErrorIs(t, result.Failed[999], ErrDeadLetterNotFound)
+
+	// 丢弃后视频保持失败状态，渲染记录保留，不会重新入队
+	deadLetters, err := videoSvc.ListDeadLetters(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusFailed, updated.Status)
+	var count int64
+	videoSvc.db.Model(&model.RenderAttempt{}).Where("video_id = ?", video.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+	waiting, err := videoSvc.queueSvc.queue.Waiting(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, waiting)
+}
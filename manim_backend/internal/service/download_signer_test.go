@@ -0,0 +1,53 @@
+package service
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"manim-backend/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadSigner(t *testing.T) {
+	signer := NewDownloadSigner(config.DownloadConfig{SigningKey: "test-key", ExpireSeconds: 60})
+	now := time.Now()
+	signer.now = func() time.Time { return now }
+
+	path := "/downloadvideo/1/2/场景 A.mp4"
+	signed, err := url.Parse(signer.Sign(path))
+	require.NoError(t, err)
+	assert.Equal(t, path, signed.Path)
+	query := signed.Query()
+	assert.Equal(t, strconv.FormatInt(now.Add(time.Minute).Unix(), 10), query.Get("expires"))
+	assert.NoError(t, signer.Verify(signed.Path, query.Get("expires"), query.Get("signature")))
+
+	// 路径、过期时间或签名被修改
+	assert.ErrorIs(t, signer.Verify("/downloadvideo/1/3/场景 A.mp4", query.Get("expires"), query.Get("signature")), ErrDownloadSignatureInvalid)
+	assert.ErrorIs(t, signer.Verify(path, "99999999999", query.Get("signature")), ErrDownloadSignatureInvalid)
+	assert.ErrorIs(t, signer.Verify(path, query.Get("expires"), query.Get("signature")+"x"), ErrDownloadSignatureInvalid)
+	assert.ErrorIs(t, signer.Verify(path, "", ""), ErrDownloadSignatureInvalid)
+
+	// 其他密钥签发的地址
+	other := NewDownloadSigner(config.DownloadConfig{SigningKey: "other-key", ExpireSeconds: 60})
+	assert.ErrorIs(t, other.Verify(path, query.Get("expires"), query.Get("signature")), ErrDownloadSignatureInvalid)
+
+	// 过期
+	now = now.Add(61 * time.Second)
+	assert.ErrorIs(t, signer.Verify(path, query.Get("expires"), query.Get("signature")), ErrDownloadURLExpired)
+}
+
+func TestDownloadSigner_RandomKey(t *testing.T) {
+	first := NewDownloadSigner(config.DownloadConfig{})
+	second := NewDownloadSigner(config.DownloadConfig{})
+	assert.Equal(t, time.Hour, first.ttl)
+
+	signed, err := url.Parse(first.Sign("/downloadvideo/1/2/a.mp4"))
+	require.NoError(t, err)
+	query := signed.Query()
+	assert.NoError(t, first.Verify(signed.Path, query.Get("expires"), query.Get("signature")))
+	assert.ErrorIs(t, second.Verify(signed.Path, query.Get("expires"), query.Get("signature")), ErrDownloadSignatureInvalid)
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"manim-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// ResolvePriority 校验用户请求的队列优先级，未指定时使用交互优先级；
+// 管理员优先级只允许管理员使用
+func (s *VideoService) ResolvePriority(ctx context.Context, userID uint, requested string) (string, error) {
+	if requested == "" {
+		return model.JobPriorityInteractive, nil
+	}
+	if !model.ValidJobPriority(requested) {
+		return "", fmt.Errorf("不支持的优先级: %s", requested)
+	}
+	if requested != model.JobPriorityAdmin {
+		return requested, nil
+	}
+
+	var user model.User
+	if err := s.db.WithContext(ctx).Select("id", "role").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", errors.New("用户不存在")
+		}
+		return "", fmt.Errorf("获取用户信息失败: %v", err)
+	}
+	if user.Role != model.UserRoleAdmin {
+		return "", errors.New("只有管理员可以使用admin优先级")
+	}
+	return requested, nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVideoService_ResolvePriority(t *testing.T) {
+	db := setupTestDBWithVideo()
+	videoSvc := newTestVideoService(t, db, config.ManimConfig{}, nil)
+	ctx := context.Background()
+
+	user := &model.User{Username: "user", Email: "user@example.com", Password: "x"}
+	admin := &model.User{Username: "admin", Email: "admin@example.com", Password: "x", Role: model.UserRoleAdmin}
+	assert.NoError(t, db.Create(user).Error)
+	assert.NoError(t, db.Create(admin).Error)
+
+	tests := []struct {
+		name      string
+		userID    uint
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{name: "默认交互优先级", userID: user.ID, want: model.JobPriorityInteractive},
+		{name: "批量任务", userID: user.ID, requested: model.JobPriorityBatch, want: model.JobPriorityBatch},
+		{name: "普通用户不能使用管理员优先级", userID: user.ID, requested: model.JobPriorityAdmin, wantErr: true},
+		{name: "管理员优先级", userID: admin.ID, requested: model.JobPriorityAdmin, want: model.JobPriorityAdmin},
+		{name: "未知优先级", userID: user.ID, requested: "urgent", wantErr: true},
+		{name: "用户不存在", userID: 999, requested: model.JobPriorityAdmin, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := videoSvc.ResolvePriority(ctx, tt.userID, tt.requested)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestVideoService_GetUserQueuePositions(t *testing.T) {
+	db := setupTestDBWithVideo()
+	videoSvc := newTestVideoService(t, db, config.ManimConfig{}, nil)
+	ctx := context.Background()
+
+	// 用户1提交两个任务，用户2的任务排在两者之间
+	var videos []*model.Video
+	for _, userID := range []uint{1, 1, 2} {
+		video, err := videoSvc.CreateVideo(ctx, userID, "画一个圆")
+		assert.NoError(t, err)
+		assert.NoError(t, videoSvc.AddToQueue(ctx, video.ID))
+		videos = append(videos, video)
+	}
+
+	total, positions, err := videoSvc.GetUserQueuePositions(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, []QueuePosition{
+		{VideoID: videos[0].ID, Position: 1},
+		{VideoID: videos[1].ID, Position: 3},
+	}, positions)
+
+	total, positions, err = videoSvc.GetUserQueuePositions(ctx, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Empty(t, positions)
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"manim-backend/internal/config"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// 渲染队列实现
+const (
+	QueueBackendStream = "stream" // Redis Streams消费者组，适合多实例部署
+	QueueBackendZSet   = "zset"   // Redis有序集合，适合单实例的小规模部署
+	QueueBackendMemory = "memory" // 进程内队列，不依赖Redis，适合单实例部署和CI
+	QueueBackendSQL    = "sql"    // 数据库render_jobs表，不依赖Redis，多个实例可以共享
+)
+
+var (
+	// ErrQueueEmpty 等待超时仍没有可领取的任务
+	ErrQueueEmpty = errors.New("队列为空")
+	// ErrJobQueued 视频已在队列中等待渲染
+	ErrJobQueued = errors.New("视频已在队列中")
+	// ErrNilJob 续约或确认时传入了空任务，通常是领取失败后没有检查错误
+	ErrNilJob = errors.New("任务为空")
+)
+
+// JobSpec 入队任务的调度信息
+type JobSpec struct {
+	VideoID  uint
+	UserID   uint
+	Priority string // 见model.JobPriority*
+}
+
+// Job 被工作者领取的渲染任务
+type Job struct {
+	VideoID  uint
+	UserID   uint
+	ID       string // 队列中的任务标识，如Streams的消息ID
+	Consumer string // 领取任务的工作者
+}
+
+// JobQueue 至少一次投递的渲染任务队列。
+// 任务先按优先级档位、再按用户之间的公平轮次调度，同一用户的任务依次排在其他用户的任务之间；
+// 用户正在处理的任务数达到上限时跳过该用户的任务。
+// 领取的任务必须在处理完成后确认，工作者通过心跳续约；
+// 工作者崩溃导致租约过期的任务会被重新投递给其他工作者
+type JobQueue interface {
+	// Enqueue 添加任务，任务已在队列中时返回ErrJobQueued，正在处理时返回ErrRenderInProgress
+	Enqueue(ctx context.Context, spec JobSpec) error
+	// Claim 领取一个任务，没有可领取的任务时最多阻塞block，超时返回ErrQueueEmpty
+	Claim(ctx context.Context, consumer string, block time.Duration) (*Job, error)
+	// Heartbeat 续约，任务已被其他工作者接管时返回false，job为nil时返回ErrNilJob
+	Heartbeat(ctx context.Context, job *Job) (bool, error)
+	// Ack 确认任务处理完成，job为nil时返回ErrNilJob
+	Ack(ctx context.Context, job *Job) error
+	// Reap 回收租约过期的任务，返回重新入队的任务数
+	Reap(ctx context.Context) (int, error)
+	// Waiting 按调度顺序获取等待中（尚未被领取）的视频ID
+	Waiting(ctx context.Context) ([]uint, error)
+	// Leased 获取已被工作者领取、尚未确认的视频ID，包括租约已过期但尚未回收的任务
+	Leased(ctx context.Context) ([]uint, error)
+	// Remove 移除等待中的任务，任务不存在或已被领取时返回false
+	Remove(ctx context.Context, videoID uint) (bool, error)
+}
+
+// NewJobQueue 根据配置创建渲染队列，Redis实现要求rdb不为空，数据库实现要求db不为空
+func NewJobQueue(rdb *redis.Client, db *gorm.DB, cfg config.QueueConfig) (JobQueue, error) {
+	leaseTTL := time.Duration(cfg.LeaseSeconds) * time.Second
+	if leaseTTL <= 0 {
+		leaseTTL = 60 * time.Second
+	}
+
+	backend := cfg.Backend
+	if backend == "" {
+		backend = QueueBackendStream
+	}
+
+	switch backend {
+	case QueueBackendStream, QueueBackendZSet:
+		if rdb == nil {
+			return nil, fmt.Errorf("队列实现 %s 需要配置Redis", backend)
+		}
+		if backend == QueueBackendZSet {
+			return NewZSetJobQueue(rdb, "video_render_queue", leaseTTL, cfg.MaxPerUser), nil
+		}
+		return NewStreamJobQueue(rdb, "video_render_stream", "render_workers", leaseTTL, cfg.MaxPerUser), nil
+	case QueueBackendMemory:
+		return NewMemoryJobQueue(leaseTTL, cfg.MaxPerUser), nil
+	case QueueBackendSQL:
+		if db == nil {
+			return nil, fmt.Errorf("队列实现 %s 需要配置数据库", backend)
+		}
+		return NewSQLJobQueue(db, leaseTTL, time.Duration(cfg.PollSeconds)*time.Second, cfg.MaxPerUser), nil
+	default:
+		return nil, fmt.Errorf("未知的队列实现: %s", cfg.Backend)
+	}
+}
+
+// parseVideoID 解析队列中保存的视频ID
+func parseVideoID(value string) (uint, error) {
+	var videoID uint
+	if _, err := fmt.Sscanf(value, "%d", &videoID); err != nil {
+		return 0, fmt.Errorf("解析视频ID失败: %v", err)
+	}
+	return videoID, nil
+}
+
+// fairRoundStride 排序分数中每个优先级档位占用的轮次范围，分数 = 档位*fairRoundStride + 轮次
+const fairRoundStride = 1e9
+
+// fairScore 任务在有序集合中的排序分数
+func fairScore(band int, round int64) float64 {
+	return float64(band)*fairRoundStride + float64(round)
+}
+
+// nextFairRound 计算新任务的公平轮次。
+// 用户在该档位还有排在当前等待队首之后的任务时，新任务排在其最后一个任务的下一轮；
+// 否则与等待队首处于同一轮，这样提交大量任务的用户不会挡住其他用户
+func nextFairRound(minWaiting int64, hasWaiting bool, userLast int64, hasUserLast bool) int64 {
+	round := int64(0)
+	if hasWaiting {
+		round = minWaiting
+	}
+	if hasUserLast && userLast >= round {
+		round = userLast + 1
+	}
+	return round
+}
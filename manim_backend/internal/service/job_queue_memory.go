@@ -0,0 +1,291 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"manim-backend/internal/model"
+)
+
+// memoryJob 内存队列中等待的任务
+type memoryJob struct {
+	videoID uint
+	userID  uint
+	band    int    // 优先级档位
+	round   int64  // 公平调度轮次
+	seq     uint64 // 入队顺序
+	index   int    // 在堆中的位置
+}
+
+// memoryJobHeap 按档位、轮次、入队顺序排列的最小堆
+type memoryJobHeap []*memoryJob
+
+func (h memoryJobHeap) Len() int { return len(h) }
+func (h memoryJobHeap) Less(i, j int) bool {
+	if h[i].band != h[j].band {
+		return h[i].band < h[j].band
+	}
+	if h[i].round != h[j].round {
+		return h[i].round < h[j].round
+	}
+	return h[i].seq < h[j].seq
+}
+func (h memoryJobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *memoryJobHeap) Push(x interface{}) {
+	job := x.(*memoryJob)
+	job.index = len(*h)
+	*h = append(*h, job)
+}
+
+func (h *memoryJobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return job
+}
+
+// memoryLease 被领取任务的租约
+type memoryLease struct {
+	job       *memoryJob // 租约过期重新入队时保留原来的位置
+	consumer  string
+	expiresAt time.Time
+}
+
+// MemoryJobQueue 进程内的渲染队列，不依赖Redis和数据库，适合单实例部署和CI。
+// 进程退出后队列中的任务会丢失
+type MemoryJobQueue struct {
+	leaseTTL   time.Duration
+	maxPerUser int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	waiting memoryJobHeap
+	queued  map[uint]*memoryJob
+	leases  map[uint]*memoryLease
+	active  map[uint]int // 用户被领取的任务数
+	seq     uint64
+	wake    chan struct{} // 有新任务或用户的任务完成时关闭并替换，唤醒所有等待的工作者
+}
+
+// NewMemoryJobQueue maxPerUser为每个用户同时被领取的任务数上限，0表示不限制
+func NewMemoryJobQueue(leaseTTL time.Duration, maxPerUser int) *MemoryJobQueue {
+	return &MemoryJobQueue{
+		leaseTTL:   leaseTTL,
+		maxPerUser: maxPerUser,
+		now:        time.Now,
+		queued:     make(map[uint]*memoryJob),
+		leases:     make(map[uint]*memoryLease),
+		active:     make(map[uint]int),
+		wake:       make(chan struct{}),
+	}
+}
+
+// Enqueue 按优先级档位和用户的公平轮次添加任务
+func (q *MemoryJobQueue) Enqueue(ctx context.Context, spec JobSpec) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.queued[spec.VideoID]; ok {
+		return ErrJobQueued
+	}
+	if _, ok := q.leases[spec.VideoID]; ok {
+		return ErrRenderInProgress
+	}
+
+	// 用户最后的轮次只按仍在等待的任务计算，任务被领取或移除后不再影响新任务
+	band := model.JobPriorityBand(spec.Priority)
+	var minWaiting, userLast int64
+	hasWaiting, hasUserLast := false, false
+	for _, job := range q.waiting {
+		if job.band != band {
+			continue
+		}
+		if !hasWaiting || job.round < minWaiting {
+			minWaiting, hasWaiting = job.round, true
+		}
+		if job.userID == spec.UserID && (!hasUserLast || job.round > userLast) {
+			userLast, hasUserLast = job.round, true
+		}
+	}
+	round := nextFairRound(minWaiting, hasWaiting, userLast, hasUserLast)
+
+	q.seq++
+	q.push(&memoryJob{videoID: spec.VideoID, userID: spec.UserID, band: band, round: round, seq: q.seq})
+	return nil
+}
+
+// push 将任务放入等待堆并唤醒工作者，调用方需持有锁
+func (q *MemoryJobQueue) push(job *memoryJob) {
+	heap.Push(&q.waiting, job)
+	q.queued[job.videoID] = job
+	q.notify()
+}
+
+// notify 唤醒等待的工作者，调用方需持有锁
+func (q *MemoryJobQueue) notify() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// release 用户的任务结束领取，调用方需持有锁
+func (q *MemoryJobQueue) release(videoID uint) {
+	lease := q.leases[videoID]
+	delete(q.leases, videoID)
+	if q.active[lease.job.userID]--; q.active[lease.job.userID] <= 0 {
+		delete(q.active, lease.job.userID)
+	}
+}
+
+// popEligible 取出排在最前且用户未达到并发上限的任务，调用方需持有锁
+func (q *MemoryJobQueue) popEligible() *memoryJob {
+	var skipped []*memoryJob
+	defer func() {
+		for _, job := range skipped {
+			heap.Push(&q.waiting, job)
+		}
+	}()
+
+	for q.waiting.Len() > 0 {
+		job := heap.Pop(&q.waiting).(*memoryJob)
+		if q.maxPerUser <= 0 || q.active[job.userID] < q.maxPerUser {
+			return job
+		}
+		skipped = append(skipped, job)
+	}
+	return nil
+}
+
+// Claim 领取排在最前的可领取任务，没有时最多阻塞block
+func (q *MemoryJobQueue) Claim(ctx context.Context, consumer string, block time.Duration) (*Job, error) {
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+
+	for {
+		q.mu.Lock()
+		if job := q.popEligible(); job != nil {
+			delete(q.queued, job.videoID)
+			q.leases[job.videoID] = &memoryLease{job: job, consumer: consumer, expiresAt: q.now().Add(q.leaseTTL)}
+			q.active[job.userID]++
+			q.mu.Unlock()
+			return &Job{VideoID: job.videoID, UserID: job.userID, ID: fmt.Sprintf("%d", job.videoID), Consumer: consumer}, nil
+		}
+		wake := q.wake
+		q.mu.Unlock()
+
+		if block <= 0 {
+			return nil, ErrQueueEmpty
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, ErrQueueEmpty
+		case <-wake:
+		}
+	}
+}
+
+// Heartbeat 延长任务租约
+func (q *MemoryJobQueue) Heartbeat(ctx context.Context, job *Job) (bool, error) {
+	if job == nil {
+		return false, ErrNilJob
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	lease, ok := q.leases[job.VideoID]
+	if !ok || lease.consumer != job.Consumer {
+		return false, nil
+	}
+	lease.expiresAt = q.now().Add(q.leaseTTL)
+	return true, nil
+}
+
+// Ack 确认任务完成，任务已被其他工作者接管时不做处理
+func (q *MemoryJobQueue) Ack(ctx context.Context, job *Job) error {
+	if job == nil {
+		return ErrNilJob
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if lease, ok := q.leases[job.VideoID]; ok && lease.consumer == job.Consumer {
+		q.release(job.VideoID)
+		// 用户的并发数减少后，之前被跳过的任务可以领取
+		q.notify()
+	}
+	return nil
+}
+
+// Reap 将租约过期的任务放回队列
+func (q *MemoryJobQueue) Reap(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	requeued := 0
+	for videoID, lease := range q.leases {
+		if now.After(lease.expiresAt) {
+			q.release(videoID)
+			q.push(lease.job)
+			requeued++
+		}
+	}
+	return requeued, nil
+}
+
+// Waiting 按调度顺序获取等待中的视频ID
+func (q *MemoryJobQueue) Waiting(ctx context.Context) ([]uint, error) {
+	// 复制任务后排序，不修改堆中任务的位置
+	q.mu.Lock()
+	sorted := make(memoryJobHeap, len(q.waiting))
+	for i, job := range q.waiting {
+		copied := *job
+		sorted[i] = &copied
+	}
+	q.mu.Unlock()
+	sort.Sort(sorted)
+
+	videoIDs := make([]uint, 0, len(sorted))
+	for _, job := range sorted {
+		videoIDs = append(videoIDs, job.videoID)
+	}
+	return videoIDs, nil
+}
+
+// Leased 获取已被领取的视频ID
+func (q *MemoryJobQueue) Leased(ctx context.Context) ([]uint, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	videoIDs := make([]uint, 0, len(q.leases))
+	for videoID := range q.leases {
+		videoIDs = append(videoIDs, videoID)
+	}
+	return videoIDs, nil
+}
+
+// Remove 移除等待中的任务
+func (q *MemoryJobQueue) Remove(ctx context.Context, videoID uint) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.queued[videoID]
+	if !ok {
+		return false, nil
+	}
+	heap.Remove(&q.waiting, job.index)
+	delete(q.queued, videoID)
+	return true, nil
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"manim-backend/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SQLJobQueue 基于数据库render_jobs表的渲染队列，不依赖Redis。
+// 领取任务时使用 SELECT ... FOR UPDATE SKIP LOCKED，多个实例可以共享同一个数据库队列；
+// 数据库不支持阻塞等待，队列为空时按pollInterval轮询
+type SQLJobQueue struct {
+	db           *gorm.DB
+	leaseTTL     time.Duration
+	pollInterval time.Duration
+	maxPerUser   int
+	now          func() time.Time
+}
+
+// NewSQLJobQueue maxPerUser为每个用户同时被领取的任务数上限，0表示不限制
+func NewSQLJobQueue(db *gorm.DB, leaseTTL, pollInterval time.Duration, maxPerUser int) *SQLJobQueue {
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	return &SQLJobQueue{db: db, leaseTTL: leaseTTL, pollInterval: pollInterval, maxPerUser: maxPerUser, now: time.Now}
+}
+
+// Enqueue 插入任务记录，video_id唯一索引保证同一视频只有一条记录
+func (q *SQLJobQueue) Enqueue(ctx context.Context, spec JobSpec) error {
+	videoID := spec.VideoID
+	var existing model.RenderQueueJob
+	err := q.db.WithContext(ctx).Where("video_id = ?", videoID).First(&existing).Error
+	if err == nil {
+		if existing.Status == model.RenderJobClaimed {
+			return ErrRenderInProgress
+		}
+		return ErrJobQueued
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	band := model.JobPriorityBand(spec.Priority)
+	round, err := q.nextRound(ctx, band, spec.UserID)
+	if err != nil {
+		return err
+	}
+
+	job := model.RenderQueueJob{VideoID: videoID, UserID: spec.UserID, Status: model.RenderJobWaiting, Band: band, Round: round}
+	if err := q.db.WithContext(ctx).Create(&job).Error; err != nil {
+		// 并发入队时唯一索引冲突
+		if q.db.WithContext(ctx).Where("video_id = ?", videoID).First(&existing).Error == nil {
+			return ErrJobQueued
+		}
+		return err
+	}
+	return nil
+}
+
+// nextRound 根据档位中等待队首的轮次和用户仍在等待的任务的轮次计算新任务的轮次
+func (q *SQLJobQueue) nextRound(ctx context.Context, band int, userID uint) (int64, error) {
+	var minWaiting, userLast sql.NullInt64
+	err := q.db.WithContext(ctx).Model(&model.RenderQueueJob{}).
+		Where("status = ? AND band = ?", model.RenderJobWaiting, band).
+		Select("MIN(round)").Scan(&minWaiting).Error
+	if err != nil {
+		return 0, err
+	}
+	err = q.db.WithContext(ctx).Model(&model.RenderQueueJob{}).
+		Where("status = ? AND user_id = ? AND band = ?", model.RenderJobWaiting, userID, band).
+		Select("MAX(round)").Scan(&userLast).Error
+	if err != nil {
+		return 0, err
+	}
+	return nextFairRound(minWaiting.Int64, minWaiting.Valid, userLast.Int64, userLast.Valid), nil
+}
+
+// Claim 领取排在最前的可领取任务，没有时轮询直到超过block
+func (q *SQLJobQueue) Claim(ctx context.Context, consumer string, block time.Duration) (*Job, error) {
+	deadline := q.now().Add(block)
+	for {
+		job, err := q.claim(ctx, consumer)
+		if !errors.Is(err, ErrQueueEmpty) {
+			return job, err
+		}
+
+		wait := min(q.pollInterval, deadline.Sub(q.now()))
+		if wait <= 0 {
+			return nil, ErrQueueEmpty
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (q *SQLJobQueue) claim(ctx context.Context, consumer string) (*Job, error) {
+	var claimed model.RenderQueueJob
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 跳过其他工作者正在领取的行，避免互相等待
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", model.RenderJobWaiting)
+		if q.maxPerUser > 0 {
+			// 跳过已达到并发上限的用户
+			saturated := tx.Model(&model.RenderQueueJob{}).
+				Select("user_id").
+				Where("status = ?", model.RenderJobClaimed).
+				Group("user_id").
+				Having("COUNT(*) >= ?", q.maxPerUser)
+			query = query.Where("user_id NOT IN (?)", saturated)
+		}
+		err := query.Order("band").Order("round").Order("id").First(&claimed).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrQueueEmpty
+		}
+		if err != nil {
+			return err
+		}
+
+		expiresAt := q.now().Add(q.leaseTTL)
+		result := tx.Model(&claimed).
+			Where("status = ?", model.RenderJobWaiting).
+			Updates(map[string]interface{}{
+				"status":           model.RenderJobClaimed,
+				"consumer":         consumer,
+				"lease_expires_at": expiresAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		// 不支持行锁的数据库（如SQLite）上可能已被其他工作者领取
+		if result.RowsAffected == 0 {
+			return ErrQueueEmpty
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{VideoID: claimed.VideoID, UserID: claimed.UserID, ID: fmt.Sprintf("%d", claimed.ID), Consumer: consumer}, nil
+}
+
+// Heartbeat 延长任务租约
+func (q *SQLJobQueue) Heartbeat(ctx context.Context, job *Job) (bool, error) {
+	if job == nil {
+		return false, ErrNilJob
+	}
+	result := q.db.WithContext(ctx).Model(&model.RenderQueueJob{}).
+		Where("id = ? AND status = ? AND consumer = ?", job.ID, model.RenderJobClaimed, job.Consumer).
+		Update("lease_expires_at", q.now().Add(q.leaseTTL))
+	return result.RowsAffected == 1, result.Error
+}
+
+// Ack 确认任务完成并删除记录，任务已被其他工作者接管时不做处理
+func (q *SQLJobQueue) Ack(ctx context.Context, job *Job) error {
+	if job == nil {
+		return ErrNilJob
+	}
+	return q.db.WithContext(ctx).
+		Where("id = ? AND status = ? AND consumer = ?", job.ID, model.RenderJobClaimed, job.Consumer).
+		Delete(&model.RenderQueueJob{}).Error
+}
+
+// Reap 将租约过期的任务恢复为等待中
+func (q *SQLJobQueue) Reap(ctx context.Context) (int, error) {
+	result := q.db.WithContext(ctx).Model(&model.RenderQueueJob{}).
+		Where("status = ? AND lease_expires_at < ?", model.RenderJobClaimed, q.now()).
+		Updates(map[string]interface{}{
+			"status":           model.RenderJobWaiting,
+			"consumer":         "",
+			"lease_expires_at": nil,
+		})
+	return int(result.RowsAffected), result.Error
+}
+
+// Waiting 按调度顺序获取等待中的视频ID
+func (q *SQLJobQueue) Waiting(ctx context.Context) ([]uint, error) {
+	var videoIDs []uint
+	err := q.db.WithContext(ctx).Model(&model.RenderQueueJob{}).
+		Where("status = ?", model.RenderJobWaiting).
+		Order("band").Order("round").Order("id").
+		Pluck("video_id", &videoIDs).Error
+	return videoIDs, err
+}
+
+// Leased 获取已被领取的视频ID
+func (q *SQLJobQueue) Leased(ctx context.Context) ([]uint, error) {
+	var videoIDs []uint
+	err := q.db.WithContext(ctx).Model(&model.RenderQueueJob{}).
+		Where("status = ?", model.RenderJobClaimed).
+		Pluck("video_id", &videoIDs).Error
+	return videoIDs, err
+}
+
+// Remove 删除等待中的任务
+func (q *SQLJobQueue) Remove(ctx context.Context, videoID uint) (bool, error) {
+	result := q.db.WithContext(ctx).
+		Where("video_id = ? AND status = ?", videoID, model.RenderJobWaiting).
+		Delete(&model.RenderQueueJob{})
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,492 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"manim-backend/internal/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Streams队列的Redis键以stream为前缀：
+//   - {stream}         已调度的任务消息，由消费者组投递
+//   - {stream}:jobs    视频ID到消息ID的索引，用于去重和移除尚未投递的消息
+//   - {stream}:ready   等待调度的任务，有序集合，分数与有序集合队列相同
+//   - {stream}:meta、{stream}:active、{stream}:rounds、{stream}:signal 与有序集合队列相同
+const (
+	streamJobsSuffix  = ":jobs"
+	streamReadySuffix = ":ready"
+
+	// 获取待确认消息时的最大数量，正常情况下不超过所有实例的工作者总数
+	maxLeasedScan = 10000
+)
+
+// streamEnqueueScript 视频不在队列中时按档位和公平轮次放入等待调度的集合
+var streamEnqueueScript = redis.NewScript(fairRoundLua + `
+if redis.call('HEXISTS', KEYS[2], ARGV[1]) == 1 or redis.call('HEXISTS', KEYS[5], ARGV[1]) == 1 then
+	return false
+end
+local score = fair_score(KEYS[1], KEYS[3], tonumber(ARGV[3]), ARGV[2], tonumber(ARGV[4]))
+redis.call('ZADD', KEYS[1], score, ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2] .. ':' .. score)
+redis.call('LPUSH', KEYS[4], ARGV[1])
+redis.call('LTRIM', KEYS[4], 0, tonumber(ARGV[5]) - 1)
+return 1
+`)
+
+// streamPromoteScript 将排在最前且用户未达到并发上限的任务追加到流中，由消费者组投递
+var streamPromoteScript = redis.NewScript(pruneRoundLua + pickFairLua + `
+redis.replicate_commands()
+local id, user = pick_fair(KEYS[1], KEYS[4], KEYS[5], KEYS[6], tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3]))
+if not id then
+	return false
+end
+local message = redis.call('XADD', KEYS[2], '*', 'video_id', id, 'user_id', user)
+redis.call('HSET', KEYS[3], id, message)
+return message
+`)
+
+// streamHeartbeatScript 消息仍属于该消费者时重置空闲时间，避免被其他消费者接管
+var streamHeartbeatScript = redis.NewScript(`
+local pending = redis.call('XPENDING', KEYS[1], ARGV[1], ARGV[2], ARGV[2], 1)
+if #pending == 0 or pending[1][2] ~= ARGV[3] then
+	return 0
+end
+redis.call('XCLAIM', KEYS[1], ARGV[1], ARGV[3], 0, ARGV[2], 'JUSTID')
+return 1
+`)
+
+// streamAckScript 消息仍属于该消费者时确认并删除消息和索引，
+// 用户的并发数减少后通知工作者调度之前被跳过的任务
+var streamAckScript = redis.NewScript(releaseUserLua + `
+local pending = redis.call('XPENDING', KEYS[1], ARGV[1], ARGV[2], ARGV[2], 1)
+if #pending == 0 or pending[1][2] ~= ARGV[3] then
+	return 0
+end
+redis.call('XACK', KEYS[1], ARGV[1], ARGV[2])
+redis.call('XDEL', KEYS[1], ARGV[2])
+if redis.call('HGET', KEYS[2], ARGV[4]) == ARGV[2] then
+	redis.call('HDEL', KEYS[2], ARGV[4])
+	release_user(KEYS[3], KEYS[4], ARGV[4])
+end
+if redis.call('ZCARD', KEYS[5]) > 0 then
+	redis.call('LPUSH', KEYS[6], ARGV[4])
+	redis.call('LTRIM', KEYS[6], 0, tonumber(ARGV[5]) - 1)
+end
+return 1
+`)
+
+// streamRemoveScript 删除等待调度的任务或尚未投递的消息，已被消费者领取的消息不删除
+var streamRemoveScript = redis.NewScript(pruneRoundLua + releaseUserLua + `
+local score = redis.call('ZSCORE', KEYS[3], ARGV[2])
+if score then
+	redis.call('ZREM', KEYS[3], ARGV[2])
+	local value = redis.call('HGET', KEYS[4], ARGV[2])
+	redis.call('HDEL', KEYS[4], ARGV[2])
+	if value then
+		prune_round(KEYS[3], KEYS[4], KEYS[6], string.match(value, '^([^:]*)'), tonumber(score), tonumber(ARGV[3]))
+	end
+	return 1
+end
+local id = redis.call('HGET', KEYS[2], ARGV[2])
+if not id then
+	return 0
+end
+local pending = redis.call('XPENDING', KEYS[1], ARGV[1], id, id, 1)
+if #pending > 0 then
+	return 0
+end
+redis.call('XDEL', KEYS[1], id)
+redis.call('HDEL', KEYS[2], ARGV[2])
+release_user(KEYS[4], KEYS[5], ARGV[2])
+return 1
+`)
+
+// StreamJobQueue 基于Redis Streams消费者组的渲染队列。
+// 新任务先按公平顺序放入等待调度的集合，工作者领取时再追加到流中；
+// 每个工作者作为组内的消费者，领取的消息进入各自的待确认列表；
+// 空闲时间超过租约时长的消息由其他消费者通过XAUTOCLAIM接管，多个实例可以共享同一队列
+type StreamJobQueue struct {
+	rdb        *redis.Client
+	stream     string
+	group      string
+	leaseTTL   time.Duration
+	maxPerUser int
+
+	mu         sync.Mutex
+	groupReady bool
+}
+
+// NewStreamJobQueue maxPerUser为每个用户同时被领取的任务数上限，0表示不限制
+func NewStreamJobQueue(rdb *redis.Client, stream, group string, leaseTTL time.Duration, maxPerUser int) *StreamJobQueue {
+	return &StreamJobQueue{rdb: rdb, stream: stream, group: group, leaseTTL: leaseTTL, maxPerUser: maxPerUser}
+}
+
+func (q *StreamJobQueue) jobsKey() string   { return q.stream + streamJobsSuffix }
+func (q *StreamJobQueue) readyKey() string  { return q.stream + streamReadySuffix }
+func (q *StreamJobQueue) metaKey() string   { return q.stream + metaKeySuffix }
+func (q *StreamJobQueue) activeKey() string { return q.stream + activeKeySuffix }
+func (q *StreamJobQueue) roundsKey() string { return q.stream + roundsKeySuffix }
+func (q *StreamJobQueue) signalKey() string { return q.stream + signalKeySuffix }
+
+// ensureGroup 创建消费者组，从流的起始位置消费，避免丢失创建组之前写入的消息
+func (q *StreamJobQueue) ensureGroup(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.groupReady {
+		return nil
+	}
+
+	err := q.rdb.XGroupCreateMkStream(ctx, q.stream, q.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("创建消费者组失败: %v", err)
+	}
+	q.groupReady = true
+	return nil
+}
+
+// checkGroup 流或消费者组被删除（如Redis数据被清空）时下次操作重新创建
+func (q *StreamJobQueue) checkGroup(err error) error {
+	if err != nil && (strings.Contains(err.Error(), "NOGROUP") || strings.Contains(err.Error(), "no such key")) {
+		q.mu.Lock()
+		q.groupReady = false
+		q.mu.Unlock()
+	}
+	return err
+}
+
+// Enqueue 按优先级档位和用户的公平轮次添加任务
+func (q *StreamJobQueue) Enqueue(ctx context.Context, spec JobSpec) error {
+	if err := q.ensureGroup(ctx); err != nil {
+		return err
+	}
+
+	member := fmt.Sprintf("%d", spec.VideoID)
+	err := streamEnqueueScript.Run(ctx, q.rdb,
+		[]string{q.readyKey(), q.metaKey(), q.roundsKey(), q.signalKey(), q.jobsKey()},
+		member, spec.UserID, model.JobPriorityBand(spec.Priority),
+		int64(fairRoundStride), maxQueueSignals).Err()
+	if err == nil {
+		return nil
+	}
+	if err != redis.Nil {
+		return err
+	}
+
+	// 已在队列中，区分等待中和处理中
+	if _, err := q.rdb.ZScore(ctx, q.readyKey(), member).Result(); err == nil {
+		return ErrJobQueued
+	}
+	id, err := q.rdb.HGet(ctx, q.jobsKey(), member).Result()
+	if err != nil {
+		return ErrJobQueued
+	}
+	pending, err := q.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: q.stream, Group: q.group, Start: id, End: id, Count: 1,
+	}).Result()
+	if err == nil && len(pending) > 0 {
+		return ErrRenderInProgress
+	}
+	return ErrJobQueued
+}
+
+// Claim 优先接管超过租约时长未确认的消息，其次读取已调度的消息，再调度等待中的任务；
+// 没有可领取的任务时阻塞等待新任务通知后再尝试一次
+func (q *StreamJobQueue) Claim(ctx context.Context, consumer string, block time.Duration) (*Job, error) {
+	if err := q.ensureGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	job, err := q.autoClaim(ctx, consumer)
+	if err != nil || job != nil {
+		return job, q.checkGroup(err)
+	}
+
+	job, err = q.claimReady(ctx, consumer)
+	if !errors.Is(err, ErrQueueEmpty) || block <= 0 {
+		return job, err
+	}
+
+	if err := q.rdb.BLPop(ctx, block, q.signalKey()).Err(); err != nil {
+		if err == redis.Nil {
+			return nil, ErrQueueEmpty
+		}
+		return nil, err
+	}
+	return q.claimReady(ctx, consumer)
+}
+
+// claimReady 读取尚未投递的消息，没有时将等待调度的任务追加到流中再读取
+func (q *StreamJobQueue) claimReady(ctx context.Context, consumer string) (*Job, error) {
+	job, err := q.readGroup(ctx, consumer)
+	if !errors.Is(err, ErrQueueEmpty) {
+		return job, err
+	}
+
+	err = streamPromoteScript.Run(ctx, q.rdb,
+		[]string{q.readyKey(), q.stream, q.jobsKey(), q.metaKey(), q.activeKey(), q.roundsKey()},
+		q.maxPerUser, fairClaimScan, int64(fairRoundStride)).Err()
+	if err == redis.Nil {
+		return nil, ErrQueueEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+	return q.readGroup(ctx, consumer)
+}
+
+// readGroup 不阻塞地读取一条尚未投递的消息
+func (q *StreamJobQueue) readGroup(ctx context.Context, consumer string) (*Job, error) {
+	streams, err := q.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.group,
+		Consumer: consumer,
+		Streams:  []string{q.stream, ">"},
+		Count:    1,
+		Block:    -1, // 不阻塞
+	}).Result()
+	if err == redis.Nil {
+		return nil, ErrQueueEmpty
+	}
+	if err != nil {
+		return nil, q.checkGroup(err)
+	}
+
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			return q.jobFromMessage(ctx, consumer, message)
+		}
+	}
+	return nil, ErrQueueEmpty
+}
+
+// autoClaim 接管其他消费者空闲超过租约时长的消息。
+// go-redis v8的XAutoClaim无法解析Redis 7返回的三元素结果，这里直接解析原始返回
+func (q *StreamJobQueue) autoClaim(ctx context.Context, consumer string) (*Job, error) {
+	reply, err := q.rdb.Do(ctx, "XAUTOCLAIM", q.stream, q.group, consumer,
+		q.leaseTTL.Milliseconds(), "0-0", "COUNT", 1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages, deleted, err := parseXAutoClaimReply(reply)
+	if err != nil {
+		return nil, err
+	}
+	// 已被删除的消息不会再处理，从待确认列表中移除
+	if len(deleted) > 0 {
+		q.rdb.XAck(ctx, q.stream, q.group, deleted...)
+	}
+	for _, message := range messages {
+		return q.jobFromMessage(ctx, consumer, message)
+	}
+	return nil, nil
+}
+
+// jobFromMessage 将消息转换为任务，无法解析的消息直接确认丢弃
+func (q *StreamJobQueue) jobFromMessage(ctx context.Context, consumer string, message redis.XMessage) (*Job, error) {
+	value, _ := message.Values["video_id"].(string)
+	videoID, err := parseVideoID(value)
+	if err != nil {
+		q.rdb.XAck(ctx, q.stream, q.group, message.ID)
+		q.rdb.XDel(ctx, q.stream, message.ID)
+		return nil, fmt.Errorf("消息 %s 无效: %v", message.ID, err)
+	}
+	userValue, _ := message.Values["user_id"].(string)
+	userID, _ := parseVideoID(userValue)
+	return &Job{VideoID: videoID, UserID: userID, ID: message.ID, Consumer: consumer}, nil
+}
+
+// Heartbeat 重置消息的空闲时间
+func (q *StreamJobQueue) Heartbeat(ctx context.Context, job *Job) (bool, error) {
+	if job == nil {
+		return false, ErrNilJob
+	}
+	n, err := streamHeartbeatScript.Run(ctx, q.rdb, []string{q.stream},
+		q.group, job.ID, job.Consumer).Int()
+	return n == 1, q.checkGroup(err)
+}
+
+// Ack 确认并删除消息，消息已被其他消费者接管时不做处理
+func (q *StreamJobQueue) Ack(ctx context.Context, job *Job) error {
+	if job == nil {
+		return ErrNilJob
+	}
+	err := streamAckScript.Run(ctx, q.rdb,
+		[]string{q.stream, q.jobsKey(), q.metaKey(), q.activeKey(), q.readyKey(), q.signalKey()},
+		q.group, job.ID, job.Consumer, fmt.Sprintf("%d", job.VideoID), maxQueueSignals).Err()
+	return q.checkGroup(err)
+}
+
+// Reap 过期消息由消费者在Claim时接管，这里只清理已退出进程遗留的空闲消费者
+func (q *StreamJobQueue) Reap(ctx context.Context) (int, error) {
+	if err := q.ensureGroup(ctx); err != nil {
+		return 0, err
+	}
+
+	reply, err := q.rdb.Do(ctx, "XINFO", "CONSUMERS", q.stream, q.group).Result()
+	if err != nil {
+		return 0, q.checkGroup(err)
+	}
+	idleLimit := 10 * q.leaseTTL
+	for _, consumer := range parseXInfoReply(reply) {
+		name, _ := consumer["name"].(string)
+		pending, _ := consumer["pending"].(int64)
+		idle, _ := consumer["idle"].(int64)
+		if name != "" && pending == 0 && time.Duration(idle)*time.Millisecond > idleLimit {
+			q.rdb.XGroupDelConsumer(ctx, q.stream, q.group, name)
+		}
+	}
+	return 0, nil
+}
+
+// Waiting 获取消费者组尚未投递的消息和等待调度的任务对应的视频ID
+func (q *StreamJobQueue) Waiting(ctx context.Context) ([]uint, error) {
+	if err := q.ensureGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	reply, err := q.rdb.Do(ctx, "XINFO", "GROUPS", q.stream).Result()
+	if err != nil {
+		return nil, q.checkGroup(err)
+	}
+	lastDelivered := "0-0"
+	for _, group := range parseXInfoReply(reply) {
+		if name, _ := group["name"].(string); name == q.group {
+			lastDelivered, _ = group["last-delivered-id"].(string)
+		}
+	}
+
+	messages, err := q.rdb.XRange(ctx, q.stream, "("+lastDelivered, "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var videoIDs []uint
+	for _, message := range messages {
+		value, _ := message.Values["video_id"].(string)
+		if videoID, err := parseVideoID(value); err == nil {
+			videoIDs = append(videoIDs, videoID)
+		}
+	}
+
+	members, err := q.rdb.ZRange(ctx, q.readyKey(), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if videoID, err := parseVideoID(member); err == nil {
+			videoIDs = append(videoIDs, videoID)
+		}
+	}
+	return videoIDs, nil
+}
+
+// Leased 获取消费者组待确认列表中的消息对应的视频ID
+func (q *StreamJobQueue) Leased(ctx context.Context) ([]uint, error) {
+	if err := q.ensureGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	pending, err := q.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: q.stream, Group: q.group, Start: "-", End: "+", Count: maxLeasedScan,
+	}).Result()
+	if err != nil {
+		return nil, q.checkGroup(err)
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	// 索引中保存的是视频ID到消息ID的映射
+	index, err := q.rdb.HGetAll(ctx, q.jobsKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	videoByMessage := make(map[string]string, len(index))
+	for member, messageID := range index {
+		videoByMessage[messageID] = member
+	}
+
+	var videoIDs []uint
+	for _, entry := range pending {
+		if videoID, err := parseVideoID(videoByMessage[entry.ID]); err == nil {
+			videoIDs = append(videoIDs, videoID)
+		}
+	}
+	return videoIDs, nil
+}
+
+// Remove 移除尚未投递的任务
+func (q *StreamJobQueue) Remove(ctx context.Context, videoID uint) (bool, error) {
+	if err := q.ensureGroup(ctx); err != nil {
+		return false, err
+	}
+
+	n, err := streamRemoveScript.Run(ctx, q.rdb,
+		[]string{q.stream, q.jobsKey(), q.readyKey(), q.metaKey(), q.activeKey(), q.roundsKey()},
+		q.group, fmt.Sprintf("%d", videoID), int64(fairRoundStride)).Int()
+	return n == 1, q.checkGroup(err)
+}
+
+// parseXAutoClaimReply 解析XAUTOCLAIM的返回：[下次起始ID, 消息列表, (Redis 7)已删除的ID]。
+// Redis 6.2中已删除的消息以空字段返回，作为deleted返回；Redis 7已自动从待确认列表中移除
+func parseXAutoClaimReply(reply interface{}) (messages []redis.XMessage, deleted []string, err error) {
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) < 2 {
+		return nil, nil, fmt.Errorf("XAUTOCLAIM返回格式错误: %v", reply)
+	}
+	entries, ok := parts[1].([]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("XAUTOCLAIM返回格式错误: %v", reply)
+	}
+
+	for _, entry := range entries {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) != 2 {
+			continue
+		}
+		id, ok := fields[0].(string)
+		if !ok {
+			continue
+		}
+		pairs, ok := fields[1].([]interface{})
+		if !ok {
+			deleted = append(deleted, id)
+			continue
+		}
+		values := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if key, ok := pairs[i].(string); ok {
+				values[key] = pairs[i+1]
+			}
+		}
+		messages = append(messages, redis.XMessage{ID: id, Values: values})
+	}
+	return messages, deleted, nil
+}
+
+// parseXInfoReply 解析XINFO GROUPS/CONSUMERS返回的键值对列表。
+// go-redis v8要求字段数固定，无法解析新版本Redis增加的字段
+func parseXInfoReply(reply interface{}) []map[string]interface{} {
+	items, _ := reply.([]interface{})
+	var result []map[string]interface{}
+	for _, item := range items {
+		pairs, ok := item.([]interface{})
+		if !ok {
+			continue
+		}
+		values := make(map[string]interface{}, len(pairs)/2)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if key, ok := pairs[i].(string); ok {
+				values[key] = pairs[i+1]
+			}
+		}
+		result = append(result, values)
+	}
+	return result
+}
@@ -0,0 +1,433 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestNewJobQueue(t *testing.T) {
+	// 只创建客户端，不会连接Redis
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	db := setupTestDB()
+
+	tests := []struct {
+		name    string
+		backend string
+		rdb     *redis.Client
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "默认使用Streams队列", backend: "", rdb: rdb, want: &StreamJobQueue{}},
+		{name: "Streams队列", backend: QueueBackendStream, rdb: rdb, want: &StreamJobQueue{}},
+		{name: "有序集合队列", backend: QueueBackendZSet, rdb: rdb, want: &ZSetJobQueue{}},
+		{name: "未配置Redis", backend: QueueBackendStream, wantErr: true},
+		{name: "进程内队列", backend: QueueBackendMemory, want: &MemoryJobQueue{}},
+		{name: "数据库队列", backend: QueueBackendSQL, want: &SQLJobQueue{}},
+		{name: "未知队列", backend: "kafka", rdb: rdb, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, err := NewJobQueue(tt.rdb, db, config.QueueConfig{Backend: tt.backend})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.IsType(t, tt.want, queue)
+		})
+	}
+}
+
+// setupTestJobQueues 创建各队列实现，租约时长很短以便测试过期接管。
+// Redis实现连接测试配置中的Redis，进程内和数据库实现不依赖外部服务
+func setupTestJobQueues(t *testing.T, maxPerUser int) map[string]JobQueue {
+	c := loadTestConfig()
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     c.Redis.Host + ":6379",
+		Password: c.Redis.Password,
+		DB:       c.Redis.DB,
+	})
+	rdb.FlushDB(context.Background())
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	// 内存数据库的每个连接都是独立的库，限制为单个连接
+	sqlDB, err := db.DB()
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	assert.NoError(t, db.AutoMigrate(&model.RenderQueueJob{}))
+
+	leaseTTL := 50 * time.Millisecond
+	return map[string]JobQueue{
+		QueueBackendStream: NewStreamJobQueue(rdb, "test_render_stream", "test_workers", leaseTTL, maxPerUser),
+		QueueBackendZSet:   NewZSetJobQueue(rdb, "test_render_queue", leaseTTL, maxPerUser),
+		QueueBackendMemory: NewMemoryJobQueue(leaseTTL, maxPerUser),
+		QueueBackendSQL:    NewSQLJobQueue(db, leaseTTL, 10*time.Millisecond, maxPerUser),
+	}
+}
+
+func TestJobQueue_ClaimAndAck(t *testing.T) {
+	queues := setupTestJobQueues(t, 0)
+	ctx := context.Background()
+
+	for name, queue := range queues {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: 1}))
+			assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: 2}))
+			assert.ErrorIs(t, queue.Enqueue(ctx, JobSpec{VideoID: 1}), ErrJobQueued)
+
+			waiting, err := queue.Waiting(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{1, 2}, waiting)
+
+			// 按入队顺序领取
+			job, err := queue.Claim(ctx, "worker-a", 0)
+			assert.NoError(t, err)
+			if !assert.NotNil(t, job) {
+				return
+			}
+			assert.Equal(t, uint(1), job.VideoID)
+			assert.ErrorIs(t, queue.Enqueue(ctx, JobSpec{VideoID: 1}), ErrRenderInProgress)
+			leased, err := queue.Leased(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{1}, leased)
+
+			// 已领取的任务不能移除，等待中的可以
+			removed, err := queue.Remove(ctx, 1)
+			assert.NoError(t, err)
+			assert.False(t, removed)
+			removed, err = queue.Remove(ctx, 2)
+			assert.NoError(t, err)
+			assert.True(t, removed)
+
+			ok, err := queue.Heartbeat(ctx, job)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.NoError(t, queue.Ack(ctx, job))
+
+			_, err = queue.Claim(ctx, "worker-a", 50*time.Millisecond)
+			assert.ErrorIs(t, err, ErrQueueEmpty)
+			waiting, err = queue.Waiting(ctx)
+			assert.NoError(t, err)
+			assert.Empty(t, waiting)
+			leased, err = queue.Leased(ctx)
+			assert.NoError(t, err)
+			assert.Empty(t, leased)
+		})
+	}
+}
+
+func TestJobQueue_ReclaimExpired(t *testing.T) {
+	queues := setupTestJobQueues(t, 0)
+	ctx := context.Background()
+
+	for name, queue := range queues {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: 1}))
+
+			// 模拟工作者领取任务后崩溃，不再续约
+			crashed, err := queue.Claim(ctx, "worker-crashed", 0)
+			assert.NoError(t, err)
+			if !assert.NotNil(t, crashed) {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+
+			_, err = queue.Reap(ctx)
+			assert.NoError(t, err)
+
+			// 租约过期的任务被其他工作者接管
+			job, err := queue.Claim(ctx, "worker-b", 0)
+			assert.NoError(t, err)
+			if !assert.NotNil(t, job) {
+				return
+			}
+			assert.Equal(t, uint(1), job.VideoID)
+
+			// 崩溃的工作者恢复后不能续约或确认该任务
+			ok, err := queue.Heartbeat(ctx, crashed)
+			assert.NoError(t, err)
+			assert.False(t, ok)
+			assert.NoError(t, queue.Ack(ctx, crashed))
+			assert.ErrorIs(t, queue.Enqueue(ctx, JobSpec{VideoID: 1}), ErrRenderInProgress)
+
+			assert.NoError(t, queue.Ack(ctx, job))
+			assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: 1}))
+		})
+	}
+}
+
+func TestJobQueue_PriorityAndFairness(t *testing.T) {
+	queues := setupTestJobQueues(t, 0)
+	ctx := context.Background()
+
+	for name, queue := range queues {
+		t.Run(name, func(t *testing.T) {
+			// 用户1连续提交3个任务后，用户2的任务与其交替调度；管理员任务最先，批量任务最后
+			specs := []JobSpec{
+				{VideoID: 1, UserID: 1, Priority: model.JobPriorityInteractive},
+				{VideoID: 2, UserID: 1, Priority: model.JobPriorityInteractive},
+				{VideoID: 3, UserID: 1, Priority: model.JobPriorityInteractive},
+				{VideoID: 4, UserID: 2, Priority: model.JobPriorityInteractive},
+				{VideoID: 5, UserID: 2},
+				{VideoID: 6, UserID: 3, Priority: model.JobPriorityBatch},
+				{VideoID: 7, UserID: 3, Priority: model.JobPriorityAdmin},
+			}
+			for _, spec := range specs {
+				assert.NoError(t, queue.Enqueue(ctx, spec))
+			}
+
+			waiting, err := queue.Waiting(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{7, 1, 4, 2, 5, 3, 6}, waiting)
+
+			for _, want := range []uint{7, 1, 4} {
+				job, err := queue.Claim(ctx, "worker-a", 0)
+				assert.NoError(t, err)
+				if !assert.NotNil(t, job) {
+					return
+				}
+				assert.Equal(t, want, job.VideoID)
+				assert.NoError(t, queue.Ack(ctx, job))
+			}
+
+			// 新用户的任务排在当前轮次，不必等待用户1剩余的任务
+			assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: 8, UserID: 4}))
+			waiting, err = queue.Waiting(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{2, 5, 8, 3, 6}, waiting)
+		})
+	}
+}
+
+func TestJobQueue_ReturningUserFairness(t *testing.T) {
+	queues := setupTestJobQueues(t, 0)
+	ctx := context.Background()
+
+	for name, queue := range queues {
+		t.Run(name, func(t *testing.T) {
+			// 用户1的任务全部被领取或移除后，再提交的任务不再排在之前的轮次之后
+			for videoID := uint(1); videoID <= 5; videoID++ {
+				assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: videoID, UserID: 1}))
+			}
+			for i := 0; i < 4; i++ {
+				job, err := queue.Claim(ctx, "worker-a", 0)
+				assert.NoError(t, err)
+				if !assert.NotNil(t, job) {
+					return
+				}
+				assert.NoError(t, queue.Ack(ctx, job))
+			}
+			removed, err := queue.Remove(ctx, 5)
+			assert.NoError(t, err)
+			assert.True(t, removed)
+
+			for videoID := uint(10); videoID <= 14; videoID++ {
+				assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: videoID, UserID: 2}))
+			}
+			assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: 100, UserID: 1}))
+
+			waiting, err := queue.Waiting(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []uint{10, 100, 11, 12, 13, 14}, waiting)
+		})
+	}
+}
+
+func TestJobQueue_MaxPerUser(t *testing.T) {
+	queues := setupTestJobQueues(t, 1)
+	ctx := context.Background()
+
+	for name, queue := range queues {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: 1, UserID: 1}))
+			assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: 2, UserID: 1}))
+			assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: 3, UserID: 2}))
+
+			first, err := queue.Claim(ctx, "worker-a", 0)
+			assert.NoError(t, err)
+			if !assert.NotNil(t, first) {
+				return
+			}
+			assert.Equal(t, uint(1), first.VideoID)
+			assert.Equal(t, uint(1), first.UserID)
+
+			// 用户1已达到并发上限，跳过其任务
+			second, err := queue.Claim(ctx, "worker-b", 0)
+			assert.NoError(t, err)
+			if !assert.NotNil(t, second) {
+				return
+			}
+			assert.Equal(t, uint(3), second.VideoID)
+
+			_, err = queue.Claim(ctx, "worker-c", 0)
+			assert.ErrorIs(t, err, ErrQueueEmpty)
+
+			// 用户1的任务完成后可以领取其下一个任务
+			assert.NoError(t, queue.Ack(ctx, first))
+			third, err := queue.Claim(ctx, "worker-c", 0)
+			assert.NoError(t, err)
+			if !assert.NotNil(t, third) {
+				return
+			}
+			assert.Equal(t, uint(2), third.VideoID)
+		})
+	}
+}
+
+func TestJobQueue_NilJob(t *testing.T) {
+	// 空任务在访问Redis或数据库之前就被拒绝，不需要连接外部服务
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	queues := map[string]JobQueue{
+		QueueBackendStream: NewStreamJobQueue(rdb, "test_nil_stream", "test_nil_group", time.Second, 0),
+		QueueBackendZSet:   NewZSetJobQueue(rdb, "test_nil_queue", time.Second, 0),
+		QueueBackendMemory: NewMemoryJobQueue(time.Second, 0),
+		QueueBackendSQL:    NewSQLJobQueue(setupTestDB(), time.Second, time.Second, 0),
+	}
+
+	for name, queue := range queues {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			assert.ErrorIs(t, queue.Ack(ctx, nil), ErrNilJob)
+			ok, err := queue.Heartbeat(ctx, nil)
+			assert.False(t, ok)
+			assert.ErrorIs(t, err, ErrNilJob)
+		})
+	}
+}
+
+func TestNextFairRound(t *testing.T) {
+	tests := []struct {
+		name        string
+		minWaiting  int64
+		hasWaiting  bool
+		userLast    int64
+		hasUserLast bool
+		want        int64
+	}{
+		{name: "空队列", want: 0},
+		{name: "新用户排在队首轮次", minWaiting: 3, hasWaiting: true, want: 3},
+		{name: "用户已有任务排在下一轮", minWaiting: 3, hasWaiting: true, userLast: 5, hasUserLast: true, want: 6},
+		{name: "用户的任务已全部调度", minWaiting: 3, hasWaiting: true, userLast: 1, hasUserLast: true, want: 3},
+		{name: "档位为空时用户继续下一轮", userLast: 2, hasUserLast: true, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, nextFairRound(tt.minWaiting, tt.hasWaiting, tt.userLast, tt.hasUserLast))
+		})
+	}
+}
+
+func TestParseXAutoClaimReply(t *testing.T) {
+	tests := []struct {
+		name        string
+		reply       interface{}
+		wantIDs     []string
+		wantDeleted []string
+		wantErr     bool
+	}{
+		{
+			name: "Redis 7返回三个元素",
+			reply: []interface{}{"0-0",
+				[]interface{}{[]interface{}{"1-0", []interface{}{"video_id", "7"}}},
+				[]interface{}{"0-5"}},
+			wantIDs: []string{"1-0"},
+		},
+		{
+			name: "Redis 6.2中已删除的消息字段为空",
+			reply: []interface{}{"0-0",
+				[]interface{}{[]interface{}{"1-0", nil}, []interface{}{"2-0", []interface{}{"video_id", "8"}}}},
+			wantIDs:     []string{"2-0"},
+			wantDeleted: []string{"1-0"},
+		},
+		{name: "没有可接管的消息", reply: []interface{}{"0-0", []interface{}{}}},
+		{name: "格式错误", reply: "OK", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, deleted, err := parseXAutoClaimReply(tt.reply)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			var ids []string
+			for _, message := range messages {
+				ids = append(ids, message.ID)
+				assert.NotEmpty(t, message.Values["video_id"])
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+			assert.Equal(t, tt.wantDeleted, deleted)
+		})
+	}
+}
+
+func TestParseXInfoReply(t *testing.T) {
+	// Redis 7比go-redis v8多返回entries-read、lag等字段
+	reply := []interface{}{
+		[]interface{}{"name", "render_workers", "consumers", int64(2), "pending", int64(1),
+			"last-delivered-id", "5-0", "entries-read", int64(5), "lag", int64(0)},
+	}
+
+	groups := parseXInfoReply(reply)
+	if assert.Len(t, groups, 1) {
+		assert.Equal(t, "render_workers", groups[0]["name"])
+		assert.Equal(t, "5-0", groups[0]["last-delivered-id"])
+		assert.Equal(t, int64(1), groups[0]["pending"])
+	}
+}
+
+func TestJobQueue_ConcurrentClaim(t *testing.T) {
+	queues := setupTestJobQueues(t, 0)
+	ctx := context.Background()
+
+	for name, queue := range queues {
+		t.Run(name, func(t *testing.T) {
+			const jobs = 20
+			for i := uint(1); i <= jobs; i++ {
+				assert.NoError(t, queue.Enqueue(ctx, JobSpec{VideoID: i}))
+			}
+
+			// 多个工作者同时领取，每个任务只会被领取一次
+			var mu sync.Mutex
+			claimed := make(map[uint]int)
+			var wg sync.WaitGroup
+			for w := 0; w < 4; w++ {
+				wg.Add(1)
+				go func(consumer string) {
+					defer wg.Done()
+					for {
+						job, err := queue.Claim(ctx, consumer, 0)
+						if err != nil {
+							return
+						}
+						mu.Lock()
+						claimed[job.VideoID]++
+						mu.Unlock()
+						queue.Ack(ctx, job)
+					}
+				}(fmt.Sprintf("worker-%d", w))
+			}
+			wg.Wait()
+
+			assert.Len(t, claimed, jobs)
+			for videoID, count := range claimed {
+				assert.Equal(t, 1, count, "视频 %d", videoID)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"manim-backend/internal/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 有序集合队列的Redis键以key为前缀：
+//   - {key}          待处理任务，有序集合，分数为 档位*fairRoundStride + 公平轮次
+//   - {key}:leases   处理中任务的租约，有序集合，分数为租约到期时间（毫秒）
+//   - {key}:owners   处理中任务所属的工作者，哈希
+//   - {key}:signal   新任务通知，工作者通过BLPOP阻塞等待
+//   - {key}:meta     任务所属用户和排序分数，哈希，值为"用户ID:分数"
+//   - {key}:active   用户被领取的任务数，哈希
+//   - {key}:rounds   用户在各档位最后一个等待中任务的轮次，哈希，字段为"档位:用户ID"，
+//     用户在档位中没有等待的任务时删除
+const (
+	leasesKeySuffix = ":leases"
+	ownersKeySuffix = ":owners"
+	signalKeySuffix = ":signal"
+	metaKeySuffix   = ":meta"
+	activeKeySuffix = ":active"
+	roundsKeySuffix = ":rounds"
+
+	// 通知列表的最大长度，没有工作者消费时避免无限增长
+	maxQueueSignals = 1000
+	// 领取任务时最多检查的等待任务数，排在前面的任务都属于达到并发上限的用户时本次不领取
+	fairClaimScan = 100
+)
+
+// fairRoundLua 计算新任务的排序分数并记录用户的轮次，规则与nextFairRound一致
+const fairRoundLua = `
+local function fair_score(waiting, rounds, band, user, stride)
+	local low = band * stride
+	local base = 0
+	local head = redis.call('ZRANGEBYSCORE', waiting, low, '(' .. (low + stride), 'WITHSCORES', 'LIMIT', 0, 1)
+	if #head > 0 then
+		base = tonumber(head[2]) - low
+	end
+	local field = band .. ':' .. user
+	local last = tonumber(redis.call('HGET', rounds, field))
+	local round = base
+	if last and last >= base then
+		round = last + 1
+	end
+	redis.call('HSET', rounds, field, round)
+	return low + round
+end
+`
+
+// pruneRoundLua 任务离开等待队列后维护用户的轮次：离开的是用户在档位中最后一轮的任务时，
+// 改为用户剩余等待任务中最大的轮次，没有剩余任务时删除；restore_round在任务放回等待队列时恢复轮次
+const pruneRoundLua = `
+local function prune_round(waiting, meta, rounds, user, score, stride)
+	local band = math.floor(score / stride)
+	local low = band * stride
+	local field = band .. ':' .. user
+	local last = tonumber(redis.call('HGET', rounds, field))
+	if not last or last > score - low then
+		return
+	end
+	local found = nil
+	local remaining = redis.call('ZRANGEBYSCORE', waiting, low, '(' .. (low + stride), 'WITHSCORES')
+	for i = 1, #remaining, 2 do
+		local value = redis.call('HGET', meta, remaining[i])
+		if value and string.match(value, '^([^:]*)') == user then
+			found = tonumber(remaining[i + 1]) - low
+		end
+	end
+	if found then
+		redis.call('HSET', rounds, field, found)
+	else
+		redis.call('HDEL', rounds, field)
+	end
+end
+
+local function restore_round(rounds, user, score, stride)
+	local band = math.floor(score / stride)
+	local round = score - band * stride
+	local field = band .. ':' .. user
+	local last = tonumber(redis.call('HGET', rounds, field))
+	if not last or last < round then
+		redis.call('HSET', rounds, field, round)
+	end
+end
+`
+
+// pickFairLua 从待处理任务中取出排在最前且用户未达到并发上限的任务，返回任务和用户ID，需与pruneRoundLua一起使用
+const pickFairLua = `
+local function pick_fair(waiting, meta, active, rounds, cap, scan, stride)
+	local items = redis.call('ZRANGE', waiting, 0, scan - 1)
+	for _, id in ipairs(items) do
+		local user = '0'
+		local value = redis.call('HGET', meta, id)
+		if value then
+			user = string.match(value, '^([^:]*)')
+		end
+		if cap <= 0 or (tonumber(redis.call('HGET', active, user)) or 0) < cap then
+			local score = tonumber(redis.call('ZSCORE', waiting, id))
+			redis.call('ZREM', waiting, id)
+			redis.call('HINCRBY', active, user, 1)
+			prune_round(waiting, meta, rounds, user, score, stride)
+			return id, user
+		end
+	end
+	return nil, nil
+end
+`
+
+// releaseUserLua 任务结束领取，减少用户的并发数并删除任务信息
+const releaseUserLua = `
+local function release_user(meta, active, id)
+	local value = redis.call('HGET', meta, id)
+	if not value then
+		return
+	end
+	local user = string.match(value, '^([^:]*)')
+	if redis.call('HINCRBY', active, user, -1) <= 0 then
+		redis.call('HDEL', active, user)
+	end
+	redis.call('HDEL', meta, id)
+end
+`
+
+// enqueueScript 按档位和公平轮次添加任务，返回1表示已在队列中，返回2表示正在处理
+var enqueueScript = redis.NewScript(fairRoundLua + `
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	return 1
+end
+if redis.call('ZSCORE', KEYS[2], ARGV[1]) then
+	return 2
+end
+local score = fair_score(KEYS[1], KEYS[4], tonumber(ARGV[3]), ARGV[2], tonumber(ARGV[4]))
+redis.call('ZADD', KEYS[1], score, ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2] .. ':' .. score)
+redis.call('LPUSH', KEYS[5], ARGV[1])
+redis.call('LTRIM', KEYS[5], 0, tonumber(ARGV[5]) - 1)
+return 0
+`)
+
+// claimScript 原子地取出可领取的任务并登记租约，工作者崩溃时任务仍在租约集合中，不会丢失
+var claimScript = redis.NewScript(pruneRoundLua + pickFairLua + `
+local id, user = pick_fair(KEYS[1], KEYS[4], KEYS[5], KEYS[6], tonumber(ARGV[4]), tonumber(ARGV[5]), tonumber(ARGV[6]))
+if not id then
+	return false
+end
+redis.call('ZADD', KEYS[2], tonumber(ARGV[1]) + tonumber(ARGV[2]), id)
+redis.call('HSET', KEYS[3], id, ARGV[3])
+return {id, user}
+`)
+
+// heartbeatScript 续约，任务已被重新分配给其他工作者时返回0
+var heartbeatScript = redis.NewScript(`
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('ZADD', KEYS[1], 'XX', ARGV[3], ARGV[1])
+return 1
+`)
+
+// ackScript 确认任务完成并释放租约，用户的并发数减少后通知工作者领取之前被跳过的任务
+var ackScript = redis.NewScript(releaseUserLua + `
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+release_user(KEYS[3], KEYS[4], ARGV[1])
+if redis.call('ZCARD', KEYS[5]) > 0 then
+	redis.call('LPUSH', KEYS[6], ARGV[1])
+	redis.call('LTRIM', KEYS[6], 0, tonumber(ARGV[3]) - 1)
+end
+return 1
+`)
+
+// reapScript 将租约过期的任务按原来的分数放回待处理队列，并恢复用户的轮次
+var reapScript = redis.NewScript(pruneRoundLua + `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, id in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('HDEL', KEYS[3], id)
+	local score = ARGV[3]
+	local value = redis.call('HGET', KEYS[5], id)
+	if value then
+		local user = string.match(value, '^([^:]*)')
+		score = string.match(value, ':(.*)$')
+		if redis.call('HINCRBY', KEYS[6], user, -1) <= 0 then
+			redis.call('HDEL', KEYS[6], user)
+		end
+		restore_round(KEYS[7], user, tonumber(score), tonumber(ARGV[5]))
+	end
+	redis.call('ZADD', KEYS[1], 'NX', score, id)
+	redis.call('LPUSH', KEYS[4], id)
+end
+if #expired > 0 then
+	redis.call('LTRIM', KEYS[4], 0, tonumber(ARGV[4]) - 1)
+end
+return #expired
+`)
+
+// removeScript 移除等待中的任务及其信息
+var removeScript = redis.NewScript(pruneRoundLua + `
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+local value = redis.call('HGET', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+if value then
+	prune_round(KEYS[1], KEYS[2], KEYS[3], string.match(value, '^([^:]*)'), tonumber(score), tonumber(ARGV[2]))
+end
+return 1
+`)
+
+// ZSetJobQueue 基于Redis有序集合的渲染队列，领取的任务进入租约集合，
+// 过期租约由Reap定期放回待处理队列
+type ZSetJobQueue struct {
+	rdb        *redis.Client
+	key        string
+	leaseTTL   time.Duration
+	maxPerUser int
+}
+
+// NewZSetJobQueue maxPerUser为每个用户同时被领取的任务数上限，0表示不限制
+func NewZSetJobQueue(rdb *redis.Client, key string, leaseTTL time.Duration, maxPerUser int) *ZSetJobQueue {
+	return &ZSetJobQueue{rdb: rdb, key: key, leaseTTL: leaseTTL, maxPerUser: maxPerUser}
+}
+
+func (q *ZSetJobQueue) leasesKey() string { return q.key + leasesKeySuffix }
+func (q *ZSetJobQueue) ownersKey() string { return q.key + ownersKeySuffix }
+func (q *ZSetJobQueue) signalKey() string { return q.key + signalKeySuffix }
+func (q *ZSetJobQueue) metaKey() string   { return q.key + metaKeySuffix }
+func (q *ZSetJobQueue) activeKey() string { return q.key + activeKeySuffix }
+func (q *ZSetJobQueue) roundsKey() string { return q.key + roundsKeySuffix }
+
+// Enqueue 按优先级档位和用户的公平轮次添加任务，同时通知等待中的工作者
+func (q *ZSetJobQueue) Enqueue(ctx context.Context, spec JobSpec) error {
+	n, err := enqueueScript.Run(ctx, q.rdb,
+		[]string{q.key, q.leasesKey(), q.metaKey(), q.roundsKey(), q.signalKey()},
+		fmt.Sprintf("%d", spec.VideoID), spec.UserID, model.JobPriorityBand(spec.Priority),
+		int64(fairRoundStride), maxQueueSignals).Int()
+	if err != nil {
+		return err
+	}
+	switch n {
+	case 1:
+		return ErrJobQueued
+	case 2:
+		return ErrRenderInProgress
+	}
+	return nil
+}
+
+// Claim 领取排在最前的可领取任务，没有时阻塞等待新任务通知后再尝试一次
+func (q *ZSetJobQueue) Claim(ctx context.Context, consumer string, block time.Duration) (*Job, error) {
+	job, err := q.claim(ctx, consumer)
+	if !errors.Is(err, ErrQueueEmpty) || block <= 0 {
+		return job, err
+	}
+
+	if err := q.rdb.BLPop(ctx, block, q.signalKey()).Err(); err != nil {
+		if err == redis.Nil {
+			return nil, ErrQueueEmpty
+		}
+		return nil, err
+	}
+	return q.claim(ctx, consumer)
+}
+
+func (q *ZSetJobQueue) claim(ctx context.Context, consumer string) (*Job, error) {
+	values, err := claimScript.Run(ctx, q.rdb,
+		[]string{q.key, q.leasesKey(), q.ownersKey(), q.metaKey(), q.activeKey(), q.roundsKey()},
+		time.Now().UnixMilli(), q.leaseTTL.Milliseconds(), consumer, q.maxPerUser, fairClaimScan, int64(fairRoundStride)).StringSlice()
+	if err == redis.Nil {
+		return nil, ErrQueueEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 2 {
+		return nil, fmt.Errorf("领取任务返回格式错误: %v", values)
+	}
+
+	videoID, err := parseVideoID(values[0])
+	if err != nil {
+		return nil, err
+	}
+	userID, _ := parseVideoID(values[1])
+	return &Job{VideoID: videoID, UserID: userID, ID: values[0], Consumer: consumer}, nil
+}
+
+// Heartbeat 延长任务租约
+func (q *ZSetJobQueue) Heartbeat(ctx context.Context, job *Job) (bool, error) {
+	if job == nil {
+		return false, ErrNilJob
+	}
+	expiresAt := time.Now().Add(q.leaseTTL).UnixMilli()
+	n, err := heartbeatScript.Run(ctx, q.rdb,
+		[]string{q.leasesKey(), q.ownersKey()},
+		job.ID, job.Consumer, expiresAt).Int()
+	return n == 1, err
+}
+
+// Ack 确认任务完成，任务已被其他工作者接管时不做处理
+func (q *ZSetJobQueue) Ack(ctx context.Context, job *Job) error {
+	if job == nil {
+		return ErrNilJob
+	}
+	return ackScript.Run(ctx, q.rdb,
+		[]string{q.leasesKey(), q.ownersKey(), q.metaKey(), q.activeKey(), q.key, q.signalKey()},
+		job.ID, job.Consumer, maxQueueSignals).Err()
+}
+
+// Reap 将租约过期的任务放回待处理队列
+func (q *ZSetJobQueue) Reap(ctx context.Context) (int, error) {
+	// 没有任务信息的任务按普通优先级放在档位最前
+	fallback := fairScore(model.JobPriorityBand(model.JobPriorityInteractive), 0)
+	return reapScript.Run(ctx, q.rdb,
+		[]string{q.key, q.leasesKey(), q.ownersKey(), q.signalKey(), q.metaKey(), q.activeKey(), q.roundsKey()},
+		time.Now().UnixMilli(), 100, int64(fallback), maxQueueSignals, int64(fairRoundStride)).Int()
+}
+
+// Waiting 按调度顺序获取等待中的视频ID
+func (q *ZSetJobQueue) Waiting(ctx context.Context) ([]uint, error) {
+	members, err := q.rdb.ZRange(ctx, q.key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var videoIDs []uint
+	for _, member := range members {
+		if videoID, err := parseVideoID(member); err == nil {
+			videoIDs = append(videoIDs, videoID)
+		}
+	}
+	return videoIDs, nil
+}
+
+// Leased 获取租约集合中的视频ID
+func (q *ZSetJobQueue) Leased(ctx context.Context) ([]uint, error) {
+	members, err := q.rdb.ZRange(ctx, q.leasesKey(), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var videoIDs []uint
+	for _, member := range members {
+		if videoID, err := parseVideoID(member); err == nil {
+			videoIDs = append(videoIDs, videoID)
+		}
+	}
+	return videoIDs, nil
+}
+
+// Remove 移除等待中的任务
+func (q *ZSetJobQueue) Remove(ctx context.Context, videoID uint) (bool, error) {
+	n, err := removeScript.Run(ctx, q.rdb, []string{q.key, q.metaKey(), q.roundsKey()}, fmt.Sprintf("%d", videoID), int64(fairRoundStride)).Int()
+	return n == 1, err
+}
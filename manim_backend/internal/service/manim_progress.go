@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"manim-backend/internal/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// tqdm进度条，例如：Animation 0: Create(Circle):  45%|####5     | 27/60 [00:00<00:01, 80.12it/s]
+	progressBarPattern = regexp.MustCompile(`Animation (\d+)\s*:.*?(\d+)%\|[^|]*\|\s*(\d+)/(\d+)\s*\[([\d:]+)<([\d:?]+)`)
+	// 单个动画渲染完成，例如：Animation 0 : Partial movie file written in '...'
+	animationDonePattern = regexp.MustCompile(`Animation (\d+)\s*:\s*(Partial movie file written|Using cached data)`)
+	// 渲染结束统计，例如：Played 10 animations
+	playedPattern = regexp.MustCompile(`Played (\d+) animations?`)
+	// LaTeX编译，例如：Writing "x^2" to media/Tex/xxx.tex
+	compilingPattern = regexp.MustCompile(`Writing .* to .*\.tex`)
+	// 代码中的动画调用
+	animationCallPattern = regexp.MustCompile(`self\.(play|wait)\(`)
+)
+
+// estimateAnimationCount 根据代码中的play/wait调用估算动画总数
+func estimateAnimationCount(manimCode string) int {
+	return len(animationCallPattern.FindAllString(manimCode, -1))
+}
+
+// renderProgressParser 解析Manim输出得到结构化进度，可被多个goroutine并发调用
+type renderProgressParser struct {
+	mu        sync.Mutex
+	progress  model.RenderProgress
+	completed int
+}
+
+func newRenderProgressParser(totalAnimations int) *renderProgressParser {
+	return &renderProgressParser{
+		progress: model.RenderProgress{
+			Phase:           model.RenderPhaseStarting,
+			TotalAnimations: totalAnimations,
+			ETASeconds:      -1,
+		},
+	}
+}
+
+// Feed 解析一行输出，进度发生变化时返回最新快照
+func (p *renderProgressParser) Feed(line string) (model.RenderProgress, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changed := false
+	switch {
+	case progressBarPattern.MatchString(line):
+		m := progressBarPattern.FindStringSubmatch(line)
+		index, _ := strconv.Atoi(m[1])
+		percent, _ := strconv.ParseFloat(m[2], 64)
+		frames, _ := strconv.Atoi(m[3])
+		totalFrames, _ := strconv.Atoi(m[4])
+
+		p.progress.Phase = model.RenderPhaseRendering
+		p.progress.CurrentAnimation = index + 1
+		p.progress.Percent = percent
+		p.progress.FramesRendered = frames
+		p.progress.TotalFrames = totalFrames
+		p.progress.ETASeconds = parseClockDuration(m[6])
+		changed = true
+
+	case animationDonePattern.MatchString(line):
+		m := animationDonePattern.FindStringSubmatch(line)
+		index, _ := strconv.Atoi(m[1])
+
+		p.progress.Phase = model.RenderPhaseRendering
+		p.progress.CurrentAnimation = index + 1
+		p.progress.Percent = 100
+		p.progress.FramesRendered = p.progress.TotalFrames
+		p.progress.ETASeconds = 0
+		if index+1 > p.completed {
+			p.completed = index + 1
+		}
+		changed = true
+
+	case strings.Contains(line, "Combining to Movie file"):
+		p.progress.Phase = model.RenderPhaseMuxing
+		changed = true
+
+	case playedPattern.MatchString(line):
+		m := playedPattern.FindStringSubmatch(line)
+		total, _ := strconv.Atoi(m[1])
+		p.progress.TotalAnimations = total
+		p.completed = total
+		changed = true
+
+	case strings.Contains(line, "File ready at"):
+		p.progress.Phase = model.RenderPhaseDone
+		p.progress.Percent = 100
+		p.progress.ETASeconds = 0
+		changed = true
+
+	case compilingPattern.MatchString(line) && p.progress.Phase != model.RenderPhaseMuxing:
+		if p.progress.Phase != model.RenderPhaseCompiling {
+			p.progress.Phase = model.RenderPhaseCompiling
+			changed = true
+		}
+	}
+
+	if !changed {
+		return p.progress, false
+	}
+
+	// 估算值偏小时以实际渲染到的动画为准
+	if p.progress.CurrentAnimation > p.progress.TotalAnimations {
+		p.progress.TotalAnimations = p.progress.CurrentAnimation
+	}
+	p.progress.OverallPercent = p.overallPercent()
+	p.progress.UpdatedAt = time.Now().Unix()
+
+	return p.progress, true
+}
+
+// Snapshot 获取当前进度
+func (p *renderProgressParser) Snapshot() model.RenderProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.progress
+}
+
+// overallPercent 计算整体进度
+func (p *renderProgressParser) overallPercent() float64 {
+	if p.progress.Phase == model.RenderPhaseDone {
+		return 100
+	}
+	if p.progress.TotalAnimations <= 0 {
+		return 0
+	}
+
+	done := float64(p.completed)
+	// 当前动画尚未完成时计入其部分进度
+	if p.progress.CurrentAnimation > p.completed {
+		done += p.progress.Percent / 100
+	}
+
+	overall := done / float64(p.progress.TotalAnimations) * 100
+	// 合成视频之前不报告100%
+	if overall > 99 {
+		overall = 99
+	}
+	return float64(int(overall*10)) / 10
+}
+
+// parseClockDuration 解析tqdm的时间格式（MM:SS或HH:MM:SS），无法解析时返回-1
+func parseClockDuration(value string) int {
+	parts := strings.Split(value, ":")
+	seconds := 0
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return -1
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+// RenderProgressStore 渲染进度存储，配置Redis时保存在Redis中以便多实例共享
+type RenderProgressStore struct {
+	rdb       *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+
+	mu    sync.RWMutex
+	local map[uint]model.RenderProgress
+}
+
+func NewRenderProgressStore(rdb *redis.Client) *RenderProgressStore {
+	return &RenderProgressStore{
+		rdb:       rdb,
+		keyPrefix: "video_progress:",
+		ttl:       24 * time.Hour,
+		local:     make(map[uint]model.RenderProgress),
+	}
+}
+
+func (s *RenderProgressStore) key(videoID uint) string {
+	return fmt.Sprintf("%s%d", s.keyPrefix, videoID)
+}
+
+// Save 保存渲染进度
+func (s *RenderProgressStore) Save(ctx context.Context, videoID uint, progress model.RenderProgress) error {
+	if s.rdb == nil {
+		s.mu.Lock()
+		s.local[videoID] = progress
+		s.mu.Unlock()
+		return nil
+	}
+
+	payload, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("序列化渲染进度失败: %v", err)
+	}
+
+	return s.rdb.Set(ctx, s.key(videoID), payload, s.ttl).Err()
+}
+
+// Get 获取渲染进度，没有记录时返回nil
+func (s *RenderProgressStore) Get(ctx context.Context, videoID uint) (*model.RenderProgress, error) {
+	if s.rdb == nil {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if progress, ok := s.local[videoID]; ok {
+			return &progress, nil
+		}
+		return nil, nil
+	}
+
+	payload, err := s.rdb.Get(ctx, s.key(videoID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var progress model.RenderProgress
+	if err := json.Unmarshal(payload, &progress); err != nil {
+		return nil, fmt.Errorf("解析渲染进度失败: %v", err)
+	}
+	return &progress, nil
+}
+
+// Delete 删除渲染进度
+func (s *RenderProgressStore) Delete(ctx context.Context, videoID uint) error {
+	if s.rdb == nil {
+		s.mu.Lock()
+		delete(s.local, videoID)
+		s.mu.Unlock()
+		return nil
+	}
+
+	return s.rdb.Del(ctx, s.key(videoID)).Err()
+}
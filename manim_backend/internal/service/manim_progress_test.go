@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderProgressParser_Feed(t *testing.T) {
+	parser := newRenderProgressParser(estimateAnimationCount(testManimCode))
+	assert.Equal(t, 12, parser.Snapshot().TotalAnimations)
+	assert.Equal(t, model.RenderPhaseStarting, parser.Snapshot().Phase)
+
+	// 无关输出不改变进度
+	_, changed := parser.Feed("Manim Community v0.18.0")
+	assert.False(t, changed)
+
+	progress, changed := parser.Feed(`INFO     Writing "3^2" to media/Tex/7a1c.tex`)
+	assert.True(t, changed)
+	assert.Equal(t, model.RenderPhaseCompiling, progress.Phase)
+
+	progress, changed = parser.Feed("Animation 0: Create(Polygon):  45%|####5     | 27/60 [00:00<00:01, 80.12it/s]")
+	assert.True(t, changed)
+	assert.Equal(t, model.RenderPhaseRendering, progress.Phase)
+	assert.Equal(t, 1, progress.CurrentAnimation)
+	assert.Equal(t, float64(45), progress.Percent)
+	assert.Equal(t, 27, progress.FramesRendered)
+	assert.Equal(t, 60, progress.TotalFrames)
+	assert.Equal(t, 1, progress.ETASeconds)
+	assert.InDelta(t, 3.7, progress.OverallPercent, 0.1)
+
+	progress, _ = parser.Feed("INFO     Animation 0 : Partial movie file written in '/tmp/media/partial_movie_files/uncached_00000.mp4'")
+	assert.Equal(t, float64(100), progress.Percent)
+	assert.InDelta(t, 8.3, progress.OverallPercent, 0.1)
+
+	// ETA未知时为-1
+	progress, _ = parser.Feed("Animation 1: Wait(run_time=0.5):   0%|          | 0/15 [00:00<?, ?it/s]")
+	assert.Equal(t, 2, progress.CurrentAnimation)
+	assert.Equal(t, -1, progress.ETASeconds)
+
+	progress, _ = parser.Feed("INFO     Combining to Movie file.")
+	assert.Equal(t, model.RenderPhaseMuxing, progress.Phase)
+
+	progress, _ = parser.Feed("INFO     Played 2 animations")
+	assert.Equal(t, 2, progress.TotalAnimations)
+	assert.Equal(t, float64(99), progress.OverallPercent)
+
+	progress, _ = parser.Feed("INFO     File ready at '/tmp/media/videos/animation/720p30/PythagoreanTheorem.mp4'")
+	assert.Equal(t, model.RenderPhaseDone, progress.Phase)
+	assert.Equal(t, float64(100), progress.OverallPercent)
+}
+
+func TestRenderProgressParser_TotalGrowsWithActualAnimations(t *testing.T) {
+	// 循环中的动画会导致估算值偏小
+	parser := newRenderProgressParser(1)
+
+	progress, _ := parser.Feed("Animation 4: FadeIn(Square):  10%|#         | 6/60 [00:00<00:02, 30.00it/s]")
+	assert.Equal(t, 5, progress.CurrentAnimation)
+	assert.Equal(t, 5, progress.TotalAnimations)
+}
+
+func TestParseClockDuration(t *testing.T) {
+	assert.Equal(t, 75, parseClockDuration("01:15"))
+	assert.Equal(t, 3675, parseClockDuration("01:01:15"))
+	assert.Equal(t, -1, parseClockDuration("?"))
+}
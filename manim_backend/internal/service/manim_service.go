@@ -1,26 +1,27 @@
 package service
 
 import (
-	"bufio"
 	"context"
 	"fmt"
-	"io"
+	"log"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
 	"manim-backend/internal/config"
 	"manim-backend/internal/model"
-
-	"github.com/google/uuid"
 )
 
 type ManimService struct {
 	cfg          config.ManimConfig
 	videoService *VideoService
+	renderer     Renderer
+	concatenator VideoConcatenator
+	prober       MediaProber
+	workspaces   *WorkspaceManager
+	locker       *RenderLocker
 	semaphore    chan struct{}
 	mu           sync.Mutex
 }
@@ -28,11 +29,27 @@ type ManimService struct {
 func NewManimService(cfg config.ManimConfig, videoService *VideoService) *ManimService {
 	semaphore := make(chan struct{}, cfg.MaxConcurrent)
 
-	return &ManimService{
+	renderer, err := NewRenderer(cfg)
+	if err != nil {
+		log.Printf("创建渲染器失败，使用本地渲染器: %v", err)
+		renderer = NewLocalRenderer(cfg.PythonPath)
+	}
+
+	s := &ManimService{
 		cfg:          cfg,
 		videoService: videoService,
+		concatenator: NewFFmpegConcatenator(cfg.FFmpegPath),
+		prober:       NewFFprobeProber(cfg.FFprobePath),
+		workspaces:   NewWorkspaceManager(cfg.Workspace),
+		locker:       NewRenderLocker(nil, time.Duration(cfg.Queue.LeaseSeconds)*time.Second),
+		renderer:     renderer,
 		semaphore:    semaphore,
 	}
+	// 假渲染器不生成真实的媒体文件，配置为fake时由它描述自身的产物
+	if fake, ok := renderer.(*FakeRenderer); ok && cfg.Renderer == RendererFake {
+		s.prober = fake
+	}
+	return s
 }
 
 // SetVideoService 设置VideoService依赖
@@ -40,17 +57,43 @@ func (s *ManimService) SetVideoService(videoService *VideoService) {
 	s.videoService = videoService
 }
 
-// GenerateVideo 生成视频
-func (s *ManimService) GenerateVideo(ctx context.Context, videoID uint, manimCode string) error {
-	// 获取信号量，控制并发数量
-	s.semaphore <- struct{}{}
-	defer func() { <-s.semaphore }()
+// SetRenderer 替换渲染器，不影响媒体探测器
+func (s *ManimService) SetRenderer(renderer Renderer) {
+	s.renderer = renderer
+}
+
+// Workspaces 获取渲染工作目录管理器
+func (s *ManimService) Workspaces() *WorkspaceManager {
+	return s.workspaces
+}
+
+// SetProber 替换媒体探测器
+func (s *ManimService) SetProber(prober MediaProber) {
+	s.prober = prober
+}
+
+// SetLocker 替换渲染锁，多实例部署时使用基于Redis的锁
+func (s *ManimService) SetLocker(locker *RenderLocker) {
+	s.locker = locker
+}
 
-	// 更新视频状态为处理中
-	err := s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusProcessing, manimCode, "", "")
+// SetConcatenator 替换场景视频拼接器
+func (s *ManimService) SetConcatenator(concatenator VideoConcatenator) {
+	s.concatenator = concatenator
+}
+
+// GenerateVideo 生成视频。同一视频同时只会有一个渲染任务，其他调用返回ErrRenderInProgress；
+// 已完成的视频不会重复渲染。渲染失败时由RecordRenderFailure决定重试还是标记失败
+func (s *ManimService) GenerateVideo(ctx context.Context, videoID uint, manimCode string) (err error) {
+	lock, err := s.locker.TryLock(ctx, videoID)
 	if err != nil {
 		return err
 	}
+	defer lock.Release()
+
+	// 获取信号量，控制并发数量
+	s.semaphore <- struct{}{}
+	defer func() { <-s.semaphore }()
 
 	// 获取视频信息以获取用户ID
 	video, err := s.videoService.GetVideoByID(ctx, videoID)
@@ -58,832 +101,320 @@ func (s *ManimService) GenerateVideo(ctx context.Context, videoID uint, manimCod
 		s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("获取视频信息失败: %v", err))
 		return err
 	}
-
-	// 创建临时工作目录
-	tempDir := filepath.Join("temp", uuid.New().String())
-	err = os.MkdirAll(tempDir, 0755)
-	if err != nil {
-		s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("创建临时目录失败: %v", err))
-		return err
+	if video.Status == model.VideoStatusCompleted {
+		log.Printf("视频 %d 已渲染完成，跳过", videoID)
+		return nil
 	}
-	// defer os.RemoveAll(tempDir) // 注释掉删除临时目录的代码，保留中间文件用于调试
 
-	// 写入Manim代码文件
-	codeFile := filepath.Join(tempDir, "animation.py")
-	err = os.WriteFile(codeFile, []byte(manimCode), 0644)
+	// 更新视频状态为处理中并记录本次渲染
+	err = s.videoService.BeginRenderAttempt(ctx, videoID, manimCode)
 	if err != nil {
-		s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("写入代码文件失败: %v", err))
 		return err
 	}
 
-	// 执行Manim命令
-	// 使用项目根目录下的videos目录作为输出目录，按用户ID分类
-	outputDir := filepath.Join("videos", fmt.Sprintf("%d", video.UserID))
-	err = os.MkdirAll(outputDir, 0755)
-	if err != nil {
-		s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("创建输出目录失败: %v", err))
-		return err
+	// 相同的代码和渲染参数已经渲染过时直接引用已有的产物
+	opts := renderOptionsOrDefault(video.RenderOptions)
+	cacheKey := s.videoService.cache.Key(manimCode, opts)
+	if linked, err := s.videoService.LinkCachedRender(ctx, videoID, cacheKey, manimCode); err != nil {
+		log.Printf("视频 %d 读取渲染缓存失败，重新渲染: %v", videoID, err)
+	} else if linked {
+		return nil
 	}
 
-	// 执行Manim渲染命令
-	videoPath, err := s.executeManimCommand(ctx, videoID, manimCode, codeFile, outputDir, tempDir)
-	if err != nil {
-		return err // 错误信息已在executeManimCommand中更新
-	}
-
-	// 提取原始文件名（不重命名）
-	originalFileName := filepath.Base(videoPath)
-	// 将视频文件移动到最终目录，保持原始文件名
-	finalVideoPath := filepath.Join("videos", fmt.Sprintf("%d", video.UserID), originalFileName)
-	err = s.moveVideoToFinalLocation(videoPath, finalVideoPath, videoID, manimCode)
-	if err != nil {
-		return err // 错误信息已在moveVideoToFinalLocation中更新
-	}
-
-	// 立即更新数据库中的video_path字段
-	err = s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusCompleted, manimCode, finalVideoPath, "")
+	// 创建临时工作目录，任务结束后删除；失败任务的目录可按配置保留用于调试
+	workspace, err := s.workspaces.Create(videoID)
 	if err != nil {
+		s.videoService.RecordRenderFailure(ctx, videoID, model.FailureReasonStorage, manimCode, err.Error())
 		return err
 	}
+	defer func() { s.workspaces.Release(workspace, err != nil) }()
+	tempDir := workspace.Dir
 
-	return nil
-}
-
-// executeManimCommand 执行Manim渲染命令
-func (s *ManimService) executeManimCommand(ctx context.Context, videoID uint, manimCode, codeFile, outputDir, tempDir string) (string, error) {
-	// 根据Manim文档和测试，正确的命令格式是：manim render [OPTIONS] FILE [SCENE_NAMES]
-	// 使用 -qm 参数表示中等质量，--media_dir 指定媒体目录
-	// 注意：codeFile需要使用绝对路径，因为cmd.Dir设置为tempDir
-	absCodeFile, _ := filepath.Abs(codeFile)
-	cmd := exec.CommandContext(ctx, s.cfg.PythonPath, "-m", "manim", "render",
-		"-qm",                    // 中等质量
-		"--media_dir", outputDir, // 媒体目录
-		absCodeFile) // Python脚本文件（绝对路径）
-	cmd.Dir = tempDir
-
-	// 设置环境变量，确保Manim能找到依赖
-	cmd.Env = append(os.Environ(),
-		"PYTHONPATH=", // 清空PYTHONPATH避免冲突
-	)
-
-	// 使用实时输出监控来获取进度信息
-	output, err := s.executeManimWithProgressMonitoring(ctx, cmd, videoID, manimCode)
-	if err != nil {
-		return "", err
-	}
-
-	// 智能等待视频文件完全生成
-	videoPath, err := s.waitForVideoFileGeneration(codeFile, outputDir, output)
+	// 写入Manim代码文件
+	codeFile := filepath.Join(tempDir, "animation.py")
+	err = os.WriteFile(codeFile, []byte(manimCode), 0644)
 	if err != nil {
-		s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusFailed, manimCode, "", err.Error())
-		return "", err
+		s.videoService.RecordRenderFailure(ctx, videoID, model.FailureReasonStorage, manimCode, fmt.Sprintf("写入代码文件失败: %v", err))
+		return err
 	}
 
-	return videoPath, nil
-}
+	// 最终产物上传到存储的videos目录中，按用户ID和视频ID分类，
+	// 同一用户的不同视频使用相同的场景类名时不会互相覆盖
+	finalDir := path.Join("videos", fmt.Sprintf("%d", video.UserID), fmt.Sprintf("%d", videoID))
 
-// executeManimWithProgressMonitoring 执行Manim命令并监控进度
-func (s *ManimService) executeManimWithProgressMonitoring(ctx context.Context, cmd *exec.Cmd, videoID uint, manimCode string) ([]byte, error) {
-	// 创建管道来捕获实时输出
-	stdoutPipe, err := cmd.StdoutPipe()
+	// 未指定场景时渲染代码中的全部场景
+	scenes, err := ResolveScenes(manimCode, opts.SceneList())
 	if err != nil {
-		return nil, fmt.Errorf("创建标准输出管道失败: %v", err)
+		s.videoService.RecordRenderFailure(ctx, videoID, model.FailureReasonInvalidScene, manimCode, fmt.Sprintf("场景选择无效: %v", err))
+		return err
 	}
 
-	stderrPipe, err := cmd.StderrPipe()
+	// 渲染和拼接受单个任务的超时限制，超时后渲染器终止整个进程树
+	timeout := s.videoService.RenderTimeout(ctx, video.UserID)
+	renderCtx, cancelRender := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		renderCtx, cancelRender = context.WithTimeoutCause(ctx, timeout, ErrRenderTimeout)
+	}
+	defer cancelRender()
+
+	// 执行Manim渲染，实时解析输出得到渲染进度
+	reporter := newProgressReporter(ctx, s.videoService, videoID, manimCode)
+	result, err := s.renderer.Render(renderCtx, RenderJob{
+		VideoID:   videoID,
+		Code:      manimCode,
+		CodeFile:  codeFile,
+		WorkDir:   tempDir,
+		OutputDir: "media", // 位于本次任务的工作目录中，不会与其他任务的输出混在一起
+		Scenes:    scenes,
+		Options:   opts,
+		OnOutput:  reporter.OnOutput,
+	})
+	reporter.Flush()
 	if err != nil {
-		return nil, fmt.Errorf("创建标准错误管道失败: %v", err)
-	}
-
-	// 启动命令
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("启动Manim命令失败: %v", err)
-	}
-
-	// 创建缓冲区来收集输出
-	var outputBuffer strings.Builder
-
-	// 创建进度监控通道
-	progressChan := make(chan string, 100)
-	doneChan := make(chan bool)
-
-	// 启动输出监控goroutine
-	go s.monitorManimOutput(stdoutPipe, stderrPipe, &outputBuffer, progressChan, doneChan, videoID, manimCode)
-
-	// 等待命令完成
-	err = cmd.Wait()
-	doneChan <- true
-
-	// 检查Manim是否成功执行
-	// Windows系统Manim有时会返回STATUS_CONTROL_C_EXIT (0xc000013a)但实际执行成功
-	// 需要检查输出内容来判断是否真正失败
-	output := []byte(outputBuffer.String())
-
-	if err != nil {
-		// 检查是否是Windows特定的控制台退出错误
-		if _, ok := err.(*exec.ExitError); ok {
-			// Windows系统: 0xc000013a = STATUS_CONTROL_C_EXIT
-			// 检查输出中是否包含成功信息
-			outputStr := string(output)
-			if strings.Contains(outputStr, "File ready at") ||
-				strings.Contains(outputStr, "Rendered") ||
-				strings.Contains(outputStr, "Played") {
-				// Manim实际执行成功，只是退出码异常
-				// 继续执行视频文件查找逻辑
-			} else {
-				// 真正的执行失败
-				s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("Manim执行失败: %v, 输出: %s", err, output))
-				return output, fmt.Errorf("Manim执行失败: %v, 输出: %s", err, output)
-			}
-		} else {
-			// 其他类型的错误
-			s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("Manim执行失败: %v, 输出: %s", err, output))
-			return output, fmt.Errorf("Manim执行失败: %v, 输出: %s", err, output)
+		var logs string
+		if result != nil {
+			logs = result.Logs
+		}
+		if timeoutErr := renderTimeoutError(ctx, renderCtx, timeout, err); timeoutErr != nil {
+			s.videoService.RecordRenderFailureWithLogs(ctx, videoID, model.FailureReasonTimeout, manimCode, timeoutErr.Error(), logs)
+			return timeoutErr
 		}
+		s.videoService.RecordRenderFailureWithLogs(ctx, videoID, renderFailureReason(err), manimCode, err.Error(), logs)
+		return err
+	}
+	renderArtifacts := result.Artifacts
+	if len(renderArtifacts) == 0 {
+		// 只返回ArtifactPath的渲染器按单个视频处理
+		renderArtifacts = []RenderArtifact{{Kind: model.ArtifactKindVideo, Format: model.FormatFromPath(result.ArtifactPath), Path: result.ArtifactPath}}
 	}
 
-	return output, nil
-}
-
-// monitorManimOutput 监控Manim输出并检测进度
-func (s *ManimService) monitorManimOutput(stdoutPipe, stderrPipe io.ReadCloser, outputBuffer *strings.Builder, progressChan chan<- string, doneChan <-chan bool, videoID uint, manimCode string) {
-	// 创建扫描器来读取输出
-	stdoutScanner := bufio.NewScanner(stdoutPipe)
-	stderrScanner := bufio.NewScanner(stderrPipe)
-
-	// 启动goroutine来读取标准输出
-	go func() {
-		for stdoutScanner.Scan() {
-			line := stdoutScanner.Text()
-			outputBuffer.WriteString(line + "\n")
-
-			// 检测进度信息
-			if progress := s.detectManimProgress(line); progress != "" {
-				select {
-				case progressChan <- progress:
-				default:
-					// 通道已满，跳过
-				}
+	// 多个场景时按顺序拼接为完整视频，作为主视频放在最前面
+	if opts.Concat {
+		concatenated, err := s.concatScenes(renderCtx, videoID, tempDir, opts, renderArtifacts)
+		if err != nil {
+			if timeoutErr := renderTimeoutError(ctx, renderCtx, timeout, err); timeoutErr != nil {
+				s.videoService.RecordRenderFailureWithLogs(ctx, videoID, model.FailureReasonTimeout, manimCode, timeoutErr.Error(), result.Logs)
+				return timeoutErr
 			}
-
-			// 检测完成标志
-			if s.isManimComplete(line) {
-				// 可以在这里更新状态为"渲染完成"
+			// ffmpeg被杀或磁盘已满等按输出判断原因，无法识别时按拼接失败重试
+			output := concatOutput(err)
+			reason := failureOutputReason(err.Error() + "\n" + output)
+			if reason == "" {
+				reason = model.FailureReasonConcat
 			}
+			s.videoService.RecordRenderFailureWithLogs(ctx, videoID, reason, manimCode, err.Error(), output)
+			return err
 		}
-	}()
-
-	// 启动goroutine来读取标准错误
-	go func() {
-		for stderrScanner.Scan() {
-			line := stderrScanner.Text()
-			outputBuffer.WriteString(line + "\n")
-
-			// 错误信息也可以包含进度信息
-			if progress := s.detectManimProgress(line); progress != "" {
-				select {
-				case progressChan <- progress:
-				default:
-					// 通道已满，跳过
-				}
-			}
+		if concatenated != nil {
+			renderArtifacts = append([]RenderArtifact{*concatenated}, renderArtifacts...)
 		}
-	}()
-
-	// 等待完成信号
-	<-doneChan
-}
-
-// detectManimProgress 从Manim输出中检测进度信息
-func (s *ManimService) detectManimProgress(line string) string {
-	// Manim进度检测模式
-	progressPatterns := []struct {
-		pattern string
-		message string
-	}{
-		{"Rendering animation", "开始渲染动画"},
-		{"Rendered", "动画渲染完成"},
-		{"Played", "动画播放完成"},
-		{"Writing to", "正在写入文件"},
-		{"Animation duration", "动画时长"},
-		{"File ready at", "文件准备就绪"},
-		{"Scene.*rendered", "场景渲染完成"},
-		{"Animation.*completed", "动画完成"},
-	}
-
-	for _, p := range progressPatterns {
-		if strings.Contains(line, p.pattern) {
-			return fmt.Sprintf("%s: %s", p.message, line)
-		}
-	}
-
-	return ""
-}
-
-// isManimComplete 检测Manim是否完成渲染
-func (s *ManimService) isManimComplete(line string) bool {
-	completionPatterns := []string{
-		"File ready at",
-		"Rendered",
-		"Played",
-		"Animation completed",
-		"Scene.*rendered",
 	}
 
-	for _, pattern := range completionPatterns {
-		if strings.Contains(line, pattern) {
-			return true
+	// 校验每个产物的容器、编码、分辨率和时长，并记录媒体信息
+	media := make([]model.MediaInfo, len(renderArtifacts))
+	for i, artifact := range renderArtifacts {
+		info, err := s.prober.Probe(ctx, artifact.Path)
+		if err == nil {
+			err = verifyMediaInfo(info, artifact, opts)
 		}
+		if err != nil {
+			err = fmt.Errorf("渲染产物 %s 校验失败: %v", filepath.Base(artifact.Path), err)
+			s.videoService.RecordRenderFailureWithLogs(ctx, videoID, model.FailureReasonInvalidOutput, manimCode, err.Error(), result.Logs)
+			return err
+		}
+		media[i] = info
 	}
 
-	return false
-}
-
-// waitForVideoFileGeneration 智能等待视频文件生成
-func (s *ManimService) waitForVideoFileGeneration(codeFile, outputDir string, manimOutput []byte) (string, error) {
-	// 首先检查Manim输出中是否包含完成标志
-	outputStr := string(manimOutput)
-	manimCompleted := strings.Contains(outputStr, "File ready at") ||
-		strings.Contains(outputStr, "Rendered") ||
-		strings.Contains(outputStr, "Played")
+	var artifacts []model.VideoArtifact
+	for i, artifact := range renderArtifacts {
+		// 将产物上传到最终位置，保持原始文件名
+		finalPath := path.Join(finalDir, filepath.Base(artifact.Path))
+		size, err := s.moveVideoToFinalLocation(ctx, artifact.Path, finalPath, videoID, manimCode)
+		if err != nil {
+			return err // 错误信息已在moveVideoToFinalLocation中更新
+		}
 
-	// 如果Manim已经完成，额外等待1秒确保文件完全写入
-	if manimCompleted {
-		time.Sleep(1 * time.Second)
+		artifacts = append(artifacts, model.VideoArtifact{
+			Kind:     artifact.Kind,
+			Scene:    artifact.Scene,
+			Format:   artifact.Format,
+			MIMEType: model.MIMETypeForFormat(artifact.Format),
+			Path:     finalPath,
+			Size:     size,
+			Media:    media[i],
+		})
 	}
+	finalVideoPath := artifacts[0].Path
 
-	// 提取Manim代码中的类名
-	className := s.extractClassNameFromCode(codeFile)
-
-	// 如果成功提取到类名，使用类名文件监控策略
-	if className != "" {
-		// 生成预期的视频文件路径模式
-		expectedVideoPaths := s.generateExpectedVideoPaths(outputDir, codeFile, className)
-
-		// 使用类名文件监控策略
-		videoPath := s.waitForClassNameVideoFile(expectedVideoPaths, 30*time.Second)
-		if videoPath != "" {
-			return videoPath, nil
-		}
+	if err := s.videoService.SaveRenderedArtifacts(ctx, videoID, cacheKey, artifacts); err != nil {
+		s.videoService.RecordRenderFailure(ctx, videoID, model.FailureReasonStorage, manimCode, fmt.Sprintf("保存渲染产物失败: %v", err))
+		return err
 	}
 
-	// 如果类名监控失败，回退到原有的多策略查找
-	return s.fallbackVideoSearch(codeFile, outputDir, manimOutput)
-}
-
-// extractClassNameFromCode 从Manim代码中提取类名
-func (s *ManimService) extractClassNameFromCode(codeFile string) string {
-	codeContent, err := os.ReadFile(codeFile)
+	// 立即更新数据库中的video_path字段
+	err = s.videoService.UpdateVideoStatus(ctx, videoID, model.VideoStatusCompleted, manimCode, finalVideoPath, "")
 	if err != nil {
-		return ""
-	}
-
-	// 查找class定义
-	lines := strings.Split(string(codeContent), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "class ") && strings.Contains(line, "(Scene):") {
-			// 提取类名
-			parts := strings.Split(strings.TrimSpace(line), " ")
-			if len(parts) >= 2 {
-				className := strings.TrimSuffix(parts[1], "(Scene):")
-				className = strings.TrimSpace(className)
-				return className
-			}
-		}
+		return err
 	}
 
-	return ""
-}
-
-// generateExpectedVideoPaths 生成预期的视频文件路径
-func (s *ManimService) generateExpectedVideoPaths(outputDir, codeFile, className string) []string {
-	fileName := strings.TrimSuffix(filepath.Base(codeFile), ".py")
-
-	// 获取临时目录的父目录（tempDir的父目录）
-	tempDir := filepath.Dir(codeFile)
-
-	// 生成多种可能的路径模式，包括临时目录内的路径
-	paths := []string{
-		// 标准输出目录路径
-		filepath.Join(outputDir, "videos", fileName, "720p30", className+".mp4"),
-		filepath.Join(outputDir, "videos", fileName, "1080p60", className+".mp4"),
-		filepath.Join(outputDir, "videos", fileName, "480p30", className+".mp4"),
-		filepath.Join(outputDir, "videos", "animation", "720p30", className+".mp4"),
-		filepath.Join(outputDir, "videos", "animation", "1080p60", className+".mp4"),
-		filepath.Join(outputDir, "videos", "animation", "480p30", className+".mp4"),
-		filepath.Join(outputDir, "videos", "720p30", className+".mp4"),
-		filepath.Join(outputDir, "videos", "1080p60", className+".mp4"),
-		filepath.Join(outputDir, "videos", "480p30", className+".mp4"),
-		
-		// 添加缺失的路径模式：包含"-"目录的路径（默认场景）
-		filepath.Join(outputDir, "videos", "-", "720p30", className+".mp4"),
-		filepath.Join(outputDir, "videos", "-", "1080p60", className+".mp4"),
-		filepath.Join(outputDir, "videos", "-", "480p30", className+".mp4"),
-
-		// 临时目录内的路径（根据实际观察的路径结构）
-		filepath.Join(tempDir, "videos", fileName, "720p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", fileName, "1080p60", className+".mp4"),
-		filepath.Join(tempDir, "videos", fileName, "480p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "animation", "720p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "animation", "1080p60", className+".mp4"),
-		filepath.Join(tempDir, "videos", "animation", "480p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "720p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "1080p60", className+".mp4"),
-		filepath.Join(tempDir, "videos", "480p30", className+".mp4"),
-		
-		// 添加缺失的路径模式：包含"-"目录的路径（默认场景）
-		filepath.Join(tempDir, "videos", "-", "720p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "-", "1080p60", className+".mp4"),
-		filepath.Join(tempDir, "videos", "-", "480p30", className+".mp4"),
-
-		// 临时目录内嵌套的videos路径（根据实际文件路径）
-		filepath.Join(tempDir, "videos", "3", "videos", fileName, "720p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", fileName, "1080p60", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", fileName, "480p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", "animation", "720p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", "animation", "1080p60", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", "animation", "480p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", "720p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", "1080p60", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", "480p30", className+".mp4"),
-		
-		// 添加缺失的路径模式：包含"-"目录的路径（默认场景）
-		filepath.Join(tempDir, "videos", "3", "videos", "-", "720p30", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", "-", "1080p60", className+".mp4"),
-		filepath.Join(tempDir, "videos", "3", "videos", "-", "480p30", className+".mp4"),
-	}
-
-	return paths
+	return nil
 }
 
-// waitForClassNameVideoFile 等待类名视频文件生成
-func (s *ManimService) waitForClassNameVideoFile(expectedPaths []string, maxWaitTime time.Duration) string {
-	checkInterval := 500 * time.Millisecond
-	startTime := time.Now()
-
-	for time.Since(startTime) < maxWaitTime {
-		// 检查所有预期的路径
-		for _, path := range expectedPaths {
-			if _, err := os.Stat(path); err == nil {
-				// 文件存在，等待文件稳定
-				if s.waitForFileStable(path, 5*time.Second) {
-					return path
-				}
-			}
+// concatScenes 拼接各场景的视频，只有一个场景时不需要拼接，返回nil
+func (s *ManimService) concatScenes(ctx context.Context, videoID uint, tempDir string, opts model.RenderOptions, artifacts []RenderArtifact) (*RenderArtifact, error) {
+	var inputs []string
+	for _, artifact := range artifacts {
+		if artifact.Kind == model.ArtifactKindVideo {
+			inputs = append(inputs, artifact.Path)
 		}
-
-		// 等待一段时间后再次检查
-		time.Sleep(checkInterval)
 	}
-
-	return ""
-}
-
-// fallbackVideoSearch 回退视频搜索策略
-func (s *ManimService) fallbackVideoSearch(codeFile, outputDir string, manimOutput []byte) (string, error) {
-	// 首先尝试从Manim输出中提取文件路径
-	videoPath := s.extractVideoPathFromOutput(manimOutput)
-	if videoPath != "" {
-		// 如果从输出中找到了路径，等待文件稳定
-		if s.waitForFileStable(videoPath, 30*time.Second) {
-			return videoPath, nil
-		}
+	if len(inputs) < 2 {
+		return nil, nil
 	}
 
-	// 如果从输出中没找到路径，或者文件不稳定，尝试其他查找方法
-	fileName := strings.TrimSuffix(filepath.Base(codeFile), ".py")
-
-	// 获取临时目录的父目录
-	tempDir := filepath.Dir(codeFile)
-
-	// 等待最多30秒，每500毫秒检查一次
-	maxWaitTime := 30 * time.Second
-	checkInterval := 500 * time.Millisecond
-	startTime := time.Now()
-
-	for time.Since(startTime) < maxWaitTime {
-		// 尝试各种查找方法，包括临时目录内的搜索
-		videoPath := s.findVideoByClassName(codeFile, outputDir, fileName)
-		if videoPath != "" && s.waitForFileStable(videoPath, 5*time.Second) {
-			return videoPath, nil
-		}
-
-		videoPath = s.findVideoByPatterns(outputDir, fileName)
-		if videoPath != "" && s.waitForFileStable(videoPath, 5*time.Second) {
-			return videoPath, nil
-		}
-
-		// 在临时目录内也进行搜索
-		videoPath = s.findVideoByPatterns(tempDir, fileName)
-		if videoPath != "" && s.waitForFileStable(videoPath, 5*time.Second) {
-			return videoPath, nil
-		}
-
-		videoPath = s.searchVideoRecursively(outputDir)
-		if videoPath != "" && s.waitForFileStable(videoPath, 5*time.Second) {
-			return videoPath, nil
-		}
-
-		// 在临时目录内也进行递归搜索
-		videoPath = s.searchVideoRecursively(tempDir)
-		if videoPath != "" && s.waitForFileStable(videoPath, 5*time.Second) {
-			return videoPath, nil
-		}
-
-		// 等待一段时间后再次检查
-		time.Sleep(checkInterval)
+	format := opts.MovieFormat()
+	output := filepath.Join(tempDir, fmt.Sprintf("video_%d.%s", videoID, format))
+	if err := s.concatenator.Concat(ctx, inputs, output); err != nil {
+		return nil, fmt.Errorf("拼接场景视频失败: %w", err)
 	}
 
-	return "", fmt.Errorf("等待视频文件生成超时，Manim输出: %s", manimOutput)
+	return &RenderArtifact{Kind: model.ArtifactKindVideo, Format: format, Path: output}, nil
 }
 
-// waitForFileStable 等待文件稳定（文件大小不再变化）
-func (s *ManimService) waitForFileStable(filePath string, maxWaitTime time.Duration) bool {
-	if _, err := os.Stat(filePath); err != nil {
-		return false // 文件不存在
-	}
-
-	startTime := time.Now()
-	checkInterval := 200 * time.Millisecond
-
-	var lastSize int64 = -1
-	var stableCount int
-
-	for time.Since(startTime) < maxWaitTime {
-		fileInfo, err := os.Stat(filePath)
-		if err != nil {
-			return false // 文件访问错误
-		}
-
-		currentSize := fileInfo.Size()
-
-		if currentSize == lastSize {
-			stableCount++
-			// 如果文件大小连续3次检查都相同，认为文件已稳定
-			if stableCount >= 3 {
-				return true
-			}
-		} else {
-			stableCount = 0
-			lastSize = currentSize
-		}
+// progressPublishInterval 进度事件的最小发布间隔
+const progressPublishInterval = 500 * time.Millisecond
 
-		time.Sleep(checkInterval)
-	}
+// progressReporter 解析渲染输出，保存并广播渲染进度
+type progressReporter struct {
+	ctx          context.Context
+	videoService *VideoService
+	videoID      uint
+	parser       *renderProgressParser
 
-	return false // 超时
+	mu            sync.Mutex
+	lastPublished time.Time
+	lastPhase     string
+	lastAnimation int
 }
 
-// extractVideoPathFromOutput 从Manim输出中提取视频文件路径
-func (s *ManimService) extractVideoPathFromOutput(manimOutput []byte) string {
-	outputStr := string(manimOutput)
-
-	// 查找"File ready at"后面的文件路径
-	if strings.Contains(outputStr, "File ready at") {
-		lines := strings.Split(outputStr, "\n")
-		for i, line := range lines {
-			if strings.Contains(line, "File ready at") {
-				// 查找下一行中的文件路径
-				if i+1 < len(lines) {
-					nextLine := strings.TrimSpace(lines[i+1])
-					// 检查是否是有效的文件路径
-					if strings.HasSuffix(nextLine, ".mp4") && !strings.Contains(nextLine, "partial_movie_files") {
-						return nextLine
-					}
-				}
-			}
-		}
+func newProgressReporter(ctx context.Context, videoService *VideoService, videoID uint, manimCode string) *progressReporter {
+	return &progressReporter{
+		ctx:          ctx,
+		videoService: videoService,
+		videoID:      videoID,
+		parser:       newRenderProgressParser(estimateAnimationCount(manimCode)),
 	}
-
-	return ""
 }
 
-// findVideoByClassName 通过类名查找视频文件
-func (s *ManimService) findVideoByClassName(codeFile, outputDir, fileName string) string {
-	// 读取Manim代码文件，提取类名
-	codeContent, err := os.ReadFile(codeFile)
-	if err != nil {
-		return ""
-	}
-
-	// 查找class定义
-	lines := strings.Split(string(codeContent), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "class ") && strings.Contains(line, "(Scene):") {
-			// 提取类名
-			parts := strings.Split(strings.TrimSpace(line), " ")
-			if len(parts) >= 2 {
-				className := strings.TrimSuffix(parts[1], "(Scene):")
-				className = strings.TrimSpace(className)
-
-				// 尝试查找该类名对应的视频文件（根据实际测试的路径格式）
-				videoPatterns := []string{
-					filepath.Join(outputDir, "videos", fileName, "720p30", className+".mp4"),
-					filepath.Join(outputDir, "videos", fileName, "1080p60", className+".mp4"),
-					filepath.Join(outputDir, "videos", fileName, "480p30", className+".mp4"),
-					filepath.Join(outputDir, "videos", "animation", "720p30", className+".mp4"),
-					filepath.Join(outputDir, "videos", "animation", "1080p60", className+".mp4"),
-					filepath.Join(outputDir, "videos", "animation", "480p30", className+".mp4"),
-					// 添加更多可能的路径模式
-					filepath.Join(outputDir, "videos", "720p30", className+".mp4"),
-					filepath.Join(outputDir, "videos", "1080p60", className+".mp4"),
-					filepath.Join(outputDir, "videos", "480p30", className+".mp4"),
-				}
-
-				for _, pattern := range videoPatterns {
-					if _, err := os.Stat(pattern); err == nil {
-						return pattern
-					}
-				}
-			}
-		}
+// OnOutput 处理一行Manim输出，进度条刷新频繁，按时间间隔节流
+func (r *progressReporter) OnOutput(line string) {
+	progress, changed := r.parser.Feed(line)
+	if !changed {
+		return
 	}
 
-	return ""
-}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-// findVideoByPatterns 通过模式匹配查找视频文件
-func (s *ManimService) findVideoByPatterns(outputDir, fileName string) string {
-	possiblePatterns := []string{
-		filepath.Join(outputDir, "videos", fileName, "720p30", "*.mp4"),
-		filepath.Join(outputDir, "videos", fileName, "1080p60", "*.mp4"),
-		filepath.Join(outputDir, "videos", fileName, "480p30", "*.mp4"),
-		filepath.Join(outputDir, "videos", "animation", "720p30", "*.mp4"),
-		filepath.Join(outputDir, "videos", "animation", "1080p60", "*.mp4"),
-		filepath.Join(outputDir, "videos", "animation", "480p30", "*.mp4"),
-		filepath.Join(outputDir, "videos", "720p30", "*.mp4"),
-		filepath.Join(outputDir, "videos", "1080p60", "*.mp4"),
-		filepath.Join(outputDir, "videos", "480p30", "*.mp4"),
-		
-		// 添加缺失的路径模式：包含"-"目录的路径（默认场景）
-		filepath.Join(outputDir, "videos", "-", "720p30", "*.mp4"),
-		filepath.Join(outputDir, "videos", "-", "1080p60", "*.mp4"),
-		filepath.Join(outputDir, "videos", "-", "480p30", "*.mp4"),
-		
-		filepath.Join(outputDir, "videos", "*.mp4"),
-		filepath.Join(outputDir, "*.mp4"),
-	}
-
-	for _, pattern := range possiblePatterns {
-		files, _ := filepath.Glob(pattern)
-		if len(files) > 0 {
-			// 优先选择主视频文件（不是partial_movie_files）
-			for _, file := range files {
-				if !strings.Contains(file, "partial_movie_files") {
-					return file
-				}
-			}
-			// 如果没有找到主视频文件，使用第一个文件
-			return files[0]
-		}
+	if time.Since(r.lastPublished) < progressPublishInterval && progress.Phase == r.lastPhase && progress.CurrentAnimation == r.lastAnimation {
+		return
 	}
-
-	return ""
+	r.publish(progress, line)
 }
 
-// searchVideoRecursively 递归搜索视频文件
-func (s *ManimService) searchVideoRecursively(dir string) string {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return ""
-	}
-
-	for _, entry := range entries {
-		fullPath := filepath.Join(dir, entry.Name())
-		if entry.IsDir() {
-			if result := s.searchVideoRecursively(fullPath); result != "" {
-				return result
-			}
-		} else if strings.HasSuffix(entry.Name(), ".mp4") && !strings.Contains(entry.Name(), "partial_movie_files") {
-			return fullPath
-		}
-	}
-
-	return ""
+// Flush 保证最终进度被记录
+func (r *progressReporter) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.publish(r.parser.Snapshot(), "")
 }
 
-// moveVideoToFinalLocation 移动视频到最终位置（不重命名）
-func (s *ManimService) moveVideoToFinalLocation(videoPath, finalVideoPath string, videoID uint, manimCode string) error {
-	// 首先检查源视频文件是否存在
-	if _, err := os.Stat(videoPath); err != nil {
-		s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("源视频文件不存在: %v", err))
-		return fmt.Errorf("源视频文件不存在: %v", err)
-	}
-
-	// 创建最终目录
-	err := os.MkdirAll(filepath.Dir(finalVideoPath), 0755)
-	if err != nil {
-		s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("创建最终目录失败: %v", err))
-		return err
-	}
-
-	// 如果目标文件已存在，先删除它（确保覆盖）
-	if _, err := os.Stat(finalVideoPath); err == nil {
-		if err := os.Remove(finalVideoPath); err != nil {
-			s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("删除旧视频文件失败: %v", err))
-			return fmt.Errorf("删除旧视频文件失败: %v", err)
-		}
-	}
-
-	// 移动文件（重命名）而不是复制，提高效率
-	err = os.Rename(videoPath, finalVideoPath)
-	if err != nil {
-		// 如果移动失败，尝试复制
-		err = copyFile(videoPath, finalVideoPath)
-		if err != nil {
-			s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("移动视频文件失败: %v", err))
-			return fmt.Errorf("移动视频文件失败: %v", err)
-		}
-		// 复制成功后删除源文件
-		os.Remove(videoPath)
-	}
-
-	// 验证移动后的文件是否存在
-	if _, err := os.Stat(finalVideoPath); err != nil {
-		s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("移动后文件验证失败: %v", err))
-		return fmt.Errorf("移动后文件验证失败: %v", err)
+func (r *progressReporter) publish(progress model.RenderProgress, line string) {
+	if err := r.videoService.SaveRenderProgress(r.ctx, r.videoID, progress); err != nil {
+		log.Printf("保存视频 %d 渲染进度失败: %v", r.videoID, err)
 	}
+	r.videoService.Events().PublishProgress(r.ctx, r.videoID, progress, line)
 
-	return nil
+	r.lastPublished = time.Now()
+	r.lastPhase = progress.Phase
+	r.lastAnimation = progress.CurrentAnimation
 }
 
-// copyVideoToFinalLocation 复制视频到最终位置
-func (s *ManimService) copyVideoToFinalLocation(videoPath, finalVideoPath string, videoID uint, manimCode string) error {
+// moveVideoToFinalLocation 将视频上传到存储的最终位置（不重命名），成功后删除临时文件，返回存储中的文件大小
+func (s *ManimService) moveVideoToFinalLocation(ctx context.Context, videoPath, finalVideoPath string, videoID uint, manimCode string) (int64, error) {
 	// 首先检查源视频文件是否存在
-	if _, err := os.Stat(videoPath); err != nil {
-		s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("源视频文件不存在: %v", err))
-		return fmt.Errorf("源视频文件不存在: %v", err)
-	}
-
-	// 创建最终目录
-	err := os.MkdirAll(filepath.Dir(finalVideoPath), 0755)
+	file, err := os.Open(videoPath)
 	if err != nil {
-		s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("创建最终目录失败: %v", err))
-		return err
-	}
-
-	// 如果目标文件已存在，先删除它（确保覆盖）
-	if _, err := os.Stat(finalVideoPath); err == nil {
-		if err := os.Remove(finalVideoPath); err != nil {
-			s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("删除旧视频文件失败: %v", err))
-			return fmt.Errorf("删除旧视频文件失败: %v", err)
-		}
+		s.videoService.RecordRenderFailure(ctx, videoID, model.FailureReasonStorage, manimCode, fmt.Sprintf("源视频文件不存在: %v", err))
+		return 0, fmt.Errorf("源视频文件不存在: %v", err)
 	}
-
-	// 复制文件
-	err = copyFile(videoPath, finalVideoPath)
+	info, err := file.Stat()
 	if err != nil {
-		s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("复制视频文件失败: %v", err))
-		return err
-	}
-
-	// 验证复制后的文件是否存在
-	if _, err := os.Stat(finalVideoPath); err != nil {
-		s.videoService.UpdateVideoStatus(context.Background(), videoID, model.VideoStatusFailed, manimCode, "", fmt.Sprintf("复制后文件验证失败: %v", err))
-		return fmt.Errorf("复制后文件验证失败: %v", err)
+		file.Close()
+		s.videoService.RecordRenderFailure(ctx, videoID, model.FailureReasonStorage, manimCode, fmt.Sprintf("读取源视频文件失败: %v", err))
+		return 0, err
 	}
 
-	return nil
-}
-
-// copyFile 复制文件 - 改进版本，支持大文件
-func copyFile(src, dst string) error {
-	// 检查源文件是否存在
-	srcInfo, err := os.Stat(src)
+	// 已存在的文件会被覆盖
+	contentType := model.MIMETypeForFormat(model.FormatFromPath(finalVideoPath))
+	err = s.videoService.blobs.Put(ctx, finalVideoPath, file, info.Size(), contentType)
+	file.Close()
 	if err != nil {
-		return fmt.Errorf("源文件不存在: %v", err)
-	}
-
-	// 检查源文件大小，如果文件过大使用流式复制
-	if srcInfo.Size() > 100*1024*1024 { // 大于100MB的文件使用流式复制
-		return copyFileStreaming(src, dst)
+		s.videoService.RecordRenderFailure(ctx, videoID, model.FailureReasonStorage, manimCode, fmt.Sprintf("上传视频文件失败: %v", err))
+		return 0, fmt.Errorf("上传视频文件失败: %v", err)
 	}
 
-	// 对于小文件，使用内存复制
-	// 读取源文件
-	data, err := os.ReadFile(src)
-	if err != nil {
-		return err
+	// 验证上传后的文件是否完整
+	stored, err := s.videoService.blobs.Stat(ctx, finalVideoPath)
+	if err == nil && stored.Size != info.Size() {
+		err = fmt.Errorf("文件大小为 %d 字节，预期 %d 字节", stored.Size, info.Size())
 	}
-
-	// 写入目标文件
-	err = os.WriteFile(dst, data, 0644)
 	if err != nil {
-		return err
+		s.videoService.RecordRenderFailure(ctx, videoID, model.FailureReasonStorage, manimCode, fmt.Sprintf("上传后文件验证失败: %v", err))
+		return 0, fmt.Errorf("上传后文件验证失败: %v", err)
 	}
 
-	return nil
+	// 上传成功后删除临时文件
+	os.Remove(videoPath)
+	return stored.Size, nil
 }
 
-// copyFileStreaming 流式复制大文件
-func copyFileStreaming(src, dst string) error {
-	// 打开源文件
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-
-	// 创建目标文件
-	dstFile, err := os.Create(dst)
+// ProcessPendingVideos 对账一次，将崩溃或队列数据丢失后遗留的视频重新入队或标记失败，见VideoService.ReconcileQueue
+func (s *ManimService) ProcessPendingVideos(ctx context.Context) error {
+	result, err := s.videoService.ReconcileQueue(ctx)
 	if err != nil {
 		return err
 	}
-	defer dstFile.Close()
-
-	// 使用缓冲区进行流式复制
-	buffer := make([]byte, 32*1024) // 32KB缓冲区
-	for {
-		n, err := srcFile.Read(buffer)
-		if err != nil && err.Error() != "EOF" {
-			return err
-		}
-		if n == 0 {
-			break
-		}
-
-		if _, err := dstFile.Write(buffer[:n]); err != nil {
-			return err
-		}
+	if len(result.Audits) > 0 {
+		log.Printf("对账完成，检查 %d 个未完成的视频，处理 %d 个孤儿任务", result.Checked, len(result.Audits))
 	}
-
 	return nil
 }
 
-// CleanOldVirs 清理过期视频文件 - 修复函数名和逻辑
-func (s *ManimService) CleanOldVirs(ctx context.Context, days int) error {
-	videosDir := "videos"
-
-	// 检查目录是否存在
-	if _, err := os.Stat(videosDir); os.IsNotExist(err) {
-		return nil // 目录不存在，无需清理
-	}
-
-	entries, err := os.ReadDir(videosDir)
-	if err != nil {
-		return err
-	}
-
-	cutoffTime := time.Now().AddDate(0, 0, -days)
-
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue // 跳过文件，只处理目录
+// StartReconciler 启动时立即对账一次，之后按Queue.ReconcileSeconds定期对账，直到ctx结束
+func (s *ManimService) StartReconciler(ctx context.Context) {
+	go func() {
+		if err := s.ProcessPendingVideos(ctx); err != nil {
+			log.Printf("启动对账失败: %v", err)
 		}
 
-		userDir := filepath.Join(videosDir, entry.Name())
-
-		// 检查用户目录下的视频文件
-		videoFiles, err := os.ReadDir(userDir)
-		if err != nil {
-			continue // 跳过无法读取的目录
+		interval := time.Duration(s.cfg.Queue.ReconcileSeconds) * time.Second
+		if interval <= 0 {
+			return
 		}
-
-		for _, videoFile := range videoFiles {
-			if videoFile.IsDir() {
-				continue // 跳过子目录
-			}
-
-			filePath := filepath.Join(userDir, videoFile.Name())
-
-			// 检查文件扩展名
-			if !strings.HasSuffix(strings.ToLower(videoFile.Name()), ".mp4") {
-				continue // 跳过非视频文件
-			}
-
-			fileInfo, err := videoFile.Info()
-			if err != nil {
-				continue
-			}
-
-			if fileInfo.ModTime().Before(cutoffTime) {
-				if err := os.Remove(filePath); err != nil {
-					// 记录错误但继续清理其他文件
-					fmt.Printf("删除过期视频文件失败: %v\n", err)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.ProcessPendingVideos(ctx); err != nil {
+					log.Printf("定期对账失败: %v", err)
 				}
 			}
 		}
-	}
-
-	return nil
-}
-
-// ProcessPendingVideos 处理待处理的视频
-func (s *ManimService) ProcessPendingVideos(ctx context.Context) error {
-	videos, err := s.videoService.GetProcessingVideos(ctx)
-	if err != nil {
-		return err
-	}
-
-	for _, video := range videos {
-		if video.Status == model.VideoStatusPending && video.ManimCode != "" {
-			go func(v model.Video) {
-				s.GenerateVideo(ctx, v.ID, v.ManimCode)
-			}(video)
-		}
-	}
-
-	return nil
+	}()
 }
 
 // GetQueueStatus 获取队列状态
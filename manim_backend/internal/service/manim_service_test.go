@@ -0,0 +1,414 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useTestWorkDir 切换到临时目录并返回该目录。最终产物写入当前目录下的videos，
+// 渲染工作目录也应放在返回的目录中，避免测试污染代码仓库
+func useTestWorkDir(t *testing.T) string {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+// setupTestManimService 创建使用假渲染器、不依赖Redis和Python的测试环境
+func setupTestManimService(t *testing.T) (*ManimService, *VideoService, *FakeRenderer) {
+	workDir := useTestWorkDir(t)
+
+	db := setupTestDBWithVideo()
+	manimCfg := config.ManimConfig{
+		PythonPath:    "python",
+		MaxConcurrent: 2,
+		Timeout:       300,
+		Renderer:      RendererFake,
+		Workspace:     config.WorkspaceConfig{Root: filepath.Join(workDir, "temp")},
+	}
+
+	manimSvc := NewManimService(manimCfg, nil)
+	videoSvc := newTestVideoService(t, db, manimCfg, manimSvc)
+	manimSvc.SetVideoService(videoSvc)
+
+	renderer := NewFakeRenderer()
+	manimSvc.SetRenderer(renderer)
+	manimSvc.SetProber(renderer)
+
+	return manimSvc, videoSvc, renderer
+}
+
+func TestNewRenderer(t *testing.T) {
+	tests := []struct {
+		name     string
+		renderer string
+		want     interface{}
+		wantErr  bool
+	}{
+		{name: "默认使用本地渲染器", renderer: "", want: &LocalRenderer{}},
+		{name: "本地渲染器", renderer: RendererLocal, want: &LocalRenderer{}},
+		{name: "Docker渲染器", renderer: RendererDocker, want: &DockerRenderer{}},
+		{name: "沙箱渲染器", renderer: RendererSandbox, want: &SandboxRenderer{}},
+		{name: "假渲染器", renderer: RendererFake, want: &FakeRenderer{}},
+		{name: "未知渲染器", renderer: "k8s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderer, err := NewRenderer(config.ManimConfig{PythonPath: "python", Renderer: tt.renderer})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.IsType(t, tt.want, renderer)
+		})
+	}
+}
+
+func TestManimService_SetRendererKeepsProber(t *testing.T) {
+	// 配置为fake时由假渲染器描述产物，其他配置使用ffprobe
+	manimSvc := NewManimService(config.ManimConfig{MaxConcurrent: 1, Renderer: RendererFake}, nil)
+	assert.IsType(t, &FakeRenderer{}, manimSvc.prober)
+
+	manimSvc = NewManimService(config.ManimConfig{MaxConcurrent: 1, PythonPath: "python"}, nil)
+	manimSvc.SetRenderer(NewFakeRenderer())
+	assert.IsType(t, &FFprobeProber{}, manimSvc.prober)
+}
+
+func TestManimService_GenerateVideo(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestManimService(t)
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+
+	err = manimSvc.GenerateVideo(ctx, video.ID, testManimCode)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, renderer.Calls())
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusCompleted, updated.Status)
+	assert.Equal(t, filepath.Join("videos", "1", fmt.Sprint(video.ID), "PythagoreanTheorem.mp4"), updated.VideoPath)
+	assert.FileExists(t, updated.VideoPath)
+	// 主视频的媒体信息记录在视频上
+	assert.Equal(t, model.MediaInfo{Duration: 1, Codec: "h264", Width: 1280, Height: 720}, updated.Media)
+
+	// 渲染输出被解析为结构化进度
+	progress, err := videoSvc.GetRenderProgress(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, progress)
+	assert.Equal(t, model.RenderPhaseDone, progress.Phase)
+
+	// 同一用户的另一个视频使用相同的场景类名时不覆盖之前的产物
+	other, err := videoSvc.CreateVideo(ctx, 1, "再画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, manimSvc.GenerateVideo(ctx, other.ID, testManimCode))
+	otherUpdated, err := videoSvc.GetVideoByID(ctx, other.ID)
+	assert.NoError(t, err)
+	assert.NotEqual(t, updated.VideoPath, otherUpdated.VideoPath)
+	assert.FileExists(t, updated.VideoPath)
+	assert.FileExists(t, otherUpdated.VideoPath)
+}
+
+func TestManimService_GenerateVideo_Concurrent(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestManimService(t)
+	renderer.Delay = 200 * time.Millisecond
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+
+	// 模拟队列工作者和其他调用方同时渲染同一视频
+	const callers = 5
+	errs := make(chan error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- manimSvc.GenerateVideo(ctx, video.ID, testManimCode)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.ErrorIs(t, err, ErrRenderInProgress)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+	assert.Equal(t, 1, renderer.Calls())
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusCompleted, updated.Status)
+
+	// 已完成的视频再次投递时不会重复渲染
+	assert.NoError(t, manimSvc.GenerateVideo(ctx, video.ID, testManimCode))
+	assert.Equal(t, 1, renderer.Calls())
+}
+
+func TestManimService_GenerateVideo_Artifacts(t *testing.T) {
+	manimSvc, videoSvc, _ := setupTestManimService(t)
+	ctx := context.Background()
+
+	opts := DefaultRenderOptions()
+	opts.Format = model.OutputFormatGIF
+	opts.SaveLastFrame = true
+	video, err := videoSvc.CreateVideoWithOptions(ctx, 1, "画一个圆", opts)
+	assert.NoError(t, err)
+
+	err = manimSvc.GenerateVideo(ctx, video.ID, testManimCode)
+	assert.NoError(t, err)
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("videos", "1", fmt.Sprint(video.ID), "PythagoreanTheorem.gif"), updated.VideoPath)
+
+	artifacts, err := videoSvc.GetVideoArtifacts(ctx, video.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, artifacts, 2) {
+		assert.Equal(t, model.ArtifactKindVideo, artifacts[0].Kind)
+		assert.Equal(t, "image/gif", artifacts[0].MIMEType)
+		assert.Equal(t, updated.VideoPath, artifacts[0].Path)
+		assert.Equal(t, model.ArtifactKindImage, artifacts[1].Kind)
+		assert.Equal(t, "image/png", artifacts[1].MIMEType)
+		assert.Equal(t, filepath.Join("videos", "1", fmt.Sprint(video.ID), "PythagoreanTheorem.png"), artifacts[1].Path)
+		assert.Equal(t, "gif", artifacts[0].Media.Codec)
+		assert.Equal(t, 1280, artifacts[0].Media.Width)
+		assert.Equal(t, "png", artifacts[1].Media.Codec)
+		assert.Positive(t, artifacts[1].Size)
+		assert.FileExists(t, artifacts[1].Path)
+	}
+}
+
+// recordingConcatenator 记录拼接顺序并把输入内容依次写入输出文件
+type recordingConcatenator struct {
+	inputs []string
+}
+
+func (c *recordingConcatenator) Concat(ctx context.Context, inputs []string, output string) error {
+	c.inputs = inputs
+	var content []byte
+	for _, input := range inputs {
+		data, err := os.ReadFile(input)
+		if err != nil {
+			return err
+		}
+		content = append(content, data...)
+	}
+	return os.WriteFile(output, content, 0644)
+}
+
+func TestManimService_GenerateVideo_MultiScene(t *testing.T) {
+	manimSvc, videoSvc, _ := setupTestManimService(t)
+	concatenator := &recordingConcatenator{}
+	manimSvc.SetConcatenator(concatenator)
+	ctx := context.Background()
+
+	opts := DefaultRenderOptions()
+	opts.Scenes = "Outro,Intro"
+	opts.Concat = true
+	video, err := videoSvc.CreateVideoWithOptions(ctx, 1, "多场景", opts)
+	assert.NoError(t, err)
+
+	err = manimSvc.GenerateVideo(ctx, video.ID, multiSceneCode)
+	assert.NoError(t, err)
+	assert.Len(t, concatenator.inputs, 2)
+
+	// 拼接后的完整视频作为主视频，每个场景各有一个产物
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("videos", "1", fmt.Sprint(video.ID), fmt.Sprintf("video_%d.mp4", video.ID)), updated.VideoPath)
+
+	artifacts, err := videoSvc.GetVideoArtifacts(ctx, video.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, artifacts, 3) {
+		assert.Equal(t, "", artifacts[0].Scene)
+		assert.Equal(t, "Outro", artifacts[1].Scene)
+		assert.Equal(t, "Intro", artifacts[2].Scene)
+		assert.Equal(t, filepath.Join("videos", "1", fmt.Sprint(video.ID), "Outro.mp4"), artifacts[1].Path)
+	}
+	content, err := os.ReadFile(updated.VideoPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "fake Outro mp4 contentfake Intro mp4 content", string(content))
+}
+
+// failingConcatenator 模拟ffmpeg拼接失败
+type failingConcatenator struct {
+	output string
+}
+
+func (c *failingConcatenator) Concat(ctx context.Context, inputs []string, output string) error {
+	return &ConcatError{Output: c.output, Err: errors.New("exit status 1")}
+}
+
+func TestManimService_GenerateVideo_ConcatFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		wantReason string
+	}{
+		{name: "磁盘已满", output: "video_1.mp4: No space left on device", wantReason: model.FailureReasonDiskFull},
+		{name: "被杀", output: "signal: killed", wantReason: model.FailureReasonOOMKilled},
+		{name: "无法识别", output: "Invalid data found when processing input", wantReason: model.FailureReasonConcat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manimSvc, videoSvc, _ := setupTestManimService(t)
+			manimSvc.SetConcatenator(&failingConcatenator{output: tt.output})
+			videoSvc.manimCfg.Retry = config.RetryConfig{MaxAttempts: 3, BaseDelaySeconds: 1, MaxDelaySeconds: 10}
+			ctx := context.Background()
+
+			opts := DefaultRenderOptions()
+			opts.Scenes = "Outro,Intro"
+			opts.Concat = true
+			video, err := videoSvc.CreateVideoWithOptions(ctx, 1, "多场景", opts)
+			assert.NoError(t, err)
+
+			err = manimSvc.GenerateVideo(ctx, video.ID, multiSceneCode)
+			assert.Error(t, err)
+
+			// 拼接失败按临时失败安排重试，ffmpeg的输出保存在渲染记录中
+			updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, model.VideoStatusQueued, updated.Status)
+			assert.Equal(t, tt.wantReason, updated.FailureReason)
+			var attempt model.RenderAttempt
+			assert.NoError(t, videoSvc.db.Where("video_id = ?", video.ID).First(&attempt).Error)
+			assert.Equal(t, model.FailureClassTransient, attempt.FailureClass)
+			assert.Contains(t, attempt.LogExcerpt, tt.output)
+		})
+	}
+}
+
+func TestManimService_GenerateVideo_UnknownScene(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestManimService(t)
+	ctx := context.Background()
+
+	opts := DefaultRenderOptions()
+	opts.Scenes = "Missing"
+	video, err := videoSvc.CreateVideoWithOptions(ctx, 1, "多场景", opts)
+	assert.NoError(t, err)
+
+	err = manimSvc.GenerateVideo(ctx, video.ID, multiSceneCode)
+	assert.Error(t, err)
+	assert.Equal(t, 0, renderer.Calls())
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusFailed, updated.Status)
+	assert.Contains(t, updated.ErrorMsg, "场景 Missing 不存在")
+}
+
+// stubProber 返回固定的媒体信息
+type stubProber struct {
+	info model.MediaInfo
+}
+
+func (p stubProber) Probe(ctx context.Context, path string) (model.MediaInfo, error) {
+	return p.info, nil
+}
+
+func TestManimService_GenerateVideo_InvalidOutput(t *testing.T) {
+	manimSvc, videoSvc, _ := setupTestManimService(t)
+	// 实际分辨率与渲染参数不一致
+	manimSvc.SetProber(stubProber{info: model.MediaInfo{Duration: 2, Codec: "h264", Width: 640, Height: 360}})
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+
+	err = manimSvc.GenerateVideo(ctx, video.ID, testManimCode)
+	assert.Error(t, err)
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusFailed, updated.Status)
+	assert.Equal(t, model.FailureReasonInvalidOutput, updated.FailureReason)
+	assert.Contains(t, updated.ErrorMsg, "640x360")
+	assert.Empty(t, updated.VideoPath)
+}
+
+func TestManimService_GenerateVideo_RenderFailed(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestManimService(t)
+	renderer.Err = errors.New("Manim执行失败: NameError")
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+
+	err = manimSvc.GenerateVideo(ctx, video.ID, testManimCode)
+	assert.Error(t, err)
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusFailed, updated.Status)
+	assert.Contains(t, updated.ErrorMsg, "NameError")
+	assert.Empty(t, updated.VideoPath)
+}
+
+func TestManimService_GenerateVideo_SandboxViolation(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestManimService(t)
+	renderer.Err = &RenderError{
+		Reason: model.FailureReasonSandboxNetwork,
+		Err:    errors.New("Manim执行失败: Network is unreachable"),
+	}
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+
+	err = manimSvc.GenerateVideo(ctx, video.ID, testManimCode)
+	assert.Error(t, err)
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusFailed, updated.Status)
+	assert.Equal(t, model.FailureReasonSandboxNetwork, updated.FailureReason)
+	assert.Contains(t, updated.ErrorMsg, "Network is unreachable")
+}
+
+func TestManimService_GenerateVideo_Timeout(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestManimService(t)
+	renderer.Delay = 10 * time.Second
+	videoSvc.manimCfg.Timeout = 1
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+
+	start := time.Now()
+	err = manimSvc.GenerateVideo(ctx, video.ID, testManimCode)
+	assert.ErrorIs(t, err, ErrRenderTimeout)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// 超时按单独的失败原因记录，并保留超时前的渲染输出
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.FailureReasonTimeout, updated.FailureReason)
+	var attempt model.RenderAttempt
+	assert.NoError(t, videoSvc.db.Where("video_id = ?", video.ID).First(&attempt).Error)
+	assert.Equal(t, model.FailureReasonTimeout, attempt.FailureReason)
+	assert.Contains(t, attempt.LogExcerpt, "Animation 0: Create(Circle)")
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+
+	"manim-backend/internal/model"
+)
+
+// MediaProber 读取媒体文件的时长、编码和分辨率
+type MediaProber interface {
+	Probe(ctx context.Context, path string) (model.MediaInfo, error)
+}
+
+// FFprobeProber 使用ffprobe读取媒体信息
+type FFprobeProber struct {
+	ffprobePath string
+}
+
+func NewFFprobeProber(ffprobePath string) *FFprobeProber {
+	if ffprobePath == "" {
+		ffprobePath = "ffprobe"
+	}
+	return &FFprobeProber{ffprobePath: ffprobePath}
+}
+
+// Probe 执行ffprobe并解析第一个视频流
+func (p *FFprobeProber) Probe(ctx context.Context, path string) (model.MediaInfo, error) {
+	cmd := exec.CommandContext(ctx, p.ffprobePath,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_entries", "format=duration:stream=codec_type,codec_name,width,height",
+		path)
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return model.MediaInfo{}, fmt.Errorf("读取媒体信息失败: %v, 输出: %s", err, exitErr.Stderr)
+		}
+		return model.MediaInfo{}, fmt.Errorf("读取媒体信息失败: %v", err)
+	}
+
+	return parseFFprobeOutput(output)
+}
+
+// ffprobeOutput ffprobe -print_format json 的输出
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// parseFFprobeOutput 解析ffprobe输出，文件中没有视频流时返回错误
+func parseFFprobeOutput(output []byte) (model.MediaInfo, error) {
+	var probe ffprobeOutput
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return model.MediaInfo{}, fmt.Errorf("解析ffprobe输出失败: %v", err)
+	}
+
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "video" {
+			continue
+		}
+		info := model.MediaInfo{Codec: stream.CodecName, Width: stream.Width, Height: stream.Height}
+		// 图片没有时长，ffprobe不输出该字段
+		if probe.Format.Duration != "" {
+			duration, err := strconv.ParseFloat(probe.Format.Duration, 64)
+			if err != nil {
+				return info, fmt.Errorf("解析时长失败: %v", err)
+			}
+			info.Duration = duration
+		}
+		return info, nil
+	}
+
+	return model.MediaInfo{}, errors.New("文件中没有视频流")
+}
+
+// 各输出格式允许的编码，Manim透明渲染的mov使用qtrle或prores
+var formatCodecs = map[string][]string{
+	model.OutputFormatMP4:  {"h264", "hevc"},
+	model.OutputFormatWebM: {"vp9", "vp8", "av1"},
+	model.OutputFormatMOV:  {"qtrle", "prores", "h264", "png"},
+	model.OutputFormatGIF:  {"gif"},
+	model.OutputFormatPNG:  {"png"},
+}
+
+// verifyMediaInfo 校验产物的编码、分辨率和时长是否与渲染参数一致
+func verifyMediaInfo(info model.MediaInfo, artifact RenderArtifact, opts model.RenderOptions) error {
+	if codecs, ok := formatCodecs[artifact.Format]; ok && !slices.Contains(codecs, info.Codec) {
+		return fmt.Errorf("%s 文件的编码 %s 不正确", artifact.Format, info.Codec)
+	}
+	if opts.Width > 0 && opts.Height > 0 && (info.Width != opts.Width || info.Height != opts.Height) {
+		return fmt.Errorf("分辨率 %dx%d 与渲染参数 %dx%d 不一致", info.Width, info.Height, opts.Width, opts.Height)
+	}
+	if artifact.Kind == model.ArtifactKindVideo && info.Duration <= 0 {
+		return errors.New("视频时长为0")
+	}
+	return nil
+}
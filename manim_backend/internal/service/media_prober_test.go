@@ -0,0 +1,59 @@
+package service
+
+import (
+	"testing"
+
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFFprobeOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    model.MediaInfo
+		wantErr string
+	}{
+		{
+			name:   "MP4视频",
+			output: `{"streams":[{"codec_name":"aac","codec_type":"audio"},{"codec_name":"h264","codec_type":"video","width":1280,"height":720}],"format":{"duration":"3.500000"}}`,
+			want:   model.MediaInfo{Duration: 3.5, Codec: "h264", Width: 1280, Height: 720},
+		},
+		{
+			name:   "PNG图片没有时长",
+			output: `{"streams":[{"codec_name":"png","codec_type":"video","width":854,"height":480}],"format":{}}`,
+			want:   model.MediaInfo{Codec: "png", Width: 854, Height: 480},
+		},
+		{name: "没有视频流", output: `{"streams":[{"codec_name":"aac","codec_type":"audio"}],"format":{"duration":"1.0"}}`, wantErr: "没有视频流"},
+		{name: "无效JSON", output: `not json`, wantErr: "解析ffprobe输出失败"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFFprobeOutput([]byte(tt.output))
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestVerifyMediaInfo(t *testing.T) {
+	opts := model.RenderOptions{Width: 1280, Height: 720}
+	video := RenderArtifact{Kind: model.ArtifactKindVideo, Format: model.OutputFormatMP4}
+	image := RenderArtifact{Kind: model.ArtifactKindImage, Format: model.OutputFormatPNG}
+
+	assert.NoError(t, verifyMediaInfo(model.MediaInfo{Duration: 2, Codec: "h264", Width: 1280, Height: 720}, video, opts))
+	assert.NoError(t, verifyMediaInfo(model.MediaInfo{Codec: "png", Width: 1280, Height: 720}, image, opts))
+
+	assert.ErrorContains(t, verifyMediaInfo(model.MediaInfo{Duration: 2, Codec: "vp9", Width: 1280, Height: 720}, video, opts), "编码 vp9")
+	assert.ErrorContains(t, verifyMediaInfo(model.MediaInfo{Duration: 2, Codec: "h264", Width: 1920, Height: 1080}, video, opts), "1920x1080")
+	assert.ErrorContains(t, verifyMediaInfo(model.MediaInfo{Codec: "h264", Width: 1280, Height: 720}, video, opts), "时长为0")
+}
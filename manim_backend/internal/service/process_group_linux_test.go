@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// processRunning 判断进程是否仍在运行，僵尸进程视为已退出
+func processRunning(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestCommandRenderer_RunKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// 模拟Manim启动的子进程，取消后子进程也必须退出
+	cmd := exec.CommandContext(ctx, "sh", "-c", `sleep 30 & echo $! > "$1"; wait`, "sh", pidFile)
+	done := make(chan error, 1)
+	go func() {
+		_, err := (&commandRenderer{}).run(cmd, RenderJob{VideoID: 1})
+		done <- err
+	}()
+
+	var childPID int
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		childPID, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("取消后渲染命令没有退出")
+	}
+	assert.Eventually(t, func() bool { return !processRunning(childPID) }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package service
+
+import "os/exec"
+
+// useProcessGroup 其他平台没有进程组，取消时只终止渲染进程本身
+func useProcessGroup(cmd *exec.Cmd) {}
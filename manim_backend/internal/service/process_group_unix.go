@@ -0,0 +1,43 @@
+//go:build unix
+
+package service
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// processGroupWaitDelay 进程组被终止后等待输出管道关闭的最长时间
+const processGroupWaitDelay = 5 * time.Second
+
+// useProcessGroup 让渲染命令在独立的进程组中运行，取消时终止整个进程组，
+// 避免Manim启动的LaTeX、ffmpeg等子进程在取消后继续运行。
+// 只对exec.CommandContext创建的命令生效，原有的Cancel（例如删除容器）先执行，之后再终止进程组
+func useProcessGroup(cmd *exec.Cmd) {
+	if cmd.Cancel == nil {
+		return
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	cancel := cmd.Cancel
+	cmd.Cancel = func() error {
+		cancel()
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = processGroupWaitDelay
+}
+
+// killProcessGroup 终止命令所在的进程组
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
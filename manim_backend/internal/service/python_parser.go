@@ -0,0 +1,592 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// pyTokenKind Python词法单元类型
+type pyTokenKind int
+
+const (
+	pyName pyTokenKind = iota
+	pyNumber
+	pyString
+	pyOp
+	pyNewline
+	pyIndent
+	pyDedent
+	pyEOF
+)
+
+// pyToken Python词法单元，行号和列号均从1开始，列号按字符计算
+type pyToken struct {
+	Kind  pyTokenKind
+	Value string
+	Line  int
+	Col   int
+
+	// Embedded f-string中花括号内表达式的词法单元
+	Embedded []pyToken
+}
+
+// pySyntaxError Python代码词法或结构错误
+type pySyntaxError struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e *pySyntaxError) Error() string {
+	return fmt.Sprintf("第%d行第%d列: %s", e.Line, e.Col, e.Msg)
+}
+
+// Python运算符，按长度从长到短匹配
+var pyOperators = []string{
+	"**=", "//=", ">>=", "<<=", "...",
+	"**", "//", "==", "!=", "<=", ">=", "->", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "@=", ":=", "<<", ">>",
+	"+", "-", "*", "/", "%", "@", "&", "|", "^", "~", "<", ">", "(", ")", "[", "]", "{", "}", ",", ":", ".", ";", "=",
+}
+
+var pyClosingBrackets = map[rune]rune{')': '(', ']': '[', '}': '{'}
+
+// pyTokenizer Python源码词法分析器，只实现静态检查所需的子集
+type pyTokenizer struct {
+	src  []rune
+	pos  int
+	line int
+	col  int
+
+	tokens   []pyToken
+	indents  []int
+	brackets []pyToken
+}
+
+// tokenizePython 将Python源码切分为词法单元，包含NEWLINE/INDENT/DEDENT
+func tokenizePython(src string) ([]pyToken, error) {
+	t := &pyTokenizer{
+		src:     []rune(strings.ReplaceAll(src, "\r\n", "\n")),
+		line:    1,
+		col:     1,
+		indents: []int{0},
+	}
+	if err := t.run(); err != nil {
+		return nil, err
+	}
+	return t.tokens, nil
+}
+
+func (t *pyTokenizer) peek(offset int) rune {
+	if t.pos+offset >= len(t.src) {
+		return 0
+	}
+	return t.src[t.pos+offset]
+}
+
+func (t *pyTokenizer) advance() rune {
+	r := t.src[t.pos]
+	t.pos++
+	if r == '\n' {
+		t.line++
+		t.col = 1
+	} else {
+		t.col++
+	}
+	return r
+}
+
+func (t *pyTokenizer) emit(kind pyTokenKind, value string, line, col int) {
+	t.tokens = append(t.tokens, pyToken{Kind: kind, Value: value, Line: line, Col: col})
+}
+
+func (t *pyTokenizer) errorf(line, col int, format string, args ...interface{}) error {
+	return &pySyntaxError{Line: line, Col: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (t *pyTokenizer) run() error {
+	atLineStart := true
+
+	for t.pos < len(t.src) {
+		if atLineStart && len(t.brackets) == 0 {
+			blank, err := t.indentation()
+			if err != nil {
+				return err
+			}
+			if blank {
+				continue
+			}
+			atLineStart = false
+		}
+
+		r := t.peek(0)
+		switch {
+		case r == '\n':
+			line, col := t.line, t.col
+			t.advance()
+			// 括号内的换行属于隐式续行
+			if len(t.brackets) == 0 {
+				t.emit(pyNewline, "", line, col)
+				atLineStart = true
+			}
+		case r == ' ' || r == '\t' || r == '\f':
+			t.advance()
+		case r == '#':
+			for t.pos < len(t.src) && t.peek(0) != '\n' {
+				t.advance()
+			}
+		case r == '\\':
+			if t.peek(1) != '\n' {
+				return t.errorf(t.line, t.col, "续行符后不能有其他字符")
+			}
+			t.advance()
+			t.advance()
+		case t.isStringStart():
+			if err := t.string(); err != nil {
+				return err
+			}
+		case unicode.IsDigit(r) || (r == '.' && unicode.IsDigit(t.peek(1))):
+			t.number()
+		case r == '_' || unicode.IsLetter(r):
+			t.name()
+		default:
+			if err := t.operator(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(t.brackets) > 0 {
+		open := t.brackets[len(t.brackets)-1]
+		return t.errorf(open.Line, open.Col, "括号 %s 未闭合", open.Value)
+	}
+
+	if len(t.tokens) > 0 && t.tokens[len(t.tokens)-1].Kind != pyNewline {
+		t.emit(pyNewline, "", t.line, t.col)
+	}
+	for len(t.indents) > 1 {
+		t.indents = t.indents[:len(t.indents)-1]
+		t.emit(pyDedent, "", t.line, t.col)
+	}
+	t.emit(pyEOF, "", t.line, t.col)
+	return nil
+}
+
+// indentation 处理行首缩进，空行和纯注释行返回true
+func (t *pyTokenizer) indentation() (bool, error) {
+	width := 0
+	for t.pos < len(t.src) {
+		switch t.peek(0) {
+		case ' ':
+			width++
+		case '\t':
+			width = (width/8 + 1) * 8
+		case '\f':
+			width = 0
+		default:
+			goto done
+		}
+		t.advance()
+	}
+done:
+	r := t.peek(0)
+	if t.pos >= len(t.src) || r == '\n' || r == '#' {
+		for t.pos < len(t.src) && t.peek(0) != '\n' {
+			t.advance()
+		}
+		if t.pos < len(t.src) {
+			t.advance()
+		}
+		return true, nil
+	}
+
+	current := t.indents[len(t.indents)-1]
+	switch {
+	case width > current:
+		t.indents = append(t.indents, width)
+		t.emit(pyIndent, "", t.line, 1)
+	case width < current:
+		for width < t.indents[len(t.indents)-1] {
+			t.indents = t.indents[:len(t.indents)-1]
+			t.emit(pyDedent, "", t.line, 1)
+		}
+		if width != t.indents[len(t.indents)-1] {
+			return false, t.errorf(t.line, t.col, "缩进与外层代码块不一致")
+		}
+	}
+	return false, nil
+}
+
+// isStringStart 判断当前位置是否为字符串（可带r/b/u/f前缀）的开始
+func (t *pyTokenizer) isStringStart() bool {
+	for i := 0; i < 3; i++ {
+		r := t.peek(i)
+		if r == '"' || r == '\'' {
+			return true
+		}
+		if !strings.ContainsRune("rRbBuUfF", r) {
+			return false
+		}
+	}
+	return false
+}
+
+// string 解析字符串字面量，f-string中的表达式单独分词后挂在Embedded上
+func (t *pyTokenizer) string() error {
+	line, col := t.line, t.col
+	start := t.pos
+
+	prefix := ""
+	for t.peek(0) != '"' && t.peek(0) != '\'' {
+		prefix += string(t.advance())
+	}
+	format := strings.ContainsAny(prefix, "fF")
+
+	quote := string(t.advance())
+	if string(t.peek(0))+string(t.peek(1)) == quote+quote {
+		t.advance()
+		t.advance()
+		quote = quote + quote + quote
+	}
+	triple := len(quote) == 3
+
+	var embedded []pyToken
+	for {
+		if t.pos >= len(t.src) || (!triple && t.peek(0) == '\n') {
+			return t.errorf(line, col, "字符串未闭合")
+		}
+
+		r := t.peek(0)
+		switch {
+		case r == '\\':
+			t.advance()
+			if t.pos < len(t.src) {
+				t.advance()
+			}
+			continue
+		case strings.HasPrefix(string(t.src[t.pos:min(t.pos+len(quote), len(t.src))]), quote):
+			for i := 0; i < len(quote); i++ {
+				t.advance()
+			}
+			token := pyToken{Kind: pyString, Value: string(t.src[start:t.pos]), Line: line, Col: col, Embedded: embedded}
+			t.tokens = append(t.tokens, token)
+			return nil
+		case format && r == '{':
+			if t.peek(1) == '{' {
+				t.advance()
+				t.advance()
+				continue
+			}
+			tokens, err := t.formatExpression(quote)
+			if err != nil {
+				return err
+			}
+			embedded = append(embedded, tokens...)
+			continue
+		}
+		t.advance()
+	}
+}
+
+// formatExpression 解析f-string中的 {expr} 并对表达式分词
+func (t *pyTokenizer) formatExpression(quote string) ([]pyToken, error) {
+	line, col := t.line, t.col
+	t.advance()
+
+	exprLine, exprCol := t.line, t.col
+	exprStart := t.pos
+	depth := 0
+	for {
+		if t.pos >= len(t.src) || strings.HasPrefix(string(t.src[t.pos:min(t.pos+len(quote), len(t.src))]), quote) {
+			return nil, t.errorf(line, col, "f-string中的表达式未闭合")
+		}
+		r := t.peek(0)
+		if r == '{' || r == '(' || r == '[' {
+			depth++
+		} else if r == ')' || r == ']' || (r == '}' && depth > 0) {
+			depth--
+		} else if r == '}' {
+			break
+		}
+		t.advance()
+	}
+	// 去掉 !r/!s/!a 转换标记，格式说明部分按普通表达式分词
+	expr := append([]rune(nil), t.src[exprStart:t.pos]...)
+	for i := range expr {
+		if expr[i] == '!' && (i+1 >= len(expr) || expr[i+1] != '=') {
+			expr[i] = ' '
+		}
+	}
+	t.advance()
+
+	tokens, err := tokenizePython(string(expr))
+	if err != nil {
+		if syntaxErr, ok := err.(*pySyntaxError); ok {
+			return nil, t.errorf(exprLine+syntaxErr.Line-1, exprCol+syntaxErr.Col-1, "%s", syntaxErr.Msg)
+		}
+		return nil, err
+	}
+
+	var result []pyToken
+	for _, token := range tokens {
+		if token.Kind == pyNewline || token.Kind == pyIndent || token.Kind == pyDedent || token.Kind == pyEOF {
+			continue
+		}
+		if token.Line == 1 {
+			token.Col += exprCol - 1
+		}
+		token.Line += exprLine - 1
+		result = append(result, token)
+	}
+	return result, nil
+}
+
+func (t *pyTokenizer) number() {
+	line, col := t.line, t.col
+	start := t.pos
+	for t.pos < len(t.src) {
+		r := t.peek(0)
+		if unicode.IsDigit(r) || unicode.IsLetter(r) || r == '_' || r == '.' {
+			t.advance()
+			// 科学计数法的指数符号
+			if (r == 'e' || r == 'E') && (t.peek(0) == '+' || t.peek(0) == '-') {
+				t.advance()
+			}
+			continue
+		}
+		break
+	}
+	t.emit(pyNumber, string(t.src[start:t.pos]), line, col)
+}
+
+func (t *pyTokenizer) name() {
+	line, col := t.line, t.col
+	start := t.pos
+	for t.pos < len(t.src) {
+		r := t.peek(0)
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		t.advance()
+	}
+	t.emit(pyName, string(t.src[start:t.pos]), line, col)
+}
+
+func (t *pyTokenizer) operator() error {
+	line, col := t.line, t.col
+	for _, op := range pyOperators {
+		if !strings.HasPrefix(string(t.src[t.pos:min(t.pos+len(op), len(t.src))]), op) {
+			continue
+		}
+		for range op {
+			t.advance()
+		}
+
+		token := pyToken{Kind: pyOp, Value: op, Line: line, Col: col}
+		switch op {
+		case "(", "[", "{":
+			t.brackets = append(t.brackets, token)
+		case ")", "]", "}":
+			if len(t.brackets) == 0 || t.brackets[len(t.brackets)-1].Value != string(pyClosingBrackets[rune(op[0])]) {
+				return t.errorf(line, col, "括号 %s 不匹配", op)
+			}
+			t.brackets = t.brackets[:len(t.brackets)-1]
+		}
+		t.tokens = append(t.tokens, token)
+		return nil
+	}
+
+	return t.errorf(line, col, "无法识别的字符 %q", t.peek(0))
+}
+
+// pyNode 轻量语法树节点：一个逻辑行及其缩进代码块
+type pyNode struct {
+	Tokens   []pyToken
+	Children []*pyNode
+}
+
+// Keyword 获取语句的首个关键字（跳过async）
+func (n *pyNode) Keyword() string {
+	for _, token := range n.Tokens {
+		if token.Value != "async" {
+			return token.Value
+		}
+	}
+	return ""
+}
+
+// pyImport 导入语句中的一个模块
+type pyImport struct {
+	Module string // 被导入的模块，相对导入以 . 开头
+	Line   int
+	Col    int
+}
+
+// pyClass 模块顶层的类定义
+type pyClass struct {
+	Name    string
+	Bases   []string // 基类的点分名称，例如 Scene、manim.Scene
+	Methods []string
+	Line    int
+	Col     int
+}
+
+// pyModule 解析后的Python模块
+type pyModule struct {
+	Tokens  []pyToken
+	Root    *pyNode
+	Imports []pyImport
+	Classes []pyClass
+}
+
+// parsePythonModule 解析Python源码，构建语句树并提取导入和类定义
+func parsePythonModule(src string) (*pyModule, error) {
+	tokens, err := tokenizePython(src)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &pyNode{}
+	stack := []*pyNode{root}
+	var current *pyNode
+
+	for _, token := range tokens {
+		switch token.Kind {
+		case pyNewline:
+			current = nil
+		case pyIndent:
+			parent := stack[len(stack)-1]
+			if len(parent.Children) == 0 {
+				return nil, &pySyntaxError{Line: token.Line, Col: token.Col, Msg: "意外的缩进"}
+			}
+			stack = append(stack, parent.Children[len(parent.Children)-1])
+		case pyDedent:
+			stack = stack[:len(stack)-1]
+		case pyEOF:
+		default:
+			if current == nil {
+				current = &pyNode{}
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, current)
+			}
+			current.Tokens = append(current.Tokens, token)
+		}
+	}
+
+	module := &pyModule{Tokens: tokens, Root: root}
+	module.collectImports(root)
+	for _, node := range root.Children {
+		if node.Keyword() == "class" {
+			module.Classes = append(module.Classes, parseClass(node))
+		}
+	}
+
+	return module, nil
+}
+
+// collectImports 递归提取所有导入语句，包括函数内和单行复合语句中的导入
+func (m *pyModule) collectImports(node *pyNode) {
+	tokens := node.Tokens
+	for i, token := range tokens {
+		if token.Kind != pyName || token.Value != "import" {
+			continue
+		}
+
+		// from X import a, b：向前查找from关键字，中间只能是模块路径
+		j := i - 1
+		for j >= 0 && (tokens[j].Kind == pyName && tokens[j].Value != "from" || tokens[j].Value == "." || tokens[j].Value == "...") {
+			j--
+		}
+		if j >= 0 && tokens[j].Kind == pyName && tokens[j].Value == "from" {
+			module := joinTokens(tokens[j+1 : i])
+			m.Imports = append(m.Imports, pyImport{Module: module, Line: tokens[j].Line, Col: tokens[j].Col})
+			continue
+		}
+
+		// import a.b as c, d
+		expectName := true
+		for k := i + 1; k < len(tokens); k++ {
+			t := tokens[k]
+			if t.Value == ";" {
+				break
+			}
+			if t.Value == "," {
+				expectName = true
+				continue
+			}
+			if expectName && t.Kind == pyName {
+				end := k
+				for end+2 < len(tokens) && tokens[end+1].Value == "." && tokens[end+2].Kind == pyName {
+					end += 2
+				}
+				m.Imports = append(m.Imports, pyImport{Module: joinTokens(tokens[k : end+1]), Line: t.Line, Col: t.Col})
+				k = end
+				expectName = false
+			}
+		}
+	}
+
+	for _, child := range node.Children {
+		m.collectImports(child)
+	}
+}
+
+// parseClass 解析类定义的名称、基类和方法
+func parseClass(node *pyNode) pyClass {
+	tokens := node.Tokens
+	class := pyClass{Line: tokens[0].Line, Col: tokens[0].Col}
+	if len(tokens) > 1 {
+		class.Name = tokens[1].Value
+	}
+
+	// class Name(Base1, module.Base2, metaclass=Meta):
+	if len(tokens) > 2 && tokens[2].Value == "(" {
+		depth := 0
+		var base []pyToken
+		flush := func() {
+			if len(base) > 0 && !(len(base) > 1 && base[1].Value == "=") {
+				class.Bases = append(class.Bases, joinTokens(base))
+			}
+			base = nil
+		}
+		for _, token := range tokens[3:] {
+			switch token.Value {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				depth--
+			}
+			if depth < 0 {
+				break
+			}
+			if depth == 0 && token.Value == "," {
+				flush()
+				continue
+			}
+			base = append(base, token)
+		}
+		flush()
+	}
+
+	for _, child := range node.Children {
+		if child.Keyword() == "def" {
+			for i, token := range child.Tokens {
+				if token.Value == "def" && i+1 < len(child.Tokens) {
+					class.Methods = append(class.Methods, child.Tokens[i+1].Value)
+					break
+				}
+			}
+		}
+	}
+
+	return class
+}
+
+// joinTokens 拼接词法单元的原始文本
+func joinTokens(tokens []pyToken) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteString(token.Value)
+	}
+	return sb.String()
+}
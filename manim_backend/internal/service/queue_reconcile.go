@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"manim-backend/internal/model"
+)
+
+// ReconcileResult 一次对账的结果
+type ReconcileResult struct {
+	Checked int                    // 检查的未完成视频数
+	Audits  []model.ReconcileAudit // 处理的孤儿任务
+}
+
+// trackedJobs 队列中等待中和已被领取的视频ID
+func (s *VideoQueueService) trackedJobs(ctx context.Context) (map[uint]bool, error) {
+	waiting, err := s.queue.Waiting(ctx)
+	if err != nil {
+		return nil, err
+	}
+	leased, err := s.queue.Leased(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[uint]bool, len(waiting)+len(leased))
+	for _, videoID := range waiting {
+		tracked[videoID] = true
+	}
+	for _, videoID := range leased {
+		tracked[videoID] = true
+	}
+	return tracked, nil
+}
+
+// ReconcileQueue 对比数据库中未完成的视频与队列中的任务和租约，处理两边不一致的孤儿任务：
+// 有代码的等待和排队视频重新入队；处理中的视频按工作者丢失记录一次临时失败，由重试策略安排重试或移入死信队列；
+// 没有代码的视频标记失败。等待重试的视频由重试调度处理，两个租约时长内更新过的视频可能正在入队，
+// 都留到下次对账。每个处理的孤儿任务都写入对账记录
+func (s *VideoService) ReconcileQueue(ctx context.Context) (ReconcileResult, error) {
+	cutoff := time.Now().Add(-2 * s.queueSvc.leaseTTL)
+
+	// 先读数据库再读队列，期间完成的任务状态已经改变，处理前的条件更新会跳过它们
+	videos, err := s.GetProcessingVideos(ctx)
+	if err != nil {
+		return ReconcileResult{}, fmt.Errorf("获取未完成的视频失败: %v", err)
+	}
+	tracked, err := s.queueSvc.trackedJobs(ctx)
+	if err != nil {
+		return ReconcileResult{}, fmt.Errorf("获取队列中的任务失败: %v", err)
+	}
+
+	result := ReconcileResult{Checked: len(videos), Audits: []model.ReconcileAudit{}}
+	for _, video := range videos {
+		if tracked[video.ID] || video.NextAttemptAt != nil || video.UpdatedAt.After(cutoff) {
+			continue
+		}
+		audit, err := s.reconcileOrphan(ctx, video, cutoff)
+		if err != nil {
+			log.Printf("对账处理视频 %d 失败: %v", video.ID, err)
+			continue
+		}
+		if audit != nil {
+			result.Audits = append(result.Audits, *audit)
+		}
+	}
+	return result, nil
+}
+
+// reconcileOrphan 处理一个孤儿任务并写入对账记录，视频在对账期间发生变化时返回nil
+func (s *VideoService) reconcileOrphan(ctx context.Context, video model.Video, cutoff time.Time) (*model.ReconcileAudit, error) {
+	// 条件更新确认视频在对账期间没有变化，多个实例同时对账时只有一个实例处理
+	claimed := s.db.WithContext(ctx).Model(&model.Video{}).
+		Where("id = ? AND status = ? AND updated_at <= ? AND next_attempt_at IS NULL", video.ID, video.Status, cutoff).
+		Update("updated_at", time.Now())
+	if claimed.Error != nil {
+		return nil, claimed.Error
+	}
+	if claimed.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	audit := &model.ReconcileAudit{VideoID: video.ID, PrevStatus: video.Status.String()}
+	switch {
+	case video.ManimCode == "":
+		audit.Action, audit.Reason = model.ReconcileActionFailed, "没有Manim代码，无法渲染"
+		if err := s.MarkVideoFailed(ctx, video.ID, "", "", "没有Manim代码"); err != nil {
+			return nil, err
+		}
+	case video.Status == model.VideoStatusProcessing:
+		audit.Reason = "处理中的视频没有租约，渲染工作者可能已退出"
+		if err := s.RecordRenderFailure(ctx, video.ID, model.FailureReasonWorkerLost, "", audit.Reason); err != nil {
+			return nil, err
+		}
+		// 重试次数用尽时已移入死信队列
+		audit.Action = model.ReconcileActionRetry
+		if updated, err := s.GetVideoByID(ctx, video.ID); err == nil && updated.Status == model.VideoStatusFailed {
+			audit.Action = model.ReconcileActionFailed
+		}
+	default:
+		audit.Action, audit.Reason = model.ReconcileActionRequeued, "队列中没有该视频的任务"
+		err := s.AddToQueue(ctx, video.ID)
+		if errors.Is(err, ErrJobQueued) || errors.Is(err, ErrRenderInProgress) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.db.WithContext(ctx).Create(audit).Error; err != nil {
+		return nil, fmt.Errorf("保存对账记录失败: %v", err)
+	}
+	log.Printf("对账: 视频 %d（%s）%s: %s", video.ID, audit.PrevStatus, audit.Action, audit.Reason)
+	return audit, nil
+}
+
+// ListReconcileAudits 获取最近的对账记录，按时间倒序
+func (s *VideoService) ListReconcileAudits(ctx context.Context, limit int) ([]model.ReconcileAudit, error) {
+	var audits []model.ReconcileAudit
+	err := s.db.WithContext(ctx).Order("id DESC").Limit(limit).Find(&audits).Error
+	return audits, err
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createReconcileVideo 创建指定状态的视频，并将更新时间设置为staleFor之前
+func createReconcileVideo(t *testing.T, videoSvc *VideoService, status model.VideoStatus, code string, attempts int, staleFor time.Duration) *model.Video {
+	ctx := context.Background()
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, videoSvc.db.Model(&model.Video{}).Where("id = ?", video.ID).UpdateColumns(map[string]interface{}{
+		"status":     status,
+		"manim_code": code,
+		"attempts":   attempts,
+		"updated_at": time.Now().Add(-staleFor),
+	}).Error)
+	return video
+}
+
+func TestVideoService_ReconcileQueue(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx := context.Background()
+	stale := 10 * time.Minute
+
+	pending := createReconcileVideo(t, videoSvc, model.VideoStatusPending, testManimCode, 0, stale)
+	queued := createReconcileVideo(t, videoSvc, model.VideoStatusQueued, testManimCode, 0, stale)
+	crashed := createReconcileVideo(t, videoSvc, model.VideoStatusProcessing, testManimCode, 1, stale)
+	exhausted := createReconcileVideo(t, videoSvc, model.VideoStatusProcessing, testManimCode, 2, stale)
+	noCode := createReconcileVideo(t, videoSvc, model.VideoStatusQueued, "", 0, stale)
+
+	// 队列中有任务、刚更新、等待重试和已完成的视频不处理
+	inQueue := createReconcileVideo(t, videoSvc, model.VideoStatusPending, testManimCode, 0, 0)
+	assert.NoError(t, videoSvc.AddToQueue(ctx, inQueue.ID))
+	videoSvc.db.Model(&model.Video{}).Where("id = ?", inQueue.ID).UpdateColumn("updated_at", time.Now().Add(-stale))
+	fresh := createReconcileVideo(t, videoSvc, model.VideoStatusProcessing, testManimCode, 1, 0)
+	retrying := createReconcileVideo(t, videoSvc, model.VideoStatusQueued, testManimCode, 1, stale)
+	videoSvc.db.Model(&model.Video{}).Where("id = ?", retrying.ID).UpdateColumn("next_attempt_at", time.Now().Add(time.Minute))
+	completed := createReconcileVideo(t, videoSvc, model.VideoStatusCompleted, testManimCode, 1, stale)
+
+	result, err := videoSvc.ReconcileQueue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, result.Checked)
+	actions := map[uint]string{}
+	for _, audit := range result.Audits {
+		actions[audit.VideoID] = audit.Action
+	}
+	assert.Equal(t, map[uint]string{
+		pending.ID:   model.ReconcileActionRequeued,
+		queued.ID:    model.ReconcileActionRequeued,
+		crashed.ID:   model.ReconcileActionRetry,
+		exhausted.ID: model.ReconcileActionFailed,
+		noCode.ID:    model.ReconcileActionFailed,
+	}, actions)
+
+	_, waiting, err := videoSvc.GetQueueStatus(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []uint{inQueue.ID, pending.ID, queued.ID}, waiting)
+
+	// 处理中的孤儿任务按工作者丢失记录失败，次数用尽时移入死信队列
+	updated, err := videoSvc.GetVideoByID(ctx, crashed.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusQueued, updated.Status)
+	assert.Equal(t, model.FailureReasonWorkerLost, updated.FailureReason)
+	assert.NotNil(t, updated.NextAttemptAt)
+	deadLetters, err := videoSvc.ListDeadLetters(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, exhausted.ID, deadLetters[0].VideoID)
+	}
+	updated, err = videoSvc.GetVideoByID(ctx, noCode.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusFailed, updated.Status)
+
+	for _, video := range []*model.Video{fresh, retrying, completed} {
+		unchanged, err := videoSvc.GetVideoByID(ctx, video.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, video.ID, unchanged.ID)
+		assert.Zero(t, unchanged.FailureReason)
+	}
+
+	// 对账记录已保存，再次对账时没有孤儿任务
+	audits, err := videoSvc.ListReconcileAudits(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, audits, 5)
+	result, err = videoSvc.ReconcileQueue(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Audits)
+}
+
+func TestVideoService_ReconcileQueue_SkipsLeased(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx := context.Background()
+
+	video := createReconcileVideo(t, videoSvc, model.VideoStatusPending, testManimCode, 0, 0)
+	assert.NoError(t, videoSvc.AddToQueue(ctx, video.ID))
+	job, err := videoSvc.queueSvc.queue.Claim(ctx, "worker-a", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, videoSvc.BeginRenderAttempt(ctx, video.ID, ""))
+	videoSvc.db.Model(&model.Video{}).Where("id = ?", video.ID).UpdateColumn("updated_at", time.Now().Add(-time.Hour))
+
+	// 工作者持有租约的视频即使长时间没有更新也不处理
+	result, err := videoSvc.ReconcileQueue(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Audits)
+
+	// 租约确认后视频仍处于处理中，说明工作者没有写入结果就退出了
+	assert.NoError(t, videoSvc.queueSvc.queue.Ack(ctx, job))
+	result, err = videoSvc.ReconcileQueue(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Audits, 1) {
+		assert.Equal(t, model.VideoStatusProcessing.String(), result.Audits[0].PrevStatus)
+		assert.Equal(t, model.ReconcileActionRetry, result.Audits[0].Action)
+	}
+}
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RenderCache 按代码和渲染参数缓存渲染产物，相同的代码（例如重复提交同一段AI输出）不再重新渲染。
+// 缓存键为规范化代码、渲染参数和Manim版本的SHA-256，命中时新视频的产物记录直接引用已有的文件；
+// 缓存条目记录引用文件的视频数，删除和清理视频时只有最后一个引用释放后才删除文件
+type RenderCache struct {
+	db      *gorm.DB
+	cfg     config.ManimConfig
+	enabled bool
+
+	versionOnce sync.Once
+	version     string
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// RenderCacheStats 渲染缓存统计，Hits和Misses为本实例启动以来的计数
+type RenderCacheStats struct {
+	Enabled    bool
+	Entries    int64 // 缓存条目数
+	References int64 // 引用缓存产物的视频数
+	TotalHits  int64 // 所有实例累计的命中次数
+	Hits       int64
+	Misses     int64
+}
+
+// errRenderCacheMiss 缓存条目已被释放，需要重新渲染
+var errRenderCacheMiss = errors.New("渲染缓存未命中")
+
+func NewRenderCache(db *gorm.DB, cfg config.ManimConfig) *RenderCache {
+	return &RenderCache{db: db, cfg: cfg, enabled: cfg.Cache.Enabled}
+}
+
+// manimVersion 渲染环境的Manim版本，无法获取时返回空字符串，此时不使用缓存
+func (c *RenderCache) manimVersion() string {
+	c.versionOnce.Do(func() {
+		c.version = c.cfg.Cache.ManimVersion
+		if c.version != "" {
+			return
+		}
+		switch c.cfg.Renderer {
+		case RendererFake:
+			c.version = RendererFake
+		case RendererDocker:
+			c.version = "docker:" + c.cfg.DockerImage
+		default:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			output, err := exec.CommandContext(ctx, c.cfg.PythonPath, "-c", "import manim; print(manim.__version__)").Output()
+			if err != nil {
+				log.Printf("获取Manim版本失败，不使用渲染缓存: %v", err)
+				return
+			}
+			c.version = strings.TrimSpace(string(output))
+		}
+	})
+	return c.version
+}
+
+// Key 计算代码和渲染参数的缓存键，缓存未启用或无法获取Manim版本时返回空字符串
+func (c *RenderCache) Key(code string, opts model.RenderOptions) string {
+	if c == nil || !c.enabled {
+		return ""
+	}
+	version := c.manimVersion()
+	if version == "" {
+		return ""
+	}
+	return renderCacheKey(code, opts, version)
+}
+
+// renderCacheKey 规范化代码、全部渲染参数和Manim版本的SHA-256，参数不同的产物不能互相替代
+func renderCacheKey(code string, opts model.RenderOptions, manimVersion string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "manim=%s\nquality=%s\nformat=%s\nwidth=%d\nheight=%d\nframe_rate=%d\nbackground=%s\ntransparent=%t\nlast_frame=%t\nscenes=%s\nconcat=%t\n",
+		manimVersion, opts.Quality, opts.Format, opts.Width, opts.Height, opts.FrameRate,
+		strings.ToLower(opts.BackgroundColor), opts.Transparent, opts.SaveLastFrame, opts.Scenes, opts.Concat)
+	h.Write([]byte(normalizeManimCode(code)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeManimCode 统一换行符，去掉行尾空白和首尾空行，不影响渲染结果的差异不产生不同的缓存键
+func normalizeManimCode(code string) string {
+	lines := strings.Split(strings.ReplaceAll(code, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// cachedArtifacts 获取缓存键对应的产物，任何一个文件丢失时使条目失效
+func (c *RenderCache) cachedArtifacts(ctx context.Context, key string, blobs BlobStore) ([]model.VideoArtifact, error) {
+	var sourceID uint
+	err := c.db.WithContext(ctx).Model(&model.VideoArtifact{}).
+		Where("cache_key = ?", key).
+		Select("COALESCE(MIN(video_id), 0)").Scan(&sourceID).Error
+	if err != nil {
+		return nil, err
+	}
+	if sourceID == 0 {
+		return nil, errRenderCacheMiss
+	}
+
+	var artifacts []model.VideoArtifact
+	err = c.db.WithContext(ctx).Where("video_id = ? AND cache_key = ?", sourceID, key).Order("id ASC").Find(&artifacts).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, artifact := range artifacts {
+		_, err := blobs.Stat(ctx, artifact.Path)
+		if errors.Is(err, ErrBlobNotFound) {
+			log.Printf("渲染缓存 %s 的产物 %s 已丢失，缓存失效", key, artifact.Path)
+			c.invalidate(ctx, key)
+			return nil, errRenderCacheMiss
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return artifacts, nil
+}
+
+// invalidate 删除缓存条目，引用它的产物改为各视频独占
+func (c *RenderCache) invalidate(ctx context.Context, key string) {
+	err := c.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cache_key = ?", key).Delete(&model.RenderCacheEntry{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.VideoArtifact{}).Where("cache_key = ?", key).Update("cache_key", "").Error
+	})
+	if err != nil {
+		log.Printf("删除渲染缓存 %s 失败: %v", key, err)
+	}
+}
+
+// Stats 获取缓存统计
+func (c *RenderCache) Stats(ctx context.Context) (RenderCacheStats, error) {
+	stats := RenderCacheStats{Enabled: c.enabled, Hits: c.hits.Load(), Misses: c.misses.Load()}
+	var totals struct {
+		Entries   int64
+		Refs      int64
+		TotalHits int64
+	}
+	err := c.db.WithContext(ctx).Model(&model.RenderCacheEntry{}).
+		Select("COUNT(*) AS entries, COALESCE(SUM(ref_count), 0) AS refs, COALESCE(SUM(hit_count), 0) AS total_hits").
+		Scan(&totals).Error
+	if err != nil {
+		return stats, err
+	}
+	stats.Entries, stats.References, stats.TotalHits = totals.Entries, totals.Refs, totals.TotalHits
+	return stats, nil
+}
+
+// LinkCachedRender 缓存命中时为视频引用已有的产物并标记完成，返回是否命中。
+// key为空或未命中时返回false，由调用方正常渲染
+func (s *VideoService) LinkCachedRender(ctx context.Context, videoID uint, key, manimCode string) (bool, error) {
+	if key == "" {
+		return false, nil
+	}
+
+	cached, err := s.cache.cachedArtifacts(ctx, key, s.blobs)
+	if errors.Is(err, errRenderCacheMiss) {
+		s.cache.misses.Add(1)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	artifacts := make([]model.VideoArtifact, len(cached))
+	for i, artifact := range cached {
+		artifact.ID = 0
+		artifact.CreatedAt = time.Time{}
+		artifacts[i] = artifact
+	}
+
+	var orphans []string
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 引用数为0的条目正在被释放，文件随时可能被删除
+		result := tx.Model(&model.RenderCacheEntry{}).
+			Where("cache_key = ? AND ref_count > 0", key).
+			Updates(map[string]interface{}{
+				"ref_count": gorm.Expr("ref_count + 1"),
+				"hit_count": gorm.Expr("hit_count + 1"),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRenderCacheMiss
+		}
+		orphans, err = replaceVideoArtifacts(tx, videoID, artifacts)
+		return err
+	})
+	if errors.Is(err, errRenderCacheMiss) {
+		s.cache.misses.Add(1)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.cache.hits.Add(1)
+	s.removeArtifactFiles(ctx, orphans, artifacts)
+
+	if err := s.UpdateVideoStatus(ctx, videoID, model.VideoStatusCompleted, manimCode, artifacts[0].Path, ""); err != nil {
+		return true, err
+	}
+	log.Printf("视频 %d 命中渲染缓存 %s", videoID, key)
+	return true, nil
+}
+
+// SaveRenderedArtifacts 保存新渲染的产物，key不为空且还没有相同的缓存条目时登记为缓存，
+// 之后相同代码和渲染参数的视频直接引用这些文件
+func (s *VideoService) SaveRenderedArtifacts(ctx context.Context, videoID uint, key string, artifacts []model.VideoArtifact) error {
+	var orphans []string
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if key != "" {
+			// 相同的代码同时渲染时只有先完成的登记为缓存，其余的产物由各自的视频独占
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.RenderCacheEntry{
+				CacheKey:      key,
+				SourceVideoID: videoID,
+				RefCount:      1,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 1 {
+				for i := range artifacts {
+					artifacts[i].CacheKey = key
+				}
+			}
+		}
+
+		var err error
+		orphans, err = replaceVideoArtifacts(tx, videoID, artifacts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.removeArtifactFiles(ctx, orphans, artifacts)
+	return nil
+}
+
+// RenderCacheStats 获取渲染缓存的命中统计
+func (s *VideoService) RenderCacheStats(ctx context.Context) (RenderCacheStats, error) {
+	return s.cache.Stats(ctx)
+}
+
+// releaseVideoArtifacts 删除视频的产物记录并释放缓存引用，返回不再被任何视频引用、可以删除的文件
+func releaseVideoArtifacts(tx *gorm.DB, videoID uint) ([]string, error) {
+	var artifacts []model.VideoArtifact
+	if err := tx.Where("video_id = ?", videoID).Find(&artifacts).Error; err != nil {
+		return nil, err
+	}
+	if len(artifacts) == 0 {
+		return nil, nil
+	}
+	if err := tx.Where("video_id = ?", videoID).Delete(&model.VideoArtifact{}).Error; err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	released := map[string]bool{}
+	for _, artifact := range artifacts {
+		if artifact.CacheKey == "" {
+			orphans = append(orphans, artifact.Path)
+			continue
+		}
+		free, ok := released[artifact.CacheKey]
+		if !ok {
+			var err error
+			free, err = releaseCacheReference(tx, artifact.CacheKey)
+			if err != nil {
+				return nil, err
+			}
+			released[artifact.CacheKey] = free
+		}
+		if free {
+			orphans = append(orphans, artifact.Path)
+		}
+	}
+	return orphans, nil
+}
+
+// releaseCacheReference 减少缓存条目的引用数，降为0时删除条目并返回true
+func releaseCacheReference(tx *gorm.DB, key string) (bool, error) {
+	err := tx.Model(&model.RenderCacheEntry{}).
+		Where("cache_key = ? AND ref_count > 0", key).
+		Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	if err != nil {
+		return false, err
+	}
+	result := tx.Where("cache_key = ? AND ref_count <= 0", key).Delete(&model.RenderCacheEntry{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// removeArtifactFiles 从存储中删除不再被引用的产物文件，跳过keep中仍在使用的路径
+func (s *VideoService) removeArtifactFiles(ctx context.Context, paths []string, keep []model.VideoArtifact) {
+	inUse := make(map[string]bool, len(keep))
+	for _, artifact := range keep {
+		inUse[artifact.Path] = true
+	}
+	for _, path := range paths {
+		if inUse[path] {
+			continue
+		}
+		if err := s.blobs.Delete(ctx, path); err != nil {
+			log.Printf("删除产物文件 %s 失败: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderCacheKey(t *testing.T) {
+	opts := DefaultRenderOptions()
+	key := renderCacheKey(testManimCode, opts, "0.18.0")
+	assert.Len(t, key, 64)
+
+	// 换行符、行尾空白和首尾空行不影响缓存键
+	crlf := "\n" + strings.ReplaceAll(testManimCode, "\n", "  \r\n") + "\n\n"
+	assert.Equal(t, key, renderCacheKey(crlf, opts, "0.18.0"))
+
+	// 代码、渲染参数和Manim版本不同时缓存键不同
+	assert.NotEqual(t, key, renderCacheKey(testManimCode+"\n        self.wait(1)", opts, "0.18.0"))
+	assert.NotEqual(t, key, renderCacheKey(testManimCode, opts, "0.19.0"))
+	other := opts
+	other.Quality = model.RenderQualityHigh
+	assert.NotEqual(t, key, renderCacheKey(testManimCode, other, "0.18.0"))
+	other = opts
+	other.Format = model.OutputFormatGIF
+	assert.NotEqual(t, key, renderCacheKey(testManimCode, other, "0.18.0"))
+
+	// 未启用缓存时不计算缓存键
+	assert.Empty(t, NewRenderCache(nil, config.ManimConfig{Renderer: RendererFake}).Key(testManimCode, opts))
+	var disabled *RenderCache
+	assert.Empty(t, disabled.Key(testManimCode, opts))
+}
+
+// setupTestRenderCache 创建启用渲染缓存的测试环境
+func setupTestRenderCache(t *testing.T) (*ManimService, *VideoService, *FakeRenderer) {
+	manimSvc, videoSvc, renderer := setupTestManimService(t)
+	videoSvc.cache = NewRenderCache(videoSvc.db, config.ManimConfig{Renderer: RendererFake, Cache: config.CacheConfig{Enabled: true}})
+	return manimSvc, videoSvc, renderer
+}
+
+// generateTestVideo 创建视频并同步渲染，返回渲染后的视频
+func generateTestVideo(t *testing.T, manimSvc *ManimService, videoSvc *VideoService, code string, opts model.RenderOptions) *model.Video {
+	ctx := context.Background()
+	video, err := videoSvc.CreateVideoWithOptions(ctx, 1, "画一个圆", opts)
+	assert.NoError(t, err)
+	assert.NoError(t, manimSvc.GenerateVideo(ctx, video.ID, code))
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusCompleted, updated.Status)
+	return updated
+}
+
+func TestManimService_GenerateVideo_CacheHit(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestRenderCache(t)
+	ctx := context.Background()
+	opts := DefaultRenderOptions()
+
+	first := generateTestVideo(t, manimSvc, videoSvc, testManimCode, opts)
+	assert.Equal(t, 1, renderer.Calls())
+
+	// 重复提交相同的代码时引用已有的产物，不再渲染
+	second := generateTestVideo(t, manimSvc, videoSvc, strings.ReplaceAll(testManimCode, "\n", "\r\n"), opts)
+	assert.Equal(t, 1, renderer.Calls())
+	assert.Equal(t, first.VideoPath, second.VideoPath)
+	assert.Equal(t, first.Media, second.Media)
+	artifacts, err := videoSvc.GetVideoArtifacts(ctx, second.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, artifacts, 1) {
+		assert.NotEmpty(t, artifacts[0].CacheKey)
+	}
+
+	// 渲染参数不同时重新渲染
+	gif := opts
+	gif.Format = model.OutputFormatGIF
+	generateTestVideo(t, manimSvc, videoSvc, testManimCode, gif)
+	assert.Equal(t, 2, renderer.Calls())
+
+	stats, err := videoSvc.RenderCacheStats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, RenderCacheStats{Enabled: true, Entries: 2, References: 3, TotalHits: 1, Hits: 1, Misses: 2}, stats)
+}
+
+func TestVideoService_DeleteVideo_SharedCacheFiles(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestRenderCache(t)
+	ctx := context.Background()
+	opts := DefaultRenderOptions()
+
+	first := generateTestVideo(t, manimSvc, videoSvc, testManimCode, opts)
+	second := generateTestVideo(t, manimSvc, videoSvc, testManimCode, opts)
+
+	// 删除首次渲染的视频后文件仍被另一个视频引用，缓存继续有效
+	assert.NoError(t, videoSvc.DeleteVideo(ctx, first.ID))
+	assert.FileExists(t, first.VideoPath)
+	third := generateTestVideo(t, manimSvc, videoSvc, testManimCode, opts)
+	assert.Equal(t, 1, renderer.Calls())
+	assert.Equal(t, first.VideoPath, third.VideoPath)
+
+	// 清理过期视频同样只释放引用
+	videoSvc.db.Model(&model.Video{}).Where("id = ?", second.ID).UpdateColumn("created_at", time.Now().AddDate(0, 0, -31))
+	assert.NoError(t, videoSvc.CleanOldVideos(ctx, 30))
+	assert.FileExists(t, first.VideoPath)
+	_, err := videoSvc.GetVideoByID(ctx, second.ID)
+	assert.Error(t, err)
+
+	// 最后一个引用删除后删除文件和缓存条目
+	assert.NoError(t, videoSvc.DeleteVideo(ctx, third.ID))
+	assert.NoFileExists(t, first.VideoPath)
+	stats, err := videoSvc.RenderCacheStats(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, stats.Entries)
+	assert.Zero(t, stats.References)
+
+	// 重复删除不会再次释放引用
+	assert.NoError(t, videoSvc.DeleteVideo(ctx, third.ID))
+}
+
+func TestVideoService_DeleteVideo_RemovesUnsharedFiles(t *testing.T) {
+	manimSvc, videoSvc, _ := setupTestManimService(t)
+	ctx := context.Background()
+
+	video := generateTestVideo(t, manimSvc, videoSvc, testManimCode, DefaultRenderOptions())
+	assert.FileExists(t, video.VideoPath)
+	assert.NoError(t, videoSvc.DeleteVideo(ctx, video.ID))
+	assert.NoFileExists(t, video.VideoPath)
+}
+
+func TestManimService_GenerateVideo_CacheFileMissing(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestRenderCache(t)
+	ctx := context.Background()
+	opts := DefaultRenderOptions()
+
+	first := generateTestVideo(t, manimSvc, videoSvc, testManimCode, opts)
+	assert.NoError(t, os.Remove(first.VideoPath))
+
+	// 缓存的文件丢失时缓存失效并重新渲染
+	second := generateTestVideo(t, manimSvc, videoSvc, testManimCode, opts)
+	assert.Equal(t, 2, renderer.Calls())
+	assert.NotEqual(t, first.VideoPath, second.VideoPath)
+	assert.FileExists(t, second.VideoPath)
+
+	artifacts, err := videoSvc.GetVideoArtifacts(ctx, first.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, artifacts, 1) {
+		assert.Empty(t, artifacts[0].CacheKey)
+	}
+	stats, err := videoSvc.RenderCacheStats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Entries)
+	assert.Equal(t, int64(2), stats.Misses)
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrRenderCancelled 用户取消了渲染
+var ErrRenderCancelled = errors.New("渲染已取消")
+
+// RenderCanceller 通过Redis发布订阅向所有实例广播取消信号，
+// 正在渲染该视频的工作者收到后取消渲染上下文，由渲染器终止整个进程组。
+// 未配置Redis时只在进程内生效
+type RenderCanceller struct {
+	rdb     *redis.Client
+	channel string
+
+	mu      sync.Mutex
+	running map[uint]context.CancelCauseFunc
+}
+
+func NewRenderCanceller(rdb *redis.Client) *RenderCanceller {
+	return &RenderCanceller{
+		rdb:     rdb,
+		channel: "video_render_cancel",
+		running: make(map[uint]context.CancelCauseFunc),
+	}
+}
+
+// Track 登记本实例正在渲染的视频，收到取消信号时返回的上下文以ErrRenderCancelled取消。
+// 渲染结束后必须调用release
+func (c *RenderCanceller) Track(ctx context.Context, videoID uint) (context.Context, func()) {
+	jobCtx, cancel := context.WithCancelCause(ctx)
+
+	c.mu.Lock()
+	c.running[videoID] = cancel
+	c.mu.Unlock()
+
+	release := func() {
+		c.mu.Lock()
+		delete(c.running, videoID)
+		c.mu.Unlock()
+		cancel(nil)
+	}
+	return jobCtx, release
+}
+
+// Cancel 广播视频的取消信号，视频不在任何实例中渲染时没有效果
+func (c *RenderCanceller) Cancel(ctx context.Context, videoID uint) error {
+	if c.rdb == nil {
+		c.cancelLocal(videoID)
+		return nil
+	}
+
+	if err := c.rdb.Publish(ctx, c.channel, strconv.FormatUint(uint64(videoID), 10)).Err(); err != nil {
+		return fmt.Errorf("发布取消信号失败: %v", err)
+	}
+	return nil
+}
+
+// cancelLocal 取消本实例中视频的渲染，返回视频是否正在本实例中渲染
+func (c *RenderCanceller) cancelLocal(videoID uint) bool {
+	c.mu.Lock()
+	cancel, ok := c.running[videoID]
+	c.mu.Unlock()
+
+	if ok {
+		log.Printf("收到视频 %d 的取消信号，终止渲染", videoID)
+		cancel(ErrRenderCancelled)
+	}
+	return ok
+}
+
+// Listen 订阅其他实例发出的取消信号，直到ctx结束。未配置Redis时直接返回
+func (c *RenderCanceller) Listen(ctx context.Context) {
+	if c.rdb == nil {
+		return
+	}
+
+	pubsub := c.rdb.Subscribe(ctx, c.channel)
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			videoID, err := strconv.ParseUint(msg.Payload, 10, 32)
+			if err != nil {
+				log.Printf("解析取消信号失败: %v", err)
+				continue
+			}
+			c.cancelLocal(uint(videoID))
+		}
+	}
+}
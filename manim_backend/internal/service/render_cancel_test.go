@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestRenderCanceller_Local(t *testing.T) {
+	canceller := NewRenderCanceller(nil)
+
+	ctx, release := canceller.Track(context.Background(), 1)
+	defer release()
+	other, releaseOther := canceller.Track(context.Background(), 2)
+	defer releaseOther()
+
+	assert.NoError(t, canceller.Cancel(context.Background(), 1))
+	assert.Error(t, ctx.Err())
+	assert.ErrorIs(t, context.Cause(ctx), ErrRenderCancelled)
+	assert.NoError(t, other.Err())
+
+	// 渲染结束后收到的取消信号没有效果
+	releaseOther()
+	assert.False(t, canceller.cancelLocal(2))
+}
+
+func TestVideoService_CancelVideo_Queued(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, videoSvc.AddToQueue(ctx, video.ID))
+
+	assert.NoError(t, videoSvc.CancelVideo(ctx, video.ID))
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusCancelled, updated.Status)
+	waiting, err := videoSvc.queueSvc.queue.Waiting(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, waiting)
+
+	// 已取消的视频不能再次取消，也不会开始渲染
+	assert.ErrorIs(t, videoSvc.CancelVideo(ctx, video.ID), ErrVideoNotCancellable)
+	assert.ErrorIs(t, videoSvc.BeginRenderAttempt(ctx, video.ID, testManimCode), ErrRenderCancelled)
+	assert.Error(t, videoSvc.CancelVideo(ctx, 999))
+}
+
+func TestVideoQueueService_CancelInFlight(t *testing.T) {
+	_, videoSvc, renderer := setupTestManimService(t)
+	renderer.Delay = 10 * time.Second
+	queueSvc := videoSvc.queueSvc
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	videoSvc.db.Model(&model.Video{}).Where("id = ?", video.ID).Update("manim_code", testManimCode)
+	assert.NoError(t, videoSvc.AddToQueue(ctx, video.ID))
+	job, err := queueSvc.queue.Claim(ctx, "worker-a", 0)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, job) {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		queueSvc.processLeased(ctx, job)
+	}()
+
+	assert.Eventually(t, func() bool {
+		updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+		return err == nil && updated.Status == model.VideoStatusProcessing
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, videoSvc.CancelVideo(ctx, video.ID))
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("取消后渲染没有停止")
+	}
+
+	// 取消不记为失败，任务已确认，不会被重新投递
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusCancelled, updated.Status)
+	assert.Empty(t, updated.FailureReason)
+	n, err := queueSvc.queue.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestManimService_GenerateVideo_CancelledAfterSave(t *testing.T) {
+	manimSvc, videoSvc, _ := setupTestManimService(t)
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	require.NoError(t, err)
+
+	// 产物保存后、标记完成前视频被取消，如由其他实例处理的取消请求
+	require.NoError(t, videoSvc.db.Callback().Create().After("gorm:create").Register("test:cancel_after_save", func(tx *gorm.DB) {
+		if tx.Statement.Table == "video_artifacts" {
+			tx.Session(&gorm.Session{NewDB: true}).Model(&model.Video{}).Where("id = ?", video.ID).Update("status", model.VideoStatusCancelled)
+		}
+	}))
+
+	events, unsubscribe, err := videoSvc.Events().Subscribe(ctx, video.ID)
+	require.NoError(t, err)
+	defer unsubscribe()
+
+	err = manimSvc.GenerateVideo(ctx, video.ID, testManimCode)
+	assert.ErrorIs(t, err, ErrRenderCancelled)
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.VideoStatusCancelled, updated.Status)
+	assert.Empty(t, updated.VideoPath)
+
+	// 没有广播完成状态
+	for {
+		select {
+		case event := <-events:
+			assert.NotEqual(t, model.VideoStatusCompleted.String(), event.Status)
+			continue
+		default:
+		}
+		break
+	}
+}
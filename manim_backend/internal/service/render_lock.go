@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// ErrRenderInProgress 视频正在由其他工作者或实例渲染
+var ErrRenderInProgress = errors.New("视频正在渲染中")
+
+// releaseLockScript 只删除自己持有的锁，避免锁过期后误删其他实例的锁
+var releaseLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// refreshLockScript 只续期自己持有的锁
+var refreshLockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// RenderLocker 按视频加锁，保证同一视频同一时间只有一个渲染任务。
+// 配置了Redis时使用分布式锁，持有期间定期续期，进程崩溃后锁在ttl后自动释放；
+// 未配置Redis时只在进程内互斥
+type RenderLocker struct {
+	rdb       *redis.Client
+	keyPrefix string
+	ttl       time.Duration
+
+	mu   sync.Mutex
+	held map[uint]string
+}
+
+func NewRenderLocker(rdb *redis.Client, ttl time.Duration) *RenderLocker {
+	if ttl <= 0 {
+		ttl = 60 * time.Second
+	}
+	return &RenderLocker{
+		rdb:       rdb,
+		keyPrefix: "video_render_lock:",
+		ttl:       ttl,
+		held:      make(map[uint]string),
+	}
+}
+
+// RenderLock 持有中的渲染锁
+type RenderLock struct {
+	locker  *RenderLocker
+	videoID uint
+	token   string
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func (l *RenderLocker) key(videoID uint) string {
+	return fmt.Sprintf("%s%d", l.keyPrefix, videoID)
+}
+
+// TryLock 尝试获取视频的渲染锁，已被持有时返回ErrRenderInProgress
+func (l *RenderLocker) TryLock(ctx context.Context, videoID uint) (*RenderLock, error) {
+	lock := &RenderLock{locker: l, videoID: videoID, token: uuid.New().String(), stop: make(chan struct{})}
+
+	if l.rdb == nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, ok := l.held[videoID]; ok {
+			return nil, ErrRenderInProgress
+		}
+		l.held[videoID] = lock.token
+		return lock, nil
+	}
+
+	ok, err := l.rdb.SetNX(ctx, l.key(videoID), lock.token, l.ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("获取渲染锁失败: %v", err)
+	}
+	if !ok {
+		return nil, ErrRenderInProgress
+	}
+
+	go lock.keepAlive()
+	return lock, nil
+}
+
+// keepAlive 渲染期间定期续期，渲染时间可能远超锁的ttl
+func (lock *RenderLock) keepAlive() {
+	l := lock.locker
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+			n, err := refreshLockScript.Run(context.Background(), l.rdb,
+				[]string{l.key(lock.videoID)}, lock.token, l.ttl.Milliseconds()).Int()
+			if err != nil {
+				log.Printf("续期视频 %d 的渲染锁失败: %v", lock.videoID, err)
+				continue
+			}
+			if n == 0 {
+				log.Printf("视频 %d 的渲染锁已失效", lock.videoID)
+				return
+			}
+		}
+	}
+}
+
+// Release 释放渲染锁，可重复调用
+func (lock *RenderLock) Release() {
+	lock.once.Do(func() {
+		close(lock.stop)
+		l := lock.locker
+
+		if l.rdb == nil {
+			l.mu.Lock()
+			if l.held[lock.videoID] == lock.token {
+				delete(l.held, lock.videoID)
+			}
+			l.mu.Unlock()
+			return
+		}
+
+		if err := releaseLockScript.Run(context.Background(), l.rdb,
+			[]string{l.key(lock.videoID)}, lock.token).Err(); err != nil {
+			log.Printf("释放视频 %d 的渲染锁失败: %v", lock.videoID, err)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderLocker_TryLock(t *testing.T) {
+	locker := NewRenderLocker(nil, time.Minute)
+	ctx := context.Background()
+
+	lock, err := locker.TryLock(ctx, 1)
+	assert.NoError(t, err)
+
+	// 同一视频不能重复加锁，其他视频不受影响
+	_, err = locker.TryLock(ctx, 1)
+	assert.ErrorIs(t, err, ErrRenderInProgress)
+	other, err := locker.TryLock(ctx, 2)
+	assert.NoError(t, err)
+	other.Release()
+
+	// 释放后可以重新加锁，重复释放不会影响新的持有者
+	lock.Release()
+	relocked, err := locker.TryLock(ctx, 1)
+	assert.NoError(t, err)
+	lock.Release()
+	_, err = locker.TryLock(ctx, 1)
+	assert.ErrorIs(t, err, ErrRenderInProgress)
+	relocked.Release()
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// qualityPreset Manim质量预设对应的分辨率和帧率
+type qualityPreset struct {
+	rank      int
+	width     int
+	height    int
+	frameRate int
+}
+
+var qualityPresets = map[string]qualityPreset{
+	model.RenderQualityLow:    {rank: 0, width: 854, height: 480, frameRate: 15},
+	model.RenderQualityMedium: {rank: 1, width: 1280, height: 720, frameRate: 30},
+	model.RenderQualityHigh:   {rank: 2, width: 1920, height: 1080, frameRate: 60},
+	model.RenderQualityPro:    {rank: 3, width: 2560, height: 1440, frameRate: 60},
+	model.RenderQualityFourK:  {rank: 4, width: 3840, height: 2160, frameRate: 60},
+}
+
+// 默认的用户等级渲染上限，配置中未设置Tiers时使用
+var defaultRenderTiers = []config.RenderTierConfig{
+	{Tier: model.UserTierFree, MaxQuality: model.RenderQualityMedium, MaxWidth: 1280, MaxHeight: 720, MaxFrameRate: 30},
+	{Tier: model.UserTierPro, MaxQuality: model.RenderQualityFourK, MaxWidth: 3840, MaxHeight: 2160, MaxFrameRate: 60},
+}
+
+// 背景色支持 #RGB、#RRGGBB 和Manim颜色常量名（如 BLACK、DARK_BLUE）
+var backgroundColorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[A-Za-z][A-Za-z0-9_]{0,19})$`)
+
+// 自定义分辨率的最小边长
+const minRenderDimension = 16
+
+// DefaultRenderOptions 未指定渲染参数时使用中等质量
+func DefaultRenderOptions() model.RenderOptions {
+	preset := qualityPresets[model.RenderQualityMedium]
+	return model.RenderOptions{
+		Quality:   model.RenderQualityMedium,
+		Width:     preset.width,
+		Height:    preset.height,
+		FrameRate: preset.frameRate,
+		Format:    model.OutputFormatMP4,
+	}
+}
+
+// renderOptionsOrDefault 兼容未保存渲染参数的旧视频
+func renderOptionsOrDefault(opts model.RenderOptions) model.RenderOptions {
+	if opts.Quality == "" || opts.Height == 0 || opts.FrameRate == 0 {
+		defaults := DefaultRenderOptions()
+		defaults.BackgroundColor = opts.BackgroundColor
+		defaults.Transparent = opts.Transparent
+		defaults.SaveLastFrame = opts.SaveLastFrame
+		defaults.Scenes = opts.Scenes
+		defaults.Concat = opts.Concat
+		opts = defaults
+	}
+	if opts.Format == "" {
+		opts.Format = model.OutputFormatMP4
+	}
+	return opts
+}
+
+// renderTierLimit 获取用户等级对应的渲染上限，未知等级按免费用户处理
+func renderTierLimit(tiers []config.RenderTierConfig, tier string) config.RenderTierConfig {
+	if len(tiers) == 0 {
+		tiers = defaultRenderTiers
+	}
+
+	for _, t := range tiers {
+		if t.Tier == tier {
+			return t
+		}
+	}
+	for _, t := range tiers {
+		if t.Tier == model.UserTierFree {
+			return t
+		}
+	}
+	return defaultRenderTiers[0]
+}
+
+// resolveRenderOptions 补全预设值并按用户等级上限校验渲染参数
+func resolveRenderOptions(req model.RenderOptions, limit config.RenderTierConfig) (model.RenderOptions, error) {
+	if req.Quality == "" {
+		req.Quality = model.RenderQualityMedium
+	}
+	preset, ok := qualityPresets[req.Quality]
+	if !ok {
+		return req, fmt.Errorf("不支持的渲染质量 %s，可选值为 l/m/h/p/k", req.Quality)
+	}
+	if maxPreset, ok := qualityPresets[limit.MaxQuality]; ok && preset.rank > maxPreset.rank {
+		return req, fmt.Errorf("当前用户等级最高支持 %s 质量", limit.MaxQuality)
+	}
+
+	// 分辨率需同时指定宽高，未指定时使用质量预设
+	switch {
+	case req.Width == 0 && req.Height == 0:
+		req.Width, req.Height = preset.width, preset.height
+	case req.Width <= 0 || req.Height <= 0:
+		return req, errors.New("自定义分辨率需要同时指定宽度和高度")
+	case req.Width < minRenderDimension || req.Height < minRenderDimension:
+		return req, fmt.Errorf("分辨率不能小于 %dx%d", minRenderDimension, minRenderDimension)
+	case req.Width%2 != 0 || req.Height%2 != 0:
+		// H.264编码要求宽高为偶数
+		return req, errors.New("分辨率的宽度和高度必须为偶数")
+	}
+	if limit.MaxWidth > 0 && req.Width > limit.MaxWidth || limit.MaxHeight > 0 && req.Height > limit.MaxHeight {
+		return req, fmt.Errorf("当前用户等级最高支持 %dx%d 分辨率", limit.MaxWidth, limit.MaxHeight)
+	}
+
+	if req.FrameRate == 0 {
+		req.FrameRate = preset.frameRate
+	}
+	if req.FrameRate < 0 {
+		return req, errors.New("帧率必须为正数")
+	}
+	if limit.MaxFrameRate > 0 && req.FrameRate > limit.MaxFrameRate {
+		return req, fmt.Errorf("当前用户等级最高支持 %d 帧率", limit.MaxFrameRate)
+	}
+
+	if req.BackgroundColor != "" && !backgroundColorPattern.MatchString(req.BackgroundColor) {
+		return req, fmt.Errorf("无效的背景色 %s", req.BackgroundColor)
+	}
+
+	switch req.Format {
+	case "":
+		req.Format = model.OutputFormatMP4
+	case model.OutputFormatMP4, model.OutputFormatWebM, model.OutputFormatMOV:
+	case model.OutputFormatGIF:
+		if req.Transparent {
+			return req, errors.New("GIF格式不支持透明背景，请使用webm或mov")
+		}
+	default:
+		return req, fmt.Errorf("不支持的输出格式 %s，可选值为 mp4/gif/webm/mov", req.Format)
+	}
+
+	return req, nil
+}
+
+// manimRenderArgs 生成渲染参数对应的Manim命令行参数
+func manimRenderArgs(opts model.RenderOptions) []string {
+	args := []string{
+		"-q" + opts.Quality,
+		"-r", strconv.Itoa(opts.Width) + "," + strconv.Itoa(opts.Height),
+		"--fps", strconv.Itoa(opts.FrameRate),
+	}
+	if opts.BackgroundColor != "" {
+		args = append(args, "-c", opts.BackgroundColor)
+	}
+	if opts.Format != "" && opts.Format != model.OutputFormatMP4 {
+		args = append(args, "--format", opts.Format)
+	}
+	if opts.Transparent {
+		args = append(args, "-t")
+	}
+	return args
+}
+
+// renderPass 一次Manim调用及其产物类型
+type renderPass struct {
+	kind string
+	args []string
+}
+
+// renderPasses 生成渲染所需的Manim调用。-s 只渲染最后一帧且不输出视频，
+// 需要最后一帧时在视频之后单独执行一次，此时动画会被跳过，耗时很短
+func renderPasses(opts model.RenderOptions) []renderPass {
+	passes := []renderPass{{kind: model.ArtifactKindVideo, args: manimRenderArgs(opts)}}
+	if opts.SaveLastFrame {
+		frameOpts := opts
+		frameOpts.Format = ""
+		passes = append(passes, renderPass{kind: model.ArtifactKindImage, args: append(manimRenderArgs(frameOpts), "-s")})
+	}
+	return passes
+}
+
+// ResolveRenderOptions 按用户等级校验渲染参数，返回补全后的最终参数
+func (s *VideoService) ResolveRenderOptions(ctx context.Context, userID uint, req model.RenderOptions) (model.RenderOptions, error) {
+	var user model.User
+	if err := s.db.WithContext(ctx).Select("id", "tier").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return req, errors.New("用户不存在")
+		}
+		return req, fmt.Errorf("获取用户信息失败: %v", err)
+	}
+
+	return resolveRenderOptions(req, renderTierLimit(s.manimCfg.Tiers, user.Tier))
+}
+
+// renderTimeout 获取用户等级对应的渲染超时，等级未配置时使用全局设置，0表示不限制
+func renderTimeout(cfg config.ManimConfig, tier string) time.Duration {
+	seconds := cfg.Timeout
+	if limit := renderTierLimit(cfg.Tiers, tier); limit.Timeout > 0 {
+		seconds = limit.Timeout
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// RenderTimeout 获取用户视频的渲染超时，获取用户信息失败时使用全局设置
+func (s *VideoService) RenderTimeout(ctx context.Context, userID uint) time.Duration {
+	var user model.User
+	if err := s.db.WithContext(ctx).Select("id", "tier").First(&user, userID).Error; err != nil {
+		return renderTimeout(s.manimCfg, "")
+	}
+	return renderTimeout(s.manimCfg, user.Tier)
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveRenderOptions(t *testing.T) {
+	free := renderTierLimit(nil, model.UserTierFree)
+	pro := renderTierLimit(nil, model.UserTierPro)
+
+	tests := []struct {
+		name    string
+		req     model.RenderOptions
+		limit   config.RenderTierConfig
+		want    model.RenderOptions
+		wantErr string
+	}{
+		{
+			name:  "默认中等质量",
+			limit: free,
+			want:  model.RenderOptions{Quality: "m", Width: 1280, Height: 720, FrameRate: 30, Format: "mp4"},
+		},
+		{
+			name:  "低质量预设",
+			req:   model.RenderOptions{Quality: "l"},
+			limit: free,
+			want:  model.RenderOptions{Quality: "l", Width: 854, Height: 480, FrameRate: 15, Format: "mp4"},
+		},
+		{
+			name:  "自定义分辨率帧率和背景色",
+			req:   model.RenderOptions{Quality: "h", Width: 1080, Height: 1920, FrameRate: 24, BackgroundColor: "#1e1e1e"},
+			limit: pro,
+			want:  model.RenderOptions{Quality: "h", Width: 1080, Height: 1920, FrameRate: 24, BackgroundColor: "#1e1e1e", Format: "mp4"},
+		},
+		{
+			name:  "颜色常量名",
+			req:   model.RenderOptions{BackgroundColor: "DARK_BLUE"},
+			limit: free,
+			want:  model.RenderOptions{Quality: "m", Width: 1280, Height: 720, FrameRate: 30, BackgroundColor: "DARK_BLUE", Format: "mp4"},
+		},
+		{name: "未知质量", req: model.RenderOptions{Quality: "x"}, limit: pro, wantErr: "不支持的渲染质量"},
+		{name: "质量超出等级上限", req: model.RenderOptions{Quality: "k"}, limit: free, wantErr: "最高支持 m 质量"},
+		{name: "只指定宽度", req: model.RenderOptions{Width: 640}, limit: free, wantErr: "同时指定宽度和高度"},
+		{name: "奇数分辨率", req: model.RenderOptions{Width: 641, Height: 480}, limit: free, wantErr: "必须为偶数"},
+		{name: "分辨率过小", req: model.RenderOptions{Width: 8, Height: 8}, limit: free, wantErr: "不能小于"},
+		{name: "分辨率超出等级上限", req: model.RenderOptions{Width: 1920, Height: 1080}, limit: free, wantErr: "1280x720"},
+		{name: "帧率超出等级上限", req: model.RenderOptions{FrameRate: 60}, limit: free, wantErr: "30 帧率"},
+		{name: "负帧率", req: model.RenderOptions{FrameRate: -1}, limit: free, wantErr: "帧率必须为正数"},
+		{name: "无效背景色", req: model.RenderOptions{BackgroundColor: "#12345"}, limit: free, wantErr: "无效的背景色"},
+		{name: "背景色包含命令行参数", req: model.RenderOptions{BackgroundColor: "--flag"}, limit: free, wantErr: "无效的背景色"},
+		{
+			name:  "透明背景WebM",
+			req:   model.RenderOptions{Format: "webm", Transparent: true, SaveLastFrame: true},
+			limit: free,
+			want:  model.RenderOptions{Quality: "m", Width: 1280, Height: 720, FrameRate: 30, Format: "webm", Transparent: true, SaveLastFrame: true},
+		},
+		{name: "GIF不支持透明", req: model.RenderOptions{Format: "gif", Transparent: true}, limit: free, wantErr: "GIF格式不支持透明背景"},
+		{name: "未知输出格式", req: model.RenderOptions{Format: "avi"}, limit: free, wantErr: "不支持的输出格式"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveRenderOptions(tt.req, tt.limit)
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRenderTierLimit(t *testing.T) {
+	tiers := []config.RenderTierConfig{
+		{Tier: "free", MaxQuality: "l", MaxWidth: 854, MaxHeight: 480, MaxFrameRate: 15},
+		{Tier: "team", MaxQuality: "h", MaxWidth: 1920, MaxHeight: 1080, MaxFrameRate: 60},
+	}
+
+	assert.Equal(t, "h", renderTierLimit(tiers, "team").MaxQuality)
+	// 未知等级按免费用户处理
+	assert.Equal(t, "l", renderTierLimit(tiers, "unknown").MaxQuality)
+	// 未配置时使用内置默认值
+	assert.Equal(t, "k", renderTierLimit(nil, model.UserTierPro).MaxQuality)
+}
+
+func TestRenderTimeout(t *testing.T) {
+	cfg := config.ManimConfig{
+		Timeout: 300,
+		Tiers: []config.RenderTierConfig{
+			{Tier: "free", MaxQuality: "m"},
+			{Tier: "pro", MaxQuality: "k", Timeout: 1800},
+		},
+	}
+
+	assert.Equal(t, 300*time.Second, renderTimeout(cfg, "free"))
+	// 等级单独设置的超时优先
+	assert.Equal(t, 1800*time.Second, renderTimeout(cfg, "pro"))
+	assert.Equal(t, 300*time.Second, renderTimeout(cfg, "unknown"))
+	assert.Zero(t, renderTimeout(config.ManimConfig{}, "free"))
+}
+
+func TestManimRenderArgs(t *testing.T) {
+	opts := model.RenderOptions{Quality: "h", Width: 1920, Height: 1080, FrameRate: 60}
+	assert.Equal(t, []string{"-qh", "-r", "1920,1080", "--fps", "60"}, manimRenderArgs(opts))
+
+	opts.BackgroundColor = "#ffffff"
+	assert.Equal(t, []string{"-qh", "-r", "1920,1080", "--fps", "60", "-c", "#ffffff"}, manimRenderArgs(opts))
+
+	assert.Equal(t, filepath.Join("media", "videos", "animation", "1080p60", "Demo.mp4"),
+		expectedArtifactPath("media", filepath.Join("temp", "animation.py"), "Demo", opts))
+
+	opts.BackgroundColor = ""
+	opts.Format = "webm"
+	opts.Transparent = true
+	assert.Equal(t, []string{"-qh", "-r", "1920,1080", "--fps", "60", "--format", "webm", "-t"}, manimRenderArgs(opts))
+	assert.Equal(t, filepath.Join("media", "videos", "animation", "1080p60", "Demo.webm"),
+		expectedArtifactPath("media", filepath.Join("temp", "animation.py"), "Demo", opts))
+}
+
+func TestRenderPasses(t *testing.T) {
+	opts := model.RenderOptions{Quality: "l", Width: 854, Height: 480, FrameRate: 15, Format: "gif"}
+	passes := renderPasses(opts)
+	assert.Len(t, passes, 1)
+	assert.Equal(t, model.ArtifactKindVideo, passes[0].kind)
+
+	// 保存最后一帧时额外执行一次 -s，且不带 --format
+	opts.SaveLastFrame = true
+	passes = renderPasses(opts)
+	assert.Len(t, passes, 2)
+	assert.Equal(t, model.ArtifactKindImage, passes[1].kind)
+	assert.Equal(t, []string{"-ql", "-r", "854,480", "--fps", "15", "-s"}, passes[1].args)
+}
+
+func TestRenderOptions_MovieFormat(t *testing.T) {
+	tests := []struct {
+		opts model.RenderOptions
+		want string
+	}{
+		{opts: model.RenderOptions{}, want: "mp4"},
+		{opts: model.RenderOptions{Format: "gif"}, want: "gif"},
+		{opts: model.RenderOptions{Format: "webm", Transparent: true}, want: "webm"},
+		// Manim透明渲染时会把mp4改为mov
+		{opts: model.RenderOptions{Format: "mp4", Transparent: true}, want: "mov"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.opts.MovieFormat())
+	}
+}
+
+func TestVideoService_ResolveRenderOptions(t *testing.T) {
+	db := setupTestDBWithVideo()
+	videoSvc := newTestVideoService(t, db, config.ManimConfig{}, nil)
+	ctx := context.Background()
+
+	freeUser := &model.User{Username: "free", Email: "free@example.com", Password: "x", Tier: model.UserTierFree}
+	proUser := &model.User{Username: "pro", Email: "pro@example.com", Password: "x", Tier: model.UserTierPro}
+	assert.NoError(t, db.Create(freeUser).Error)
+	assert.NoError(t, db.Create(proUser).Error)
+
+	_, err := videoSvc.ResolveRenderOptions(ctx, freeUser.ID, model.RenderOptions{Quality: "k"})
+	assert.Error(t, err)
+
+	opts, err := videoSvc.ResolveRenderOptions(ctx, proUser.ID, model.RenderOptions{Quality: "k"})
+	assert.NoError(t, err)
+	assert.Equal(t, "2160p60", opts.OutputFolder())
+
+	_, err = videoSvc.ResolveRenderOptions(ctx, 999, model.RenderOptions{})
+	assert.Error(t, err)
+
+	// 渲染参数保存在视频记录上
+	video, err := videoSvc.CreateVideoWithOptions(ctx, proUser.ID, "画一个圆", opts)
+	assert.NoError(t, err)
+	saved, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, opts, saved.RenderOptions)
+}
@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// retryCheckInterval 检查到达重试时间的视频的间隔
+	retryCheckInterval = 5 * time.Second
+	// renderTimeoutMessage 渲染超过截止时间被终止时错误信息中的固定文字
+	renderTimeoutMessage = "渲染超时"
+	// maxLogExcerptBytes 失败记录中保留的渲染输出长度
+	maxLogExcerptBytes = 4096
+)
+
+// failureClasses 各失败原因的类别，未列出的原因按永久失败处理
+var failureClasses = map[string]string{
+	model.FailureReasonTimeout:           model.FailureClassTransient,
+	model.FailureReasonOOMKilled:         model.FailureClassTransient,
+	model.FailureReasonDiskFull:          model.FailureClassTransient,
+	model.FailureReasonLockContention:    model.FailureClassTransient,
+	model.FailureReasonStorage:           model.FailureClassTransient,
+	model.FailureReasonWorkerLost:        model.FailureClassTransient,
+	model.FailureReasonSandboxCPU:        model.FailureClassTransient,
+	model.FailureReasonSandboxMemory:     model.FailureClassTransient,
+	model.FailureReasonSandboxProcesses:  model.FailureClassTransient,
+	model.FailureReasonConcat:            model.FailureClassTransient,
+	model.FailureReasonPythonSyntax:      model.FailureClassPermanent,
+	model.FailureReasonPythonName:        model.FailureClassPermanent,
+	model.FailureReasonPythonError:       model.FailureClassPermanent,
+	model.FailureReasonLatex:             model.FailureClassPermanent,
+	model.FailureReasonSandboxFileSize:   model.FailureClassPermanent,
+	model.FailureReasonSandboxNetwork:    model.FailureClassPermanent,
+	model.FailureReasonSandboxFilesystem: model.FailureClassPermanent,
+	model.FailureReasonInvalidOutput:     model.FailureClassPermanent,
+	model.FailureReasonInvalidScene:      model.FailureClassPermanent,
+}
+
+// failureOutputReason 根据错误信息和渲染输出判断失败原因，不区分大小写。
+// 代码错误排在前面，其输出中可能同时出现其他关键字；
+// 超时只匹配进程被截止时间终止时的输出，用户代码自己打印的"timeout"不算。
+// 没有匹配到已知原因但包含Python调用栈时视为用户代码抛出的异常
+func failureOutputReason(output string) string {
+	patterns := []struct {
+		reason   string
+		keywords []string
+	}{
+		{model.FailureReasonPythonSyntax, []string{"SyntaxError", "IndentationError", "TabError"}},
+		{model.FailureReasonPythonName, []string{"NameError"}},
+		{model.FailureReasonLatex, []string{"LaTeX Error", "latex error converting", "LaTeX compilation error", "Undefined control sequence"}},
+		{model.FailureReasonLockContention, []string{"database is locked", "Lock wait timeout exceeded", "Deadlock found", ErrRenderInProgress.Error()}},
+		{model.FailureReasonTimeout, []string{"context deadline exceeded", renderTimeoutMessage}},
+		{model.FailureReasonOOMKilled, []string{"signal: killed", "exit status 137", "Out of memory", "OOMKilled"}},
+		{model.FailureReasonDiskFull, []string{"No space left on device", "Disk quota exceeded"}},
+	}
+
+	lower := strings.ToLower(output)
+	for _, p := range patterns {
+		for _, keyword := range p.keywords {
+			if strings.Contains(lower, strings.ToLower(keyword)) {
+				return p.reason
+			}
+		}
+	}
+	if strings.Contains(output, "Traceback (most recent call last)") {
+		return model.FailureReasonPythonError
+	}
+	return ""
+}
+
+// classifyFailure 确定失败原因和类别，渲染器已给出原因时优先使用。
+// 无法识别的失败按永久失败处理，避免代码错误耗尽全部重试次数
+func classifyFailure(reason, output string) (class, resolved string) {
+	if reason == "" {
+		reason = failureOutputReason(output)
+	}
+	if class, ok := failureClasses[reason]; ok {
+		return class, reason
+	}
+	return model.FailureClassPermanent, reason
+}
+
+// retryBackoff 第attempt次渲染失败后到下次重试的等待时间，按指数增长并受上限限制
+func retryBackoff(cfg config.RetryConfig, attempt int) time.Duration {
+	delay := time.Duration(cfg.BaseDelaySeconds) * time.Second
+	if delay <= 0 {
+		delay = 10 * time.Second
+	}
+	maxDelay := time.Duration(cfg.MaxDelaySeconds) * time.Second
+	if maxDelay <= 0 {
+		maxDelay = 300 * time.Second
+	}
+
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
+
+// manimCodeHash Manim代码的SHA-256，用于判断失败任务是否使用了相同的代码
+func manimCodeHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// logExcerpt 保留输出的最后一段，不截断多字节字符
+func logExcerpt(output string) string {
+	if len(output) <= maxLogExcerptBytes {
+		return output
+	}
+	start := len(output) - maxLogExcerptBytes
+	for start < len(output) && !utf8.RuneStart(output[start]) {
+		start++
+	}
+	return output[start:]
+}
+
+// BeginRenderAttempt 将视频标记为处理中并增加渲染次数，清除上次失败的信息。
+// 视频已被取消时返回ErrRenderCancelled
+func (s *VideoService) BeginRenderAttempt(ctx context.Context, id uint, manimCode string) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	updates := map[string]interface{}{
+		"status":          model.VideoStatusProcessing,
+		"attempts":        gorm.Expr("attempts + 1"),
+		"next_attempt_at": nil,
+		"failure_reason":  "",
+		"error_msg":       "",
+		"updated_at":      time.Now(),
+	}
+	if manimCode != "" {
+		updates["manim_code"] = manimCode
+	}
+	// 已取消的视频不再渲染，避免取消与领取任务同时发生时状态被覆盖
+	result := s.db.WithContext(timeoutCtx).Model(&model.Video{}).
+		Where("id = ? AND status <> ?", id, model.VideoStatusCancelled).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRenderCancelled
+	}
+
+	s.events.PublishStatus(ctx, id, model.VideoStatusProcessing.String(), "")
+	return nil
+}
+
+// RecordRenderFailure 记录一次没有渲染输出的失败，见RecordRenderFailureWithLogs
+func (s *VideoService) RecordRenderFailure(ctx context.Context, id uint, reason, manimCode, errorMsg string) error {
+	return s.RecordRenderFailureWithLogs(ctx, id, reason, manimCode, errorMsg, "")
+}
+
+// RecordRenderFailureWithLogs 记录一次渲染失败，logs为渲染器的输出，用于判断失败原因和保存日志摘录。
+// 临时失败在次数未用尽时按退避时间安排重试，用尽后标记失败并移入死信队列；永久失败直接标记失败。
+// 渲染被取消（进程退出或失去租约）时不记录，任务会被重新投递；视频已被用户取消时返回ErrRenderCancelled
+func (s *VideoService) RecordRenderFailureWithLogs(ctx context.Context, id uint, reason, manimCode, errorMsg, logs string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var video model.Video
+	if err := s.db.WithContext(timeoutCtx).Select("id", "user_id", "manim_code", "attempts").First(&video, id).Error; err != nil {
+		return err
+	}
+	if manimCode == "" {
+		manimCode = video.ManimCode
+	}
+	attempt := max(video.Attempts, 1)
+	class, reason := classifyFailure(reason, errorMsg+"\n"+logs)
+	codeHash := manimCodeHash(manimCode)
+
+	updates := map[string]interface{}{
+		"failure_reason": reason,
+		"error_msg":      errorMsg,
+		"updated_at":     time.Now(),
+	}
+	if manimCode != "" {
+		updates["manim_code"] = manimCode
+	}
+
+	retry := class == model.FailureClassTransient && attempt < s.manimCfg.Retry.MaxAttempts
+	var delay time.Duration
+	if retry {
+		delay = retryBackoff(s.manimCfg.Retry, attempt)
+		updates["status"] = model.VideoStatusQueued
+		updates["next_attempt_at"] = time.Now().Add(delay)
+	} else {
+		updates["status"] = model.VideoStatusFailed
+		updates["next_attempt_at"] = nil
+	}
+
+	err := s.db.WithContext(timeoutCtx).Transaction(func(tx *gorm.DB) error {
+		// 视频已被取消时不记录失败，也不安排重试
+		result := tx.Model(&model.Video{}).Where("id = ? AND status <> ?", id, model.VideoStatusCancelled).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRenderCancelled
+		}
+		if err := tx.Create(&model.RenderAttempt{
+			VideoID:       id,
+			Attempt:       attempt,
+			FailureClass:  class,
+			FailureReason: reason,
+			ErrorMsg:      errorMsg,
+			LogExcerpt:    logExcerpt(logs),
+			CodeHash:      codeHash,
+		}).Error; err != nil {
+			return fmt.Errorf("保存渲染记录失败: %v", err)
+		}
+		if retry || class != model.FailureClassTransient {
+			return nil
+		}
+
+		// 重试次数用尽，移入死信队列
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "video_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"attempts", "failure_reason", "error_msg", "code_hash", "updated_at"}),
+		}).Create(&model.DeadLetterJob{
+			VideoID:       id,
+			UserID:        video.UserID,
+			Attempts:      attempt,
+			FailureReason: reason,
+			ErrorMsg:      errorMsg,
+			CodeHash:      codeHash,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if retry {
+		log.Printf("视频 %d 第%d次渲染失败（%s），%s后重试", id, attempt, reason, delay)
+		s.events.PublishStatus(ctx, id, model.VideoStatusQueued.String(), fmt.Sprintf("第%d次渲染失败，%s后重试: %s", attempt, delay, errorMsg))
+		return nil
+	}
+	if class == model.FailureClassTransient {
+		log.Printf("视频 %d 渲染失败%d次，已移入死信队列", id, attempt)
+	}
+	s.events.PublishStatus(ctx, id, model.VideoStatusFailed.String(), errorMsg)
+	return nil
+}
+
+// retryScheduler 定期将到达重试时间的视频重新加入队列
+func (s *VideoQueueService) retryScheduler(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(retryCheckInterval)
+	defer ticker.Stop()
+
+	for s.running() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.enqueueDueRetries(ctx); err != nil {
+				log.Printf("重试任务入队失败: %v", err)
+			} else if n > 0 {
+				log.Printf("已将 %d 个重试任务加入队列", n)
+			}
+		}
+	}
+}
+
+// enqueueDueRetries 将到达重试时间的视频加入队列，返回入队的数量。
+// 多个实例同时执行时由队列去重
+func (s *VideoQueueService) enqueueDueRetries(ctx context.Context) (int, error) {
+	var videos []model.Video
+	err := s.db.WithContext(ctx).Select("id").
+		Where("status = ? AND next_attempt_at IS NOT NULL AND next_attempt_at <= ?", model.VideoStatusQueued, time.Now()).
+		Order("next_attempt_at").
+		Limit(100).
+		Find(&videos).Error
+	if err != nil {
+		return 0, err
+	}
+
+	enqueued := 0
+	for _, video := range videos {
+		err := s.AddToQueue(ctx, video.ID)
+		if err != nil && !errors.Is(err, ErrJobQueued) && !errors.Is(err, ErrRenderInProgress) {
+			log.Printf("视频 %d 重试入队失败: %v", video.ID, err)
+			continue
+		}
+		if err == nil {
+			enqueued++
+		}
+		s.db.WithContext(ctx).Model(&model.Video{}).
+			Where("id = ? AND status = ?", video.ID, model.VideoStatusQueued).
+			Update("next_attempt_at", nil)
+	}
+	return enqueued, nil
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		reason     string
+		output     string
+		wantClass  string
+		wantReason string
+	}{
+		{name: "语法错误", output: "Manim执行失败: exit status 1, 输出: SyntaxError: invalid syntax", wantClass: model.FailureClassPermanent, wantReason: model.FailureReasonPythonSyntax},
+		{name: "名称错误", output: "NameError: name 'Circel' is not defined", wantClass: model.FailureClassPermanent, wantReason: model.FailureReasonPythonName},
+		{name: "LaTeX错误", output: "ValueError: latex error converting to dvi", wantClass: model.FailureClassPermanent, wantReason: model.FailureReasonLatex},
+		{name: "超时", output: "Manim执行失败: context deadline exceeded", wantClass: model.FailureClassTransient, wantReason: model.FailureReasonTimeout},
+		{name: "内存不足被杀", output: "Manim执行失败: signal: killed", wantClass: model.FailureClassTransient, wantReason: model.FailureReasonOOMKilled},
+		{name: "磁盘已满", output: "write media/out.mp4: no space left on device", wantClass: model.FailureClassTransient, wantReason: model.FailureReasonDiskFull},
+		{name: "锁冲突", output: "Error 1205: Lock wait timeout exceeded", wantClass: model.FailureClassTransient, wantReason: model.FailureReasonLockContention},
+		{name: "渲染器给出的原因优先", reason: model.FailureReasonSandboxNetwork, output: "timeout", wantClass: model.FailureClassPermanent, wantReason: model.FailureReasonSandboxNetwork},
+		{name: "其他Python异常", output: "Traceback (most recent call last):\n  File \"scene.py\", line 5\nTypeError: unsupported operand", wantClass: model.FailureClassPermanent, wantReason: model.FailureReasonPythonError},
+		{name: "用户代码输出timeout不算超时", output: "Traceback (most recent call last):\nValueError: timeout must be positive", wantClass: model.FailureClassPermanent, wantReason: model.FailureReasonPythonError},
+		{name: "未知错误按永久失败处理", output: "ffmpeg崩溃", wantClass: model.FailureClassPermanent, wantReason: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, reason := classifyFailure(tt.reason, tt.output)
+			assert.Equal(t, tt.wantClass, class)
+			assert.Equal(t, tt.wantReason, reason)
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	cfg := config.RetryConfig{BaseDelaySeconds: 10, MaxDelaySeconds: 60}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 10 * time.Second},
+		{attempt: 2, want: 20 * time.Second},
+		{attempt: 3, want: 40 * time.Second},
+		{attempt: 4, want: 60 * time.Second},
+		{attempt: 100, want: 60 * time.Second},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, retryBackoff(cfg, tt.attempt))
+	}
+	assert.Equal(t, 10*time.Second, retryBackoff(config.RetryConfig{}, 1))
+}
+
+func TestLogExcerpt(t *testing.T) {
+	assert.Equal(t, "short", logExcerpt("short"))
+
+	// 截断位置落在多字节字符中间时跳过不完整的字符
+	long := "a" + strings.Repeat("圆", maxLogExcerptBytes)
+	excerpt := logExcerpt(long)
+	assert.LessOrEqual(t, len(excerpt), maxLogExcerptBytes)
+	assert.True(t, strings.HasPrefix(excerpt, "圆"))
+}
+
+// setupTestRetryService 创建使用进程内队列、最多渲染两次的测试环境
+func setupTestRetryService(t *testing.T) *VideoService {
+	db := setupTestDBWithVideo()
+	manimCfg := config.ManimConfig{
+		Queue: config.QueueConfig{Backend: QueueBackendMemory},
+		Retry: config.RetryConfig{MaxAttempts: 2, BaseDelaySeconds: 1, MaxDelaySeconds: 10},
+	}
+	return newTestVideoService(t, db, manimCfg, nil)
+}
+
+func TestVideoService_RecordRenderFailure_RetryThenDeadLetter(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+
+	// 第一次临时失败，安排重试
+	assert.NoError(t, videoSvc.BeginRenderAttempt(ctx, video.ID, testManimCode))
+	assert.NoError(t, videoSvc.RecordRenderFailureWithLogs(ctx, video.ID, "", "", "Manim执行失败: exit status 1", "Animation 0: Create(Circle)\nsignal: killed"))
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusQueued, updated.Status)
+	assert.Equal(t, 1, updated.Attempts)
+	assert.Equal(t, model.FailureReasonOOMKilled, updated.FailureReason)
+	if assert.NotNil(t, updated.NextAttemptAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Second), *updated.NextAttemptAt, time.Second)
+	}
+
+	// 未到重试时间不入队，到期后入队
+	queueSvc := videoSvc.queueSvc
+	n, err := queueSvc.enqueueDueRetries(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	videoSvc.db.Model(&model.Video{}).Where("id = ?", video.ID).Update("next_attempt_at", time.Now().Add(-time.Second))
+	n, err = queueSvc.enqueueDueRetries(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	job, err := queueSvc.queue.Claim(ctx, "worker-a", 0)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, job) {
+		return
+	}
+	assert.Equal(t, video.ID, job.VideoID)
+
+	// 第二次失败后次数用尽，移入死信队列
+	assert.NoError(t, videoSvc.BeginRenderAttempt(ctx, video.ID, ""))
+	assert.NoError(t, videoSvc.RecordRenderFailure(ctx, video.ID, "", "", "Manim执行失败: signal: killed"))
+	assert.NoError(t, queueSvc.queue.Ack(ctx, job))
+
+	updated, err = videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusFailed, updated.Status)
+	assert.Equal(t, 2, updated.Attempts)
+	assert.Nil(t, updated.NextAttemptAt)
+
+	var attempts []model.RenderAttempt
+	assert.NoError(t, videoSvc.db.Where("video_id = ?", video.ID).Order("attempt").Find(&attempts).Error)
+	if assert.Len(t, attempts, 2) {
+		assert.Equal(t, model.FailureReasonOOMKilled, attempts[0].FailureReason)
+		assert.Contains(t, attempts[0].LogExcerpt, "Animation 0: Create(Circle)")
+		assert.Equal(t, 2, attempts[1].Attempt)
+		assert.Equal(t, model.FailureClassTransient, attempts[1].FailureClass)
+		assert.Equal(t, manimCodeHash(testManimCode), attempts[1].CodeHash)
+	}
+
+	deadLetters, err := videoSvc.ListDeadLetters(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, video.ID, deadLetters[0].VideoID)
+		assert.Equal(t, 2, deadLetters[0].Attempts)
+	}
+
+	// 重新入队后渲染次数重新计算
+	assert.NoError(t, videoSvc.ReplayDeadLetter(ctx, video.ID))
+	assert.ErrorIs(t, videoSvc.ReplayDeadLetter(ctx, video.ID), ErrDeadLetterNotFound)
+	deadLetters, err = videoSvc.ListDeadLetters(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+
+	updated, err = videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusQueued, updated.Status)
+	assert.Equal(t, 0, updated.Attempts)
+	waiting, err := queueSvc.queue.Waiting(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{video.ID}, waiting)
+}
+
+func TestVideoService_RecordRenderFailure_Permanent(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, videoSvc.BeginRenderAttempt(ctx, video.ID, testManimCode))
+	assert.NoError(t, videoSvc.RecordRenderFailure(ctx, video.ID, "", "", "NameError: name 'Circel' is not defined"))
+
+	// 代码错误不重试，也不进入死信队列
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusFailed, updated.Status)
+	assert.Equal(t, model.FailureReasonPythonName, updated.FailureReason)
+	assert.Nil(t, updated.NextAttemptAt)
+
+	deadLetters, err := videoSvc.ListDeadLetters(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
+
+func TestVideoService_RecordRenderFailure_VideoCancelled(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, videoSvc.BeginRenderAttempt(ctx, video.ID, testManimCode))
+
+	// 渲染失败前视频已被取消，不安排重试也不记录失败
+	assert.NoError(t, videoSvc.CancelVideo(ctx, video.ID))
+	assert.ErrorIs(t, videoSvc.RecordRenderFailure(ctx, video.ID, model.FailureReasonTimeout, "", "渲染超时"), ErrRenderCancelled)
+
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusCancelled, updated.Status)
+	assert.Nil(t, updated.NextAttemptAt)
+	var count int64
+	videoSvc.db.Model(&model.RenderAttempt{}).Where("video_id = ?", video.ID).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestVideoService_RecordRenderFailure_Cancelled(t *testing.T) {
+	videoSvc := setupTestRetryService(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, videoSvc.BeginRenderAttempt(ctx, video.ID, testManimCode))
+
+	// 渲染被取消时不记录失败，任务会被重新投递
+	cancel()
+	assert.Error(t, videoSvc.RecordRenderFailure(ctx, video.ID, "", "", "context canceled"))
+	updated, err := videoSvc.GetVideoByID(context.Background(), video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusProcessing, updated.Status)
+}
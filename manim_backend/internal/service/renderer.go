@@ -0,0 +1,351 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+)
+
+// 渲染器类型
+const (
+	RendererLocal   = "local"
+	RendererDocker  = "docker"
+	RendererSandbox = "sandbox"
+	RendererFake    = "fake"
+)
+
+// RenderJob 渲染任务
+type RenderJob struct {
+	VideoID   uint
+	Code      string
+	CodeFile  string   // 工作目录中的Manim脚本
+	WorkDir   string   // 本次渲染的临时工作目录
+	OutputDir string   // Manim媒体输出目录
+	Scenes    []string // 要渲染的场景类名，为空时渲染代码中的第一个场景
+	Options   model.RenderOptions
+
+	// OnOutput 每读取到一行Manim输出时调用，可能被并发调用
+	OnOutput func(line string)
+}
+
+// RenderArtifact 渲染产物
+type RenderArtifact struct {
+	Kind   string // model.ArtifactKindVideo / model.ArtifactKindImage
+	Scene  string
+	Format string
+	Path   string
+}
+
+// RenderResult 渲染结果，ArtifactPath为主视频文件，Artifacts包含全部产物
+type RenderResult struct {
+	ArtifactPath string
+	Artifacts    []RenderArtifact
+	Logs         string
+}
+
+// Renderer 渲染后端，负责执行Manim并返回生成的视频文件
+type Renderer interface {
+	Render(ctx context.Context, job RenderJob) (*RenderResult, error)
+}
+
+// RenderError 带有失败原因的渲染错误
+type RenderError struct {
+	Reason string
+	Err    error
+}
+
+func (e *RenderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// ErrRenderTimeout 渲染超过ManimConfig.Timeout或用户等级的超时设置
+var ErrRenderTimeout = errors.New(renderTimeoutMessage)
+
+// renderTimeoutError 渲染因超时被终止时返回带超时时长和原始错误的ErrRenderTimeout，否则返回nil。
+// ctx已取消（用户取消、进程退出）时不按超时处理
+func renderTimeoutError(ctx, renderCtx context.Context, timeout time.Duration, err error) error {
+	if ctx.Err() != nil || !errors.Is(context.Cause(renderCtx), ErrRenderTimeout) {
+		return nil
+	}
+	return fmt.Errorf("%w（超过%v），已终止渲染进程: %v", ErrRenderTimeout, timeout, err)
+}
+
+// renderFailureReason 获取渲染错误的失败原因，未分类时返回空字符串
+func renderFailureReason(err error) string {
+	var renderErr *RenderError
+	if errors.As(err, &renderErr) {
+		return renderErr.Reason
+	}
+	return ""
+}
+
+// NewRenderer 根据配置创建渲染器
+func NewRenderer(cfg config.ManimConfig) (Renderer, error) {
+	switch cfg.Renderer {
+	case "", RendererLocal:
+		return NewLocalRenderer(cfg.PythonPath), nil
+	case RendererDocker:
+		return NewDockerRenderer(cfg.DockerPath, cfg.DockerImage, cfg.Sandbox), nil
+	case RendererSandbox:
+		return NewSandboxRenderer(cfg.PythonPath, cfg.Sandbox), nil
+	case RendererFake:
+		return NewFakeRenderer(), nil
+	default:
+		return nil, fmt.Errorf("未知的渲染器类型: %s", cfg.Renderer)
+	}
+}
+
+// commandRenderer 基于外部命令的渲染器的公共逻辑
+type commandRenderer struct{}
+
+// mediaDirFor Manim进程以工作目录为当前目录运行，相对的媒体目录以工作目录为基准
+func mediaDirFor(job RenderJob) string {
+	if filepath.IsAbs(job.OutputDir) {
+		return job.OutputDir
+	}
+	return filepath.Join(job.WorkDir, job.OutputDir)
+}
+
+// renderAll 依次执行各次Manim调用并收集每个场景的产物。
+// 每个场景单独调用一次Manim并通过 --output_file 指定输出文件名，
+// 媒体目录位于本次任务独立的工作目录中，产物路径完全由参数决定，不需要搜索文件系统。
+// build根据Manim参数和场景类名构造具体的命令，场景类名需放在脚本文件之后
+func (r *commandRenderer) renderAll(job RenderJob, mediaDir string, build func(manimArgs, scenes []string) (*exec.Cmd, error)) (*RenderResult, error) {
+	result := &RenderResult{}
+	var logs strings.Builder
+
+	scenes := job.Scenes
+	if len(scenes) == 0 {
+		className := r.extractClassNameFromCode(job.CodeFile)
+		if className == "" {
+			return result, fmt.Errorf("无法从代码中识别场景类名")
+		}
+		scenes = []string{className}
+	}
+
+	for _, pass := range renderPasses(job.Options) {
+		for _, scene := range scenes {
+			args := append(append([]string{}, pass.args...), "--output_file", scene)
+			cmd, err := build(args, []string{scene})
+			if err != nil {
+				return result, err
+			}
+
+			output, err := r.run(cmd, job)
+			logs.WriteString(output)
+			result.Logs = logs.String()
+			if err != nil {
+				return result, err
+			}
+
+			artifact := RenderArtifact{Kind: pass.kind, Scene: scene}
+			if pass.kind == model.ArtifactKindImage {
+				artifact.Format = model.OutputFormatPNG
+				artifact.Path, err = findLastFrameImage(job.CodeFile, mediaDir, scene)
+			} else {
+				artifact.Format = job.Options.MovieFormat()
+				artifact.Path = expectedArtifactPath(mediaDir, job.CodeFile, scene, job.Options)
+				err = checkArtifactFile(artifact.Path)
+			}
+			if err != nil {
+				return result, &RenderError{Reason: model.FailureReasonInvalidOutput, Err: err}
+			}
+			result.Artifacts = append(result.Artifacts, artifact)
+		}
+	}
+
+	if len(result.Artifacts) == 0 {
+		return result, &RenderError{Reason: model.FailureReasonInvalidOutput, Err: fmt.Errorf("Manim未生成任何输出文件")}
+	}
+	result.ArtifactPath = result.Artifacts[0].Path
+	return result, nil
+}
+
+// checkArtifactFile Manim进程退出后输出文件应已完整写入
+func checkArtifactFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Manim未生成预期的输出文件 %s: %v", path, err)
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("Manim输出文件为空: %s", path)
+	}
+	return nil
+}
+
+// findLastFrameImage 查找 -s 保存的最后一帧图片，指定 --output_file 时为
+// {media_dir}/images/{脚本名}/{场景类名}.png，部分Manim版本会在文件名后追加版本号
+func findLastFrameImage(codeFile, mediaDir, className string) (string, error) {
+	imageDir := filepath.Join(mediaDir, "images", strings.TrimSuffix(filepath.Base(codeFile), ".py"))
+	for _, pattern := range []string{className + ".png", className + "_ManimCE_v*.png"} {
+		matches, _ := filepath.Glob(filepath.Join(imageDir, pattern))
+		if len(matches) > 0 {
+			return matches[len(matches)-1], checkArtifactFile(matches[len(matches)-1])
+		}
+	}
+
+	return "", fmt.Errorf("未找到最后一帧图片: %s", imageDir)
+}
+
+// run 执行渲染命令，实时转发输出并返回完整日志
+func (r *commandRenderer) run(cmd *exec.Cmd, job RenderJob) (string, error) {
+	// 创建管道来捕获实时输出
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("创建标准输出管道失败: %v", err)
+	}
+
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return "", fmt.Errorf("创建标准错误管道失败: %v", err)
+	}
+
+	// 启动命令，取消时终止整个进程组
+	useProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("启动Manim命令失败: %v", err)
+	}
+
+	// 创建缓冲区来收集输出
+	outputBuffer := &syncBuffer{}
+
+	// 必须在读取完管道输出之后再等待命令完成
+	r.monitorOutput(stdoutPipe, stderrPipe, outputBuffer, job.OnOutput)
+	err = cmd.Wait()
+
+	// 检查Manim是否成功执行
+	// Windows系统Manim有时会返回STATUS_CONTROL_C_EXIT (0xc000013a)但实际执行成功
+	// 需要检查输出内容来判断是否真正失败
+	output := outputBuffer.String()
+
+	if err != nil {
+		// 检查是否是Windows特定的控制台退出错误
+		if _, ok := err.(*exec.ExitError); ok {
+			// Windows系统: 0xc000013a = STATUS_CONTROL_C_EXIT
+			// 检查输出中是否包含成功信息
+			if strings.Contains(output, "File ready at") ||
+				strings.Contains(output, "Rendered") ||
+				strings.Contains(output, "Played") {
+				// Manim实际执行成功，只是退出码异常
+				// 继续检查输出文件
+				log.Printf("视频 %d 的Manim进程退出码异常但输出显示渲染成功: %v", job.VideoID, err)
+			} else {
+				// 真正的执行失败
+				return output, fmt.Errorf("Manim执行失败: %w, 输出: %s", err, output)
+			}
+		} else {
+			// 其他类型的错误
+			return output, fmt.Errorf("Manim执行失败: %w, 输出: %s", err, output)
+		}
+	}
+
+	return output, nil
+}
+
+// monitorOutput 读取标准输出和标准错误直到结束
+func (r *commandRenderer) monitorOutput(stdoutPipe, stderrPipe io.Reader, outputBuffer *syncBuffer, onOutput func(line string)) {
+	var wg sync.WaitGroup
+
+	scan := func(pipe io.Reader) {
+		defer wg.Done()
+
+		// tqdm进度条使用\r刷新同一行，需要同时按\r和\n切分
+		scanner := bufio.NewScanner(pipe)
+		scanner.Split(scanProgressLines)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+			outputBuffer.WriteString(line + "\n")
+
+			if onOutput != nil {
+				onOutput(line)
+			}
+		}
+	}
+
+	// 标准输出和标准错误都可能包含进度信息
+	wg.Add(2)
+	go scan(stdoutPipe)
+	go scan(stderrPipe)
+
+	wg.Wait()
+}
+
+// scanProgressLines 按\n或\r切分输出行
+func scanProgressLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	for i, b := range data {
+		if b == '\n' || b == '\r' {
+			return i + 1, data[:i], nil
+		}
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// syncBuffer 并发安全的输出缓冲区
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *syncBuffer) WriteString(str string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.WriteString(str)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// extractClassNameFromCode 从Manim代码中提取第一个场景类名
+func (r *commandRenderer) extractClassNameFromCode(codeFile string) string {
+	codeContent, err := os.ReadFile(codeFile)
+	if err != nil {
+		return ""
+	}
+
+	module, err := parsePythonModule(string(codeContent))
+	if err != nil {
+		return ""
+	}
+	if scenes := detectSceneClasses(module); len(scenes) > 0 {
+		return scenes[0]
+	}
+
+	return ""
+}
+
+// expectedArtifactPath Manim输出视频的确定路径：{media_dir}/videos/{脚本名}/{高度}p{帧率}/{场景类名}.{格式}
+func expectedArtifactPath(mediaDir, codeFile, className string, opts model.RenderOptions) string {
+	fileName := strings.TrimSuffix(filepath.Base(codeFile), ".py")
+	return filepath.Join(mediaDir, "videos", fileName, opts.OutputFolder(), className+"."+opts.MovieFormat())
+}
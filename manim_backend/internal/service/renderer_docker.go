@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"manim-backend/internal/config"
+
+	"github.com/google/uuid"
+)
+
+// DockerRenderer 在Docker容器中执行Manim，工作目录挂载到容器内，
+// 资源限制与沙箱渲染器使用相同的配置
+type DockerRenderer struct {
+	commandRenderer
+	dockerPath string
+	image      string
+	limits     config.SandboxConfig
+}
+
+func NewDockerRenderer(dockerPath, image string, limits config.SandboxConfig) *DockerRenderer {
+	if dockerPath == "" {
+		dockerPath = "docker"
+	}
+	if image == "" {
+		image = "manimcommunity/manim:stable"
+	}
+
+	return &DockerRenderer{
+		dockerPath: dockerPath,
+		image:      image,
+		limits:     limits,
+	}
+}
+
+// limitArgs 将资源限制转换为docker run参数，为0的限制不设置
+func (r *DockerRenderer) limitArgs() []string {
+	var args []string
+	if r.limits.MemoryMB > 0 {
+		// 与内存相同的交换上限表示不允许使用交换空间
+		memory := fmt.Sprintf("%dm", r.limits.MemoryMB)
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+	if r.limits.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(r.limits.CPUs, 'f', -1, 64))
+	}
+	if r.limits.MaxProcesses > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(r.limits.MaxProcesses))
+	}
+	if r.limits.CPUSeconds > 0 {
+		args = append(args, "--ulimit", fmt.Sprintf("cpu=%d:%d", r.limits.CPUSeconds, r.limits.CPUSeconds+5))
+	}
+	if r.limits.FileSizeMB > 0 {
+		size := int64(r.limits.FileSizeMB) << 20
+		args = append(args, "--ulimit", fmt.Sprintf("fsize=%d:%d", size, size))
+	}
+	return args
+}
+
+// Render 在容器中执行Manim渲染命令
+func (r *DockerRenderer) Render(ctx context.Context, job RenderJob) (*RenderResult, error) {
+	absWorkDir, err := filepath.Abs(job.WorkDir)
+	if err != nil {
+		return nil, fmt.Errorf("解析工作目录失败: %v", err)
+	}
+
+	// 容器内的输出写入挂载的工作目录
+	return r.renderAll(job, filepath.Join(job.WorkDir, "media"), func(manimArgs, scenes []string) (*exec.Cmd, error) {
+		containerName := fmt.Sprintf("manim-render-%d-%s", job.VideoID, uuid.New().String()[:8])
+		args := []string{"run", "--rm",
+			"--name", containerName,
+			"--network", "none",
+			"-v", absWorkDir + ":/manim",
+			"-w", "/manim",
+		}
+		args = append(args, r.limitArgs()...)
+		// 以当前用户运行，避免生成的文件属于root
+		if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 && gid >= 0 {
+			args = append(args, "--user", fmt.Sprintf("%d:%d", uid, gid))
+		}
+		args = append(args, r.image, "manim", "render")
+		args = append(args, manimArgs...)
+		args = append(args,
+			"--media_dir", "media",
+			filepath.Base(job.CodeFile))
+		args = append(args, scenes...)
+
+		cmd := exec.CommandContext(ctx, r.dockerPath, args...)
+		// 取消时docker客户端退出并不会停止容器，需要显式删除
+		cmd.Cancel = func() error {
+			exec.Command(r.dockerPath, "rm", "-f", containerName).Run()
+			return cmd.Process.Kill()
+		}
+		return cmd, nil
+	})
+}
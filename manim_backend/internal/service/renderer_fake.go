@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"manim-backend/internal/model"
+)
+
+var fakeSceneClassPattern = regexp.MustCompile(`class\s+(\w+)\s*\(`)
+
+// FakeRenderer 进程内的假渲染器，不依赖Python，用于测试和本地调试
+type FakeRenderer struct {
+	// Err 不为空时渲染失败并返回该错误
+	Err error
+	// Delay 模拟渲染耗时
+	Delay time.Duration
+
+	mu     sync.Mutex
+	calls  int
+	media  map[string]model.MediaInfo // 写入的产物对应的媒体信息
+	latest model.MediaInfo
+}
+
+func NewFakeRenderer() *FakeRenderer {
+	return &FakeRenderer{}
+}
+
+// Render 模拟Manim输出并写入一个假的视频文件
+func (r *FakeRenderer) Render(ctx context.Context, job RenderJob) (*RenderResult, error) {
+	r.mu.Lock()
+	r.calls++
+	r.mu.Unlock()
+
+	scenes := job.Scenes
+	if len(scenes) == 0 {
+		className := "Scene"
+		if m := fakeSceneClassPattern.FindStringSubmatch(job.Code); m != nil {
+			className = m[1]
+		}
+		scenes = []string{className}
+	}
+
+	opts := renderOptionsOrDefault(job.Options)
+	module := strings.TrimSuffix(filepath.Base(job.CodeFile), ".py")
+	var artifacts []RenderArtifact
+	lines := []string{"Manim Community v0.18.0"}
+	for _, className := range scenes {
+		artifactPath := expectedArtifactPath(mediaDirFor(job), job.CodeFile, className, opts)
+		artifacts = append(artifacts, RenderArtifact{Kind: model.ArtifactKindVideo, Scene: className, Format: opts.MovieFormat(), Path: artifactPath})
+		lines = append(lines,
+			"Animation 0: Create(Circle):  50%|#####     | 30/60 [00:00<00:00, 80.00it/s]",
+			"Animation 0 : Partial movie file written in 'partial_movie_files/uncached_00000.mp4'",
+			"Combining to Movie file.",
+			"File ready at '"+artifactPath+"'",
+			"Rendered "+className,
+			"Played 1 animations",
+		)
+	}
+	if opts.SaveLastFrame {
+		for _, className := range scenes {
+			imagePath := filepath.Join(mediaDirFor(job), "images", module, className+".png")
+			artifacts = append(artifacts, RenderArtifact{Kind: model.ArtifactKindImage, Scene: className, Format: model.OutputFormatPNG, Path: imagePath})
+		}
+	}
+
+	var logs strings.Builder
+	for _, line := range lines {
+		logs.WriteString(line + "\n")
+		if job.OnOutput != nil {
+			job.OnOutput(line)
+		}
+	}
+
+	if r.Delay > 0 {
+		select {
+		case <-time.After(r.Delay):
+		case <-ctx.Done():
+			return &RenderResult{Logs: logs.String()}, ctx.Err()
+		}
+	}
+
+	if r.Err != nil {
+		return &RenderResult{Logs: logs.String()}, r.Err
+	}
+
+	for _, artifact := range artifacts {
+		if err := os.MkdirAll(filepath.Dir(artifact.Path), 0755); err != nil {
+			return &RenderResult{Logs: logs.String()}, fmt.Errorf("创建输出目录失败: %v", err)
+		}
+		if err := os.WriteFile(artifact.Path, []byte("fake "+artifact.Scene+" "+artifact.Format+" content"), 0644); err != nil {
+			return &RenderResult{Logs: logs.String()}, fmt.Errorf("写入产物文件失败: %v", err)
+		}
+		r.recordMedia(artifact, opts)
+	}
+
+	return &RenderResult{ArtifactPath: artifacts[0].Path, Artifacts: artifacts, Logs: logs.String()}, nil
+}
+
+// recordMedia 记录产物的媒体信息，供Probe返回
+func (r *FakeRenderer) recordMedia(artifact RenderArtifact, opts model.RenderOptions) {
+	info := model.MediaInfo{Codec: formatCodecs[artifact.Format][0], Width: opts.Width, Height: opts.Height}
+	if artifact.Kind == model.ArtifactKindVideo {
+		info.Duration = 1
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.media == nil {
+		r.media = make(map[string]model.MediaInfo)
+	}
+	r.media[artifact.Path] = info
+	if artifact.Kind == model.ArtifactKindVideo {
+		r.latest = info
+	}
+}
+
+// Probe 返回写入产物时记录的媒体信息，其他文件（如拼接后的视频）按最近一次渲染的视频处理
+func (r *FakeRenderer) Probe(ctx context.Context, path string) (model.MediaInfo, error) {
+	if _, err := os.Stat(path); err != nil {
+		return model.MediaInfo{}, fmt.Errorf("读取媒体信息失败: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, ok := r.media[path]; ok {
+		return info, nil
+	}
+	return r.latest, nil
+}
+
+// Calls 返回Render被调用的次数
+func (r *FakeRenderer) Calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// LocalRenderer 在本机子进程中执行Manim
+type LocalRenderer struct {
+	commandRenderer
+	pythonPath string
+}
+
+func NewLocalRenderer(pythonPath string) *LocalRenderer {
+	return &LocalRenderer{pythonPath: pythonPath}
+}
+
+// Render 执行Manim渲染命令
+func (r *LocalRenderer) Render(ctx context.Context, job RenderJob) (*RenderResult, error) {
+	// 根据Manim文档和测试，正确的命令格式是：manim render [OPTIONS] FILE [SCENE_NAMES]
+	// 质量、分辨率、帧率和背景色由任务的渲染参数决定，--media_dir 指定媒体目录
+	// 注意：codeFile需要使用绝对路径，因为cmd.Dir设置为工作目录
+	absCodeFile, _ := filepath.Abs(job.CodeFile)
+
+	// 使用实时输出监控来获取进度信息，相对的媒体目录以工作目录为基准
+	return r.renderAll(job, mediaDirFor(job), func(manimArgs, scenes []string) (*exec.Cmd, error) {
+		args := []string{"-m", "manim", "render"}
+		args = append(args, manimArgs...)
+		args = append(args,
+			"--media_dir", job.OutputDir, // 媒体目录
+			absCodeFile) // Python脚本文件（绝对路径）
+		args = append(args, scenes...) // 要渲染的场景类名
+		cmd := exec.CommandContext(ctx, r.pythonPath, args...)
+		cmd.Dir = job.WorkDir
+
+		// 设置环境变量，确保Manim能找到依赖
+		cmd.Env = append(os.Environ(),
+			"PYTHONPATH=", // 清空PYTHONPATH避免冲突
+		)
+		return cmd, nil
+	})
+}
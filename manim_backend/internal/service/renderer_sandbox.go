@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+)
+
+// SandboxRenderer 在受限环境中执行用户提交的Manim代码：
+// 独立的用户和网络命名空间、只读根文件系统（仅工作目录可写）、
+// 精简的环境变量、CPU时间、地址空间和文件大小限制，以及基于cgroup的进程数限制
+type SandboxRenderer struct {
+	commandRenderer
+	pythonPath string
+	cfg        config.SandboxConfig
+}
+
+func NewSandboxRenderer(pythonPath string, cfg config.SandboxConfig) *SandboxRenderer {
+	if cfg.BwrapPath == "" {
+		cfg.BwrapPath = "bwrap"
+	}
+	if cfg.PrlimitPath == "" {
+		cfg.PrlimitPath = "prlimit"
+	}
+
+	return &SandboxRenderer{
+		pythonPath: pythonPath,
+		cfg:        cfg,
+	}
+}
+
+// Render 在沙箱中执行Manim渲染命令
+func (r *SandboxRenderer) Render(ctx context.Context, job RenderJob) (*RenderResult, error) {
+	absWorkDir, err := filepath.Abs(job.WorkDir)
+	if err != nil {
+		return nil, fmt.Errorf("解析工作目录失败: %v", err)
+	}
+	absCodeFile, _ := filepath.Abs(job.CodeFile)
+
+	// 同一任务的各次Manim调用依次执行，共用一个cgroup
+	cgroup, release, err := r.processCgroup(job.VideoID)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	result, err := r.renderAll(job, mediaDirFor(job), func(manimArgs, scenes []string) (*exec.Cmd, error) {
+		args := []string{r.pythonPath, "-m", "manim", "render"}
+		args = append(args, manimArgs...)
+		args = append(args, "--media_dir", job.OutputDir, absCodeFile)
+		args = append(args, scenes...)
+		return r.command(ctx, absWorkDir, cgroup, args)
+	})
+	if err != nil {
+		// 优先根据退出信号判断，其次根据输出判断违规类型
+		reason := sandboxSignalReason(err)
+		if reason == "" {
+			reason = sandboxOutputReason(result.Logs)
+		}
+		if reason != "" {
+			return result, &RenderError{Reason: reason, Err: err}
+		}
+		return result, err
+	}
+
+	return result, nil
+}
+
+// sandboxEnv 构造传给渲染进程的精简环境变量
+func (r *SandboxRenderer) sandboxEnv(workDir string, lookup func(string) (string, bool)) []string {
+	env := []string{
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"HOME=" + workDir,
+		"TMPDIR=" + workDir,
+		"MPLCONFIGDIR=" + workDir,
+		"LANG=C.UTF-8",
+		"PYTHONDONTWRITEBYTECODE=1",
+	}
+
+	for _, name := range r.cfg.PassEnv {
+		if value, ok := lookup(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+
+	return env
+}
+
+// sandboxOutputReason 根据Python的报错输出判断沙箱违规类型
+func sandboxOutputReason(output string) string {
+	patterns := []struct {
+		reason   string
+		keywords []string
+	}{
+		{model.FailureReasonSandboxMemory, []string{"MemoryError", "Cannot allocate memory"}},
+		{model.FailureReasonSandboxFileSize, []string{"File too large"}},
+		{model.FailureReasonSandboxProcesses, []string{"Resource temporarily unavailable", "can't start new thread"}},
+		{model.FailureReasonSandboxNetwork, []string{"Network is unreachable", "Temporary failure in name resolution", "Name or service not known"}},
+		{model.FailureReasonSandboxFilesystem, []string{"Read-only file system"}},
+	}
+
+	for _, p := range patterns {
+		for _, keyword := range p.keywords {
+			if strings.Contains(output, keyword) {
+				return p.reason
+			}
+		}
+	}
+
+	return ""
+}
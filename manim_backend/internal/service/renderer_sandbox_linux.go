@@ -0,0 +1,130 @@
+//go:build linux
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"manim-backend/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// processCgroup 为一次渲染创建cgroup v2子组并设置pids.max，返回子组目录的文件描述符。
+// RLIMIT_NPROC统计的是服务用户的全部进程，繁忙时会误判，因此进程数通过cgroup限制。
+// 未配置进程数上限时返回nil
+func (r *SandboxRenderer) processCgroup(videoID uint) (*os.File, func(), error) {
+	if r.cfg.MaxProcesses <= 0 {
+		return nil, func() {}, nil
+	}
+
+	dir := filepath.Join(r.cfg.CgroupRoot, fmt.Sprintf("video-%d-%s", videoID, uuid.New().String()[:8]))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("创建进程数限制cgroup失败（需要对 %s 委派写权限，或将MaxProcesses设为0）: %v", r.cfg.CgroupRoot, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pids.max"), []byte(strconv.Itoa(r.cfg.MaxProcesses)), 0644); err != nil {
+		os.Remove(dir)
+		return nil, nil, fmt.Errorf("设置pids.max失败: %v", err)
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return nil, nil, fmt.Errorf("打开cgroup目录失败: %v", err)
+	}
+
+	// 渲染进程退出后子组为空，可以直接删除
+	return fd, func() {
+		fd.Close()
+		os.Remove(dir)
+	}, nil
+}
+
+// command 构造沙箱命令：prlimit设置资源限制后执行bwrap，bwrap再执行Manim。
+// cgroup不为空时进程直接在该cgroup中创建，整个进程树受其pids.max限制
+func (r *SandboxRenderer) command(ctx context.Context, workDir string, cgroup *os.File, manimArgs []string) (*exec.Cmd, error) {
+	args := []string{}
+	if r.cfg.CPUSeconds > 0 {
+		// 软限制触发SIGXCPU，硬限制多留5秒后触发SIGKILL
+		args = append(args, fmt.Sprintf("--cpu=%d:%d", r.cfg.CPUSeconds, r.cfg.CPUSeconds+5))
+	}
+	if r.cfg.MemoryMB > 0 {
+		args = append(args, fmt.Sprintf("--as=%d", int64(r.cfg.MemoryMB)<<20))
+	}
+	if r.cfg.FileSizeMB > 0 {
+		args = append(args, fmt.Sprintf("--fsize=%d", int64(r.cfg.FileSizeMB)<<20))
+	}
+
+	args = append(args, "--", r.cfg.BwrapPath,
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--bind", workDir, workDir,
+		"--chdir", workDir,
+		"--unshare-all", // 包含PID命名空间，渲染进程看不到也无法影响宿主的其他进程
+		"--die-with-parent",
+		"--new-session",
+		"--clearenv",
+	)
+	for _, kv := range r.sandboxEnv(workDir, os.LookupEnv) {
+		name, value := splitEnv(kv)
+		args = append(args, "--setenv", name, value)
+	}
+	args = append(args, manimArgs...)
+
+	cmd := exec.CommandContext(ctx, r.cfg.PrlimitPath, args...)
+	cmd.Dir = workDir
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin"}
+	if cgroup != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cgroup.Fd())}
+	}
+	return cmd, nil
+}
+
+// splitEnv 拆分KEY=VALUE格式的环境变量
+func splitEnv(kv string) (string, string) {
+	for i := 0; i < len(kv); i++ {
+		if kv[i] == '=' {
+			return kv[:i], kv[i+1:]
+		}
+	}
+	return kv, ""
+}
+
+// sandboxSignalReason 根据进程退出信号判断沙箱违规类型
+func sandboxSignalReason(err error) string {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return ""
+	}
+
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return ""
+	}
+
+	// bwrap会将子进程的终止信号转换为128+信号值的退出码
+	var signal syscall.Signal
+	switch {
+	case status.Signaled():
+		signal = status.Signal()
+	case status.Exited() && status.ExitStatus() > 128:
+		signal = syscall.Signal(status.ExitStatus() - 128)
+	default:
+		return ""
+	}
+
+	switch signal {
+	case syscall.SIGXCPU:
+		return model.FailureReasonSandboxCPU
+	case syscall.SIGXFSZ:
+		return model.FailureReasonSandboxFileSize
+	}
+	return ""
+}
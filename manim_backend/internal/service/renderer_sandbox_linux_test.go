@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"manim-backend/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxRenderer_ProcessCgroup(t *testing.T) {
+	// 普通目录模拟cgroup v2层级，只检查子组的创建和删除
+	root := t.TempDir()
+	r := NewSandboxRenderer("python", config.SandboxConfig{CgroupRoot: root, MaxProcesses: 16})
+	cgroup, release, err := r.processCgroup(1)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, cgroup) {
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(cgroup.Name(), "pids.max"))
+	assert.NoError(t, err)
+	assert.Equal(t, "16", string(data))
+
+	cmd, err := r.command(context.Background(), "/tmp/work", cgroup, []string{"python"})
+	assert.NoError(t, err)
+	if assert.NotNil(t, cmd.SysProcAttr) {
+		assert.True(t, cmd.SysProcAttr.UseCgroupFD)
+	}
+
+	// 模拟的子组中只有pids.max文件，删除前先移除它
+	os.Remove(filepath.Join(cgroup.Name(), "pids.max"))
+	release()
+	entries, err := os.ReadDir(root)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// 未配置进程数上限时不创建cgroup
+	r = NewSandboxRenderer("python", config.SandboxConfig{CgroupRoot: root})
+	cgroup, release, err = r.processCgroup(1)
+	assert.NoError(t, err)
+	assert.Nil(t, cgroup)
+	release()
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// processCgroup 其他平台没有cgroup
+func (r *SandboxRenderer) processCgroup(videoID uint) (*os.File, func(), error) {
+	return nil, func() {}, nil
+}
+
+// command 沙箱依赖Linux命名空间和资源限制，其他平台不支持
+func (r *SandboxRenderer) command(ctx context.Context, workDir string, cgroup *os.File, manimArgs []string) (*exec.Cmd, error) {
+	return nil, errors.New("沙箱渲染器仅支持Linux系统")
+}
+
+// sandboxSignalReason 其他平台无法根据信号判断违规类型
+func sandboxSignalReason(err error) string {
+	return ""
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"manim-backend/internal/config"
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxOutputReason(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{name: "内存不足", output: "Traceback (most recent call last):\nMemoryError", want: model.FailureReasonSandboxMemory},
+		{name: "文件过大", output: "OSError: [Errno 27] File too large", want: model.FailureReasonSandboxFileSize},
+		{name: "进程数超限", output: "BlockingIOError: [Errno 11] Resource temporarily unavailable", want: model.FailureReasonSandboxProcesses},
+		{name: "访问网络", output: "OSError: [Errno 101] Network is unreachable", want: model.FailureReasonSandboxNetwork},
+		{name: "域名解析失败", output: "socket.gaierror: [Errno -3] Temporary failure in name resolution", want: model.FailureReasonSandboxNetwork},
+		{name: "写入只读目录", output: "OSError: [Errno 30] Read-only file system: '/etc/x'", want: model.FailureReasonSandboxFilesystem},
+		{name: "普通代码错误", output: "NameError: name 'Circle2' is not defined", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sandboxOutputReason(tt.output))
+		})
+	}
+}
+
+func TestSandboxSignalReason(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("仅在Linux上根据信号判断")
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{name: "CPU时间超限", script: "kill -XCPU $$", want: model.FailureReasonSandboxCPU},
+		{name: "文件大小超限", script: "kill -XFSZ $$", want: model.FailureReasonSandboxFileSize},
+		{name: "bwrap转换的退出码", script: "exit 152", want: model.FailureReasonSandboxCPU},
+		{name: "普通退出码", script: "exit 1", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := exec.Command("sh", "-c", tt.script).Run()
+			assert.Error(t, err)
+			assert.Equal(t, tt.want, sandboxSignalReason(err))
+		})
+	}
+}
+
+func TestSandboxRenderer_Command(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("沙箱渲染器仅支持Linux")
+	}
+
+	r := NewSandboxRenderer("python", config.SandboxConfig{
+		CPUSeconds:   60,
+		MemoryMB:     1024,
+		MaxProcesses: 16,
+	})
+	cmd, err := r.command(context.Background(), "/tmp/work", nil, []string{"python", "-m", "manim"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "prlimit", cmd.Args[0])
+	assert.Contains(t, cmd.Args, "--cpu=60:65")
+	assert.Contains(t, cmd.Args, "--as=1073741824")
+	// 进程数由cgroup限制，不使用按用户统计的RLIMIT_NPROC
+	assert.NotContains(t, cmd.Args, "--nproc=16")
+	assert.Nil(t, cmd.SysProcAttr)
+	assert.Contains(t, cmd.Args, "--unshare-all")
+	assert.Contains(t, cmd.Args, "--clearenv")
+	// 未配置的限制不出现在命令中
+	for _, arg := range cmd.Args {
+		assert.NotContains(t, arg, "--fsize")
+	}
+	assert.Equal(t, []string{"python", "-m", "manim"}, cmd.Args[len(cmd.Args)-3:])
+}
+
+func TestDockerRenderer_LimitArgs(t *testing.T) {
+	r := NewDockerRenderer("docker", "", config.SandboxConfig{
+		CPUSeconds:   60,
+		CPUs:         1.5,
+		MemoryMB:     1024,
+		MaxProcesses: 16,
+	})
+	assert.Equal(t, []string{
+		"--memory", "1024m", "--memory-swap", "1024m",
+		"--cpus", "1.5",
+		"--pids-limit", "16",
+		"--ulimit", "cpu=60:65",
+	}, r.limitArgs())
+
+	// 未配置限制时不添加参数
+	assert.Empty(t, NewDockerRenderer("docker", "", config.SandboxConfig{}).limitArgs())
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandRenderer_RenderAll(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("需要sh")
+	}
+
+	workDir := t.TempDir()
+	codeFile := filepath.Join(workDir, "animation.py")
+	assert.NoError(t, os.WriteFile(codeFile, []byte(multiSceneCode), 0644))
+
+	job := RenderJob{
+		CodeFile:  codeFile,
+		WorkDir:   workDir,
+		OutputDir: "media",
+		Scenes:    []string{"Intro", "Outro"},
+		Options:   DefaultRenderOptions(),
+	}
+	mediaDir := mediaDirFor(job)
+
+	// 模拟Manim：每次调用只渲染一个场景，并按 --output_file 写入确定的路径
+	var calls [][]string
+	r := &commandRenderer{}
+	result, err := r.renderAll(job, mediaDir, func(manimArgs, scenes []string) (*exec.Cmd, error) {
+		calls = append(calls, append(append([]string{}, manimArgs...), scenes...))
+		path := expectedArtifactPath(mediaDir, codeFile, scenes[0], job.Options)
+		return exec.Command("sh", "-c", `mkdir -p "$(dirname "$1")" && printf video > "$1"`, "sh", path), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"-qm", "-r", "1280,720", "--fps", "30", "--output_file", "Intro", "Intro"},
+		{"-qm", "-r", "1280,720", "--fps", "30", "--output_file", "Outro", "Outro"},
+	}, calls)
+	if assert.Len(t, result.Artifacts, 2) {
+		assert.Equal(t, "Intro", result.Artifacts[0].Scene)
+		assert.Equal(t, filepath.Join(mediaDir, "videos", "animation", "720p30", "Outro.mp4"), result.Artifacts[1].Path)
+	}
+
+	// 进程成功退出但没有生成输出文件时不再搜索其他位置
+	_, err = r.renderAll(job, filepath.Join(workDir, "empty"), func(manimArgs, scenes []string) (*exec.Cmd, error) {
+		return exec.Command("true"), nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, model.FailureReasonInvalidOutput, renderFailureReason(err))
+}
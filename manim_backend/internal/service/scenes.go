@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// detectSceneClasses 按定义顺序返回代码中的全部场景类，
+// 包括直接继承Manim场景类（Scene、MovingCameraScene、ThreeDScene等）的类，
+// 以及继承自文件中其他场景类的子类
+func detectSceneClasses(module *pyModule) []string {
+	scenes := make(map[string]bool)
+	for changed := true; changed; {
+		changed = false
+		for _, class := range module.Classes {
+			if scenes[class.Name] {
+				continue
+			}
+			if isSceneClass(class) || inheritsLocalScene(class, scenes) {
+				scenes[class.Name] = true
+				changed = true
+			}
+		}
+	}
+
+	var names []string
+	for _, class := range module.Classes {
+		if scenes[class.Name] {
+			names = append(names, class.Name)
+			delete(scenes, class.Name) // 同名类重复定义时只保留一次
+		}
+	}
+	return names
+}
+
+// inheritsLocalScene 判断类是否继承自文件中已识别的场景类
+func inheritsLocalScene(class pyClass, scenes map[string]bool) bool {
+	for _, base := range class.Bases {
+		if scenes[base] {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveScenes 检测代码中的场景类并校验选择的场景，未选择时渲染全部场景。
+// 返回的场景按选择的顺序排列，拼接视频时也按此顺序
+func ResolveScenes(code string, requested []string) ([]string, error) {
+	module, err := parsePythonModule(code)
+	if err != nil {
+		return nil, fmt.Errorf("解析代码失败: %v", err)
+	}
+
+	available := detectSceneClasses(module)
+	if len(available) == 0 {
+		return nil, errors.New("代码中没有找到场景类")
+	}
+	if len(requested) == 0 {
+		return available, nil
+	}
+
+	seen := make(map[string]bool, len(requested))
+	var scenes []string
+	for _, name := range requested {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if !slices.Contains(available, name) {
+			return nil, fmt.Errorf("场景 %s 不存在，可选场景: %s", name, strings.Join(available, ", "))
+		}
+		seen[name] = true
+		scenes = append(scenes, name)
+	}
+	if len(scenes) == 0 {
+		return available, nil
+	}
+	return scenes, nil
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const multiSceneCode = `from manim import *
+
+class Helper:
+    pass
+
+class Intro(Scene):
+    def construct(self):
+        self.play(Write(Text("Hi")))
+
+class Zoom(MovingCameraScene):
+    def construct(self):
+        self.play(self.camera.frame.animate.scale(0.5))
+
+class Surface(ThreeDScene):
+    def construct(self):
+        self.wait()
+
+class Outro(Intro):
+    def construct(self):
+        self.wait()
+`
+
+func TestDetectSceneClasses(t *testing.T) {
+	module, err := parsePythonModule(multiSceneCode)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Intro", "Zoom", "Surface", "Outro"}, detectSceneClasses(module))
+
+	// 子类定义在父类之前时同样能识别
+	module, err = parsePythonModule("class B(A):\n    pass\n\nclass A(manim.Scene):\n    pass\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B", "A"}, detectSceneClasses(module))
+}
+
+func TestResolveScenes(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		requested []string
+		want      []string
+		wantErr   string
+	}{
+		{name: "默认渲染全部场景", code: multiSceneCode, want: []string{"Intro", "Zoom", "Surface", "Outro"}},
+		{name: "按选择顺序渲染部分场景", code: multiSceneCode, requested: []string{"Outro", "Intro", "Outro"}, want: []string{"Outro", "Intro"}},
+		{name: "场景不存在", code: multiSceneCode, requested: []string{"Helper"}, wantErr: "场景 Helper 不存在"},
+		{name: "没有场景类", code: "x = 1\n", wantErr: "没有找到场景类"},
+		{name: "语法错误", code: "class A(Scene:\n", wantErr: "解析代码失败"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveScenes(tt.code, tt.requested)
+			if tt.wantErr != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), tt.wantErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
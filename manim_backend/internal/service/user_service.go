@@ -46,6 +46,7 @@ func (s *UserService) Register(ctx context.Context, username, email, password st
 		Username: username,
 		Email:    email,
 		Password: string(hashedPassword),
+		Tier:     model.UserTierFree,
 	}
 
 	if err := s.db.WithContext(timeoutCtx).Create(user).Error; err != nil {
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"manim-backend/internal/model"
+)
+
+// VideoConcatenator 将多个场景的视频按顺序拼接为一个视频
+type VideoConcatenator interface {
+	Concat(ctx context.Context, inputs []string, output string) error
+}
+
+// ConcatError 拼接失败，Output为ffmpeg的输出，用于判断失败原因和保存日志摘录
+type ConcatError struct {
+	Output string
+	Err    error
+}
+
+func (e *ConcatError) Error() string {
+	return "拼接视频失败: " + e.Err.Error()
+}
+
+func (e *ConcatError) Unwrap() error {
+	return e.Err
+}
+
+// concatOutput 获取拼接错误中ffmpeg的输出
+func concatOutput(err error) string {
+	var concatErr *ConcatError
+	if errors.As(err, &concatErr) {
+		return concatErr.Output
+	}
+	return ""
+}
+
+// FFmpegConcatenator 使用ffmpeg的concat demuxer拼接视频
+type FFmpegConcatenator struct {
+	ffmpegPath string
+}
+
+func NewFFmpegConcatenator(ffmpegPath string) *FFmpegConcatenator {
+	if ffmpegPath == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	return &FFmpegConcatenator{ffmpegPath: ffmpegPath}
+}
+
+// Concat 拼接视频。同一次渲染的场景编码参数一致，除GIF外直接复制流，不重新编码
+func (c *FFmpegConcatenator) Concat(ctx context.Context, inputs []string, output string) error {
+	listFile := output + ".txt"
+	if err := os.WriteFile(listFile, []byte(concatList(inputs)), 0644); err != nil {
+		return fmt.Errorf("写入拼接列表失败: %v", err)
+	}
+	defer os.Remove(listFile)
+
+	cmd := exec.CommandContext(ctx, c.ffmpegPath, concatArgs(listFile, output)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return &ConcatError{Output: string(out), Err: err}
+	}
+	return nil
+}
+
+// concatArgs 生成ffmpeg拼接命令参数
+func concatArgs(listFile, output string) []string {
+	args := []string{"-y", "-loglevel", "error", "-f", "concat", "-safe", "0", "-i", listFile}
+	if model.FormatFromPath(output) != model.OutputFormatGIF {
+		args = append(args, "-c", "copy")
+	}
+	return append(args, output)
+}
+
+// concatList 生成concat demuxer的输入列表，路径中的单引号需要转义
+func concatList(inputs []string) string {
+	var b strings.Builder
+	for _, input := range inputs {
+		if abs, err := filepath.Abs(input); err == nil {
+			input = abs
+		}
+		b.WriteString("file '" + strings.ReplaceAll(input, "'", `'\''`) + "'\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcatArgs(t *testing.T) {
+	assert.Equal(t,
+		[]string{"-y", "-loglevel", "error", "-f", "concat", "-safe", "0", "-i", "list.txt", "-c", "copy", "out.mp4"},
+		concatArgs("list.txt", "out.mp4"))
+
+	// GIF无法直接复制流，需要重新编码
+	assert.Equal(t,
+		[]string{"-y", "-loglevel", "error", "-f", "concat", "-safe", "0", "-i", "list.txt", "out.gif"},
+		concatArgs("list.txt", "out.gif"))
+}
+
+func TestConcatList(t *testing.T) {
+	list := concatList([]string{"/media/A.mp4", "/media/it's.mp4"})
+	assert.Equal(t, "file '/media/A.mp4'\nfile '/media/it'\\''s.mp4'\n", list)
+}
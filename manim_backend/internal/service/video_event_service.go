@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"manim-backend/internal/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// VideoEventStatus 视频状态变更事件
+	VideoEventStatus = "status"
+	// VideoEventProgress 渲染进度事件
+	VideoEventProgress = "progress"
+)
+
+// VideoEvent 视频渲染事件，通过Redis发布订阅在多个实例之间广播
+type VideoEvent struct {
+	Type    string `json:"type"`
+	VideoID uint   `json:"video_id"`
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+	Line    string `json:"line,omitempty"`
+	Time    int64  `json:"time"`
+
+	Progress *model.RenderProgress `json:"progress,omitempty"`
+}
+
+type VideoEventService struct {
+	rdb           *redis.Client
+	channelPrefix string
+
+	// 未配置Redis时使用进程内订阅者
+	mu          sync.RWMutex
+	subscribers map[uint]map[chan VideoEvent]struct{}
+}
+
+func NewVideoEventService(rdb *redis.Client) *VideoEventService {
+	return &VideoEventService{
+		rdb:           rdb,
+		channelPrefix: "video_events:",
+		subscribers:   make(map[uint]map[chan VideoEvent]struct{}),
+	}
+}
+
+// channel 获取视频对应的发布订阅频道
+func (s *VideoEventService) channel(videoID uint) string {
+	return fmt.Sprintf("%s%d", s.channelPrefix, videoID)
+}
+
+// Publish 发布视频事件
+func (s *VideoEventService) Publish(ctx context.Context, event VideoEvent) error {
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+
+	if s.rdb == nil {
+		s.publishLocal(event)
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("序列化视频事件失败: %v", err)
+	}
+
+	if err := s.rdb.Publish(ctx, s.channel(event.VideoID), payload).Err(); err != nil {
+		return fmt.Errorf("发布视频事件失败: %v", err)
+	}
+
+	return nil
+}
+
+// PublishStatus 发布状态变更事件，失败只记录日志
+func (s *VideoEventService) PublishStatus(ctx context.Context, videoID uint, status, message string) {
+	err := s.Publish(ctx, VideoEvent{
+		Type:    VideoEventStatus,
+		VideoID: videoID,
+		Status:  status,
+		Message: message,
+	})
+	if err != nil {
+		log.Printf("发布视频 %d 状态事件失败: %v", videoID, err)
+	}
+}
+
+// PublishProgress 发布渲染进度事件，失败只记录日志
+func (s *VideoEventService) PublishProgress(ctx context.Context, videoID uint, progress model.RenderProgress, line string) {
+	err := s.Publish(ctx, VideoEvent{
+		Type:     VideoEventProgress,
+		VideoID:  videoID,
+		Message:  progress.Phase,
+		Line:     line,
+		Progress: &progress,
+	})
+	if err != nil {
+		log.Printf("发布视频 %d 进度事件失败: %v", videoID, err)
+	}
+}
+
+// Subscribe 订阅视频事件，返回事件通道和取消订阅函数
+func (s *VideoEventService) Subscribe(ctx context.Context, videoID uint) (<-chan VideoEvent, func(), error) {
+	if s.rdb == nil {
+		return s.subscribeLocal(videoID)
+	}
+
+	pubsub := s.rdb.Subscribe(ctx, s.channel(videoID))
+	// 等待订阅确认，确保之后发布的事件不会丢失
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, nil, fmt.Errorf("订阅视频事件失败: %v", err)
+	}
+
+	events := make(chan VideoEvent, 100)
+	go func() {
+		defer close(events)
+		for msg := range pubsub.Channel() {
+			var event VideoEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				log.Printf("解析视频事件失败: %v", err)
+				continue
+			}
+
+			select {
+			case events <- event:
+			default:
+				// 订阅者处理过慢，丢弃事件
+			}
+		}
+	}()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() { pubsub.Close() })
+	}
+
+	return events, unsubscribe, nil
+}
+
+// subscribeLocal 进程内订阅
+func (s *VideoEventService) subscribeLocal(videoID uint) (<-chan VideoEvent, func(), error) {
+	events := make(chan VideoEvent, 100)
+
+	s.mu.Lock()
+	if s.subscribers[videoID] == nil {
+		s.subscribers[videoID] = make(map[chan VideoEvent]struct{})
+	}
+	s.subscribers[videoID][events] = struct{}{}
+	s.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subscribers[videoID], events)
+			if len(s.subscribers[videoID]) == 0 {
+				delete(s.subscribers, videoID)
+			}
+			s.mu.Unlock()
+			close(events)
+		})
+	}
+
+	return events, unsubscribe, nil
+}
+
+// publishLocal 进程内广播
+func (s *VideoEventService) publishLocal(event VideoEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for ch := range s.subscribers[event.VideoID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
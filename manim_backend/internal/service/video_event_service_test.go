@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVideoEventService_LocalPublishSubscribe(t *testing.T) {
+	// 未配置Redis时使用进程内广播
+	service := NewVideoEventService(nil)
+	ctx := context.Background()
+
+	events, unsubscribe, err := service.Subscribe(ctx, 1)
+	assert.NoError(t, err)
+	defer unsubscribe()
+
+	other, unsubscribeOther, err := service.Subscribe(ctx, 2)
+	assert.NoError(t, err)
+	defer unsubscribeOther()
+
+	service.PublishStatus(ctx, 1, "processing", "")
+	service.PublishProgress(ctx, 1, model.RenderProgress{Phase: model.RenderPhaseRendering, Percent: 50}, "Animation 0: Create(Circle):  50%")
+
+	select {
+	case event := <-events:
+		assert.Equal(t, VideoEventStatus, event.Type)
+		assert.Equal(t, uint(1), event.VideoID)
+		assert.Equal(t, "processing", event.Status)
+		assert.NotZero(t, event.Time)
+	case <-time.After(time.Second):
+		t.Fatal("未收到状态事件")
+	}
+
+	select {
+	case event := <-events:
+		assert.Equal(t, VideoEventProgress, event.Type)
+		assert.Equal(t, model.RenderPhaseRendering, event.Message)
+		assert.Equal(t, float64(50), event.Progress.Percent)
+	case <-time.After(time.Second):
+		t.Fatal("未收到进度事件")
+	}
+
+	// 其他视频的订阅者不应收到事件
+	select {
+	case event := <-other:
+		t.Fatalf("收到了不属于该视频的事件: %+v", event)
+	default:
+	}
+}
+
+func TestVideoEventService_Unsubscribe(t *testing.T) {
+	service := NewVideoEventService(nil)
+	ctx := context.Background()
+
+	events, unsubscribe, err := service.Subscribe(ctx, 1)
+	assert.NoError(t, err)
+
+	unsubscribe()
+	unsubscribe() // 重复取消订阅不应panic
+
+	_, ok := <-events
+	assert.False(t, ok)
+
+	// 取消订阅后发布事件不应阻塞
+	service.PublishStatus(ctx, 1, "completed", "")
+}
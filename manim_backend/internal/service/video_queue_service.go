@@ -2,37 +2,80 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"manim-backend/internal/config"
 	"manim-backend/internal/model"
 
-	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
 type VideoQueueService struct {
 	db        *gorm.DB
-	rdb       *redis.Client
 	manimCfg  config.ManimConfig
 	manimSvc  *ManimService
-	queueName string
+	events    *VideoEventService
+	cancels   *RenderCanceller
+	queue     JobQueue
 	workers   int
-	mu        sync.Mutex
-	isRunning bool
+	consumer  string // 本进程的工作者名称前缀
+	leaseTTL  time.Duration
+	blockTime time.Duration
+	grace     time.Duration // 停止时等待进行中的渲染完成的时间
+
+	mu         sync.Mutex
+	isRunning  bool
+	draining   bool
+	stopClaims context.CancelFunc      // 停止领取新任务
+	cancelJobs context.CancelCauseFunc // 中止进行中的渲染
+	stopped    chan struct{}           // 所有工作者退出后关闭
+	inFlight   atomic.Int32
 }
 
-func NewVideoQueueService(db *gorm.DB, rdb *redis.Client, manimCfg config.ManimConfig, manimSvc *ManimService) *VideoQueueService {
+// ErrWorkerShutdown 工作者停止时宽限期内没有完成的渲染被中止，任务重新入队
+var ErrWorkerShutdown = errors.New("渲染工作者已停止")
+
+// WorkerStatus 本实例渲染工作者的状态
+type WorkerStatus struct {
+	Running  bool
+	Draining bool
+	InFlight int
+}
+
+// NewVideoQueueService 创建队列服务，queue由NewJobQueue按配置创建
+func NewVideoQueueService(db *gorm.DB, queue JobQueue, events *VideoEventService, cancels *RenderCanceller, manimCfg config.ManimConfig, manimSvc *ManimService) *VideoQueueService {
+	leaseTTL := time.Duration(manimCfg.Queue.LeaseSeconds) * time.Second
+	if leaseTTL <= 0 {
+		leaseTTL = 60 * time.Second
+	}
+	blockTime := time.Duration(manimCfg.Queue.BlockSeconds) * time.Second
+	if blockTime <= 0 {
+		blockTime = 5 * time.Second
+	}
+	grace := time.Duration(manimCfg.Queue.GraceSeconds) * time.Second
+	if grace <= 0 {
+		grace = 60 * time.Second
+	}
+	hostname, _ := os.Hostname()
+
 	return &VideoQueueService{
 		db:        db,
-		rdb:       rdb,
 		manimCfg:  manimCfg,
 		manimSvc:  manimSvc,
-		queueName: "video_render_queue",
+		events:    events,
+		cancels:   cancels,
+		queue:     queue,
 		workers:   manimCfg.MaxConcurrent,
+		consumer:  fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		leaseTTL:  leaseTTL,
+		blockTime: blockTime,
+		grace:     grace,
 		isRunning: false,
 	}
 }
@@ -45,18 +88,11 @@ func (s *VideoQueueService) AddToQueue(ctx context.Context, videoID uint) error
 		return fmt.Errorf("视频不存在: %v", err)
 	}
 
-	// 检查视频是否已经在队列中
-	exists, err := s.rdb.ZScore(ctx, s.queueName, fmt.Sprintf("%d", videoID)).Result()
-	if err == nil && exists > 0 {
-		return fmt.Errorf("视频已在队列中")
-	}
-
-	// 添加到Redis有序集合，使用时间戳作为分数
-	score := float64(time.Now().Unix())
-	if err := s.rdb.ZAdd(ctx, s.queueName, &redis.Z{
-		Score:  score,
-		Member: fmt.Sprintf("%d", videoID),
-	}).Err(); err != nil {
+	spec := JobSpec{VideoID: videoID, UserID: video.UserID, Priority: video.Priority}
+	if err := s.queue.Enqueue(ctx, spec); err != nil {
+		if errors.Is(err, ErrJobQueued) || errors.Is(err, ErrRenderInProgress) {
+			return err
+		}
 		return fmt.Errorf("添加到队列失败: %v", err)
 	}
 
@@ -64,19 +100,29 @@ func (s *VideoQueueService) AddToQueue(ctx context.Context, videoID uint) error
 	if err := s.db.WithContext(ctx).Model(&video).Update("status", model.VideoStatusQueued).Error; err != nil {
 		return fmt.Errorf("更新视频状态失败: %v", err)
 	}
+	s.events.PublishStatus(ctx, videoID, model.VideoStatusQueued.String(), "")
 
 	log.Printf("视频 %d 已添加到渲染队列", videoID)
 	return nil
 }
 
-// StartWorkers 启动队列工作者
+// StartWorkers 启动队列工作者和过期租约回收，ctx结束时立即中止进行中的渲染。
+// 需要平滑停止时调用Drain或Shutdown
 func (s *VideoQueueService) StartWorkers(ctx context.Context) error {
 	s.mu.Lock()
 	if s.isRunning {
 		s.mu.Unlock()
 		return fmt.Errorf("队列工作者已在运行")
 	}
+	// 领取任务和进行中的渲染使用不同的上下文，停止领取后渲染仍可在宽限期内完成
+	claimCtx, stopClaims := context.WithCancel(ctx)
+	jobCtx, cancelJobs := context.WithCancelCause(ctx)
+	stopped := make(chan struct{})
 	s.isRunning = true
+	s.draining = false
+	s.stopClaims = stopClaims
+	s.cancelJobs = cancelJobs
+	s.stopped = stopped
 	s.mu.Unlock()
 
 	log.Printf("启动 %d 个视频渲染工作者", s.workers)
@@ -84,148 +130,360 @@ func (s *VideoQueueService) StartWorkers(ctx context.Context) error {
 	var wg sync.WaitGroup
 	for i := 0; i < s.workers; i++ {
 		wg.Add(1)
-		go s.worker(ctx, i, &wg)
+		go s.worker(claimCtx, jobCtx, i, &wg)
 	}
 
+	wg.Add(1)
+	go s.reaper(claimCtx, &wg)
+
+	wg.Add(1)
+	go s.retryScheduler(claimCtx, &wg)
+
+	// 停止领取后进行中的渲染仍可能被用户取消
+	listenCtx, stopListen := context.WithCancel(jobCtx)
+	listenDone := make(chan struct{})
+	go func() {
+		defer close(listenDone)
+		s.cancels.Listen(listenCtx)
+	}()
+
 	// 等待所有工作者完成
 	go func() {
 		wg.Wait()
+		stopListen()
+		<-listenDone
+		stopClaims()
+		cancelJobs(nil)
 		s.mu.Lock()
 		s.isRunning = false
+		s.draining = false
 		s.mu.Unlock()
+		close(stopped)
 		log.Println("所有视频渲染工作者已停止")
 	}()
 
 	return nil
 }
 
-// StopWorkers 停止队列工作者
-func (s *VideoQueueService) StopWorkers() {
+// Drain 停止领取新任务，进行中的渲染在grace内完成，超时后中止并重新入队。
+// 不等待工作者退出，返回进行中的任务数；工作者未运行时返回0
+func (s *VideoQueueService) Drain(grace time.Duration) int {
+	s.mu.Lock()
+	if !s.isRunning {
+		s.mu.Unlock()
+		return 0
+	}
+	stopClaims, cancelJobs, stopped := s.stopClaims, s.cancelJobs, s.stopped
+	alreadyDraining := s.draining
+	s.draining = true
+	s.mu.Unlock()
+
+	inFlight := int(s.inFlight.Load())
+	if alreadyDraining {
+		return inFlight
+	}
+
+	log.Printf("渲染工作者开始排空，%d 个任务进行中，宽限期 %v", inFlight, grace)
+	stopClaims()
+	go func() {
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case <-stopped:
+		case <-timer.C:
+			log.Printf("宽限期结束，中止 %d 个进行中的渲染并重新入队", s.inFlight.Load())
+			cancelJobs(ErrWorkerShutdown)
+		}
+	}()
+	return inFlight
+}
+
+// Shutdown 平滑停止工作者并等待全部退出，ctx结束时不再等待
+func (s *VideoQueueService) Shutdown(ctx context.Context, grace time.Duration) error {
 	s.mu.Lock()
-	s.isRunning = false
+	stopped := s.stopped
 	s.mu.Unlock()
+	if stopped == nil {
+		return nil
+	}
+
+	s.Drain(grace)
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StopWorkers 按配置的宽限期停止队列工作者，等待全部退出
+func (s *VideoQueueService) StopWorkers() {
+	s.Shutdown(context.Background(), s.grace)
+}
+
+// Status 获取本实例渲染工作者的状态
+func (s *VideoQueueService) Status() WorkerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return WorkerStatus{Running: s.isRunning, Draining: s.draining, InFlight: int(s.inFlight.Load())}
+}
+
+func (s *VideoQueueService) running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isRunning
 }
 
-// worker 队列工作者
-func (s *VideoQueueService) worker(ctx context.Context, id int, wg *sync.WaitGroup) {
+// worker 队列工作者，claimCtx结束后不再领取新任务，已领取的任务在jobCtx下处理
+func (s *VideoQueueService) worker(claimCtx, jobCtx context.Context, id int, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	log.Printf("视频渲染工作者 %d 启动", id)
+	workerID := fmt.Sprintf("%s-%d", s.consumer, id)
+	log.Printf("视频渲染工作者 %s 启动", workerID)
 
-	for {
-		// 检查是否应该停止
-		s.mu.Lock()
-		if !s.isRunning {
-			s.mu.Unlock()
-			break
+	for claimCtx.Err() == nil {
+		// 领取任务，队列为空时阻塞等待
+		job, err := s.claimNextTask(claimCtx, workerID)
+		if errors.Is(err, ErrQueueEmpty) {
+			continue
 		}
-		s.mu.Unlock()
-
-		// 从队列中获取任务
-		videoID, err := s.getNextTask(ctx)
 		if err != nil {
-			if err != redis.Nil {
-				log.Printf("工作者 %d 获取任务失败: %v", id, err)
+			if claimCtx.Err() != nil {
+				break
+			}
+			log.Printf("工作者 %s 获取任务失败: %v", workerID, err)
+			select {
+			case <-claimCtx.Done():
+			case <-time.After(time.Second):
 			}
-			time.Sleep(5 * time.Second) // 队列为空，等待5秒
 			continue
 		}
 
-		log.Printf("工作者 %d 开始处理视频 %d", id, videoID)
-		s.processVideo(ctx, videoID, id)
+		log.Printf("工作者 %s 开始处理视频 %d", workerID, job.VideoID)
+		s.processLeased(jobCtx, job)
 	}
 
-	log.Printf("视频渲染工作者 %d 停止", id)
+	log.Printf("视频渲染工作者 %s 停止", workerID)
 }
 
-// getNextTask 获取下一个任务
-func (s *VideoQueueService) getNextTask(ctx context.Context) (uint, error) {
-	// 使用阻塞弹出操作获取最早的任务
-	result, err := s.rdb.ZPopMin(ctx, s.queueName, 1).Result()
-	if err != nil {
-		return 0, err
+// claimNextTask 领取下一个任务，队列为空时最多阻塞blockTime，超时返回ErrQueueEmpty
+func (s *VideoQueueService) claimNextTask(ctx context.Context, workerID string) (*Job, error) {
+	return s.queue.Claim(ctx, workerID, s.blockTime)
+}
+
+// processLeased 在租约保护下处理任务：定期续约，处理结束后确认。
+// 租约被回收（例如长时间无法续约）时取消本次渲染，避免与重新领取的工作者重复处理；
+// 用户取消时同样中止渲染，任务照常确认
+func (s *VideoQueueService) processLeased(ctx context.Context, job *Job) {
+	s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+
+	trackedCtx, release := s.cancels.Track(ctx, job.VideoID)
+	defer release()
+	jobCtx, cancel := context.WithCancel(trackedCtx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(s.leaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ok, err := s.queue.Heartbeat(jobCtx, job)
+				if err != nil {
+					log.Printf("工作者 %s 续约视频 %d 失败: %v", job.Consumer, job.VideoID, err)
+					continue
+				}
+				if !ok {
+					log.Printf("工作者 %s 失去视频 %d 的租约，停止处理", job.Consumer, job.VideoID)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := s.processVideo(jobCtx, job.VideoID, job.Consumer)
+	close(done)
+
+	// 停止工作者时中止的任务立即重新入队；进程退出导致的取消不确认任务，租约过期后由其他工作者重新处理
+	if ctx.Err() != nil {
+		if errors.Is(context.Cause(ctx), ErrWorkerShutdown) {
+			s.requeueInterrupted(job)
+		}
+		return
+	}
+	// 其他工作者正在渲染时不确认，对方失去租约中止渲染后任务仍会被重新处理
+	if errors.Is(err, ErrRenderInProgress) {
+		return
 	}
+	if err := s.queue.Ack(ctx, job); err != nil {
+		log.Printf("工作者 %s 确认视频 %d 失败: %v", job.Consumer, job.VideoID, err)
+	}
+}
+
+// requeueInterrupted 将停止工作者时中止的任务重新入队，被中止的渲染不计入渲染次数
+func (s *VideoQueueService) requeueInterrupted(job *Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	if len(result) == 0 {
-		return 0, redis.Nil
+	// 无法确认时不重复入队，等待租约过期后重新投递
+	if err := s.queue.Ack(ctx, job); err != nil {
+		log.Printf("确认被中止的视频 %d 失败: %v", job.VideoID, err)
+		return
 	}
 
-	var videoID uint
-	_, err = fmt.Sscanf(result[0].Member.(string), "%d", &videoID)
-	if err != nil {
-		return 0, fmt.Errorf("解析视频ID失败: %v", err)
+	// 只重新入队仍处于处理中的视频，期间被取消或已完成的不再渲染
+	result := s.db.WithContext(ctx).Model(&model.Video{}).
+		Where("id = ? AND status = ?", job.VideoID, model.VideoStatusProcessing).
+		Update("attempts", gorm.Expr("CASE WHEN attempts > 0 THEN attempts - 1 ELSE 0 END"))
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
 	}
+	if err := s.AddToQueue(ctx, job.VideoID); err != nil {
+		log.Printf("被中止的视频 %d 重新入队失败: %v", job.VideoID, err)
+		return
+	}
+	log.Printf("视频 %d 的渲染在停止时被中止，已重新入队", job.VideoID)
+}
 
-	return videoID, nil
+// reaper 定期回收租约过期的任务
+func (s *VideoQueueService) reaper(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(s.leaseTTL / 2)
+	defer ticker.Stop()
+
+	for s.running() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := s.queue.Reap(ctx); err != nil {
+				log.Printf("回收过期任务失败: %v", err)
+			} else if n > 0 {
+				log.Printf("已将 %d 个租约过期的任务重新入队", n)
+			}
+		}
+	}
 }
 
-// processVideo 处理视频渲染
-func (s *VideoQueueService) processVideo(ctx context.Context, videoID uint, workerID int) {
+// processVideo 处理视频渲染，返回ErrRenderInProgress时说明其他工作者正在渲染该视频
+func (s *VideoQueueService) processVideo(ctx context.Context, videoID uint, workerID string) error {
 	// 获取视频信息
 	var video model.Video
 	if err := s.db.WithContext(ctx).First(&video, videoID).Error; err != nil {
-		log.Printf("工作者 %d 获取视频 %d 信息失败: %v", workerID, videoID, err)
-		return
+		log.Printf("工作者 %s 获取视频 %d 信息失败: %v", workerID, videoID, err)
+		return nil
+	}
+
+	// 领取前已被取消的任务直接确认
+	if video.Status == model.VideoStatusCancelled {
+		log.Printf("工作者 %s 视频 %d 已取消，跳过", workerID, videoID)
+		return nil
 	}
 
 	// 检查是否有Manim代码
 	if video.ManimCode == "" {
-		log.Printf("工作者 %d 视频 %d 没有Manim代码，无法渲染", workerID, videoID)
+		log.Printf("工作者 %s 视频 %d 没有Manim代码，无法渲染", workerID, videoID)
 		// 更新视频状态为失败
 		s.db.WithContext(ctx).Model(&video).Update("status", model.VideoStatusFailed)
 		s.db.WithContext(ctx).Model(&video).Update("error_msg", "没有Manim代码")
-		return
+		s.events.PublishStatus(ctx, videoID, model.VideoStatusFailed.String(), "没有Manim代码")
+		return nil
 	}
 
-	log.Printf("工作者 %d 开始渲染视频 %d", workerID, videoID)
+	log.Printf("工作者 %s 开始渲染视频 %d", workerID, videoID)
 
 	// 调用Manim服务进行实际渲染
 	err := s.manimSvc.GenerateVideo(ctx, videoID, video.ManimCode)
+	if errors.Is(err, ErrRenderInProgress) {
+		log.Printf("工作者 %s 视频 %d 正在其他工作者中渲染", workerID, videoID)
+		return err
+	}
+	if errors.Is(err, ErrRenderCancelled) || errors.Is(context.Cause(ctx), ErrRenderCancelled) {
+		log.Printf("工作者 %s 视频 %d 已被用户取消", workerID, videoID)
+		return nil
+	}
 	if err != nil {
-		log.Printf("工作者 %d 渲染视频 %d 失败: %v", workerID, videoID, err)
-		// 更新视频状态为失败
-		s.db.WithContext(ctx).Model(&video).Update("status", model.VideoStatusFailed)
-		s.db.WithContext(ctx).Model(&video).Update("error_msg", err.Error())
-		return
+		// 进程退出或租约丢失导致的取消不记为失败，任务会被重新处理
+		if ctx.Err() != nil {
+			log.Printf("工作者 %s 中止渲染视频 %d: %v", workerID, videoID, err)
+			return nil
+		}
+		// 失败状态和重试已由GenerateVideo记录
+		log.Printf("工作者 %s 渲染视频 %d 失败: %v", workerID, videoID, err)
+		return nil
 	}
 
-	log.Printf("工作者 %d 完成视频 %d 渲染", workerID, videoID)
+	log.Printf("工作者 %s 完成视频 %d 渲染", workerID, videoID)
+	return nil
 }
 
-// GetQueueStatus 获取队列状态
+// GetQueueStatus 获取队列状态，返回等待中的任务数量和视频ID
 func (s *VideoQueueService) GetQueueStatus(ctx context.Context) (int64, []uint, error) {
-	// 获取队列长度
-	count, err := s.rdb.ZCard(ctx, s.queueName).Result()
+	videoIDs, err := s.queue.Waiting(ctx)
 	if err != nil {
 		return 0, nil, err
 	}
+	return int64(len(videoIDs)), videoIDs, nil
+}
 
-	// 获取队列中的视频ID
-	result, err := s.rdb.ZRange(ctx, s.queueName, 0, -1).Result()
+// QueuePosition 视频在等待队列中的位置，从1开始
+type QueuePosition struct {
+	VideoID  uint
+	Position int
+}
+
+// GetUserQueuePositions 获取等待中的任务总数和用户的任务在整个队列中的位置
+func (s *VideoQueueService) GetUserQueuePositions(ctx context.Context, userID uint) (int64, []QueuePosition, error) {
+	videoIDs, err := s.queue.Waiting(ctx)
 	if err != nil {
-		return count, nil, err
+		return 0, nil, err
+	}
+	if len(videoIDs) == 0 {
+		return 0, []QueuePosition{}, nil
+	}
+
+	var owned []uint
+	if err := s.db.WithContext(ctx).Model(&model.Video{}).
+		Where("id IN ? AND user_id = ?", videoIDs, userID).
+		Pluck("id", &owned).Error; err != nil {
+		return 0, nil, fmt.Errorf("获取用户视频失败: %v", err)
+	}
+	ownedSet := make(map[uint]bool, len(owned))
+	for _, id := range owned {
+		ownedSet[id] = true
 	}
 
-	var videoIDs []uint
-	for _, member := range result {
-		var videoID uint
-		_, err := fmt.Sscanf(member, "%d", &videoID)
-		if err == nil {
-			videoIDs = append(videoIDs, videoID)
+	positions := []QueuePosition{}
+	for i, videoID := range videoIDs {
+		if ownedSet[videoID] {
+			positions = append(positions, QueuePosition{VideoID: videoID, Position: i + 1})
 		}
 	}
+	return int64(len(videoIDs)), positions, nil
+}
 
-	return count, videoIDs, nil
+// CancelRender 从队列中移除视频并通知正在渲染该视频的工作者中止渲染
+func (s *VideoQueueService) CancelRender(ctx context.Context, videoID uint) error {
+	if _, err := s.queue.Remove(ctx, videoID); err != nil {
+		return fmt.Errorf("从队列中移除视频失败: %v", err)
+	}
+	return s.cancels.Cancel(ctx, videoID)
 }
 
-// RemoveFromQueue 从队列中移除视频
+// RemoveFromQueue 从队列中移除等待中的视频
 func (s *VideoQueueService) RemoveFromQueue(ctx context.Context, videoID uint) error {
-	removed, err := s.rdb.ZRem(ctx, s.queueName, fmt.Sprintf("%d", videoID)).Result()
+	removed, err := s.queue.Remove(ctx, videoID)
 	if err != nil {
 		return err
 	}
 
-	if removed == 0 {
+	if !removed {
 		return fmt.Errorf("视频不在队列中")
 	}
 
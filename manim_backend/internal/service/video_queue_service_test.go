@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/zeromicro/go-zero/core/conf"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -69,17 +71,18 @@ func loadTestConfig() config.Config {
 	return c
 }
 
-func setupTestVideoQueueService() (*VideoQueueService, *gorm.DB, *redis.Client) {
+func setupTestVideoQueueService(t *testing.T) (*VideoQueueService, *gorm.DB, *redis.Client) {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	if err != nil {
 		panic("failed to connect to test database")
 	}
 
 	// 迁移表结构
-	db.AutoMigrate(&model.User{}, &model.Video{})
+	db.AutoMigrate(&model.User{}, &model.Video{}, &model.VideoArtifact{}, &model.RenderAttempt{}, &model.DeadLetterJob{}, &model.ReconcileAudit{}, &model.RenderCacheEntry{})
 
-	// 加载测试配置
+	// 加载测试配置，渲染工作目录和产物写入临时目录
 	c := loadTestConfig()
+	c.Manim.Workspace.Root = filepath.Join(useTestWorkDir(t), "temp")
 
 	// 使用配置文件中的Redis配置
 	rdb := redis.NewClient(&redis.Options{
@@ -89,19 +92,37 @@ func setupTestVideoQueueService() (*VideoQueueService, *gorm.DB, *redis.Client)
 	})
 
 	// 创建VideoService（需要正确的参数）
-	videoService := NewVideoService(db, rdb, c.Manim)
+	videoService := newTestRedisVideoService(t, db, rdb, c.Manim, nil)
 
 	// 创建Manim服务
 	manimSvc := NewManimService(c.Manim, videoService)
 
 	// 创建视频队列服务
-	service := NewVideoQueueService(db, rdb, c.Manim, manimSvc)
+	queue, err := NewJobQueue(rdb, db, c.Manim.Queue)
+	require.NoError(t, err)
+	service := NewVideoQueueService(db, queue, NewVideoEventService(rdb), NewRenderCanceller(rdb), c.Manim, manimSvc)
 
 	return service, db, rdb
 }
 
+// newTestVideoService 创建使用进程内队列、事件和进度存储的VideoService，不依赖Redis
+func newTestVideoService(t *testing.T, db *gorm.DB, manimCfg config.ManimConfig, manimSvc *ManimService) *VideoService {
+	queueCfg := manimCfg.Queue
+	queueCfg.Backend = QueueBackendMemory
+	queue, err := NewJobQueue(nil, db, queueCfg)
+	require.NoError(t, err)
+	return NewVideoService(db, queue, NewVideoEventService(nil), NewRenderProgressStore(nil), NewRenderCanceller(nil), manimCfg, manimSvc)
+}
+
+// newTestRedisVideoService 创建按配置使用Redis队列、事件和进度存储的VideoService
+func newTestRedisVideoService(t *testing.T, db *gorm.DB, rdb *redis.Client, manimCfg config.ManimConfig, manimSvc *ManimService) *VideoService {
+	queue, err := NewJobQueue(rdb, db, manimCfg.Queue)
+	require.NoError(t, err)
+	return NewVideoService(db, queue, NewVideoEventService(rdb), NewRenderProgressStore(rdb), NewRenderCanceller(rdb), manimCfg, manimSvc)
+}
+
 func TestVideoQueueService_AddToQueue(t *testing.T) {
-	service, db, rdb := setupTestVideoQueueService()
+	service, db, rdb := setupTestVideoQueueService(t)
 	ctx := context.Background()
 
 	// 清理Redis数据库
@@ -118,7 +139,7 @@ func TestVideoQueueService_AddToQueue(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	videoService := NewVideoService(db, rdb, manimCfg)
+	videoService := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	video, err := videoService.CreateVideo(ctx, user.ID, "提示")
 	assert.NoError(t, err)
 
@@ -169,7 +190,7 @@ func TestVideoQueueService_AddToQueue(t *testing.T) {
 }
 
 func TestVideoQueueService_AddToQueue_Duplicate(t *testing.T) {
-	service, db, rdb := setupTestVideoQueueService()
+	service, db, rdb := setupTestVideoQueueService(t)
 	ctx := context.Background()
 
 	// 清理Redis数据库
@@ -186,7 +207,7 @@ func TestVideoQueueService_AddToQueue_Duplicate(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	videoService := NewVideoService(db, rdb, manimCfg)
+	videoService := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	video, err := videoService.CreateVideo(ctx, user.ID, "提示")
 	assert.NoError(t, err)
 
@@ -207,7 +228,7 @@ func TestVideoQueueService_AddToQueue_Duplicate(t *testing.T) {
 }
 
 func TestVideoQueueService_GetQueueStatus(t *testing.T) {
-	service, db, rdb := setupTestVideoQueueService()
+	service, db, rdb := setupTestVideoQueueService(t)
 	ctx := context.Background()
 
 	// 清理Redis数据库
@@ -224,7 +245,7 @@ func TestVideoQueueService_GetQueueStatus(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	videoService := NewVideoService(db, rdb, manimCfg)
+	videoService := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	video1, err := videoService.CreateVideo(ctx, user.ID, "提示1")
 	assert.NoError(t, err)
 	video2, err := videoService.CreateVideo(ctx, user.ID, "提示2")
@@ -245,7 +266,7 @@ func TestVideoQueueService_GetQueueStatus(t *testing.T) {
 }
 
 func TestVideoQueueService_RemoveFromQueue(t *testing.T) {
-	service, db, rdb := setupTestVideoQueueService()
+	service, db, rdb := setupTestVideoQueueService(t)
 	ctx := context.Background()
 
 	// 清理Redis数据库
@@ -262,7 +283,7 @@ func TestVideoQueueService_RemoveFromQueue(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	videoService := NewVideoService(db, rdb, manimCfg)
+	videoService := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	video, err := videoService.CreateVideo(ctx, user.ID, "提示")
 	assert.NoError(t, err)
 
@@ -293,7 +314,7 @@ func TestVideoQueueService_RemoveFromQueue(t *testing.T) {
 }
 
 func TestVideoQueueService_StartStopWorkers(t *testing.T) {
-	service, _, _ := setupTestVideoQueueService()
+	service, _, _ := setupTestVideoQueueService(t)
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
@@ -316,7 +337,7 @@ func TestVideoQueueService_StartStopWorkers(t *testing.T) {
 }
 
 func TestVideoQueueService_ProcessVideo_NoManimCode(t *testing.T) {
-	service, db, rdb := setupTestVideoQueueService()
+	service, db, rdb := setupTestVideoQueueService(t)
 	ctx := context.Background()
 
 	// 清理Redis数据库
@@ -333,7 +354,7 @@ func TestVideoQueueService_ProcessVideo_NoManimCode(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	videoService := NewVideoService(db, rdb, manimCfg)
+	videoService := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	video, err := videoService.CreateVideo(ctx, user.ID, "提示")
 	assert.NoError(t, err)
 
@@ -342,7 +363,7 @@ func TestVideoQueueService_ProcessVideo_NoManimCode(t *testing.T) {
 	assert.NoError(t, err)
 
 	// 直接调用processVideo来测试没有Manim代码的情况
-	service.processVideo(ctx, video.ID, 0)
+	service.processVideo(ctx, video.ID, "worker-test")
 
 	// 验证视频状态已更新为失败
 	updatedVideo, err := videoService.GetVideoByID(ctx, video.ID)
@@ -352,7 +373,7 @@ func TestVideoQueueService_ProcessVideo_NoManimCode(t *testing.T) {
 }
 
 func TestVideoQueueService_ProcessVideo_WithManimCode(t *testing.T) {
-	service, db, rdb := setupTestVideoQueueService()
+	service, db, rdb := setupTestVideoQueueService(t)
 	ctx := context.Background()
 
 	// 清理Redis数据库
@@ -369,7 +390,7 @@ func TestVideoQueueService_ProcessVideo_WithManimCode(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	videoService := NewVideoService(db, rdb, manimCfg)
+	videoService := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	video, err := videoService.CreateVideo(ctx, user.ID, "提示")
 	assert.NoError(t, err)
 
@@ -382,23 +403,24 @@ func TestVideoQueueService_ProcessVideo_WithManimCode(t *testing.T) {
 	assert.NoError(t, err)
 
 	// 直接调用processVideo来测试有Manim代码的情况
-	service.processVideo(ctx, video.ID, 0)
+	service.processVideo(ctx, video.ID, "worker-test")
 
 	// 由于我们使用的是模拟的Manim服务，这里主要验证流程没有panic
 	// 在实际环境中，Manim服务会进行实际的视频渲染
 }
 
 func TestVideoQueueService_GetNextTask(t *testing.T) {
-	service, db, rdb := setupTestVideoQueueService()
+	service, db, rdb := setupTestVideoQueueService(t)
 	ctx := context.Background()
 
 	// 清理Redis数据库
 	rdb.FlushDB(ctx)
 
 	// 测试空队列
-	task, err := service.getNextTask(ctx)
-	assert.Equal(t, uint(0), task)
-	assert.Error(t, err) // 空队列应该返回错误
+	service.blockTime = 100 * time.Millisecond
+	task, err := service.claimNextTask(ctx, "worker-test")
+	assert.Nil(t, task)
+	assert.ErrorIs(t, err, ErrQueueEmpty) // 空队列应该返回错误
 
 	// 创建测试用户和视频
 	userService := NewUserService(db)
@@ -411,7 +433,7 @@ func TestVideoQueueService_GetNextTask(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	videoService := NewVideoService(db, rdb, manimCfg)
+	videoService := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	video, err := videoService.CreateVideo(ctx, user.ID, "提示")
 	assert.NoError(t, err)
 
@@ -420,13 +442,21 @@ func TestVideoQueueService_GetNextTask(t *testing.T) {
 	assert.NoError(t, err)
 
 	// 获取下一个任务
-	task, err = service.getNextTask(ctx)
+	task, err = service.claimNextTask(ctx, "worker-test")
 	assert.NoError(t, err)
-	assert.Equal(t, video.ID, task)
+	require.NotNil(t, task)
+	assert.Equal(t, video.ID, task.VideoID)
+
+	// 处理中的视频不能重复入队
+	assert.ErrorIs(t, service.AddToQueue(ctx, video.ID), ErrRenderInProgress)
+
+	// 确认后可以重新入队
+	assert.NoError(t, service.queue.Ack(ctx, task))
+	assert.NoError(t, service.AddToQueue(ctx, video.ID))
 }
 
 func TestVideoQueueService_WorkerLifecycle(t *testing.T) {
-	service, db, rdb := setupTestVideoQueueService()
+	service, db, rdb := setupTestVideoQueueService(t)
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// 启动工作者
@@ -444,7 +474,7 @@ func TestVideoQueueService_WorkerLifecycle(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	videoService := NewVideoService(db, rdb, manimCfg)
+	videoService := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	video, err := videoService.CreateVideo(ctx, user.ID, "提示")
 	assert.NoError(t, err)
 
@@ -464,7 +494,7 @@ func TestVideoQueueService_WorkerLifecycle(t *testing.T) {
 }
 
 func TestVideoQueueService_ConcurrentOperations(t *testing.T) {
-	service, db, rdb := setupTestVideoQueueService()
+	service, db, rdb := setupTestVideoQueueService(t)
 	ctx := context.Background()
 
 	// 清理Redis数据库
@@ -481,7 +511,7 @@ func TestVideoQueueService_ConcurrentOperations(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	videoService := NewVideoService(db, rdb, manimCfg)
+	videoService := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 
 	var videos []*model.Video
 	for i := 0; i < 5; i++ {
@@ -514,3 +544,32 @@ func TestVideoQueueService_ConcurrentOperations(t *testing.T) {
 	assert.Equal(t, int64(0), count)
 	assert.Empty(t, videoIDs)
 }
+
+func TestVideoQueueService_WithoutRedis(t *testing.T) {
+	// 未配置Redis时使用进程内队列，工作者完成渲染并确认任务
+	_, videoSvc, renderer := setupTestManimService(t)
+	assert.IsType(t, &MemoryJobQueue{}, videoSvc.queueSvc.queue)
+	videoSvc.queueSvc.blockTime = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, videoSvc.UpdateVideoStatus(ctx, video.ID, video.Status, testManimCode, "", ""))
+	assert.NoError(t, videoSvc.AddToQueue(ctx, video.ID))
+	assert.ErrorIs(t, videoSvc.AddToQueue(ctx, video.ID), ErrJobQueued)
+
+	assert.NoError(t, videoSvc.StartQueueWorkers(ctx))
+	defer videoSvc.StopQueueWorkers()
+
+	assert.Eventually(t, func() bool {
+		updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+		return err == nil && updated.Status == model.VideoStatusCompleted
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, 1, renderer.Calls())
+
+	// 确认后任务从队列中移除，可以再次入队
+	assert.Eventually(t, func() bool {
+		return videoSvc.AddToQueue(ctx, video.ID) == nil
+	}, time.Second, 20*time.Millisecond)
+}
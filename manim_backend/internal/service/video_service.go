@@ -2,67 +2,81 @@ package service
 
 import (
 	"context"
+	"errors"
+	"io"
 	"log"
 	"time"
 
 	"manim-backend/internal/config"
 	"manim-backend/internal/model"
 
-	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
 type VideoService struct {
 	db       *gorm.DB
-	rdb      *redis.Client
 	queueSvc *VideoQueueService
+	events   *VideoEventService
+	progress *RenderProgressStore
+	cache    *RenderCache
 	manimCfg config.ManimConfig
+
+	blobs      BlobStore
+	presignTTL time.Duration
 }
 
-func NewVideoService(db *gorm.DB, rdb *redis.Client, manimCfg config.ManimConfig) *VideoService {
-	// 先创建VideoService实例
+// defaultPresignTTL 未配置时预签名下载地址的有效期
+const defaultPresignTTL = 15 * time.Minute
+
+// ErrVideoNotCancellable 视频已完成、失败或已取消，不能再取消
+var ErrVideoNotCancellable = errors.New("视频已结束，无法取消")
+
+// NewVideoService 创建VideoService。队列、事件、进度存储和取消信号由调用方按配置创建，
+// 未使用Redis时传入进程内或数据库实现；manimService为nil时创建默认的ManimService
+func NewVideoService(db *gorm.DB, queue JobQueue, events *VideoEventService, progress *RenderProgressStore, cancels *RenderCanceller, manimCfg config.ManimConfig, manimService *ManimService) *VideoService {
 	videoService := &VideoService{
 		db:       db,
-		rdb:      rdb,
+		events:   events,
+		progress: progress,
+		cache:    NewRenderCache(db, manimCfg),
 		manimCfg: manimCfg,
+
+		blobs:      NewLocalBlobStore(""),
+		presignTTL: defaultPresignTTL,
 	}
 
-	// 创建ManimService实例
-	manimSvc := NewManimService(manimCfg, videoService)
+	if manimService == nil {
+		manimService = NewManimService(manimCfg, videoService)
+	}
 
-	// 创建队列服务
-	queueSvc := NewVideoQueueService(db, rdb, manimCfg, manimSvc)
-	videoService.queueSvc = queueSvc
+	// 创建队列服务，与VideoService共用事件服务
+	videoService.queueSvc = NewVideoQueueService(db, queue, events, cancels, manimCfg, manimService)
 
 	return videoService
 }
 
-// NewVideoServiceWithManim 创建VideoService并传入已有的ManimService
-func NewVideoServiceWithManim(db *gorm.DB, rdb *redis.Client, manimCfg config.ManimConfig, manimService *ManimService) *VideoService {
-	// 创建VideoService实例
-	videoService := &VideoService{
-		db:       db,
-		rdb:      rdb,
-		manimCfg: manimCfg,
-	}
-
-	// 创建队列服务，使用传入的ManimService
-	queueSvc := NewVideoQueueService(db, rdb, manimCfg, manimService)
-	videoService.queueSvc = queueSvc
+// CreateVideo 创建视频任务，使用默认渲染参数
+func (s *VideoService) CreateVideo(ctx context.Context, userID uint, prompt string) (*model.Video, error) {
+	return s.CreateVideoWithOptions(ctx, userID, prompt, DefaultRenderOptions())
+}
 
-	return videoService
+// CreateVideoWithOptions 使用指定的渲染参数创建视频任务，参数需先经过ResolveRenderOptions校验
+func (s *VideoService) CreateVideoWithOptions(ctx context.Context, userID uint, prompt string, opts model.RenderOptions) (*model.Video, error) {
+	return s.CreateVideoWithPriority(ctx, userID, prompt, opts, model.JobPriorityInteractive)
 }
 
-// CreateVideo 创建视频任务
-func (s *VideoService) CreateVideo(ctx context.Context, userID uint, prompt string) (*model.Video, error) {
+// CreateVideoWithPriority 使用指定的渲染参数和队列优先级创建视频任务，优先级需先经过ResolvePriority校验
+func (s *VideoService) CreateVideoWithPriority(ctx context.Context, userID uint, prompt string, opts model.RenderOptions, priority string) (*model.Video, error) {
 	// 为数据库操作创建带超时的上下文（30秒超时）
 	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	video := &model.Video{
-		UserID: userID,
-		Prompt: prompt,
-		Status: model.VideoStatusPending,
+		UserID:        userID,
+		Prompt:        prompt,
+		Status:        model.VideoStatusPending,
+		Priority:      priority,
+		RenderOptions: opts,
 	}
 
 	if err := s.db.WithContext(timeoutCtx).Create(video).Error; err != nil {
@@ -110,7 +124,7 @@ func (s *VideoService) GetUserVideos(ctx context.Context, userID uint, page, pag
 	return videos, total, err
 }
 
-// UpdateVideoStatus 更新视频状态
+// UpdateVideoStatus 更新视频状态，视频已被取消时不覆盖并返回ErrRenderCancelled
 func (s *VideoService) UpdateVideoStatus(ctx context.Context, id uint, status model.VideoStatus, manimCode, videoPath, errorMsg string) error {
 	// 为数据库操作创建带超时的上下文（30秒超时）
 	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
@@ -137,23 +151,178 @@ func (s *VideoService) UpdateVideoStatus(ctx context.Context, id uint, status mo
 		updates["error_msg"] = errorMsg
 	}
 
-	return s.db.WithContext(timeoutCtx).Model(&model.Video{}).Where("id = ?", id).Updates(updates).Error
+	// 已取消的视频不被渲染结果覆盖，取消可能在渲染结束后到达或由其他实例发出
+	query := s.db.WithContext(timeoutCtx).Model(&model.Video{}).Where("id = ?", id)
+	if status != model.VideoStatusCancelled {
+		query = query.Where("status <> ?", model.VideoStatusCancelled)
+	}
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRenderCancelled
+	}
+
+	// 广播状态变更，供SSE订阅者实时获取
+	s.events.PublishStatus(ctx, id, status.String(), errorMsg)
+	return nil
 }
 
-// DeleteVideo 删除视频
+// MarkVideoFailed 将视频标记为失败，并记录机器可读的失败原因
+func (s *VideoService) MarkVideoFailed(ctx context.Context, id uint, reason, manimCode, errorMsg string) error {
+	if reason != "" {
+		if err := s.db.WithContext(ctx).Model(&model.Video{}).Where("id = ? AND status <> ?", id, model.VideoStatusCancelled).Update("failure_reason", reason).Error; err != nil {
+			return err
+		}
+	}
+
+	return s.UpdateVideoStatus(ctx, id, model.VideoStatusFailed, manimCode, "", errorMsg)
+}
+
+// CancelVideo 取消等待中或正在渲染的视频：标记为已取消，从队列中移除，
+// 并通知正在渲染的工作者（可能在其他实例上）终止渲染进程
+func (s *VideoService) CancelVideo(ctx context.Context, id uint) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	// 先更新状态再发送取消信号，取消信号到达前领取任务的工作者会在开始渲染时发现已取消
+	result := s.db.WithContext(timeoutCtx).Model(&model.Video{}).
+		Where("id = ? AND status IN ?", id, []model.VideoStatus{model.VideoStatusPending, model.VideoStatusQueued, model.VideoStatusProcessing}).
+		Updates(map[string]interface{}{
+			"status":          model.VideoStatusCancelled,
+			"next_attempt_at": nil,
+			"updated_at":      time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		var video model.Video
+		if err := s.db.WithContext(timeoutCtx).Select("id").First(&video, id).Error; err != nil {
+			return err
+		}
+		return ErrVideoNotCancellable
+	}
+
+	if err := s.queueSvc.CancelRender(ctx, id); err != nil {
+		log.Printf("取消视频 %d 的渲染失败: %v", id, err)
+	}
+	s.events.PublishStatus(ctx, id, model.VideoStatusCancelled.String(), "")
+	log.Printf("视频 %d 已取消", id)
+	return nil
+}
+
+// DeleteVideo 删除视频，正在渲染时终止渲染
 func (s *VideoService) DeleteVideo(ctx context.Context, id uint) error {
 	// 为数据库操作创建带超时的上下文（30秒超时）
 	timeoutCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	// 先从队列中移除视频
-	err := s.queueSvc.RemoveFromQueue(ctx, id)
+	// 先从队列中移除视频并终止正在进行的渲染
+	err := s.queueSvc.CancelRender(ctx, id)
 	if err != nil {
-		// 如果视频不在队列中，忽略错误
-		log.Printf("从队列中移除视频 %d 失败: %v", id, err)
+		log.Printf("取消视频 %d 的渲染失败: %v", id, err)
 	}
 
-	return s.db.WithContext(timeoutCtx).Delete(&model.Video{}, id).Error
+	_, err = s.deleteVideoRecord(timeoutCtx, id)
+	return err
+}
+
+// deleteVideoRecord 删除视频记录和产物记录，产物文件只在没有其他视频引用（共享渲染缓存）时删除。
+// 视频已被删除时返回false
+func (s *VideoService) deleteVideoRecord(ctx context.Context, id uint) (bool, error) {
+	var orphans []string
+	deleted := false
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&model.Video{}, id)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		deleted = true
+
+		var err error
+		orphans, err = releaseVideoArtifacts(tx, id)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	s.removeArtifactFiles(ctx, orphans, nil)
+	return deleted, nil
+}
+
+// SaveVideoArtifacts 保存视频的渲染产物，替换之前渲染留下的记录，
+// 主视频（第一个产物）的媒体信息同时记录在视频上
+func (s *VideoService) SaveVideoArtifacts(ctx context.Context, videoID uint, artifacts []model.VideoArtifact) error {
+	return s.SaveRenderedArtifacts(ctx, videoID, "", artifacts)
+}
+
+// replaceVideoArtifacts 用新的产物替换视频之前的产物记录，返回旧产物中不再被引用的文件
+func replaceVideoArtifacts(tx *gorm.DB, videoID uint, artifacts []model.VideoArtifact) ([]string, error) {
+	orphans, err := releaseVideoArtifacts(tx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if len(artifacts) == 0 {
+		return orphans, nil
+	}
+
+	media := artifacts[0].Media
+	if err := tx.Model(&model.Video{}).Where("id = ?", videoID).Updates(map[string]interface{}{
+		"media_duration": media.Duration,
+		"media_codec":    media.Codec,
+		"media_width":    media.Width,
+		"media_height":   media.Height,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	for i := range artifacts {
+		artifacts[i].VideoID = videoID
+	}
+	return orphans, tx.Create(&artifacts).Error
+}
+
+// GetVideoArtifacts 获取视频的渲染产物，主视频在前
+func (s *VideoService) GetVideoArtifacts(ctx context.Context, videoID uint) ([]model.VideoArtifact, error) {
+	var artifacts []model.VideoArtifact
+	err := s.db.WithContext(ctx).Where("video_id = ?", videoID).Order("id ASC").Find(&artifacts).Error
+	return artifacts, err
+}
+
+// SetBlobStore 设置产物存储和预签名下载地址的有效期，默认保存在当前目录下的videos中
+func (s *VideoService) SetBlobStore(blobs BlobStore, presignTTL time.Duration) {
+	if presignTTL <= 0 {
+		presignTTL = defaultPresignTTL
+	}
+	s.blobs = blobs
+	s.presignTTL = presignTTL
+}
+
+// PresignArtifact 生成产物的预签名下载地址，存储不支持时返回ErrPresignNotSupported
+func (s *VideoService) PresignArtifact(ctx context.Context, path string) (string, error) {
+	return s.blobs.PresignGet(ctx, path, s.presignTTL)
+}
+
+// OpenArtifact 读取产物文件，调用方负责关闭，文件不存在时返回ErrBlobNotFound
+func (s *VideoService) OpenArtifact(ctx context.Context, path string) (io.ReadCloser, BlobInfo, error) {
+	return s.blobs.Get(ctx, path)
+}
+
+// Events 获取视频事件服务
+func (s *VideoService) Events() *VideoEventService {
+	return s.events
+}
+
+// SaveRenderProgress 保存渲染进度
+func (s *VideoService) SaveRenderProgress(ctx context.Context, id uint, progress model.RenderProgress) error {
+	return s.progress.Save(ctx, id, progress)
+}
+
+// GetRenderProgress 获取渲染进度，没有记录时返回nil
+func (s *VideoService) GetRenderProgress(ctx context.Context, id uint) (*model.RenderProgress, error) {
+	return s.progress.Get(ctx, id)
 }
 
 // AddToQueue 添加视频到渲染队列
@@ -166,16 +335,39 @@ func (s *VideoService) StartQueueWorkers(ctx context.Context) error {
 	return s.queueSvc.StartWorkers(ctx)
 }
 
-// StopQueueWorkers 停止队列工作者
+// StopQueueWorkers 按配置的宽限期停止队列工作者，等待全部退出
 func (s *VideoService) StopQueueWorkers() {
 	s.queueSvc.StopWorkers()
 }
 
+// ShutdownQueueWorkers 平滑停止队列工作者，宽限期为Queue.GraceSeconds，ctx结束时不再等待
+func (s *VideoService) ShutdownQueueWorkers(ctx context.Context) error {
+	return s.queueSvc.Shutdown(ctx, s.queueSvc.grace)
+}
+
+// DrainQueueWorkers 停止本实例领取新任务，进行中的渲染在宽限期内完成，grace为0时使用Queue.GraceSeconds
+func (s *VideoService) DrainQueueWorkers(grace time.Duration) int {
+	if grace <= 0 {
+		grace = s.queueSvc.grace
+	}
+	return s.queueSvc.Drain(grace)
+}
+
+// QueueWorkerStatus 获取本实例渲染工作者的状态
+func (s *VideoService) QueueWorkerStatus() WorkerStatus {
+	return s.queueSvc.Status()
+}
+
 // GetQueueStatus 获取队列状态
 func (s *VideoService) GetQueueStatus(ctx context.Context) (int64, []uint, error) {
 	return s.queueSvc.GetQueueStatus(ctx)
 }
 
+// GetUserQueuePositions 获取队列中等待的任务总数和用户的任务位置
+func (s *VideoService) GetUserQueuePositions(ctx context.Context, userID uint) (int64, []QueuePosition, error) {
+	return s.queueSvc.GetUserQueuePositions(ctx, userID)
+}
+
 // CleanOldVideos 清理过期视频（避免长时间堆积）
 func (s *VideoService) CleanOldVideos(ctx context.Context, days int) error {
 	// 为数据库操作创建带超时的上下文（30秒超时）
@@ -201,21 +393,27 @@ func (s *VideoService) CleanOldVideos(ctx context.Context, days int) error {
 		}
 	}
 
-	// 删除过期视频
-	result := s.db.WithContext(timeoutCtx).Where("created_at < ?", cutoffTime).Delete(&model.Video{})
-	if result.Error != nil {
-		return result.Error
+	// 删除过期视频，与其他视频共享的缓存产物保留到最后一个引用删除时
+	deleted := 0
+	for _, videoID := range videoIDs {
+		ok, err := s.deleteVideoRecord(timeoutCtx, videoID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			deleted++
+		}
 	}
 
-	log.Printf("清理了 %d 个过期视频", result.RowsAffected)
+	log.Printf("清理了 %d 个过期视频", deleted)
 	return nil
 }
 
-// GetProcessingVideos 获取正在处理的视频
+// GetProcessingVideos 获取尚未结束（等待、排队和处理中）的视频
 func (s *VideoService) GetProcessingVideos(ctx context.Context) ([]model.Video, error) {
 	var videos []model.Video
 	err := s.db.WithContext(ctx).
-		Where("status IN ?", []model.VideoStatus{model.VideoStatusPending, model.VideoStatusProcessing}).
+		Where("status IN ?", []model.VideoStatus{model.VideoStatusPending, model.VideoStatusQueued, model.VideoStatusProcessing}).
 		Order("created_at ASC").
 		Find(&videos).Error
 	return videos, err
@@ -20,7 +20,7 @@ func setupTestDBWithRedis() (*gorm.DB, *redis.Client) {
 	}
 
 	// 迁移表结构
-	db.AutoMigrate(&model.User{}, &model.Video{})
+	db.AutoMigrate(&model.User{}, &model.Video{}, &model.VideoArtifact{}, &model.RenderAttempt{}, &model.DeadLetterJob{}, &model.ReconcileAudit{}, &model.RenderCacheEntry{})
 
 	// 创建测试Redis客户端（使用模拟客户端）
 	rdb := redis.NewClient(&redis.Options{
@@ -39,7 +39,7 @@ func setupTestDBWithVideo() *gorm.DB {
 	}
 
 	// 迁移表结构
-	db.AutoMigrate(&model.User{}, &model.Video{})
+	db.AutoMigrate(&model.User{}, &model.Video{}, &model.VideoArtifact{}, &model.RenderAttempt{}, &model.DeadLetterJob{}, &model.ReconcileAudit{}, &model.RenderCacheEntry{})
 
 	return db
 }
@@ -51,7 +51,7 @@ func TestVideoService_CreateVideo(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	service := NewVideoService(db, rdb, manimCfg)
+	service := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	ctx := context.Background()
 
 	// 先创建一个测试用户
@@ -103,7 +103,7 @@ func TestVideoService_GetVideoByID(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	service := NewVideoService(db, rdb, manimCfg)
+	service := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	ctx := context.Background()
 
 	// 先创建测试数据
@@ -153,7 +153,7 @@ func TestVideoService_UpdateVideoStatus(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	service := NewVideoService(db, rdb, manimCfg)
+	service := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	ctx := context.Background()
 
 	// 先创建测试数据
@@ -224,7 +224,7 @@ func TestVideoService_GetUserVideos(t *testing.T) {
 		MaxConcurrent: 3,
 		Timeout:       300,
 	}
-	service := NewVideoService(db, rdb, manimCfg)
+	service := newTestRedisVideoService(t, db, rdb, manimCfg, nil)
 	ctx := context.Background()
 
 	// 创建测试用户
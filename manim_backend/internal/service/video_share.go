@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"manim-backend/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// ErrShareNotFound 分享令牌不存在或已撤销
+var ErrShareNotFound = errors.New("分享链接不存在或已撤销")
+
+// ShareVideo 为视频生成公开分享的令牌，持有令牌的人不登录也能下载该视频的产物。
+// 已分享时返回现有令牌，撤销后再分享生成新令牌
+func (s *VideoService) ShareVideo(ctx context.Context, videoID uint) (string, error) {
+	video, err := s.GetVideoByID(ctx, videoID)
+	if err != nil {
+		return "", err
+	}
+	if video.ShareToken != nil {
+		return *video.ShareToken, nil
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	// 同时分享时只有第一个令牌生效
+	result := s.db.WithContext(ctx).Model(&model.Video{}).
+		Where("id = ? AND share_token IS NULL", videoID).
+		Update("share_token", token)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		video, err := s.GetVideoByID(ctx, videoID)
+		if err != nil {
+			return "", err
+		}
+		if video.ShareToken == nil {
+			return "", ErrShareNotFound
+		}
+		return *video.ShareToken, nil
+	}
+	return token, nil
+}
+
+// RevokeVideoShare 撤销视频的分享令牌，之前分享的链接立即失效
+func (s *VideoService) RevokeVideoShare(ctx context.Context, videoID uint) error {
+	return s.db.WithContext(ctx).Model(&model.Video{}).Where("id = ?", videoID).Update("share_token", nil).Error
+}
+
+// GetVideoByShareToken 根据分享令牌获取视频，令牌不存在或已撤销时返回ErrShareNotFound
+func (s *VideoService) GetVideoByShareToken(ctx context.Context, token string) (*model.Video, error) {
+	if token == "" {
+		return nil, ErrShareNotFound
+	}
+	var video model.Video
+	err := s.db.WithContext(ctx).Where("share_token = ?", token).First(&video).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &video, nil
+}
+
+// ResolveArtifact 根据下载地址中的文件名查找视频产物的存储路径，只在该视频的产物记录和video_path中查找，
+// 包括命中渲染缓存时引用的其他视频的文件。文件名含路径时返回ErrInvalidBlobKey，不属于该视频时返回ErrBlobNotFound
+func (s *VideoService) ResolveArtifact(ctx context.Context, video *model.Video, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") || filepath.IsAbs(name) {
+		return "", ErrInvalidBlobKey
+	}
+
+	artifacts, err := s.GetVideoArtifacts(ctx, video.ID)
+	if err != nil {
+		return "", err
+	}
+	for _, artifact := range artifacts {
+		if path.Base(filepath.ToSlash(artifact.Path)) == name {
+			return artifact.Path, nil
+		}
+	}
+	// 早期的视频没有产物记录
+	if video.VideoPath != "" && path.Base(filepath.ToSlash(video.VideoPath)) == name {
+		return video.VideoPath, nil
+	}
+	return "", ErrBlobNotFound
+}
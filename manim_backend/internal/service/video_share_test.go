@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVideoService_ShareVideo(t *testing.T) {
+	manimSvc, videoSvc, _ := setupTestRenderCache(t)
+	ctx := context.Background()
+
+	video := generateTestVideo(t, manimSvc, videoSvc, testManimCode, DefaultRenderOptions())
+	_, err := videoSvc.GetVideoByShareToken(ctx, "")
+	assert.ErrorIs(t, err, ErrShareNotFound)
+
+	token, err := videoSvc.ShareVideo(ctx, video.ID)
+	require.NoError(t, err)
+	assert.Len(t, token, 48)
+
+	// 重复分享返回同一个令牌
+	again, err := videoSvc.ShareVideo(ctx, video.ID)
+	require.NoError(t, err)
+	assert.Equal(t, token, again)
+
+	shared, err := videoSvc.GetVideoByShareToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, video.ID, shared.ID)
+
+	// 撤销后令牌失效，再次分享生成新令牌
+	require.NoError(t, videoSvc.RevokeVideoShare(ctx, video.ID))
+	_, err = videoSvc.GetVideoByShareToken(ctx, token)
+	assert.ErrorIs(t, err, ErrShareNotFound)
+	renewed, err := videoSvc.ShareVideo(ctx, video.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, token, renewed)
+
+	_, err = videoSvc.ShareVideo(ctx, 9999)
+	assert.Error(t, err)
+}
+
+func TestVideoService_ResolveArtifact(t *testing.T) {
+	manimSvc, videoSvc, _ := setupTestRenderCache(t)
+	ctx := context.Background()
+
+	first := generateTestVideo(t, manimSvc, videoSvc, testManimCode, DefaultRenderOptions())
+	// 命中缓存的视频引用第一个视频的文件
+	second := generateTestVideo(t, manimSvc, videoSvc, testManimCode, DefaultRenderOptions())
+	require.Equal(t, first.VideoPath, second.VideoPath)
+
+	other := generateTestVideo(t, manimSvc, videoSvc, testManimCode+"\n        self.wait(1)", DefaultRenderOptions())
+
+	storedPath, err := videoSvc.ResolveArtifact(ctx, second, path.Base(first.VideoPath))
+	assert.NoError(t, err)
+	assert.Equal(t, first.VideoPath, storedPath)
+
+	// 文件名相同但属于其他视频的记录只按本视频的记录查找
+	storedPath, err = videoSvc.ResolveArtifact(ctx, other, path.Base(first.VideoPath))
+	assert.NoError(t, err)
+	assert.Equal(t, other.VideoPath, storedPath)
+
+	_, err = videoSvc.ResolveArtifact(ctx, second, "Missing.mp4")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+
+	for _, name := range []string{"", ".", "..", "../" + path.Base(other.VideoPath), other.VideoPath, "/" + other.VideoPath, "a\\b.mp4", "a\x00.mp4"} {
+		_, err = videoSvc.ResolveArtifact(ctx, second, name)
+		assert.ErrorIs(t, err, ErrInvalidBlobKey, name)
+	}
+
+	// 没有产物记录的早期视频按video_path查找
+	legacy := &model.Video{UserID: 1, Prompt: "旧视频", Status: model.VideoStatusCompleted, VideoPath: "videos/1/Legacy.mp4"}
+	require.NoError(t, videoSvc.db.Create(legacy).Error)
+	storedPath, err = videoSvc.ResolveArtifact(ctx, legacy, "Legacy.mp4")
+	assert.NoError(t, err)
+	assert.Equal(t, "videos/1/Legacy.mp4", storedPath)
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"manim-backend/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startTestWorkers 启动使用进程内队列的工作者，并将一个视频加入队列直到开始渲染
+func startTestWorkers(t *testing.T, delay time.Duration) (*VideoService, *model.Video) {
+	_, videoSvc, renderer := setupTestManimService(t)
+	renderer.Delay = delay
+	videoSvc.queueSvc.blockTime = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, videoSvc.UpdateVideoStatus(ctx, video.ID, video.Status, testManimCode, "", ""))
+	assert.NoError(t, videoSvc.AddToQueue(ctx, video.ID))
+	assert.NoError(t, videoSvc.StartQueueWorkers(ctx))
+
+	assert.Eventually(t, func() bool {
+		return videoSvc.QueueWorkerStatus().InFlight == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	return videoSvc, video
+}
+
+func TestVideoQueueService_DrainFinishesInFlight(t *testing.T) {
+	videoSvc, video := startTestWorkers(t, 300*time.Millisecond)
+	ctx := context.Background()
+
+	assert.Equal(t, 1, videoSvc.DrainQueueWorkers(5*time.Second))
+	status := videoSvc.QueueWorkerStatus()
+	assert.True(t, status.Running)
+	assert.True(t, status.Draining)
+
+	// 排空后不再领取新任务
+	other, err := videoSvc.CreateVideo(ctx, 1, "再画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, videoSvc.AddToQueue(ctx, other.ID))
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, videoSvc.ShutdownQueueWorkers(shutdownCtx))
+	assert.Equal(t, WorkerStatus{}, videoSvc.QueueWorkerStatus())
+
+	// 进行中的渲染在宽限期内完成
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusCompleted, updated.Status)
+	_, waiting, err := videoSvc.GetQueueStatus(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{other.ID}, waiting)
+}
+
+func TestVideoQueueService_DrainRequeuesAfterGrace(t *testing.T) {
+	videoSvc, video := startTestWorkers(t, 10*time.Second)
+	ctx := context.Background()
+
+	videoSvc.DrainQueueWorkers(100 * time.Millisecond)
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, videoSvc.ShutdownQueueWorkers(shutdownCtx))
+
+	// 宽限期内没有完成的渲染被中止并重新入队，不计入渲染次数
+	updated, err := videoSvc.GetVideoByID(ctx, video.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VideoStatusQueued, updated.Status)
+	assert.Equal(t, 0, updated.Attempts)
+	assert.Empty(t, updated.FailureReason)
+	_, waiting, err := videoSvc.GetQueueStatus(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{video.ID}, waiting)
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"manim-backend/internal/config"
+
+	"github.com/google/uuid"
+)
+
+// 工作目录中的标记文件
+const (
+	// workspaceOwnerFile 记录创建工作目录的主机和进程，文件修改时间作为心跳
+	workspaceOwnerFile = ".owner"
+	// workspaceKeepFile 失败后保留用于调试的工作目录，内容为过期时间
+	workspaceKeepFile = ".keep"
+)
+
+// Workspace 一次渲染任务独占的临时工作目录
+type Workspace struct {
+	Dir     string
+	VideoID uint
+}
+
+// WorkspaceManager 管理渲染工作目录的创建、释放和清理。
+// 正在使用的工作目录由清理任务定期刷新心跳，进程崩溃后心跳停止，
+// 其工作目录会在启动时或心跳过期后被当作孤儿目录删除
+type WorkspaceManager struct {
+	root       string
+	keepFailed time.Duration
+	maxBytes   int64
+	interval   time.Duration
+	hostname   string
+
+	mu     sync.Mutex
+	active map[string]bool
+	now    func() time.Time
+}
+
+func NewWorkspaceManager(cfg config.WorkspaceConfig) *WorkspaceManager {
+	root := cfg.Root
+	if root == "" {
+		root = "temp"
+	}
+	interval := time.Duration(cfg.JanitorMinutes) * time.Minute
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	hostname, _ := os.Hostname()
+
+	return &WorkspaceManager{
+		root:       root,
+		keepFailed: time.Duration(cfg.KeepFailedHours) * time.Hour,
+		maxBytes:   int64(cfg.MaxTotalMB) << 20,
+		interval:   interval,
+		hostname:   hostname,
+		active:     make(map[string]bool),
+		now:        time.Now,
+	}
+}
+
+// Create 为视频创建新的工作目录
+func (m *WorkspaceManager) Create(videoID uint) (*Workspace, error) {
+	dir := filepath.Join(m.root, fmt.Sprintf("%d-%s", videoID, uuid.New().String()))
+	ws := &Workspace{Dir: dir, VideoID: videoID}
+
+	// 先登记为使用中，避免写入所有者信息之前被清理任务当作孤儿目录删除
+	m.mu.Lock()
+	m.active[dir] = true
+	m.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		m.Release(ws, false)
+		return nil, fmt.Errorf("创建工作目录失败: %v", err)
+	}
+
+	owner := fmt.Sprintf("%s %d", m.hostname, os.Getpid())
+	if err := os.WriteFile(filepath.Join(dir, workspaceOwnerFile), []byte(owner), 0644); err != nil {
+		m.Release(ws, false)
+		return nil, fmt.Errorf("写入工作目录信息失败: %v", err)
+	}
+
+	return ws, nil
+}
+
+// Release 任务结束后释放工作目录，配置了保留时长时失败任务的目录会保留用于调试
+func (m *WorkspaceManager) Release(ws *Workspace, failed bool) {
+	if ws == nil {
+		return
+	}
+	defer func() {
+		m.mu.Lock()
+		delete(m.active, ws.Dir)
+		m.mu.Unlock()
+	}()
+
+	if failed && m.keepFailed > 0 {
+		expiresAt := m.now().Add(m.keepFailed).Format(time.RFC3339)
+		if err := os.WriteFile(filepath.Join(ws.Dir, workspaceKeepFile), []byte(expiresAt), 0644); err == nil {
+			log.Printf("视频 %d 渲染失败，工作目录保留至 %s: %s", ws.VideoID, expiresAt, ws.Dir)
+			return
+		}
+	}
+
+	if err := os.RemoveAll(ws.Dir); err != nil {
+		log.Printf("删除工作目录失败: %v", err)
+	}
+}
+
+// StartJanitor 启动时立即清理一次孤儿目录，之后定期刷新心跳并清理
+func (m *WorkspaceManager) StartJanitor(ctx context.Context) {
+	m.reconcile()
+
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.heartbeat()
+				m.reconcile()
+			}
+		}
+	}()
+}
+
+func (m *WorkspaceManager) reconcile() {
+	removed, err := m.Sweep()
+	if err != nil {
+		log.Printf("清理工作目录失败: %v", err)
+	} else if removed > 0 {
+		log.Printf("已清理 %d 个工作目录", removed)
+	}
+}
+
+// heartbeat 刷新本进程正在使用的工作目录的心跳
+func (m *WorkspaceManager) heartbeat() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for dir := range m.active {
+		os.Chtimes(filepath.Join(dir, workspaceOwnerFile), now, now)
+	}
+}
+
+// keptWorkspace 失败后保留的工作目录
+type keptWorkspace struct {
+	dir       string
+	size      int64
+	expiresAt time.Time
+}
+
+// Sweep 删除过期的保留目录和孤儿目录，总大小超出上限时从最早过期的保留目录开始删除
+func (m *WorkspaceManager) Sweep() (int, error) {
+	entries, err := os.ReadDir(m.root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	now := m.now()
+	removed := 0
+	var total int64
+	var kept []keptWorkspace
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(m.root, entry.Name())
+
+		m.mu.Lock()
+		active := m.active[dir]
+		m.mu.Unlock()
+
+		switch {
+		case active:
+			total += dirSize(dir)
+		case m.isKept(dir):
+			expiresAt := readKeepExpiry(dir)
+			if !now.Before(expiresAt) {
+				if os.RemoveAll(dir) == nil {
+					removed++
+				}
+				continue
+			}
+			size := dirSize(dir)
+			kept = append(kept, keptWorkspace{dir: dir, size: size, expiresAt: expiresAt})
+			total += size
+		case m.isOrphan(dir, now):
+			if os.RemoveAll(dir) == nil {
+				removed++
+			}
+		default:
+			// 其他进程正在使用
+			total += dirSize(dir)
+		}
+	}
+
+	if m.maxBytes <= 0 || total <= m.maxBytes {
+		return removed, nil
+	}
+
+	sort.Slice(kept, func(i, j int) bool { return kept[i].expiresAt.Before(kept[j].expiresAt) })
+	for _, ws := range kept {
+		if total <= m.maxBytes {
+			break
+		}
+		if os.RemoveAll(ws.dir) == nil {
+			removed++
+			total -= ws.size
+		}
+	}
+	if total > m.maxBytes {
+		log.Printf("工作目录总大小 %dMB 仍超出上限 %dMB", total>>20, m.maxBytes>>20)
+	}
+
+	return removed, nil
+}
+
+func (m *WorkspaceManager) isKept(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, workspaceKeepFile))
+	return err == nil
+}
+
+// isOrphan 判断工作目录的任务是否已不再运行：
+// 没有所有者信息、所有者进程已退出（同一主机），或心跳超过三个清理周期未刷新
+func (m *WorkspaceManager) isOrphan(dir string, now time.Time) bool {
+	ownerFile := filepath.Join(dir, workspaceOwnerFile)
+	info, err := os.Stat(ownerFile)
+	if err != nil {
+		return true
+	}
+	if now.Sub(info.ModTime()) > 3*m.interval {
+		return true
+	}
+
+	content, err := os.ReadFile(ownerFile)
+	if err != nil {
+		return true
+	}
+	host, pidStr, _ := strings.Cut(strings.TrimSpace(string(content)), " ")
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return true
+	}
+	if host != m.hostname {
+		return false
+	}
+	// 本进程创建但已不在使用中的目录，或者创建它的进程已退出
+	return pid == os.Getpid() || !processAlive(pid)
+}
+
+// readKeepExpiry 读取保留目录的过期时间，无法解析时视为已过期
+func readKeepExpiry(dir string) time.Time {
+	content, err := os.ReadFile(filepath.Join(dir, workspaceKeepFile))
+	if err != nil {
+		return time.Time{}
+	}
+	expiresAt, err := time.Parse(time.RFC3339, strings.TrimSpace(string(content)))
+	if err != nil {
+		return time.Time{}
+	}
+	return expiresAt
+}
+
+// dirSize 计算目录中文件的总大小
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
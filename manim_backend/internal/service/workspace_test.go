@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"manim-backend/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWorkspaceManager(t *testing.T, cfg config.WorkspaceConfig) *WorkspaceManager {
+	cfg.Root = t.TempDir()
+	if cfg.JanitorMinutes == 0 {
+		cfg.JanitorMinutes = 10
+	}
+	return NewWorkspaceManager(cfg)
+}
+
+func TestWorkspaceManager_Release(t *testing.T) {
+	m := newTestWorkspaceManager(t, config.WorkspaceConfig{KeepFailedHours: 2})
+
+	succeeded, err := m.Create(1)
+	assert.NoError(t, err)
+	assert.DirExists(t, succeeded.Dir)
+	m.Release(succeeded, false)
+	assert.NoDirExists(t, succeeded.Dir)
+
+	// 失败任务的目录保留用于调试
+	failed, err := m.Create(2)
+	assert.NoError(t, err)
+	m.Release(failed, true)
+	assert.FileExists(t, filepath.Join(failed.Dir, workspaceKeepFile))
+
+	// 保留期内不会被清理，过期后删除
+	removed, err := m.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+	m.now = func() time.Time { return time.Now().Add(3 * time.Hour) }
+	removed, err = m.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.NoDirExists(t, failed.Dir)
+}
+
+func TestWorkspaceManager_ReleaseWithoutKeep(t *testing.T) {
+	m := newTestWorkspaceManager(t, config.WorkspaceConfig{})
+
+	ws, err := m.Create(1)
+	assert.NoError(t, err)
+	m.Release(ws, true)
+	assert.NoDirExists(t, ws.Dir)
+}
+
+func TestWorkspaceManager_SweepOrphans(t *testing.T) {
+	m := newTestWorkspaceManager(t, config.WorkspaceConfig{})
+
+	active, err := m.Create(1)
+	assert.NoError(t, err)
+
+	// 旧版本遗留的没有所有者信息的目录
+	legacy := filepath.Join(m.root, "5b1f0c3e")
+	assert.NoError(t, os.MkdirAll(legacy, 0755))
+
+	// 本机已退出的进程创建的目录
+	cmd := exec.Command("go", "version")
+	assert.NoError(t, cmd.Run())
+	crashed := writeTestWorkspace(t, m, "2-crashed", fmt.Sprintf("%s %d", m.hostname, cmd.Process.Pid))
+
+	// 其他主机上心跳正常的目录
+	remote := writeTestWorkspace(t, m, "3-remote", "other-host 42")
+
+	// 其他主机上心跳过期的目录
+	stale := writeTestWorkspace(t, m, "4-stale", "other-host 43")
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(stale, workspaceOwnerFile), old, old))
+
+	removed, err := m.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	assert.DirExists(t, active.Dir)
+	assert.DirExists(t, remote)
+	assert.NoDirExists(t, legacy)
+	assert.NoDirExists(t, crashed)
+	assert.NoDirExists(t, stale)
+}
+
+func TestWorkspaceManager_SweepSizeLimit(t *testing.T) {
+	m := newTestWorkspaceManager(t, config.WorkspaceConfig{KeepFailedHours: 24, MaxTotalMB: 1})
+
+	var kept []*Workspace
+	for i := uint(1); i <= 3; i++ {
+		ws, err := m.Create(i)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(ws.Dir, "partial.mp4"), make([]byte, 400<<10), 0644))
+		// 保留时间依次递增
+		m.now = func() time.Time { return time.Now().Add(time.Duration(i) * time.Minute) }
+		m.Release(ws, true)
+		kept = append(kept, ws)
+	}
+	m.now = time.Now
+
+	// 总大小1.2MB超出1MB上限，删除最早保留的目录
+	removed, err := m.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.NoDirExists(t, kept[0].Dir)
+	assert.DirExists(t, kept[1].Dir)
+	assert.DirExists(t, kept[2].Dir)
+}
+
+func TestManimService_GenerateVideo_ReleasesWorkspace(t *testing.T) {
+	manimSvc, videoSvc, renderer := setupTestManimService(t)
+	ctx := context.Background()
+
+	video, err := videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	assert.NoError(t, manimSvc.GenerateVideo(ctx, video.ID, testManimCode))
+
+	entries, err := os.ReadDir(manimSvc.workspaces.root)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// 渲染失败时按配置保留工作目录
+	manimSvc.workspaces.keepFailed = time.Hour
+	renderer.Err = errors.New("Manim执行失败")
+	video, err = videoSvc.CreateVideo(ctx, 1, "画一个圆")
+	assert.NoError(t, err)
+	assert.Error(t, manimSvc.GenerateVideo(ctx, video.ID, testManimCode))
+
+	entries, err = os.ReadDir(manimSvc.workspaces.root)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.FileExists(t, filepath.Join(manimSvc.workspaces.root, entries[0].Name(), workspaceKeepFile))
+	}
+}
+
+func writeTestWorkspace(t *testing.T, m *WorkspaceManager, name, owner string) string {
+	dir := filepath.Join(m.root, name)
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, workspaceOwnerFile), []byte(owner), 0644))
+	return dir
+}
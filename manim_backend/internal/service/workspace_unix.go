@@ -0,0 +1,17 @@
+//go:build !windows
+
+package service
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive 判断同一主机上的进程是否仍在运行
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package service
+
+// processAlive Windows上无法可靠地探测进程，始终视为运行中，孤儿目录依靠心跳过期清理
+func processAlive(pid int) bool {
+	return pid > 0
+}
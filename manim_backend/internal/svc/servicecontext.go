@@ -1,6 +1,9 @@
 package svc
 
 import (
+	"log"
+	"time"
+
 	"manim-backend/internal/config"
 	"manim-backend/internal/middleware"
 	"manim-backend/internal/model"
@@ -14,6 +17,8 @@ type ServiceContext struct {
 	VideoService *service.VideoService
 	AIService    *service.AIService
 	ManimService *service.ManimService
+	// DownloadSigner 为视频下载地址签名，下载时校验
+	DownloadSigner *service.DownloadSigner
 }
 
 func NewServiceContext(c config.Config) *ServiceContext {
@@ -28,14 +33,29 @@ func NewServiceContext(c config.Config) *ServiceContext {
 
 	// 先创建ManimService（不依赖VideoService）
 	manimService := service.NewManimService(c.Manim, nil)
+	manimService.SetLocker(service.NewRenderLocker(redisClient, time.Duration(c.Manim.Queue.LeaseSeconds)*time.Second))
+
+	// 队列实现与Redis配置不匹配时直接退出，避免多实例部署时各自使用进程内队列
+	queue, err := service.NewJobQueue(redisClient, db, c.Manim.Queue)
+	if err != nil {
+		log.Fatalf("创建渲染队列失败: %v", err)
+	}
 
 	// 创建VideoService，传入ManimService
-	videoService := service.NewVideoServiceWithManim(db, redisClient, c.Manim, manimService)
+	videoService := service.NewVideoService(db, queue, service.NewVideoEventService(redisClient), service.NewRenderProgressStore(redisClient), service.NewRenderCanceller(redisClient), c.Manim, manimService)
+
+	// 渲染产物保存在本地目录或对象存储中，多实例部署时使用对象存储共享
+	blobs, err := service.NewBlobStore(c.Storage)
+	if err != nil {
+		log.Fatalf("创建产物存储失败: %v", err)
+	}
+	videoService.SetBlobStore(blobs, time.Duration(c.Storage.PresignSeconds)*time.Second)
 
 	// 设置ManimService的VideoService依赖
 	manimService.SetVideoService(videoService)
 
 	aiService := service.NewAIService(c.OpenAI)
+	aiService.SetCodeAnalyzer(service.NewCodeAnalyzer(c.CodeSafety))
 
 	// 初始化中间件
 	auth := middleware.NewAuthMiddleware(userService)
@@ -47,5 +67,7 @@ func NewServiceContext(c config.Config) *ServiceContext {
 		VideoService: videoService,
 		AIService:    aiService,
 		ManimService: manimService,
+
+		DownloadSigner: service.NewDownloadSigner(c.Download),
 	}
 }
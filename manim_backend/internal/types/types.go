@@ -20,22 +20,96 @@ type User struct {
 	ID       uint   `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
+	Tier     string `json:"tier,omitempty"`
 }
 
 type CreateVideoRequest struct {
 	Prompt string `json:"prompt"`
 	Code   string `json:"code,omitempty"`
+
+	// 渲染参数，均可选
+	Quality         string `json:"quality,omitempty"` // l/m/h/p/k，默认m
+	Width           int    `json:"width,omitempty"`
+	Height          int    `json:"height,omitempty"`
+	FrameRate       int    `json:"frame_rate,omitempty"`
+	BackgroundColor string `json:"background_color,omitempty"`
+	Format          string `json:"format,omitempty"` // mp4/gif/webm/mov，默认mp4
+	Transparent     bool   `json:"transparent,omitempty"`
+	SaveLastFrame   bool   `json:"save_last_frame,omitempty"`
+
+	// 要渲染的场景类名，默认渲染代码中的全部场景；Concat为true时按顺序拼接为一个视频
+	Scenes []string `json:"scenes,omitempty"`
+	Concat bool     `json:"concat,omitempty"`
+
+	// 队列优先级：interactive（默认）/batch/admin，admin仅限管理员
+	Priority string `json:"priority,omitempty"`
 }
 
 type VideoResponse struct {
-	ID        uint   `json:"id"`
-	Prompt    string `json:"prompt"`
-	ManimCode string `json:"manim_code,omitempty"`
-	VideoPath string `json:"video_path,omitempty"`
-	Status    string `json:"status"`
-	ErrorMsg  string `json:"error_msg,omitempty"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	ID            uint            `json:"id"`
+	Prompt        string          `json:"prompt"`
+	ManimCode     string          `json:"manim_code,omitempty"`
+	VideoPath     string          `json:"video_path,omitempty"`
+	DownloadURL   string          `json:"download_url,omitempty"` // 主视频的签名下载地址，过期后重新获取视频详情
+	ShareToken    string          `json:"share_token,omitempty"`  // 公开分享的令牌，未分享时为空
+	Status        string          `json:"status"`
+	ErrorMsg      string          `json:"error_msg,omitempty"`
+	FailureReason string          `json:"failure_reason,omitempty"`
+	Priority      string          `json:"priority,omitempty"`
+	Attempts      int             `json:"attempts,omitempty"`        // 已开始的渲染次数
+	NextAttemptAt string          `json:"next_attempt_at,omitempty"` // 临时失败后下次重试的时间
+	Progress      *RenderProgress `json:"progress,omitempty"`
+	CreatedAt     string          `json:"created_at"`
+	UpdatedAt     string          `json:"updated_at"`
+
+	Quality         string   `json:"quality,omitempty"`
+	Width           int      `json:"width,omitempty"`
+	Height          int      `json:"height,omitempty"`
+	FrameRate       int      `json:"frame_rate,omitempty"`
+	BackgroundColor string   `json:"background_color,omitempty"`
+	Format          string   `json:"format,omitempty"`
+	Transparent     bool     `json:"transparent,omitempty"`
+	SaveLastFrame   bool     `json:"save_last_frame,omitempty"`
+	Scenes          []string `json:"scenes,omitempty"`
+	Concat          bool     `json:"concat,omitempty"`
+
+	Media     *MediaInfo      `json:"media,omitempty"` // 主视频的实际媒体信息
+	Artifacts []VideoArtifact `json:"artifacts,omitempty"`
+}
+
+// ShareVideoResponse 公开分享的链接，持有链接的人不登录也能下载，撤销后失效
+type ShareVideoResponse struct {
+	ShareToken  string `json:"share_token"`
+	DownloadURL string `json:"download_url"` // 主视频的分享下载地址
+}
+
+type MediaInfo struct {
+	Duration float64 `json:"duration"` // 秒，图片为0
+	Codec    string  `json:"codec"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+}
+
+type VideoArtifact struct {
+	Kind     string     `json:"kind"`            // video/image
+	Scene    string     `json:"scene,omitempty"` // 拼接后的完整视频为空
+	Format   string     `json:"format"`
+	MIMEType string     `json:"mime_type"`
+	URL      string     `json:"url"`
+	Size     int64      `json:"size"`
+	Media    *MediaInfo `json:"media,omitempty"`
+}
+
+type RenderProgress struct {
+	Phase            string  `json:"phase"`
+	CurrentAnimation int     `json:"current_animation"`
+	TotalAnimations  int     `json:"total_animations"`
+	Percent          float64 `json:"percent"`
+	OverallPercent   float64 `json:"overall_percent"`
+	FramesRendered   int     `json:"frames_rendered"`
+	TotalFrames      int     `json:"total_frames"`
+	ETASeconds       int     `json:"eta_seconds"`
+	UpdatedAt        int64   `json:"updated_at"`
 }
 
 type VideoListResponse struct {
@@ -50,9 +124,18 @@ type GenerateCodeRequest struct {
 }
 
 type GenerateCodeResponse struct {
-	Code    string `json:"code"`
-	IsValid bool   `json:"is_valid"`
-	Message string `json:"message,omitempty"`
+	Code        string           `json:"code"`
+	IsValid     bool             `json:"is_valid"`
+	Message     string           `json:"message,omitempty"`
+	Diagnostics []CodeDiagnostic `json:"diagnostics,omitempty"`
+}
+
+// CodeDiagnostic 代码检查诊断信息，行号和列号从1开始
+type CodeDiagnostic struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
 
 type QueueStatusResponse struct {
@@ -60,6 +143,107 @@ type QueueStatusResponse struct {
 	Max     int `json:"max"`
 }
 
+// QueuePosition 视频在渲染队列中的位置，从1开始
+type QueuePosition struct {
+	VideoID  uint `json:"video_id"`
+	Position int  `json:"position"`
+}
+
+// UserQueueResponse 渲染队列中等待的任务总数和当前用户的任务位置
+type UserQueueResponse struct {
+	Total     int64           `json:"total"`
+	Positions []QueuePosition `json:"positions"`
+}
+
+// RenderAttempt 一次渲染失败的记录
+type RenderAttempt struct {
+	Attempt       int    `json:"attempt"`
+	FailureClass  string `json:"failure_class"` // transient/permanent
+	FailureReason string `json:"failure_reason,omitempty"`
+	ErrorMsg      string `json:"error_msg,omitempty"`
+	LogExcerpt    string `json:"log_excerpt,omitempty"`
+	CodeHash      string `json:"code_hash"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// DeadLetterJob 重试次数用尽后移入死信队列的任务
+type DeadLetterJob struct {
+	VideoID        uint            `json:"video_id"`
+	UserID         uint            `json:"user_id"`
+	Attempts       int             `json:"attempts"`
+	FailureReason  string          `json:"failure_reason,omitempty"`
+	ErrorMsg       string          `json:"error_msg,omitempty"`
+	CodeHash       string          `json:"code_hash"`
+	LastLogExcerpt string          `json:"last_log_excerpt,omitempty"`
+	History        []RenderAttempt `json:"history"`
+	CreatedAt      string          `json:"created_at"`
+	UpdatedAt      string          `json:"updated_at"`
+}
+
+type DeadLetterListResponse struct {
+	Jobs  []DeadLetterJob `json:"jobs"`
+	Total int             `json:"total"`
+}
+
+// DeadLetterBatchRequest 批量重新入队或丢弃死信任务
+type DeadLetterBatchRequest struct {
+	VideoIDs []uint `json:"video_ids"`
+}
+
+// DeadLetterBatchFailure 批量操作中失败的任务
+type DeadLetterBatchFailure struct {
+	VideoID uint   `json:"video_id"`
+	Error   string `json:"error"`
+}
+
+type DeadLetterBatchResponse struct {
+	Succeeded []uint                   `json:"succeeded"`
+	Failed    []DeadLetterBatchFailure `json:"failed,omitempty"`
+}
+
+// DrainWorkersRequest 排空渲染工作者，GraceSeconds为0时使用配置的宽限期
+type DrainWorkersRequest struct {
+	GraceSeconds int `json:"grace_seconds,omitempty"`
+}
+
+// WorkerStatusResponse 处理请求的实例上渲染工作者的状态
+type WorkerStatusResponse struct {
+	Running  bool `json:"running"`
+	Draining bool `json:"draining"`
+	InFlight int  `json:"in_flight"` // 进行中的渲染任务数
+}
+
+// ReconcileAudit 对账处理的孤儿任务
+type ReconcileAudit struct {
+	VideoID    uint   `json:"video_id"`
+	PrevStatus string `json:"prev_status"` // 对账前的视频状态
+	Action     string `json:"action"`      // requeued | retry_scheduled | failed
+	Reason     string `json:"reason"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// ReconcileResponse 一次对账的结果
+type ReconcileResponse struct {
+	Checked int              `json:"checked"` // 检查的未完成视频数
+	Audits  []ReconcileAudit `json:"audits"`
+}
+
+// ReconcileAuditListResponse 最近的对账记录，按时间倒序
+type ReconcileAuditListResponse struct {
+	Audits []ReconcileAudit `json:"audits"`
+}
+
+// RenderCacheStatsResponse 渲染缓存统计，hits和misses为处理请求的实例启动以来的计数
+type RenderCacheStatsResponse struct {
+	Enabled    bool    `json:"enabled"`
+	Entries    int64   `json:"entries"`    // 缓存条目数
+	References int64   `json:"references"` // 引用缓存产物的视频数
+	TotalHits  int64   `json:"total_hits"` // 所有实例累计的命中次数
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	HitRate    float64 `json:"hit_rate"` // hits/(hits+misses)，没有查询时为0
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"time"
 
 	"manim-backend/internal/config"
 	"manim-backend/internal/handler"
@@ -12,6 +13,7 @@ import (
 	"manim-backend/internal/svc"
 
 	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/core/proc"
 	"github.com/zeromicro/go-zero/rest"
 )
 
@@ -29,17 +31,42 @@ func main() {
 	ctx := svc.NewServiceContext(c)
 	handler.RegisterHandlers(server, ctx)
 
+	// 后台任务的根上下文，工作者全部停止后再取消
+	appCtx, cancelApp := context.WithCancel(context.Background())
+	defer cancelApp()
+
 	// 启动视频渲染队列工作者
-	if err := ctx.VideoService.StartQueueWorkers(context.Background()); err != nil {
+	if err := ctx.VideoService.StartQueueWorkers(appCtx); err != nil {
 		log.Printf("启动队列工作者失败: %v", err)
 	} else {
 		log.Println("视频渲染队列工作者已启动")
 	}
 
+	// 收到SIGTERM/SIGINT时go-zero停止HTTP服务并通知关闭监听器：工作者停止领取新任务，
+	// 进行中的渲染在宽限期内完成，超时的任务重新入队。强制退出的时间需要长于宽限期
+	grace := time.Duration(c.Manim.Queue.GraceSeconds) * time.Second
+	proc.SetTimeToForceQuit(grace + 10*time.Second)
+	workersStopped := proc.AddShutdownListener(func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), grace+5*time.Second)
+		defer cancel()
+		if err := ctx.VideoService.ShutdownQueueWorkers(shutdownCtx); err != nil {
+			log.Printf("等待队列工作者停止超时: %v", err)
+		}
+	})
+
+	// 将崩溃或队列数据丢失后遗留在数据库中的未完成视频重新入队或标记失败，并定期对账
+	ctx.ManimService.StartReconciler(appCtx)
+
+	// 清理上次运行遗留的渲染工作目录，并定期清理过期和超出容量的工作目录
+	ctx.ManimService.Workspaces().StartJanitor(appCtx)
+
 	// 启动定时清理服务
 	cleanupService := service.NewCleanupService(ctx.VideoService)
-	cleanupService.StartCleanupScheduler(context.Background())
+	cleanupService.StartCleanupScheduler(appCtx)
 
 	fmt.Printf("Starting server at %s:%d...\n", c.Host, c.Port)
 	server.Start()
+
+	// HTTP服务停止后等待工作者处理完进行中的任务再退出
+	workersStopped()
 }
@@ -252,7 +252,7 @@ const Home = () => {
                 </div>
               )}
               
-              {videoStatus === 'completed' && currentVideo && currentVideo.video_path && (
+              {videoStatus === 'completed' && currentVideo && currentVideo.download_url && (
                 <div style={{ width: '100%', maxWidth: '500px' }}>
                   <video 
                     controls 
@@ -263,7 +263,7 @@ const Home = () => {
                       borderRadius: '8px',
                       boxShadow: '0 4px 12px rgba(0,0,0,0.1)'
                     }}
-                    src={`http://localhost:8888/downloadvideo${currentVideo.video_path.replace(/\\/g, '/').replace('/videos/', '/')}`}
+                    src={`http://localhost:8888${currentVideo.download_url}`}
                   >
                     您的浏览器不支持视频播放
                   </video>
@@ -282,7 +282,7 @@ const Home = () => {
                     </button>
                     
                     <button 
-                      onClick={() => window.open(`http://localhost:8888/downloadvideo${currentVideo.video_path.replace(/\\/g, '/').replace('/videos/', '/')}`, '_blank')}
+                      onClick={() => window.open(`http://localhost:8888${currentVideo.download_url}`, '_blank')}
                       className="btn-secondary"
                     >
                       在新窗口打开
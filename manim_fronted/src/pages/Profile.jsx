@@ -148,9 +148,9 @@ const Profile = () => {
                       </button>
                     )}
                     
-                    {video.status === 'completed' && video.video_path && (
+                    {video.status === 'completed' && video.download_url && (
                       <button 
-                      onClick={() => window.open(`http://localhost:8888/downloadvideo${video.video_path.replace(/\\/g, '/').replace('/videos/', '/')}`, '_blank')}
+                      onClick={() => window.open(`http://localhost:8888${video.download_url}`, '_blank')}
                       className="btn-secondary"
                       style={{ flex: 1 }}
                     >